@@ -0,0 +1,29 @@
+// Package api holds the types of the stager API that its clients decode, so
+// that they need not depend on the handlers serving them.
+package api
+
+import "code.cloudfoundry.org/runtimeschema/cc_messages"
+
+const (
+	StagingStatePending   = "pending"
+	StagingStateRunning   = "running"
+	StagingStateCompleted = "completed"
+	StagingStateFailed    = "failed"
+)
+
+// StagingStatus describes the progress of a single staging task, for
+// clients polling instead of waiting on the completion callback. Result is
+// only set once the task has completed or failed.
+type StagingStatus struct {
+	TaskGuid string                            `json:"task_guid"`
+	State    string                            `json:"state"`
+	Result   *cc_messages.StagingResponseForCC `json:"result,omitempty"`
+}
+
+// StagingTask describes a task in one of the staging domains for operators.
+type StagingTask struct {
+	AppId        string `json:"app_id,omitempty"`
+	TaskGuid     string `json:"task_guid"`
+	State        string `json:"state"`
+	AgeInSeconds int64  `json:"age_in_seconds"`
+}
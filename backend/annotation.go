@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+const (
+	// AnnotationV1 annotations are the bare JSON StagingTaskAnnotation
+	// written by stagers before annotations were versioned.
+	AnnotationV1 = 1
+
+	// AnnotationV2 annotations wrap the StagingTaskAnnotation in an envelope
+	// carrying its version.
+	AnnotationV2 = 2
+
+	// CurrentAnnotationVersion stays at AnnotationV1 until every stager in a
+	// deployment decodes AnnotationV2: a stager from before versioning would
+	// decode the envelope as an empty annotation and drop the completion.
+	CurrentAnnotationVersion = AnnotationV1
+)
+
+type UnsupportedAnnotationVersionError struct {
+	Version int
+}
+
+func (e *UnsupportedAnnotationVersionError) Error() string {
+	return fmt.Sprintf("unsupported staging task annotation version: %d", e.Version)
+}
+
+type annotationEnvelope struct {
+	Version    int             `json:"version"`
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+// EncodeAnnotation serializes the annotation at the current version.
+func EncodeAnnotation(annotation StagingTaskAnnotation) (string, error) {
+	annotationJson, err := json.Marshal(annotation)
+	if err != nil {
+		return "", err
+	}
+
+	if CurrentAnnotationVersion == AnnotationV1 {
+		return string(annotationJson), nil
+	}
+
+	envelopeJson, err := json.Marshal(annotationEnvelope{
+		Version:    CurrentAnnotationVersion,
+		Annotation: annotationJson,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(envelopeJson), nil
+}
+
+// DecodeAnnotation parses an annotation of any supported version, so that
+// tasks desired by a previous stager can still be completed after an
+// upgrade. Annotations without a version are decoded as AnnotationV1.
+func DecodeAnnotation(annotation string) (StagingTaskAnnotation, error) {
+	var decoded StagingTaskAnnotation
+
+	var envelope annotationEnvelope
+	err := json.Unmarshal([]byte(annotation), &envelope)
+	if err != nil {
+		return decoded, err
+	}
+
+	switch envelope.Version {
+	case 0, AnnotationV1:
+		err = json.Unmarshal([]byte(annotation), &decoded)
+	case AnnotationV2:
+		err = json.Unmarshal(envelope.Annotation, &decoded)
+	default:
+		err = &UnsupportedAnnotationVersionError{Version: envelope.Version}
+	}
+
+	return decoded, err
+}
+
+// AnnotateRequestId records the id of the staging request in the annotation
+// of a task built by a backend.
+func AnnotateRequestId(taskDef *models.TaskDefinition, requestId string) error {
+	return annotate(taskDef, func(annotation *StagingTaskAnnotation) {
+		annotation.RequestId = requestId
+	})
+}
+
+// AnnotateResponseFormat records the staging response format requested for
+// the staging in the annotation of a task built by a backend.
+func AnnotateResponseFormat(taskDef *models.TaskDefinition, format string) error {
+	return annotate(taskDef, func(annotation *StagingTaskAnnotation) {
+		annotation.ResponseFormat = format
+	})
+}
+
+func annotate(taskDef *models.TaskDefinition, update func(*StagingTaskAnnotation)) error {
+	annotation, err := DecodeAnnotation(taskDef.Annotation)
+	if err != nil {
+		return err
+	}
+
+	update(&annotation)
+	encoded, err := EncodeAnnotation(annotation)
+	if err != nil {
+		return err
+	}
+
+	taskDef.Annotation = encoded
+	return nil
+}
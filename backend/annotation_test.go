@@ -0,0 +1,93 @@
+package backend_test
+
+import (
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Annotations", func() {
+	annotation := backend.StagingTaskAnnotation{
+		StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{
+			Lifecycle:          "buildpack",
+			CompletionCallback: "https://api.cc.com/v1/staging/some-staging-guid/droplet_completed",
+		},
+		AppId:       "some-app-id",
+		Stack:       "cflinuxfs2",
+		HealthCheck: &backend.HealthCheck{Type: "http", Endpoint: "/healthz"},
+	}
+
+	It("encodes annotations without an envelope, so that previous stagers can decode them", func() {
+		encoded, err := backend.EncodeAnnotation(annotation)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encoded).To(MatchJSON(`{
+			"lifecycle": "buildpack",
+			"completion_callback": "https://api.cc.com/v1/staging/some-staging-guid/droplet_completed",
+			"app_id": "some-app-id",
+			"stack": "cflinuxfs2",
+			"health_check": {"type": "http", "endpoint": "/healthz"}
+		}`))
+	})
+
+	It("decodes the annotations it encodes", func() {
+		encoded, err := backend.EncodeAnnotation(annotation)
+		Expect(err).NotTo(HaveOccurred())
+
+		decoded, err := backend.DecodeAnnotation(encoded)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded).To(Equal(annotation))
+	})
+
+	It("decodes unversioned annotations written by previous stagers", func() {
+		decoded, err := backend.DecodeAnnotation(`{
+			"lifecycle": "buildpack",
+			"completion_callback": "https://api.cc.com/v1/staging/some-staging-guid/droplet_completed",
+			"app_id": "some-app-id",
+			"stack": "cflinuxfs2",
+			"health_check": {"type": "http", "endpoint": "/healthz"}
+		}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded).To(Equal(annotation))
+	})
+
+	It("decodes annotations in a versioned envelope", func() {
+		decoded, err := backend.DecodeAnnotation(`{
+			"version": 2,
+			"annotation": {
+				"lifecycle": "buildpack",
+				"completion_callback": "https://api.cc.com/v1/staging/some-staging-guid/droplet_completed",
+				"app_id": "some-app-id",
+				"stack": "cflinuxfs2",
+				"health_check": {"type": "http", "endpoint": "/healthz"}
+			}
+		}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded).To(Equal(annotation))
+	})
+
+	It("rejects annotations from a newer stager", func() {
+		_, err := backend.DecodeAnnotation(`{"version": 3, "annotation": {}}`)
+		Expect(err).To(Equal(&backend.UnsupportedAnnotationVersionError{Version: 3}))
+	})
+
+	It("rejects invalid annotations", func() {
+		_, err := backend.DecodeAnnotation(",goo")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("records the requested response format in the annotation of a task", func() {
+		encoded, err := backend.EncodeAnnotation(annotation)
+		Expect(err).NotTo(HaveOccurred())
+		taskDef := &models.TaskDefinition{Annotation: encoded}
+
+		Expect(backend.AnnotateResponseFormat(taskDef, "capi-v3")).To(Succeed())
+
+		decoded, err := backend.DecodeAnnotation(taskDef.Annotation)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded.ResponseFormat).To(Equal("capi-v3"))
+		Expect(decoded.AppId).To(Equal(annotation.AppId))
+	})
+})
@@ -0,0 +1,24 @@
+package backend
+
+import (
+	"strconv"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+// artifactByteLimitScript fails the staging task when an artifact it
+// downloaded, or is about to upload, is larger than the staging request
+// allows, so that the task does not spend more of the cell on it.
+const artifactByteLimitScript = `size=$(du -sb "$2" | cut -f 1)
+if [ "$size" -gt "$3" ]; then
+  echo "$1 is $size bytes, more than the $3 bytes allowed" >&2
+  exit 1
+fi`
+
+func artifactByteLimitAction(artifact, path string, limit uint64) models.ActionInterface {
+	return &models.RunAction{
+		Path: "/bin/sh",
+		Args: []string{"-c", artifactByteLimitScript, "artifact-byte-limit", artifact, path, strconv.FormatUint(limit, 10)},
+		User: "vcap",
+	}
+}
@@ -1,23 +1,48 @@
 package backend
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/buildpackapplifecycle"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/stager/diego_errors"
+	"code.cloudfoundry.org/stager/docker_registry"
 )
 
 const (
 	TaskLogSource                 = "STG"
 	DefaultStagingTimeout         = 15 * time.Minute
 	TrustedSystemCertificatesPath = "/etc/cf-system-certificates"
+
+	MaxEnvironmentVariables = 1024
+	MaxBuildpacks           = 64
+
+	// InvalidStagingRequest is the id of the StagingError reported when the
+	// stager rejects a staging request before desiring a task for it.
+	InvalidStagingRequest = "InvalidStagingRequestError"
+
+	// StagerBusy is the id of the StagingError reported when a staging
+	// request is rejected because the stager is receiving too many of them.
+	StagerBusy = "StagerBusyError"
+
+	// PlatformBusy is the id of the StagingError reported when a staging
+	// request is rejected because too many staging tasks are outstanding.
+	PlatformBusy = "PlatformBusyError"
+
+	// StagingResultTooLarge is the id of the StagingError reported when the
+	// result of a staging task was larger than the cell or the stager
+	// accept.
+	StagingResultTooLarge = "StagingResultTooLargeError"
 )
 
 type FailureReasonSanitizer func(string) *cc_messages.StagingError
@@ -32,6 +57,12 @@ var ErrNoCompilerDefined = errors.New(diego_errors.NO_COMPILER_DEFINED_MESSAGE)
 var ErrMissingAppId = errors.New(diego_errors.MISSING_APP_ID_MESSAGE)
 var ErrMissingAppBitsDownloadUri = errors.New(diego_errors.MISSING_APP_BITS_DOWNLOAD_URI_MESSAGE)
 var ErrMissingLifecycleData = errors.New(diego_errors.MISSING_LIFECYCLE_DATA_MESSAGE)
+var ErrTooManyEnvironmentVariables = errors.New(diego_errors.TOO_MANY_ENVIRONMENT_VARIABLES)
+var ErrTooManyBuildpacks = errors.New(diego_errors.TOO_MANY_BUILDPACKS)
+var ErrMalformedStagingRequest = errors.New(diego_errors.MALFORMED_STAGING_REQUEST_MESSAGE)
+var ErrStagerBusy = errors.New(diego_errors.STAGER_BUSY_MESSAGE)
+var ErrPlatformBusy = errors.New(diego_errors.PLATFORM_BUSY_MESSAGE)
+var ErrInsecureTransferURL = errors.New(diego_errors.INSECURE_TRANSFER_URL_MESSAGE)
 
 type Config struct {
 	TaskDomain               string
@@ -46,6 +77,167 @@ type Config struct {
 	Sanitizer                FailureReasonSanitizer
 	DockerStagingStack       string
 	PrivilegedContainers     bool
+	DetectTimeout            time.Duration
+	MinStagingTimeout        time.Duration
+	MaxStagingTimeout        time.Duration
+	MinStagingMemoryMB       int
+	MaxStagingMemoryMB       int
+	MinStagingDiskMB         int
+	MaxStagingDiskMB         int
+
+	// StagingCpuWeight, when positive, is the CPU weight of every staging
+	// task instead of one derived from its memory.
+	StagingCpuWeight uint32
+
+	// RequireTLS only allows the app bits, build artifacts and droplet to be
+	// transferred over https, so that the cells can mutually authenticate
+	// with CC and the CC uploader using their own client certificates.
+	RequireTLS bool
+
+	// DropletUploadSigningKey, when set, is used to sign the droplet upload
+	// URI instead of passing on the credentials CC embeds in it. Signed
+	// URIs expire DropletUploadURLSlack after the staging timeout of the task,
+	// measured from when it is desired by Clock.
+	DropletUploadSigningKey string
+	DropletUploadURLSlack   time.Duration
+	Clock                   clock.Clock
+
+	// StagingEnvironmentGroup is set in the environment of every staging
+	// task, unless CC or the app set the same variables.
+	StagingEnvironmentGroup map[string]string
+
+	// ImageMetadataClient, when set, is used to check that docker images
+	// exist before desiring a task to stage them.
+	ImageMetadataClient docker_registry.Client
+
+	// DisableBuildpackCaching makes staging tasks download admin buildpacks
+	// instead of declaring them as cached dependencies.
+	DisableBuildpackCaching bool
+
+	// WindowsTaskDomain is the domain of the staging tasks desired for
+	// Windows cells. If empty, TaskDomain is used.
+	WindowsTaskDomain string
+
+	// DropletChecksums has buildpack staging tasks compute the checksums of
+	// the droplet they upload, reported to CC with the staging result.
+	// Windows staging tasks do not support it.
+	DropletChecksums bool
+
+	// BuilderArgs are appended to the arguments of the builder of each
+	// lifecycle, see ParseBuilderArgs.
+	BuilderArgs map[string][]string
+
+	// AppBitsURLBuilder, when set, builds the URL buildpack staging tasks
+	// download the app bits from instead of the one CC sent.
+	AppBitsURLBuilder AppBitsURLBuilder
+
+	// MaxResultBytes, when positive, is the size of the largest staging
+	// result reported to CC. Larger results, and results cut short at the
+	// limit, are reported as a StagingResultTooLargeError.
+	MaxResultBytes int
+
+	// StackRootFSes maps stacks to the rootfs their staging tasks run on.
+	// Stacks that are not mapped run on the preloaded rootfs of the same
+	// name.
+	StackRootFSes map[string]string
+}
+
+// HealthCheck is the app health check declared in a staging request. It is
+// carried through the task annotation and added to the execution metadata of
+// a successful staging result.
+type HealthCheck struct {
+	Type             string `json:"type,omitempty"`
+	Endpoint         string `json:"endpoint,omitempty"`
+	TimeoutInSeconds int    `json:"timeout_in_seconds,omitempty"`
+}
+
+// Placement is where a staging request asks its task to run. The isolation
+// segment is a placement tag like any other as far as Diego is concerned.
+type Placement struct {
+	IsolationSegment string   `json:"isolation_segment,omitempty"`
+	PlacementTags    []string `json:"placement_tags,omitempty"`
+}
+
+// Tags returns the placement tags for the staging task, without duplicates.
+func (p Placement) Tags() []string {
+	var tags []string
+	seen := map[string]bool{}
+
+	for _, tag := range append([]string{p.IsolationSegment}, p.PlacementTags...) {
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// StagingTaskAnnotation extends the annotation CC expects on staging tasks
+// with the details the stager needs when the task completes.
+type StagingTaskAnnotation struct {
+	cc_messages.StagingTaskAnnotation
+
+	AppId       string       `json:"app_id,omitempty"`
+	Stack       string       `json:"stack,omitempty"`
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+	RequestId   string       `json:"request_id,omitempty"`
+
+	// ResponseFormat is the format of the staging response requested for
+	// this staging. If empty, the configured format is used.
+	ResponseFormat string `json:"response_format,omitempty"`
+
+	// DockerImageDigest is the digest of the docker image being staged,
+	// reported to CC in the lifecycle metadata of the staging result.
+	DockerImageDigest string `json:"docker_image_digest,omitempty"`
+
+	// DropletChecksums is set when the staging task adds the checksums of
+	// its droplet to its result.
+	DropletChecksums bool `json:"droplet_checksums,omitempty"`
+}
+
+// DecodeLifecycleData decodes the lifecycle data of a staging request into
+// the lifecycle data type of the backend the request was routed to, so that
+// every lifecycle defines the schema of its own lifecycle data.
+func DecodeLifecycleData(request cc_messages.StagingRequestFromCC, lifecycleData interface{}) error {
+	if request.LifecycleData == nil {
+		return ErrMissingLifecycleData
+	}
+
+	return json.Unmarshal(*request.LifecycleData, lifecycleData)
+}
+
+// UnknownStackError is returned for staging requests for a stack that no
+// lifecycle bundle is configured for.
+type UnknownStackError struct {
+	Stack  string
+	Stacks []string
+}
+
+func (e *UnknownStackError) Error() string {
+	return fmt.Sprintf("%s: %s (available stacks: %s)", diego_errors.NO_COMPILER_DEFINED_MESSAGE, e.Stack, strings.Join(e.Stacks, ", "))
+}
+
+// Stacks returns the stacks that bundles of the given lifecycle are
+// configured for.
+func (c Config) Stacks(lifecycle string) []string {
+	stacks := []string{}
+	for key := range c.Lifecycles {
+		if strings.HasPrefix(key, lifecycle+"/") {
+			stacks = append(stacks, strings.TrimPrefix(key, lifecycle+"/"))
+		}
+	}
+	sort.Strings(stacks)
+	return stacks
+}
+
+// RootFS returns the rootfs staging tasks for the given stack run on.
+func (c Config) RootFS(stack string) string {
+	if rootFS, ok := c.StackRootFSes[stack]; ok {
+		return rootFS
+	}
+	return models.PreloadedRootFS(stack)
 }
 
 func (c Config) CallbackURL(stagingGuid string) string {
@@ -60,6 +252,60 @@ func max(x, y uint64) uint64 {
 	}
 }
 
+// stagingTimeout honors the timeout requested by CC, bounded by the operator
+// configured minimum and maximum. A zero bound is not enforced.
+func stagingTimeout(config Config, request cc_messages.StagingRequestFromCC, logger lager.Logger) time.Duration {
+	timeout := DefaultStagingTimeout
+	if request.Timeout > 0 {
+		timeout = time.Duration(request.Timeout) * time.Second
+	} else {
+		logger.Info("overriding requested timeout", lager.Data{
+			"requested-timeout": request.Timeout,
+			"default-timeout":   DefaultStagingTimeout,
+			"app-id":            request.AppId,
+		})
+	}
+
+	bounded := timeout
+	if config.MinStagingTimeout > 0 && bounded < config.MinStagingTimeout {
+		bounded = config.MinStagingTimeout
+	}
+	if config.MaxStagingTimeout > 0 && bounded > config.MaxStagingTimeout {
+		bounded = config.MaxStagingTimeout
+	}
+
+	if bounded != timeout {
+		logger.Info("bounding requested timeout", lager.Data{
+			"requested-timeout": timeout,
+			"bounded-timeout":   bounded,
+			"app-id":            request.AppId,
+		})
+	}
+
+	return bounded
+}
+
+// validateTransferURLs checks that every non-empty URL uses https when the
+// config requires TLS.
+func validateTransferURLs(config Config, urls ...string) error {
+	if !config.RequireTLS {
+		return nil
+	}
+
+	for _, transferURL := range urls {
+		if transferURL == "" {
+			continue
+		}
+
+		parsed, err := url.Parse(transferURL)
+		if err != nil || parsed.Scheme != "https" {
+			return ErrInsecureTransferURL
+		}
+	}
+
+	return nil
+}
+
 func addTimeoutParamToURL(u url.URL, timeout time.Duration) *url.URL {
 	query := u.Query()
 	query.Set(cc_messages.CcTimeoutKey, fmt.Sprintf("%.0f", timeout.Seconds()))
@@ -67,6 +313,140 @@ func addTimeoutParamToURL(u url.URL, timeout time.Duration) *url.URL {
 	return &u
 }
 
+func buildStagingResponse(sanitizer FailureReasonSanitizer, schema resultSchema, maxResultBytes int, taskResponse *models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error) {
+	var response cc_messages.StagingResponseForCC
+
+	if taskResponse.Failed {
+		if strings.HasPrefix(taskResponse.FailureReason, resultFileTooLargeReason) {
+			stagingResultsTooLarge.Increment()
+		}
+		response.Error = sanitizer(taskResponse.FailureReason)
+		return response, nil
+	}
+
+	var annotation StagingTaskAnnotation
+	if taskResponse.Annotation != "" {
+		var err error
+		annotation, err = DecodeAnnotation(taskResponse.Annotation)
+		if err != nil {
+			return response, err
+		}
+	}
+
+	err := checkResultSize(taskResponse.Result, maxResultBytes)
+	if err != nil {
+		stagingResultsTooLarge.Increment()
+		response.Error = sanitizer(err.Error())
+		return response, nil
+	}
+
+	err = schema.validate([]byte(taskResponse.Result))
+	if err != nil {
+		malformedStagingResults.Increment()
+		response.Error = sanitizer(err.Error())
+		return response, nil
+	}
+
+	resultJson, err := normalizeProcessTypes([]byte(taskResponse.Result))
+	if err != nil {
+		return response, err
+	}
+
+	if annotation.HealthCheck != nil {
+		resultJson, err = addHealthCheckToResult(resultJson, annotation.HealthCheck)
+		if err != nil {
+			return response, err
+		}
+	}
+
+	if annotation.DockerImageDigest != "" {
+		resultJson, err = addImageDigestToResult(resultJson, annotation.DockerImageDigest)
+		if err != nil {
+			return response, err
+		}
+	}
+
+	if annotation.DropletChecksums {
+		resultJson, err = moveDropletChecksums(resultJson)
+		if malformedErr, ok := err.(*MalformedResultError); ok {
+			malformedStagingResults.Increment()
+			response.Error = sanitizer(malformedErr.Error())
+			return response, nil
+		}
+		if err != nil {
+			return response, err
+		}
+	}
+
+	result := json.RawMessage(resultJson)
+	response.Result = &result
+
+	return response, nil
+}
+
+func addHealthCheckToResult(resultJson []byte, healthCheck *HealthCheck) ([]byte, error) {
+	var result map[string]json.RawMessage
+	err := json.Unmarshal(resultJson, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	var executionMetadataJson string
+	if raw, ok := result["execution_metadata"]; ok {
+		err = json.Unmarshal(raw, &executionMetadataJson)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	executionMetadata := map[string]interface{}{}
+	if executionMetadataJson != "" {
+		err = json.Unmarshal([]byte(executionMetadataJson), &executionMetadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	executionMetadata["health_check"] = healthCheck
+
+	updatedMetadata, err := json.Marshal(executionMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	result["execution_metadata"], err = json.Marshal(string(updatedMetadata))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(result)
+}
+
+func addImageDigestToResult(resultJson []byte, digest string) ([]byte, error) {
+	var result map[string]json.RawMessage
+	err := json.Unmarshal(resultJson, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	lifecycleMetadata := map[string]interface{}{}
+	if raw, ok := result["lifecycle_metadata"]; ok {
+		err = json.Unmarshal(raw, &lifecycleMetadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lifecycleMetadata["docker_image_digest"] = digest
+
+	result["lifecycle_metadata"], err = json.Marshal(lifecycleMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(result)
+}
+
 func SanitizeErrorMessage(message string) *cc_messages.StagingError {
 	const staging_failed = "staging failed"
 	id := cc_messages.STAGING_ERROR
@@ -80,16 +460,47 @@ func SanitizeErrorMessage(message string) *cc_messages.StagingError {
 	case strings.HasSuffix(message, strconv.Itoa(buildpackapplifecycle.RELEASE_FAIL_CODE)):
 		id = cc_messages.BUILDPACK_RELEASE_FAILED
 		message = staging_failed
+	case strings.HasPrefix(message, diego_errors.DETECT_TIMEOUT_MESSAGE):
+		id = cc_messages.BUILDPACK_DETECT_FAILED
+		message = diego_errors.DETECT_TIMEOUT_MESSAGE
 	case strings.HasPrefix(message, diego_errors.INSUFFICIENT_RESOURCES_MESSAGE):
 		id = cc_messages.INSUFFICIENT_RESOURCES
 	case strings.HasPrefix(message, diego_errors.CELL_MISMATCH_MESSAGE):
 		id = cc_messages.NO_COMPATIBLE_CELL
+	case strings.HasPrefix(message, diego_errors.INVALID_RESOURCE_REQUEST_MESSAGE),
+		strings.HasPrefix(message, diego_errors.INVALID_EGRESS_RULE_MESSAGE),
+		strings.HasPrefix(message, diego_errors.INVALID_BUILDPACK_CHECKSUM_MESSAGE),
+		strings.HasPrefix(message, diego_errors.INVALID_DOCKER_IMAGE_DIGEST_MESSAGE),
+		strings.HasPrefix(message, diego_errors.NO_COMPILER_DEFINED_MESSAGE+": "):
+		id = InvalidStagingRequest
+	case strings.HasPrefix(message, diego_errors.MALFORMED_STAGING_RESULT_MESSAGE+": "):
+	case strings.HasPrefix(message, diego_errors.STAGING_RESULT_TOO_LARGE_MESSAGE+": "):
+		id = StagingResultTooLarge
+	case strings.HasPrefix(message, resultFileTooLargeReason):
+		id = StagingResultTooLarge
+		message = diego_errors.STAGING_RESULT_TOO_LARGE_MESSAGE
+	case message == diego_errors.TASK_CANCELLED_MESSAGE:
+		message = diego_errors.STAGING_CANCELLED_MESSAGE
 	case message == diego_errors.CELL_COMMUNICATION_ERROR:
 		id = cc_messages.CELL_COMMUNICATION_ERROR
-	case message == diego_errors.MISSING_DOCKER_IMAGE_URL:
+	case message == diego_errors.MALFORMED_STAGING_REQUEST_MESSAGE,
+		message == diego_errors.MISSING_APP_ID_MESSAGE,
+		message == diego_errors.MISSING_APP_BITS_DOWNLOAD_URI_MESSAGE,
+		message == diego_errors.MISSING_LIFECYCLE_DATA_MESSAGE,
+		message == diego_errors.MISSING_DOCKER_IMAGE_URL,
+		message == diego_errors.MISSING_DOCKER_CREDENTIALS,
+		message == diego_errors.TOO_MANY_ENVIRONMENT_VARIABLES,
+		message == diego_errors.TOO_MANY_BUILDPACKS,
+		message == diego_errors.INSECURE_TRANSFER_URL_MESSAGE,
+		message == diego_errors.DOCKER_IMAGE_NOT_FOUND_MESSAGE:
+		id = InvalidStagingRequest
+	case message == diego_errors.STAGER_BUSY_MESSAGE:
+		id = StagerBusy
+	case message == diego_errors.PLATFORM_BUSY_MESSAGE:
+		id = PlatformBusy
 	case message == diego_errors.MISSING_DOCKER_REGISTRY:
-	case message == diego_errors.MISSING_DOCKER_CREDENTIALS:
 	case message == diego_errors.INVALID_DOCKER_REGISTRY_ADDRESS:
+	case message == diego_errors.RETRY_BUDGET_EXHAUSTED_MESSAGE:
 	default:
 		message = "staging failed"
 	}
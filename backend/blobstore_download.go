@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+const (
+	DefaultBlobstorePackagesDirectory = "cc-packages"
+	DefaultBlobstoreURLTTL            = time.Hour
+)
+
+// AppBitsURLBuilder builds the URL staging tasks download the app bits from,
+// given the one CC sent, e.g. to download them from the blobstore rather
+// than through CC.
+type AppBitsURLBuilder interface {
+	AppBitsURL(ccURL string) (string, error)
+}
+
+type webdavAppBitsURLBuilder struct {
+	blobstoreURL *url.URL
+	directory    string
+	secret       string
+	ttl          time.Duration
+	clock        clock.Clock
+}
+
+// NewWebDAVAppBitsURLBuilder returns a builder pointing staging tasks at the
+// packages in CC's WebDAV blobstore, with links signed as the blobstore's
+// nginx secure_link module verifies them, using the secret shared with CC.
+// URLs that are not CC package downloads are left as they are.
+func NewWebDAVAppBitsURLBuilder(blobstoreURL, directory, secret string, ttl time.Duration, clock clock.Clock) (AppBitsURLBuilder, error) {
+	u, err := url.ParseRequestURI(blobstoreURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blobstore URL: %s", err)
+	}
+
+	if directory == "" {
+		directory = DefaultBlobstorePackagesDirectory
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultBlobstoreURLTTL
+	}
+
+	return &webdavAppBitsURLBuilder{
+		blobstoreURL: u,
+		directory:    directory,
+		secret:       secret,
+		ttl:          ttl,
+		clock:        clock,
+	}, nil
+}
+
+func (b *webdavAppBitsURLBuilder) AppBitsURL(ccURL string) (string, error) {
+	guid, ok := packageGuid(ccURL)
+	if !ok {
+		return ccURL, nil
+	}
+
+	readPath := path.Join("/read", b.directory, guid[0:2], guid[2:4], guid)
+	expires := strconv.FormatInt(b.clock.Now().Add(b.ttl).Unix(), 10)
+
+	sum := md5.Sum([]byte(expires + readPath + " " + b.secret))
+
+	u := *b.blobstoreURL
+	u.Path = path.Join(u.Path, readPath)
+	u.RawQuery = url.Values{
+		"md5":     {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"expires": {expires},
+	}.Encode()
+
+	return u.String(), nil
+}
+
+// packageGuid returns the guid of the package CC serves at the URL, e.g.
+// https://cc.internal/staging/packages/<guid>.
+func packageGuid(ccURL string) (string, bool) {
+	u, err := url.Parse(ccURL)
+	if err != nil {
+		return "", false
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || segments[len(segments)-2] != "packages" {
+		return "", false
+	}
+
+	guid := segments[len(segments)-1]
+	if len(guid) < 4 {
+		return "", false
+	}
+
+	return guid, true
+}
@@ -0,0 +1,59 @@
+package backend_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/stager/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WebDAVAppBitsURLBuilder", func() {
+	var (
+		blobstoreURL string
+		directory    string
+		builder      backend.AppBitsURLBuilder
+	)
+
+	BeforeEach(func() {
+		blobstoreURL = "https://blobstore.example.com"
+		directory = ""
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		builder, err = backend.NewWebDAVAppBitsURLBuilder(blobstoreURL, directory, "secret", time.Hour, fakeclock.NewFakeClock(time.Unix(1000, 0)))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("signs a link to the package in the blobstore", func() {
+		appBitsURL, err := builder.AppBitsURL("https://cc.example.com/staging/packages/abcdef-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(appBitsURL).To(Equal("https://blobstore.example.com/read/cc-packages/ab/cd/abcdef-guid?expires=4600&md5=I0ablIAavnuEmpw-4IMccQ"))
+	})
+
+	Context("with a packages directory", func() {
+		BeforeEach(func() {
+			directory = "packages"
+		})
+
+		It("links to the package in that directory", func() {
+			appBitsURL, err := builder.AppBitsURL("https://cc.example.com/staging/packages/abcdef-guid")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(appBitsURL).To(HavePrefix("https://blobstore.example.com/read/packages/ab/cd/abcdef-guid?"))
+		})
+	})
+
+	It("leaves URLs that are not package downloads alone", func() {
+		appBitsURL, err := builder.AppBitsURL("https://cc.example.com/v2/apps/app-guid/download")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(appBitsURL).To(Equal("https://cc.example.com/v2/apps/app-guid/download"))
+	})
+
+	It("rejects an invalid blobstore URL", func() {
+		_, err := backend.NewWebDAVAppBitsURLBuilder("not a url", "", "secret", time.Hour, fakeclock.NewFakeClock(time.Now()))
+		Expect(err).To(HaveOccurred())
+	})
+})
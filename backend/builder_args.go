@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AllowedBuilderArgs lists the builder flags operators may pass to the
+// builder of each lifecycle. The flags the stager sets from the staging
+// request, like the build paths or the docker image, are not among them.
+var AllowedBuilderArgs = map[string][]string{
+	TraditionalLifecycleName: {"skipDetect", "skipCertVerify"},
+	WindowsLifecycleName:     {"skipDetect", "skipCertVerify"},
+	DockerLifecycleName:      {"insecureDockerRegistries"},
+}
+
+// DisallowedBuilderArgError is returned for builder arguments that are not
+// in AllowedBuilderArgs for their lifecycle.
+type DisallowedBuilderArgError struct {
+	Lifecycle string
+	Arg       string
+}
+
+func (e *DisallowedBuilderArgError) Error() string {
+	return fmt.Sprintf("builder argument not allowed for lifecycle %s: %s", e.Lifecycle, e.Arg)
+}
+
+// ParseBuilderArgs parses builder arguments of the form lifecycle:-flag or
+// lifecycle:-flag=value into the arguments to append to the builder of each
+// lifecycle, sorted so that staging tasks for the same request do not
+// differ. Flags and their values must be given as a single argument.
+func ParseBuilderArgs(specs []string) (map[string][]string, error) {
+	builderArgs := map[string][]string{}
+
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid builder argument '%s', expected lifecycle:-flag[=value]", spec)
+		}
+
+		lifecycle, arg := parts[0], parts[1]
+		if !builderArgAllowed(lifecycle, arg) {
+			return nil, &DisallowedBuilderArgError{Lifecycle: lifecycle, Arg: arg}
+		}
+
+		builderArgs[lifecycle] = append(builderArgs[lifecycle], arg)
+	}
+
+	for _, args := range builderArgs {
+		sort.Strings(args)
+	}
+
+	return builderArgs, nil
+}
+
+func builderArgAllowed(lifecycle, arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+
+	name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+	for _, allowed := range AllowedBuilderArgs[lifecycle] {
+		if name == allowed {
+			return true
+		}
+	}
+
+	return false
+}
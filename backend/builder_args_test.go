@@ -0,0 +1,45 @@
+package backend_test
+
+import (
+	"code.cloudfoundry.org/stager/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseBuilderArgs", func() {
+	It("groups the arguments by lifecycle, sorted", func() {
+		builderArgs, err := backend.ParseBuilderArgs([]string{
+			"buildpack:-skipDetect=true",
+			"docker:-insecureDockerRegistries=registry.example.com",
+			"buildpack:-skipCertVerify",
+			"windows:-skipCertVerify",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(builderArgs).To(Equal(map[string][]string{
+			"buildpack": {"-skipCertVerify", "-skipDetect=true"},
+			"docker":    {"-insecureDockerRegistries=registry.example.com"},
+			"windows":   {"-skipCertVerify"},
+		}))
+	})
+
+	It("rejects arguments without a lifecycle", func() {
+		_, err := backend.ParseBuilderArgs([]string{"-skipDetect=true"})
+		Expect(err).To(MatchError("invalid builder argument '-skipDetect=true', expected lifecycle:-flag[=value]"))
+	})
+
+	It("rejects flags that are not allowed for the lifecycle", func() {
+		_, err := backend.ParseBuilderArgs([]string{"docker:-skipDetect=true"})
+		Expect(err).To(Equal(&backend.DisallowedBuilderArgError{Lifecycle: "docker", Arg: "-skipDetect=true"}))
+	})
+
+	It("rejects flags the stager sets itself", func() {
+		_, err := backend.ParseBuilderArgs([]string{"buildpack:-outputDroplet=/tmp/evil"})
+		Expect(err).To(MatchError("builder argument not allowed for lifecycle buildpack: -outputDroplet=/tmp/evil"))
+	})
+
+	It("rejects values given apart from their flag", func() {
+		_, err := backend.ParseBuilderArgs([]string{"buildpack:/tmp/droplet"})
+		Expect(err).To(BeAssignableToTypeOf(&backend.DisallowedBuilderArgError{}))
+	})
+})
@@ -1,7 +1,6 @@
 package backend
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
@@ -14,6 +13,7 @@ import (
 	"code.cloudfoundry.org/cc-uploader"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/metric"
 	"github.com/cloudfoundry/gunk/urljoiner"
 	"github.com/tedsuo/rata"
 )
@@ -23,11 +23,55 @@ const (
 	StagingTaskCpuWeight     = uint32(50)
 
 	DefaultLANG = "en_US.UTF-8"
+
+	BuildpackDebugEnvVar = "BP_DEBUG"
+
+	// Metrics
+	cachelessStagingRequests = metric.Counter("CachelessStagingRequests")
 )
 
+// buildpackStagingData extends the lifecycle data sent by CC with staging
+// options that are specific to this stager.
+type buildpackStagingData struct {
+	cc_messages.BuildpackStagingData
+
+	// Verbose turns on debug output from the buildpacks for this staging only.
+	Verbose bool `json:"verbose,omitempty"`
+
+	// SkipDetect skips buildpack detection, as when the app pins its
+	// buildpack, whether or not the buildpacks are marked to skip it.
+	SkipDetect bool `json:"skip_detect,omitempty"`
+
+	// ArtifactByteLimit bounds the size of the app package downloaded and
+	// of the droplet uploaded by the staging task, if positive. It is not
+	// enforced on Windows cells.
+	ArtifactByteLimit uint64 `json:"artifact_byte_limit,omitempty"`
+
+	// DisableBuildArtifactsCache stages the app from scratch, neither
+	// downloading nor uploading its build artifacts cache.
+	DisableBuildArtifactsCache bool `json:"disable_build_artifacts_cache,omitempty"`
+
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+
+	// StagingEnvironmentGroup is the staging environment variable group
+	// configured in CC, merged beneath the app's own environment.
+	StagingEnvironmentGroup []*models.EnvironmentVariable `json:"staging_env_group,omitempty"`
+
+	// BuildpackChecksums maps the URLs of admin buildpacks and of custom
+	// buildpacks delivered as zip or tgz archives to their sha256 checksums,
+	// verified on download. The checksum of an admin buildpack is also part
+	// of its cache key, so that cells do not reuse a replaced buildpack.
+	BuildpackChecksums map[string]string `json:"buildpack_checksums,omitempty"`
+
+	Placement
+}
+
 type traditionalBackend struct {
 	config Config
 	logger lager.Logger
+
+	// windows stages for Windows cells, see NewWindowsBackend.
+	windows bool
 }
 
 func NewTraditionalBackend(config Config, logger lager.Logger) Backend {
@@ -41,17 +85,18 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 	logger := backend.logger.Session("build-recipe", lager.Data{"app-id": request.AppId, "staging-guid": stagingGuid})
 	logger.Info("staging-request")
 
-	if request.LifecycleData == nil {
-		return &models.TaskDefinition{}, "", "", ErrMissingLifecycleData
+	var lifecycleData buildpackStagingData
+	err := DecodeLifecycleData(request, &lifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
 	}
 
-	var lifecycleData cc_messages.BuildpackStagingData
-	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
+	err = backend.validateRequest(request, lifecycleData)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
 
-	err = backend.validateRequest(request, lifecycleData)
+	memoryMB, diskMB, err := stagingResources(backend.config, request, logger)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
@@ -61,28 +106,54 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		return &models.TaskDefinition{}, "", "", err
 	}
 
+	appBitsURL, err := backend.appBitsURL(lifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
 	buildpacksOrder := []string{}
-	for _, buildpack := range lifecycleData.Buildpacks {
-		buildpacksOrder = append(buildpacksOrder, buildpack.Key)
+	for i, buildpack := range lifecycleData.Buildpacks {
+		buildpacksOrder = append(buildpacksOrder, buildpackKey(i, buildpack))
 	}
 
-	skipDetect := len(lifecycleData.Buildpacks) == 1 && lifecycleData.Buildpacks[0].SkipDetect
-	builderConfig := buildpackapplifecycle.NewLifecycleBuilderConfig(buildpacksOrder, skipDetect, backend.config.SkipCertVerify)
+	builderConfig := buildpackapplifecycle.NewLifecycleBuilderConfig(buildpacksOrder, skipDetect(lifecycleData), backend.config.SkipCertVerify)
+
+	builderArgs := builderConfig.Args()
+	builderArgs = append(builderArgs, backend.config.BuilderArgs[backend.lifecycleName()]...)
 
-	timeout := traditionalTimeout(request, backend.logger)
+	timeout := stagingTimeout(backend.config, request, backend.logger)
 
 	actions := []models.ActionInterface{}
 
 	//Download app package
 	appDownloadAction := &models.DownloadAction{
 		Artifact: "app package",
-		From:     lifecycleData.AppBitsDownloadUri,
+		From:     appBitsURL,
 		To:       builderConfig.BuildDir(),
 		User:     "vcap",
 	}
 
 	actions = append(actions, appDownloadAction)
 
+	artifactByteLimit := lifecycleData.ArtifactByteLimit
+	if backend.windows {
+		artifactByteLimit = 0
+	}
+	if artifactByteLimit > 0 {
+		actions = append(actions, artifactByteLimitAction("app package", builderConfig.BuildDir(), artifactByteLimit))
+	}
+
+	//Download custom buildpack archives
+	for i, buildpack := range lifecycleData.Buildpacks {
+		if isBuildpackArchive(buildpack) {
+			actions = append(actions, customBuildpackDownload(
+				buildpack,
+				lifecycleData.BuildpackChecksums[buildpack.Url],
+				builderConfig.BuildpackPath(buildpackKey(i, buildpack)),
+			))
+		}
+	}
+
 	cachedDependencies := []*models.CachedDependency{}
 	//Download builder
 	cachedDependencies = append(
@@ -96,26 +167,34 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 
 	//Download buildpacks
 	for _, buildpack := range lifecycleData.Buildpacks {
-		if buildpack.Name != cc_messages.CUSTOM_BUILDPACK {
-			cachedDependencies = append(
-				cachedDependencies,
-				&models.CachedDependency{
-					Name:     buildpack.Name,
-					From:     buildpack.Url,
-					To:       builderConfig.BuildpackPath(buildpack.Key),
-					CacheKey: buildpack.Key,
-				},
-			)
+		if buildpack.Name == cc_messages.CUSTOM_BUILDPACK {
+			continue
+		}
+
+		checksum := lifecycleData.BuildpackChecksums[buildpack.Url]
+		if backend.config.DisableBuildpackCaching {
+			actions = append(actions, adminBuildpackDownload(buildpack, checksum, builderConfig.BuildpackPath(buildpack.Key)))
+			continue
 		}
+
+		cachedDependencies = append(
+			cachedDependencies,
+			adminBuildpackDependency(buildpack, checksum, builderConfig.BuildpackPath(buildpack.Key)),
+		)
 	}
 
 	//Download buildpack artifacts cache
+	if lifecycleData.DisableBuildArtifactsCache {
+		logger.Info("build-artifacts-cache-disabled")
+		cachelessStagingRequests.Increment()
+	}
+
 	downloadURL, err := backend.buildArtifactsDownloadURL(lifecycleData)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
 
-	if downloadURL != nil {
+	if downloadURL != nil && !lifecycleData.DisableBuildArtifactsCache {
 		downloadAction := models.Try(
 			&models.DownloadAction{
 				Artifact: "build artifacts cache",
@@ -127,21 +206,36 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		actions = append(actions, downloadAction)
 	}
 
-	fileDescriptorLimit := uint64(request.FileDescriptors)
+	var resourceLimits *models.ResourceLimits
+	if !backend.windows {
+		fileDescriptorLimit := uint64(request.FileDescriptors)
+		resourceLimits = &models.ResourceLimits{Nofile: &fileDescriptorLimit}
+	}
 
 	//Run Builder
-	runEnv := append(request.Environment, &models.EnvironmentVariable{"CF_STACK", lifecycleData.Stack})
+	runEnv := stagingEnvironment(backend.config.StagingEnvironmentGroup, lifecycleData.StagingEnvironmentGroup, request.Environment)
+	runEnv = append(runEnv, &models.EnvironmentVariable{"CF_STACK", lifecycleData.Stack})
+	if lifecycleData.Verbose {
+		runEnv = append(runEnv, &models.EnvironmentVariable{BuildpackDebugEnvVar, "true"})
+	}
+
+	if backend.config.DetectTimeout > 0 && !backend.windows && !skipDetect(lifecycleData) {
+		buildpackPaths := []string{}
+		for _, key := range buildpacksOrder {
+			buildpackPaths = append(buildpackPaths, builderConfig.BuildpackPath(key))
+		}
+		actions = append(actions, detectTimeoutAction(builderConfig.BuildDir(), buildpackPaths, runEnv, backend.config.DetectTimeout))
+	}
+
 	actions = append(
 		actions,
 		models.EmitProgressFor(
 			&models.RunAction{
-				User: "vcap",
-				Path: builderConfig.Path(),
-				Args: builderConfig.Args(),
-				Env:  runEnv,
-				ResourceLimits: &models.ResourceLimits{
-					Nofile: &fileDescriptorLimit,
-				},
+				User:           "vcap",
+				Path:           builderConfig.Path(),
+				Args:           builderArgs,
+				Env:            runEnv,
+				ResourceLimits: resourceLimits,
 			},
 			"Staging...",
 			"Staging complete",
@@ -149,10 +243,20 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 		),
 	)
 
+	//Checksum Droplet
+	dropletChecksums := backend.config.DropletChecksums && !backend.windows
+	if dropletChecksums {
+		actions = append(actions, dropletChecksumsAction(builderConfig.OutputDroplet(), builderConfig.OutputMetadata()))
+	}
+
+	if artifactByteLimit > 0 {
+		actions = append(actions, artifactByteLimitAction("droplet", builderConfig.OutputDroplet(), artifactByteLimit))
+	}
+
 	//Upload Droplet
 	uploadActions := []models.ActionInterface{}
 	uploadNames := []string{}
-	uploadURL, err := backend.dropletUploadURL(request, lifecycleData)
+	uploadURL, err := backend.dropletUploadURL(request, lifecycleData, timeout)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
@@ -169,72 +273,92 @@ func (backend *traditionalBackend) BuildRecipe(stagingGuid string, request cc_me
 	uploadNames = append(uploadNames, "droplet")
 
 	//Upload Buildpack Artifacts Cache
-	uploadURL, err = backend.buildArtifactsUploadURL(request, lifecycleData)
-	if err != nil {
-		return &models.TaskDefinition{}, "", "", err
-	}
+	if !lifecycleData.DisableBuildArtifactsCache {
+		uploadURL, err = backend.buildArtifactsUploadURL(request, lifecycleData)
+		if err != nil {
+			return &models.TaskDefinition{}, "", "", err
+		}
 
-	uploadActions = append(uploadActions,
-		models.Try(
-			&models.UploadAction{
-				Artifact: "build artifacts cache",
-				From:     builderConfig.OutputBuildArtifactsCache(), // get the compressed build artifacts cache
-				To:       addTimeoutParamToURL(*uploadURL, timeout).String(),
-				User:     "vcap",
-			},
-		),
-	)
-	uploadNames = append(uploadNames, "build artifacts cache")
+		uploadActions = append(uploadActions,
+			models.Try(
+				&models.UploadAction{
+					Artifact: "build artifacts cache",
+					From:     builderConfig.OutputBuildArtifactsCache(), // get the compressed build artifacts cache
+					To:       addTimeoutParamToURL(*uploadURL, timeout).String(),
+					User:     "vcap",
+				},
+			),
+		)
+		uploadNames = append(uploadNames, "build artifacts cache")
+	}
 
 	uploadMsg := fmt.Sprintf("Uploading %s...", strings.Join(uploadNames, ", "))
 	actions = append(actions, models.EmitProgressFor(models.Parallel(uploadActions...), uploadMsg, "Uploading complete", "Uploading failed"))
 
-	annotationJson, _ := json.Marshal(cc_messages.StagingTaskAnnotation{
-		Lifecycle:          TraditionalLifecycleName,
-		CompletionCallback: request.CompletionCallback,
+	annotation, err := EncodeAnnotation(StagingTaskAnnotation{
+		StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{
+			Lifecycle:          backend.lifecycleName(),
+			CompletionCallback: request.CompletionCallback,
+		},
+		AppId:            request.AppId,
+		Stack:            lifecycleData.Stack,
+		HealthCheck:      lifecycleData.HealthCheck,
+		DropletChecksums: dropletChecksums,
 	})
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
 
 	taskDefinition := &models.TaskDefinition{
-		RootFs:                        models.PreloadedRootFS(lifecycleData.Stack),
+		RootFs:                        backend.config.RootFS(lifecycleData.Stack),
+		PlacementTags:                 lifecycleData.Placement.Tags(),
 		ResultFile:                    builderConfig.OutputMetadata(),
-		MemoryMb:                      int32(request.MemoryMB),
-		DiskMb:                        int32(request.DiskMB),
-		CpuWeight:                     uint32(StagingTaskCpuWeight),
+		MemoryMb:                      memoryMB,
+		DiskMb:                        diskMB,
+		CpuWeight:                     stagingCpuWeight(backend.config, memoryMB),
 		CachedDependencies:            cachedDependencies,
 		Action:                        models.WrapAction(models.Timeout(models.Serial(actions...), timeout)),
 		LogGuid:                       request.LogGuid,
 		LogSource:                     TaskLogSource,
 		CompletionCallbackUrl:         backend.config.CallbackURL(stagingGuid),
 		EgressRules:                   request.EgressRules,
-		Annotation:                    string(annotationJson),
+		Annotation:                    annotation,
 		Privileged:                    backend.config.PrivilegedContainers,
 		EnvironmentVariables:          []*models.EnvironmentVariable{{"LANG", DefaultLANG}},
 		LegacyDownloadUser:            "vcap",
 		TrustedSystemCertificatesPath: TrustedSystemCertificatesPath,
 	}
+	if backend.windows {
+		adaptTaskForWindows(taskDefinition)
+	}
 
 	logger.Debug("staging-task-request")
 
-	return taskDefinition, stagingGuid, backend.config.TaskDomain, nil
+	return taskDefinition, stagingGuid, backend.taskDomain(), nil
 }
 
 func (backend *traditionalBackend) BuildStagingResponse(taskResponse *models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error) {
-	var response cc_messages.StagingResponseForCC
+	return buildStagingResponse(backend.config.Sanitizer, buildpackResultSchema, backend.config.MaxResultBytes, taskResponse)
+}
+
+// appBitsURL is the URL the staging task downloads the app bits from.
+func (backend *traditionalBackend) appBitsURL(buildpackData buildpackStagingData) (string, error) {
+	if backend.config.AppBitsURLBuilder == nil {
+		return buildpackData.AppBitsDownloadUri, nil
+	}
 
-	if taskResponse.Failed {
-		response.Error = backend.config.Sanitizer(taskResponse.FailureReason)
-	} else {
-		result := json.RawMessage([]byte(taskResponse.Result))
-		response.Result = &result
+	appBitsURL, err := backend.config.AppBitsURLBuilder.AppBitsURL(buildpackData.AppBitsDownloadUri)
+	if err != nil {
+		return "", fmt.Errorf("failed to build app bits download URL: %s", err)
 	}
 
-	return response, nil
+	return appBitsURL, nil
 }
 
-func (backend *traditionalBackend) compilerDownloadURL(request cc_messages.StagingRequestFromCC, buildpackData cc_messages.BuildpackStagingData) (*url.URL, error) {
+func (backend *traditionalBackend) compilerDownloadURL(request cc_messages.StagingRequestFromCC, buildpackData buildpackStagingData) (*url.URL, error) {
 	compilerPath, ok := backend.config.Lifecycles[request.Lifecycle+"/"+buildpackData.Stack]
 	if !ok {
-		return nil, ErrNoCompilerDefined
+		return nil, &UnknownStackError{Stack: buildpackData.Stack, Stacks: backend.config.Stacks(request.Lifecycle)}
 	}
 
 	parsed, err := url.Parse(compilerPath)
@@ -261,7 +385,7 @@ func (backend *traditionalBackend) compilerDownloadURL(request cc_messages.Stagi
 	return url, nil
 }
 
-func (backend *traditionalBackend) dropletUploadURL(request cc_messages.StagingRequestFromCC, buildpackData cc_messages.BuildpackStagingData) (*url.URL, error) {
+func (backend *traditionalBackend) dropletUploadURL(request cc_messages.StagingRequestFromCC, buildpackData buildpackStagingData, timeout time.Duration) (*url.URL, error) {
 	path, err := ccuploader.Routes.CreatePathForRoute(ccuploader.UploadDropletRoute, rata.Params{
 		"guid": request.AppId,
 	})
@@ -276,14 +400,23 @@ func (backend *traditionalBackend) dropletUploadURL(request cc_messages.StagingR
 		return nil, fmt.Errorf("failed to parse droplet upload URL: %s", err)
 	}
 
+	dropletUploadUri := buildpackData.DropletUploadUri
+	if backend.config.DropletUploadSigningKey != "" {
+		expires := backend.config.Clock.Now().Add(timeout + backend.config.DropletUploadURLSlack)
+		dropletUploadUri, err = SignUploadURI(dropletUploadUri, []byte(backend.config.DropletUploadSigningKey), expires)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign droplet upload URI: %s", err)
+		}
+	}
+
 	values := make(url.Values, 1)
-	values.Add(cc_messages.CcDropletUploadUriKey, buildpackData.DropletUploadUri)
+	values.Add(cc_messages.CcDropletUploadUriKey, dropletUploadUri)
 	u.RawQuery = values.Encode()
 
 	return u, nil
 }
 
-func (backend *traditionalBackend) buildArtifactsUploadURL(request cc_messages.StagingRequestFromCC, buildpackData cc_messages.BuildpackStagingData) (*url.URL, error) {
+func (backend *traditionalBackend) buildArtifactsUploadURL(request cc_messages.StagingRequestFromCC, buildpackData buildpackStagingData) (*url.URL, error) {
 	path, err := ccuploader.Routes.CreatePathForRoute(ccuploader.UploadBuildArtifactsRoute, rata.Params{
 		"app_guid": request.AppId,
 	})
@@ -305,7 +438,7 @@ func (backend *traditionalBackend) buildArtifactsUploadURL(request cc_messages.S
 	return u, nil
 }
 
-func (backend *traditionalBackend) buildArtifactsDownloadURL(buildpackData cc_messages.BuildpackStagingData) (*url.URL, error) {
+func (backend *traditionalBackend) buildArtifactsDownloadURL(buildpackData buildpackStagingData) (*url.URL, error) {
 	urlString := buildpackData.BuildArtifactsCacheDownloadUri
 	if urlString == "" {
 		return nil, nil
@@ -319,7 +452,29 @@ func (backend *traditionalBackend) buildArtifactsDownloadURL(buildpackData cc_me
 	return url, nil
 }
 
-func (backend *traditionalBackend) validateRequest(stagingRequest cc_messages.StagingRequestFromCC, buildpackData cc_messages.BuildpackStagingData) error {
+// skipDetect is true when the user picked the buildpacks to stage with. With
+// more than one buildpack, the builder then runs the supply phase of every
+// buildpack in order and the finalize phase of the last one. Without
+// buildpacks there is nothing to stage with but what detection finds.
+func skipDetect(lifecycleData buildpackStagingData) bool {
+	if len(lifecycleData.Buildpacks) == 0 {
+		return false
+	}
+
+	if lifecycleData.SkipDetect {
+		return true
+	}
+
+	for _, buildpack := range lifecycleData.Buildpacks {
+		if !buildpack.SkipDetect {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (backend *traditionalBackend) validateRequest(stagingRequest cc_messages.StagingRequestFromCC, buildpackData buildpackStagingData) error {
 	if len(stagingRequest.AppId) == 0 {
 		return ErrMissingAppId
 	}
@@ -328,18 +483,24 @@ func (backend *traditionalBackend) validateRequest(stagingRequest cc_messages.St
 		return ErrMissingAppBitsDownloadUri
 	}
 
-	return nil
-}
+	if len(buildpackData.Buildpacks) > MaxBuildpacks {
+		return ErrTooManyBuildpacks
+	}
 
-func traditionalTimeout(request cc_messages.StagingRequestFromCC, logger lager.Logger) time.Duration {
-	if request.Timeout > 0 {
-		return time.Duration(request.Timeout) * time.Second
-	} else {
-		logger.Info("overriding requested timeout", lager.Data{
-			"requested-timeout": request.Timeout,
-			"default-timeout":   DefaultStagingTimeout,
-			"app-id":            request.AppId,
-		})
-		return DefaultStagingTimeout
+	err := validateEgressRules(stagingRequest.EgressRules)
+	if err != nil {
+		return err
 	}
+
+	err = validateBuildpackChecksums(buildpackData.BuildpackChecksums)
+	if err != nil {
+		return err
+	}
+
+	return validateTransferURLs(
+		backend.config,
+		backend.config.CCUploaderURL,
+		buildpackData.AppBitsDownloadUri,
+		buildpackData.BuildArtifactsCacheDownloadUri,
+	)
 }
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+const BuildpackChecksumAlgorithm = "sha256"
+
+var buildpackArchiveSuffixes = []string{".zip", ".tgz", ".tar.gz"}
+
+// InvalidBuildpackChecksumError is returned for staging requests carrying a
+// checksum for a custom buildpack that is not a sha256 hex digest.
+type InvalidBuildpackChecksumError struct {
+	Url string
+}
+
+func (e *InvalidBuildpackChecksumError) Error() string {
+	return fmt.Sprintf("%s: %s", diego_errors.INVALID_BUILDPACK_CHECKSUM_MESSAGE, e.Url)
+}
+
+// isBuildpackArchive reports whether a custom buildpack is delivered as an
+// archive rather than as a git repository.
+func isBuildpackArchive(buildpack cc_messages.Buildpack) bool {
+	if buildpack.Name != cc_messages.CUSTOM_BUILDPACK {
+		return false
+	}
+
+	u, err := url.Parse(buildpack.Url)
+	if err != nil {
+		return false
+	}
+
+	for _, suffix := range buildpackArchiveSuffixes {
+		if strings.HasSuffix(strings.ToLower(u.Path), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildpackKey returns the key the builder knows a buildpack by. Archive
+// custom buildpacks are downloaded by the recipe, so the builder must not
+// see their URL and fetch them again.
+func buildpackKey(index int, buildpack cc_messages.Buildpack) string {
+	if isBuildpackArchive(buildpack) {
+		return fmt.Sprintf("custom-buildpack-%d", index)
+	}
+	return buildpack.Key
+}
+
+// customBuildpackDownload returns the action downloading an archive custom
+// buildpack to the builder's buildpack path, verifying its checksum if the
+// staging request has one for it.
+func customBuildpackDownload(buildpack cc_messages.Buildpack, checksum, to string) *models.DownloadAction {
+	action := &models.DownloadAction{
+		Artifact: "custom buildpack",
+		From:     buildpack.Url,
+		To:       to,
+		User:     "vcap",
+	}
+
+	if checksum != "" {
+		action.ChecksumAlgorithm = BuildpackChecksumAlgorithm
+		action.ChecksumValue = strings.ToLower(checksum)
+	}
+
+	return action
+}
+
+// adminBuildpackDependency returns the cached dependency for an admin
+// buildpack. Its cache key includes the checksum of the buildpack, if the
+// staging request has one for it.
+func adminBuildpackDependency(buildpack cc_messages.Buildpack, checksum, to string) *models.CachedDependency {
+	dependency := &models.CachedDependency{
+		Name:     buildpack.Name,
+		From:     buildpack.Url,
+		To:       to,
+		CacheKey: buildpack.Key,
+	}
+
+	if checksum != "" {
+		dependency.CacheKey = fmt.Sprintf("%s-%s", buildpack.Key, strings.ToLower(checksum))
+		dependency.ChecksumAlgorithm = BuildpackChecksumAlgorithm
+		dependency.ChecksumValue = strings.ToLower(checksum)
+	}
+
+	return dependency
+}
+
+// adminBuildpackDownload returns the action downloading an admin buildpack
+// when buildpack caching is disabled.
+func adminBuildpackDownload(buildpack cc_messages.Buildpack, checksum, to string) *models.DownloadAction {
+	action := &models.DownloadAction{
+		Artifact: buildpack.Name,
+		From:     buildpack.Url,
+		To:       to,
+		User:     "vcap",
+	}
+
+	if checksum != "" {
+		action.ChecksumAlgorithm = BuildpackChecksumAlgorithm
+		action.ChecksumValue = strings.ToLower(checksum)
+	}
+
+	return action
+}
+
+func validateBuildpackChecksums(checksums map[string]string) error {
+	for buildpackURL, checksum := range checksums {
+		decoded, err := hex.DecodeString(checksum)
+		if err != nil || len(decoded) != 32 {
+			return &InvalidBuildpackChecksumError{Url: buildpackURL}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+// detectTimeoutScript runs the detect script of every buildpack already on
+// the cell until one of them detects the app. The builder detects again
+// itself, as it does not run detection apart from compiling; running it
+// first under its own timeout is what lets a hung detect script fail the
+// staging quickly instead of holding it for the whole staging timeout.
+// Custom buildpacks the builder clones from git are not on the cell yet, so
+// their detect scripts are not bounded here.
+const detectTimeoutScript = `app=$1
+shift
+for buildpack in "$@"; do
+  if [ -x "$buildpack/bin/detect" ] && "$buildpack/bin/detect" "$app" >/dev/null 2>&1; then
+    exit 0
+  fi
+done`
+
+// detectTimeoutAction fails the staging with DETECT_TIMEOUT_MESSAGE when
+// detecting the buildpack of the app takes longer than timeout.
+func detectTimeoutAction(buildDir string, buildpackPaths []string, env []*models.EnvironmentVariable, timeout time.Duration) models.ActionInterface {
+	args := append([]string{"-c", detectTimeoutScript, "detect-timeout", buildDir}, buildpackPaths...)
+
+	return models.EmitProgressFor(
+		models.Timeout(
+			&models.RunAction{
+				Path: "/bin/sh",
+				Args: args,
+				Env:  env,
+				User: "vcap",
+			},
+			timeout,
+		),
+		"",
+		"",
+		diego_errors.DETECT_TIMEOUT_MESSAGE,
+	)
+}
@@ -10,12 +10,12 @@ import (
 	"net/url"
 	"path"
 	"strings"
-	"time"
 
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/stager/diego_errors"
+	"code.cloudfoundry.org/stager/docker_registry"
 	"github.com/cloudfoundry/gunk/urljoiner"
 )
 
@@ -24,12 +24,37 @@ const (
 	MountCgroupsPath            = "/tmp/docker_app_lifecycle/mount_cgroups"
 	DockerBuilderExecutablePath = "/tmp/docker_app_lifecycle/builder"
 	DockerBuilderOutputPath     = "/tmp/docker-result/result.json"
+
+	// The docker builder reads the registry credentials of private images
+	// from these environment variables. The docker lifecycle configured with
+	// -lifecycle must be one whose builder reads them, as the credentials are
+	// no longer passed as -dockerUser, -dockerPassword and -dockerEmail.
+	DockerLoginServerEnvVar = "DOCKER_LOGIN_SERVER"
+	DockerUserEnvVar        = "DOCKER_USER"
+	DockerPasswordEnvVar    = "DOCKER_PASSWORD"
+	DockerEmailEnvVar       = "DOCKER_EMAIL"
 )
 
 var ErrMissingDockerImageUrl = errors.New(diego_errors.MISSING_DOCKER_IMAGE_URL)
 var ErrMissingDockerRegistry = errors.New(diego_errors.MISSING_DOCKER_REGISTRY)
 var ErrMissingDockerCredentials = errors.New(diego_errors.MISSING_DOCKER_CREDENTIALS)
 var ErrInvalidDockerRegistryAddress = errors.New(diego_errors.INVALID_DOCKER_REGISTRY_ADDRESS)
+var ErrDockerImageNotFound = errors.New(diego_errors.DOCKER_IMAGE_NOT_FOUND_MESSAGE)
+var ErrInvalidDockerImageDigest = errors.New(diego_errors.INVALID_DOCKER_IMAGE_DIGEST_MESSAGE)
+
+// dockerStagingData extends the lifecycle data sent by CC with staging
+// options that are specific to this stager.
+type dockerStagingData struct {
+	cc_messages.DockerStagingData
+
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+
+	// StagingEnvironmentGroup is the staging environment variable group
+	// configured in CC, merged beneath the app's own environment.
+	StagingEnvironmentGroup []*models.EnvironmentVariable `json:"staging_env_group,omitempty"`
+
+	Placement
+}
 
 type dockerBackend struct {
 	config Config
@@ -51,8 +76,8 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 	logger := backend.logger.Session("build-recipe", lager.Data{"app-id": request.AppId, "staging-guid": stagingGuid})
 	logger.Info("staging-request")
 
-	var lifecycleData cc_messages.DockerStagingData
-	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
+	var lifecycleData dockerStagingData
+	err := DecodeLifecycleData(request, &lifecycleData)
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
 	}
@@ -62,6 +87,28 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 		return &models.TaskDefinition{}, "", "", err
 	}
 
+	memoryMB, diskMB, err := stagingResources(backend.config, request, logger)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	digest, err := backend.checkImage(logger, lifecycleData)
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
+
+	ref, err := docker_registry.ParseDockerRef(lifecycleData.DockerImageUrl)
+	if err == nil && docker_registry.InsecureRegistry(ref, backend.config.InsecureDockerRegistries) {
+		logger.Info("staging-from-insecure-registry", lager.Data{"registry": ref.Registry})
+	}
+
+	// Pin the builder to the digest that was checked, so that the image
+	// reported to CC is the one that was staged even if the tag moves.
+	dockerRef := lifecycleData.DockerImageUrl
+	if digest != "" {
+		dockerRef = docker_registry.PinnedRef(dockerRef, digest)
+	}
+
 	compilerURL, err := backend.compilerDownloadURL()
 	if err != nil {
 		return &models.TaskDefinition{}, "", "", err
@@ -77,27 +124,28 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 
 	runActionArguments := []string{
 		"-outputMetadataJSONFilename", DockerBuilderOutputPath,
-		"-dockerRef", lifecycleData.DockerImageUrl,
+		"-dockerRef", dockerRef,
 	}
 
 	if len(backend.config.InsecureDockerRegistries) > 0 {
 		insecureDockerRegistries := strings.Join(backend.config.InsecureDockerRegistries, ",")
 		runActionArguments = append(runActionArguments, "-insecureDockerRegistries", insecureDockerRegistries)
 	}
+	runActionArguments = append(runActionArguments, backend.config.BuilderArgs[DockerLifecycleName]...)
 
+	env := stagingEnvironment(backend.config.StagingEnvironmentGroup, lifecycleData.StagingEnvironmentGroup, request.Environment)
 	fileDescriptorLimit := uint64(request.FileDescriptors)
 	runAs := "vcap"
 
 	actions := []models.ActionInterface{}
 
-	if cacheDockerImage(request.Environment) {
+	if cacheDockerImage(env) {
 		runAs = "root"
 
 		additionalEgressRules, additionalArgs, err := cachingEgressRulesAndArgs(
 			logger,
 			backend.config.DockerRegistryAddress,
 			backend.config.ConsulCluster,
-			lifecycleData,
 		)
 		if err != nil {
 			return &models.TaskDefinition{}, "", "", err
@@ -129,7 +177,7 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 			&models.RunAction{
 				Path: DockerBuilderExecutablePath,
 				Args: runActionArguments,
-				Env:  request.Environment,
+				Env:  builderEnvironment(env, lifecycleData.DockerStagingData),
 				ResourceLimits: &models.ResourceLimits{
 					Nofile: &fileDescriptorLimit,
 				},
@@ -141,23 +189,34 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 		),
 	)
 
-	annotationJson, _ := json.Marshal(cc_messages.StagingTaskAnnotation{
-		Lifecycle:          DockerLifecycleName,
-		CompletionCallback: request.CompletionCallback,
+	annotation, err := EncodeAnnotation(StagingTaskAnnotation{
+		StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{
+			Lifecycle:          DockerLifecycleName,
+			CompletionCallback: request.CompletionCallback,
+		},
+		AppId:             request.AppId,
+		Stack:             backend.config.DockerStagingStack,
+		HealthCheck:       lifecycleData.HealthCheck,
+		DockerImageDigest: digest,
 	})
+	if err != nil {
+		return &models.TaskDefinition{}, "", "", err
+	}
 
 	taskDefinition := &models.TaskDefinition{
-		RootFs:                        models.PreloadedRootFS(backend.config.DockerStagingStack),
+		RootFs:                        backend.config.RootFS(backend.config.DockerStagingStack),
+		PlacementTags:                 lifecycleData.Placement.Tags(),
 		ResultFile:                    DockerBuilderOutputPath,
 		Privileged:                    backend.config.PrivilegedContainers,
-		MemoryMb:                      int32(request.MemoryMB),
+		MemoryMb:                      memoryMB,
+		CpuWeight:                     stagingCpuWeight(backend.config, memoryMB),
 		LogSource:                     TaskLogSource,
 		LogGuid:                       request.LogGuid,
 		EgressRules:                   request.EgressRules,
-		DiskMb:                        int32(request.DiskMB),
+		DiskMb:                        diskMB,
 		CompletionCallbackUrl:         backend.config.CallbackURL(stagingGuid),
-		Annotation:                    string(annotationJson),
-		Action:                        models.WrapAction(models.Timeout(models.Serial(actions...), dockerTimeout(request, backend.logger))),
+		Annotation:                    annotation,
+		Action:                        models.WrapAction(models.Timeout(models.Serial(actions...), stagingTimeout(backend.config, request, backend.logger))),
 		CachedDependencies:            cachedDependencies,
 		LegacyDownloadUser:            "vcap",
 		TrustedSystemCertificatesPath: TrustedSystemCertificatesPath,
@@ -168,16 +227,7 @@ func (backend *dockerBackend) BuildRecipe(stagingGuid string, request cc_message
 }
 
 func (backend *dockerBackend) BuildStagingResponse(taskResponse *models.TaskCallbackResponse) (cc_messages.StagingResponseForCC, error) {
-	var response cc_messages.StagingResponseForCC
-
-	if taskResponse.Failed {
-		response.Error = backend.config.Sanitizer(taskResponse.FailureReason)
-	} else {
-		result := json.RawMessage([]byte(taskResponse.Result))
-		response.Result = &result
-	}
-
-	return response, nil
+	return buildStagingResponse(backend.config.Sanitizer, dockerResultSchema, backend.config.MaxResultBytes, taskResponse)
 }
 
 func (backend *dockerBackend) compilerDownloadURL() (*url.URL, error) {
@@ -210,7 +260,7 @@ func (backend *dockerBackend) compilerDownloadURL() (*url.URL, error) {
 	return url, nil
 }
 
-func (backend *dockerBackend) validateRequest(stagingRequest cc_messages.StagingRequestFromCC, dockerData cc_messages.DockerStagingData) error {
+func (backend *dockerBackend) validateRequest(stagingRequest cc_messages.StagingRequestFromCC, dockerData dockerStagingData) error {
 	if len(stagingRequest.AppId) == 0 {
 		return ErrMissingAppId
 	}
@@ -219,25 +269,53 @@ func (backend *dockerBackend) validateRequest(stagingRequest cc_messages.Staging
 		return ErrMissingDockerImageUrl
 	}
 
+	ref, err := docker_registry.ParseDockerRef(dockerData.DockerImageUrl)
+	if err == nil && ref.IsDigest() && docker_registry.ValidateDigest(ref.Reference) != nil {
+		return ErrInvalidDockerImageDigest
+	}
+
 	credentialsPresent := (len(dockerData.DockerUser) + len(dockerData.DockerPassword) + len(dockerData.DockerEmail)) > 0
 	if credentialsPresent && (len(dockerData.DockerUser) == 0 || len(dockerData.DockerPassword) == 0 || len(dockerData.DockerEmail) == 0) {
 		return ErrMissingDockerCredentials
 	}
 
-	return nil
+	return validateEgressRules(stagingRequest.EgressRules)
 }
 
-func dockerTimeout(request cc_messages.StagingRequestFromCC, logger lager.Logger) time.Duration {
-	if request.Timeout > 0 {
-		return time.Duration(request.Timeout) * time.Second
-	} else {
-		logger.Info("overriding requested timeout", lager.Data{
-			"requested-timeout": request.Timeout,
-			"default-timeout":   DefaultStagingTimeout,
-			"app-id":            request.AppId,
-		})
-		return DefaultStagingTimeout
+// checkImage fails fast when the registry reports that the image does not
+// exist, and returns the digest of the image when it is known. Any other
+// failure to fetch the image metadata is left for the builder to report.
+func (backend *dockerBackend) checkImage(logger lager.Logger, dockerData dockerStagingData) (string, error) {
+	var digest string
+	ref, err := docker_registry.ParseDockerRef(dockerData.DockerImageUrl)
+	if err == nil && ref.IsDigest() {
+		digest = ref.Reference
+	}
+
+	if backend.config.ImageMetadataClient == nil {
+		return digest, nil
+	}
+
+	credentials := docker_registry.Credentials{
+		Username: dockerData.DockerUser,
+		Password: dockerData.DockerPassword,
+	}
+
+	metadata, err := backend.config.ImageMetadataClient.ImageMetadata(logger, dockerData.DockerImageUrl, credentials)
+	if err == docker_registry.ErrImageNotFound {
+		return "", ErrDockerImageNotFound
 	}
+	if err != nil {
+		logger.Info("skipping-image-check", lager.Data{"error": err.Error()})
+		return digest, nil
+	}
+
+	logger.Info("image-found", lager.Data{"exposed-ports": metadata.ExposedPorts, "digest": metadata.Digest})
+	if metadata.Digest != "" {
+		digest = metadata.Digest
+	}
+
+	return digest, nil
 }
 
 func getDockerRegistryServices(consulCluster string, backendLogger lager.Logger) ([]consulServiceInfo, error) {
@@ -273,7 +351,6 @@ func cachingEgressRulesAndArgs(
 	logger lager.Logger,
 	dockerRegistryAddress string,
 	consulCluster string,
-	stagingData cc_messages.DockerStagingData,
 ) ([]*models.SecurityGroupRule, []string, error) {
 	host, port, err := net.SplitHostPort(dockerRegistryAddress)
 	if err != nil {
@@ -309,17 +386,29 @@ func cachingEgressRulesAndArgs(
 		strings.Join(registryIPs, ","),
 	}
 
+	return egressRules, args, nil
+}
+
+// builderEnvironment passes the registry credentials to the builder through
+// its environment rather than its arguments, which end up in the task
+// definition and the process list of the cell.
+func builderEnvironment(env []*models.EnvironmentVariable, stagingData cc_messages.DockerStagingData) []*models.EnvironmentVariable {
+	builderEnv := make([]*models.EnvironmentVariable, len(env), len(env)+4)
+	copy(builderEnv, env)
+
 	if len(stagingData.DockerLoginServer) > 0 {
-		args = append(args, "-dockerLoginServer", stagingData.DockerLoginServer)
+		builderEnv = append(builderEnv, &models.EnvironmentVariable{Name: DockerLoginServerEnvVar, Value: stagingData.DockerLoginServer})
 	}
 
 	if len(stagingData.DockerUser) > 0 {
-		args = append(args, "-dockerUser", stagingData.DockerUser,
-			"-dockerPassword", stagingData.DockerPassword,
-			"-dockerEmail", stagingData.DockerEmail)
+		builderEnv = append(builderEnv,
+			&models.EnvironmentVariable{Name: DockerUserEnvVar, Value: stagingData.DockerUser},
+			&models.EnvironmentVariable{Name: DockerPasswordEnvVar, Value: stagingData.DockerPassword},
+			&models.EnvironmentVariable{Name: DockerEmailEnvVar, Value: stagingData.DockerEmail},
+		)
 	}
 
-	return egressRules, args, nil
+	return builderEnv
 }
 
 func cacheDockerImage(env []*models.EnvironmentVariable) bool {
@@ -2,6 +2,7 @@ package backend_test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,6 +12,8 @@ import (
 	"code.cloudfoundry.org/lager/lagertest"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/docker_registry"
+	registry_fakes "code.cloudfoundry.org/stager/docker_registry/fakes"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -157,11 +160,10 @@ var _ = Describe("DockerBackend", func() {
 			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
 
-			var annotation cc_messages.StagingTaskAnnotation
-			err = json.Unmarshal([]byte(taskDef.Annotation), &annotation)
+			annotation, err := backend.DecodeAnnotation(taskDef.Annotation)
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(annotation).To(Equal(cc_messages.StagingTaskAnnotation{
+			Expect(annotation.StagingTaskAnnotation).To(Equal(cc_messages.StagingTaskAnnotation{
 				Lifecycle:          "docker",
 				CompletionCallback: "https://api.cc.com/v1/staging/some-staging-guid/droplet_completed",
 			}))
@@ -237,6 +239,12 @@ var _ = Describe("DockerBackend", func() {
 			Expect(taskDef.DiskMb).To(Equal(diskMb))
 		})
 
+		It("sets the task CpuWeight", func() {
+			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(taskDef.CpuWeight).To(Equal(backend.StagingTaskCpuWeight))
+		})
+
 		It("sets the task EgressRules", func() {
 			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
@@ -259,6 +267,20 @@ var _ = Describe("DockerBackend", func() {
 			Expect(taskDef.RootFs).To(Equal(models.PreloadedRootFS("penguin")))
 		})
 
+		Context("when a rootfs is configured for the Docker staging stack", func() {
+			BeforeEach(func() {
+				config.StackRootFSes = map[string]string{"penguin": "preloaded:penguin-v2"}
+				docker = backend.NewDockerBackend(config, logger)
+			})
+
+			It("sets the task RootFS to it", func() {
+				taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(taskDef.RootFs).To(Equal("preloaded:penguin-v2"))
+			})
+		})
+
 		It("sets the task CompletionCallbackURL", func() {
 			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
 			Expect(err).NotTo(HaveOccurred())
@@ -273,6 +295,26 @@ var _ = Describe("DockerBackend", func() {
 			Expect(taskDef.TrustedSystemCertificatesPath).To(Equal(backend.TrustedSystemCertificatesPath))
 		})
 
+		Context("when the staging request declares an isolation segment", func() {
+			JustBeforeEach(func() {
+				setLifecycleDataField(&stagingRequest, "isolation_segment", "segment-a")
+			})
+
+			It("constrains the task placement to the segment", func() {
+				taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(taskDef.PlacementTags).To(Equal([]string{"segment-a"}))
+			})
+		})
+
+		It("does not constrain the task placement by default", func() {
+			taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(taskDef.PlacementTags).To(BeEmpty())
+		})
+
 		Context("with a missing app id", func() {
 			BeforeEach(func() {
 				appID = ""
@@ -284,6 +326,14 @@ var _ = Describe("DockerBackend", func() {
 			})
 		})
 
+		Context("with missing lifecycle data", func() {
+			It("returns an error", func() {
+				stagingRequest.LifecycleData = nil
+				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).To(Equal(backend.ErrMissingLifecycleData))
+			})
+		})
+
 		Context("with a missing docker image url", func() {
 			BeforeEach(func() {
 				dockerImageUrl = ""
@@ -295,6 +345,176 @@ var _ = Describe("DockerBackend", func() {
 			})
 		})
 
+		Context("with an image digest", func() {
+			BeforeEach(func() {
+				dockerImageUrl = "my.registry/app@sha256:abababababababababababababababababababababababababababababababab"
+			})
+
+			It("records the digest in the annotation", func() {
+				taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				annotation, err := backend.DecodeAnnotation(taskDef.Annotation)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(annotation.DockerImageDigest).To(Equal("sha256:abababababababababababababababababababababababababababababababab"))
+			})
+
+			Context("when the digest is malformed", func() {
+				BeforeEach(func() {
+					dockerImageUrl = "my.registry/app@sha256:abc"
+				})
+
+				It("returns an error", func() {
+					_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+					Expect(err).To(Equal(backend.ErrInvalidDockerImageDigest))
+				})
+			})
+		})
+
+		Context("with an invalid egress rule", func() {
+			JustBeforeEach(func() {
+				stagingRequest.EgressRules = append(stagingRequest.EgressRules, &models.SecurityGroupRule{
+					Protocol:     "bogus",
+					Destinations: []string{"0.0.0.0/0"},
+				})
+			})
+
+			It("returns an InvalidEgressRuleError", func() {
+				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).To(BeAssignableToTypeOf(&backend.InvalidEgressRuleError{}))
+				Expect(err.(*backend.InvalidEgressRuleError).Index).To(Equal(1))
+				Expect(backend.SanitizeErrorMessage(err.Error()).Id).To(Equal(backend.InvalidStagingRequest))
+			})
+		})
+
+		Context("with staging environment groups", func() {
+			BeforeEach(func() {
+				config.StagingEnvironmentGroup = map[string]string{"HTTP_PROXY": "operator-proxy", "NO_PROXY": "operator-no-proxy"}
+				docker = backend.NewDockerBackend(config, logger)
+			})
+
+			JustBeforeEach(func() {
+				setLifecycleDataField(&stagingRequest, "staging_env_group", []*models.EnvironmentVariable{
+					{Name: "HTTP_PROXY", Value: "cc-proxy"},
+					{Name: "VCAP_SERVICES", Value: "cc-services"},
+				})
+			})
+
+			It("merges the groups beneath the app environment", func() {
+				taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				actions := actionsFromTaskDef(taskDef)
+				runAction := actions[0].GetEmitProgressAction().Action.GetRunAction()
+				Expect(runAction.Env).To(Equal([]*models.EnvironmentVariable{
+					{Name: "HTTP_PROXY", Value: "cc-proxy"},
+					{Name: "NO_PROXY", Value: "operator-no-proxy"},
+					{Name: "VCAP_SERVICES", Value: "bar"},
+					{Name: "VCAP_APPLICATION", Value: "foo"},
+				}))
+			})
+		})
+
+		Context("with complete docker credentials", func() {
+			BeforeEach(func() {
+				dockerUser = "user"
+				dockerPassword = "password"
+				dockerEmail = "email@example.com"
+			})
+
+			It("passes the credentials to the builder through its environment", func() {
+				taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				actions := actionsFromTaskDef(taskDef)
+				runAction := actions[0].GetEmitProgressAction().Action.GetRunAction()
+
+				Expect(runAction.Env).To(ContainElement(&models.EnvironmentVariable{Name: "DOCKER_USER", Value: "user"}))
+				Expect(runAction.Env).To(ContainElement(&models.EnvironmentVariable{Name: "DOCKER_PASSWORD", Value: "password"}))
+				Expect(runAction.Env).To(ContainElement(&models.EnvironmentVariable{Name: "DOCKER_EMAIL", Value: "email@example.com"}))
+				Expect(runAction.Args).NotTo(ContainElement("password"))
+			})
+
+			It("does not add the credentials to the staging request environment", func() {
+				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(stagingRequest.Environment).To(HaveLen(2))
+			})
+		})
+
+		Context("when image metadata is checked against the registry", func() {
+			var fakeRegistryClient *registry_fakes.FakeClient
+
+			BeforeEach(func() {
+				dockerUser = "user"
+				dockerPassword = "password"
+				dockerEmail = "email@example.com"
+
+				fakeRegistryClient = &registry_fakes.FakeClient{}
+				fakeRegistryClient.ImageMetadataReturns(&docker_registry.ImageMetadata{ExposedPorts: []string{"8080/tcp"}}, nil)
+
+				config.ImageMetadataClient = fakeRegistryClient
+				docker = backend.NewDockerBackend(config, logger)
+			})
+
+			It("fetches the metadata of the image with the docker credentials", func() {
+				_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeRegistryClient.ImageMetadataCallCount()).To(Equal(1))
+				_, imageURL, credentials := fakeRegistryClient.ImageMetadataArgsForCall(0)
+				Expect(imageURL).To(Equal("busybox"))
+				Expect(credentials).To(Equal(docker_registry.Credentials{Username: "user", Password: "password"}))
+			})
+
+			Context("when the registry reports the digest of the image", func() {
+				BeforeEach(func() {
+					fakeRegistryClient.ImageMetadataReturns(&docker_registry.ImageMetadata{Digest: "sha256:abababababababababababababababababababababababababababababababab"}, nil)
+				})
+
+				It("pins the builder to the digest", func() {
+					taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+					Expect(err).NotTo(HaveOccurred())
+
+					actions := actionsFromTaskDef(taskDef)
+					runAction := actions[0].GetEmitProgressAction().Action.GetRunAction()
+					Expect(runAction.Args).To(ContainElement("busybox@sha256:abababababababababababababababababababababababababababababababab"))
+				})
+
+				It("records the digest in the annotation", func() {
+					taskDef, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+					Expect(err).NotTo(HaveOccurred())
+
+					annotation, err := backend.DecodeAnnotation(taskDef.Annotation)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(annotation.DockerImageDigest).To(Equal("sha256:abababababababababababababababababababababababababababababababab"))
+				})
+			})
+
+			Context("when the image does not exist", func() {
+				BeforeEach(func() {
+					fakeRegistryClient.ImageMetadataReturns(nil, docker_registry.ErrImageNotFound)
+				})
+
+				It("returns an error", func() {
+					_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+					Expect(err).To(Equal(backend.ErrDockerImageNotFound))
+				})
+			})
+
+			Context("when the registry cannot be reached", func() {
+				BeforeEach(func() {
+					fakeRegistryClient.ImageMetadataReturns(nil, errors.New("connection refused"))
+				})
+
+				It("leaves the image check to the builder", func() {
+					_, _, _, err := docker.BuildRecipe("staging-guid", stagingRequest)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+		})
+
 		Context("with password and email but no user", func() {
 			BeforeEach(func() {
 				dockerPassword = "password"
@@ -440,6 +660,47 @@ var _ = Describe("DockerBackend", func() {
 				}))
 			})
 
+			Context("when the result has no docker image", func() {
+				BeforeEach(func() {
+					taskResponse := &models.TaskCallbackResponse{
+						Result: `{"lifecycle_metadata": {}, "process_types": {"web": "start"}}`,
+					}
+
+					response, buildError = docker.BuildStagingResponse(taskResponse)
+					Expect(buildError).NotTo(HaveOccurred())
+				})
+
+				It("reports a malformed staging result instead", func() {
+					Expect(response).To(Equal(cc_messages.StagingResponseForCC{
+						Error: &cc_messages.StagingError{Message: "malformed staging result: missing lifecycle_metadata.docker_image was totally sanitized"},
+					}))
+				})
+			})
+
+			Context("when the digest of the image is annotated", func() {
+				BeforeEach(func() {
+					annotation, err := backend.EncodeAnnotation(backend.StagingTaskAnnotation{DockerImageDigest: "sha256:abababababababababababababababababababababababababababababababab"})
+					Expect(err).NotTo(HaveOccurred())
+
+					taskResponse := &models.TaskCallbackResponse{
+						Result:     string(stagingResultJson),
+						Annotation: annotation,
+					}
+
+					response, buildError = docker.BuildStagingResponse(taskResponse)
+					Expect(buildError).NotTo(HaveOccurred())
+				})
+
+				It("adds the digest to the lifecycle metadata", func() {
+					var result struct {
+						LifecycleMetadata map[string]string `json:"lifecycle_metadata"`
+					}
+					Expect(json.Unmarshal(*response.Result, &result)).To(Succeed())
+					Expect(result.LifecycleMetadata).To(HaveKeyWithValue("docker_image", "cloudfoundry/diego-docker-app"))
+					Expect(result.LifecycleMetadata).To(HaveKeyWithValue("docker_image_digest", "sha256:abababababababababababababababababababababababababababababababab"))
+				})
+			})
+
 			Context("with a failed task response", func() {
 				BeforeEach(func() {
 					taskResponse := &models.TaskCallbackResponse{
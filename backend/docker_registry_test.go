@@ -444,13 +444,13 @@ var _ = Describe("DockerBackend", func() {
 							"-dockerRegistryHost", dockerRegistryHost,
 							"-dockerRegistryPort", fmt.Sprintf("%d", dockerRegistryPort),
 							"-dockerRegistryIPs", strings.Join(dockerRegistryIPs, ","),
-							"-dockerLoginServer", loginServer,
-							"-dockerUser", user,
-							"-dockerPassword", password,
-							"-dockerEmail", email,
 						},
 						Env: []*models.EnvironmentVariable{
 							&models.EnvironmentVariable{Name: "DIEGO_DOCKER_CACHE", Value: "true"},
+							&models.EnvironmentVariable{Name: "DOCKER_LOGIN_SERVER", Value: loginServer},
+							&models.EnvironmentVariable{Name: "DOCKER_USER", Value: user},
+							&models.EnvironmentVariable{Name: "DOCKER_PASSWORD", Value: password},
+							&models.EnvironmentVariable{Name: "DOCKER_EMAIL", Value: email},
 						},
 						ResourceLimits: &models.ResourceLimits{
 							Nofile: &fileDescriptorLimit,
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+// dropletChecksumsScript computes the checksums of the droplet once it is
+// built and adds them to the staging result written by the builder, the
+// only output of the task the stager gets back.
+const dropletChecksumsScript = `set -e
+sha1=$(sha1sum "$1" | cut -d " " -f 1)
+sha256=$(sha256sum "$1" | cut -d " " -f 1)
+result=$(cat "$2")
+printf '%s,"droplet_checksums":{"sha1":"%s","sha256":"%s"}}' "${result%\}}" "$sha1" "$sha256" > "$2"`
+
+// DropletChecksums are the checksums of the droplet uploaded by a staging
+// task, reported to CC in the lifecycle metadata of the staging result so
+// that it can verify the droplet it received.
+type DropletChecksums struct {
+	Sha1   string `json:"sha1"`
+	Sha256 string `json:"sha256"`
+}
+
+func dropletChecksumsAction(dropletPath, resultPath string) models.ActionInterface {
+	return &models.RunAction{
+		Path: "/bin/sh",
+		Args: []string{"-c", dropletChecksumsScript, "droplet-checksums", dropletPath, resultPath},
+		User: "vcap",
+	}
+}
+
+// moveDropletChecksums checks the droplet checksums the staging task added
+// to its result and moves them to the lifecycle metadata.
+func moveDropletChecksums(resultJson []byte) ([]byte, error) {
+	var result map[string]json.RawMessage
+	err := json.Unmarshal(resultJson, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := result["droplet_checksums"]
+	if !ok {
+		return nil, &MalformedResultError{Reason: "missing droplet_checksums"}
+	}
+
+	var checksums DropletChecksums
+	err = json.Unmarshal(raw, &checksums)
+	if err != nil {
+		return nil, &MalformedResultError{Reason: "droplet_checksums must be an object"}
+	}
+
+	err = validateChecksum("sha1", checksums.Sha1, 20)
+	if err != nil {
+		return nil, err
+	}
+	err = validateChecksum("sha256", checksums.Sha256, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	lifecycleMetadata := map[string]interface{}{}
+	if raw, ok := result["lifecycle_metadata"]; ok {
+		err = json.Unmarshal(raw, &lifecycleMetadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lifecycleMetadata["droplet_sha1"] = checksums.Sha1
+	lifecycleMetadata["droplet_sha256"] = checksums.Sha256
+	delete(result, "droplet_checksums")
+
+	result["lifecycle_metadata"], err = json.Marshal(lifecycleMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(result)
+}
+
+func validateChecksum(algorithm, checksum string, size int) error {
+	decoded, err := hex.DecodeString(checksum)
+	if err != nil || len(decoded) != size {
+		return &MalformedResultError{Reason: fmt.Sprintf("droplet_checksums.%s is not a %s checksum", algorithm, algorithm)}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+// InvalidEgressRuleError is returned for staging requests carrying an egress
+// rule the BBS would refuse, so that CC sees an invalid request rather than
+// a failure to desire the task.
+type InvalidEgressRuleError struct {
+	Index int
+	Err   error
+}
+
+func (e *InvalidEgressRuleError) Error() string {
+	return fmt.Sprintf("%s: rule %d: %s", diego_errors.INVALID_EGRESS_RULE_MESSAGE, e.Index, e.Err)
+}
+
+func validateEgressRules(rules []*models.SecurityGroupRule) error {
+	for i, rule := range rules {
+		if rule == nil {
+			return &InvalidEgressRuleError{Index: i, Err: errors.New("rule is empty")}
+		}
+
+		err := rule.Validate()
+		if err != nil {
+			return &InvalidEgressRuleError{Index: i, Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"sort"
+
+	"code.cloudfoundry.org/bbs/models"
+)
+
+// stagingEnvironment merges the environment of the staging task. Variables
+// set by the app take precedence over the staging environment group sent by
+// CC, which in turn takes precedence over the group configured by the
+// operator. Each variable keeps the position where it was first defined.
+func stagingEnvironment(operatorGroup map[string]string, ccGroup, appEnv []*models.EnvironmentVariable) []*models.EnvironmentVariable {
+	names := make([]string, 0, len(operatorGroup))
+	for name := range operatorGroup {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := make([]*models.EnvironmentVariable, 0, len(names)+len(ccGroup)+len(appEnv))
+	positions := map[string]int{}
+
+	set := func(name, value string) {
+		if i, ok := positions[name]; ok {
+			env[i] = &models.EnvironmentVariable{Name: name, Value: value}
+			return
+		}
+		positions[name] = len(env)
+		env = append(env, &models.EnvironmentVariable{Name: name, Value: value})
+	}
+
+	for _, name := range names {
+		set(name, operatorGroup[name])
+	}
+	for _, envVar := range ccGroup {
+		set(envVar.Name, envVar.Value)
+	}
+	for _, envVar := range appEnv {
+		set(envVar.Name, envVar.Value)
+	}
+
+	return env
+}
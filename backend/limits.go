@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+const (
+	// MaxResourceMB is the largest memory or disk request that is considered
+	// a mistake rather than something to bound.
+	MaxResourceMB = 1024 * 1024
+
+	// MaxCpuWeight is the largest CPU weight Diego gives a task.
+	MaxCpuWeight = 100
+
+	// CpuWeightReferenceMemoryMB is the staging memory that is given
+	// MaxCpuWeight. Smaller tasks get a proportionally smaller weight, but
+	// never less than StagingTaskCpuWeight.
+	CpuWeightReferenceMemoryMB = 8192
+
+	// Metrics
+	stagingResourcesClamped = metric.Counter("StagingResourcesClamped")
+)
+
+// InvalidResourceError is returned for memory or disk requests that cannot
+// be sensibly bounded, e.g. negative sizes.
+type InvalidResourceError struct {
+	Resource  string
+	Requested int
+}
+
+func (e *InvalidResourceError) Error() string {
+	return fmt.Sprintf("%s: %s %d", diego_errors.INVALID_RESOURCE_REQUEST_MESSAGE, e.Resource, e.Requested)
+}
+
+// stagingResources honors the memory and disk requested by CC, bounded by
+// the operator configured minimums and maximums. A zero bound is not
+// enforced.
+func stagingResources(config Config, request cc_messages.StagingRequestFromCC, logger lager.Logger) (int32, int32, error) {
+	memoryMB, err := boundResource("memory_mb", request.MemoryMB, config.MinStagingMemoryMB, config.MaxStagingMemoryMB, request.AppId, logger)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	diskMB, err := boundResource("disk_mb", request.DiskMB, config.MinStagingDiskMB, config.MaxStagingDiskMB, request.AppId, logger)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int32(memoryMB), int32(diskMB), nil
+}
+
+func boundResource(resource string, requested, min, max int, appId string, logger lager.Logger) (int, error) {
+	if requested < 0 || requested > MaxResourceMB {
+		return 0, &InvalidResourceError{Resource: resource, Requested: requested}
+	}
+
+	bounded := requested
+	if min > 0 && bounded < min {
+		bounded = min
+	}
+	if max > 0 && bounded > max {
+		bounded = max
+	}
+
+	if bounded != requested {
+		logger.Info("bounding-requested-resource", lager.Data{
+			"resource":  resource,
+			"requested": requested,
+			"bounded":   bounded,
+			"app-id":    appId,
+		})
+		stagingResourcesClamped.Increment()
+	}
+
+	return bounded, nil
+}
+
+// stagingCpuWeight derives the CPU weight of a staging task from its memory,
+// so that large stagings are not starved on busy cells, unless the operator
+// configured a fixed weight.
+func stagingCpuWeight(config Config, memoryMB int32) uint32 {
+	if config.StagingCpuWeight > 0 {
+		return config.StagingCpuWeight
+	}
+
+	weight := uint32(int64(memoryMB) * MaxCpuWeight / CpuWeightReferenceMemoryMB)
+	if weight < StagingTaskCpuWeight {
+		return StagingTaskCpuWeight
+	}
+	if weight > MaxCpuWeight {
+		return MaxCpuWeight
+	}
+	return weight
+}
@@ -0,0 +1,72 @@
+package backend
+
+import "encoding/json"
+
+// Process is an entry of the process list written by newer buildpack
+// lifecycles in place of, or next to, the process type map.
+type Process struct {
+	Type    string `json:"type"`
+	Command string `json:"command"`
+}
+
+// normalizeProcessTypes collects the commands of every process type in a
+// staging result, whether the lifecycle wrote them to detected_start_command,
+// process_types or the processes list, and writes the full map back to each
+// of the process type fields CC reads. Results that carry a single process
+// type field, or that are not JSON objects, are returned unchanged.
+func normalizeProcessTypes(resultJson []byte) ([]byte, error) {
+	var result map[string]json.RawMessage
+	err := json.Unmarshal(resultJson, &result)
+	if err != nil {
+		return resultJson, nil
+	}
+
+	_, hasProcesses := result["processes"]
+	_, hasStartCommands := result["detected_start_command"]
+	_, hasProcessTypes := result["process_types"]
+	if !hasProcesses && !(hasStartCommands && hasProcessTypes) {
+		return resultJson, nil
+	}
+
+	processTypes := map[string]string{}
+	for _, field := range []string{"detected_start_command", "process_types"} {
+		raw, ok := result[field]
+		if !ok {
+			continue
+		}
+
+		var commands map[string]string
+		err = json.Unmarshal(raw, &commands)
+		if err != nil {
+			return nil, err
+		}
+
+		for processType, command := range commands {
+			processTypes[processType] = command
+		}
+	}
+
+	if hasProcesses {
+		var processes []Process
+		err = json.Unmarshal(result["processes"], &processes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, process := range processes {
+			processTypes[process.Type] = process.Command
+		}
+	}
+
+	processTypesJson, err := json.Marshal(processTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	result["process_types"] = processTypesJson
+	if hasStartCommands {
+		result["detected_start_command"] = processTypesJson
+	}
+
+	return json.Marshal(result)
+}
@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Factory builds the backend for a lifecycle from the shared stager config.
+type Factory func(config Config, logger lager.Logger) Backend
+
+// Registry maps lifecycle names, as sent by CC in staging requests, to the
+// factories for their backends.
+type Registry map[string]Factory
+
+// DefaultRegistry returns a registry containing the buildpack, docker and
+// windows backends.
+func DefaultRegistry() Registry {
+	return Registry{
+		TraditionalLifecycleName: NewTraditionalBackend,
+		DockerLifecycleName:      NewDockerBackend,
+		WindowsLifecycleName:     NewWindowsBackend,
+	}
+}
+
+func (r Registry) Register(lifecycle string, factory Factory) error {
+	if _, exists := r[lifecycle]; exists {
+		return fmt.Errorf("backend already registered for lifecycle '%s'", lifecycle)
+	}
+
+	r[lifecycle] = factory
+	return nil
+}
+
+func (r Registry) Backends(config Config, logger lager.Logger) map[string]Backend {
+	backends := make(map[string]Backend, len(r))
+	for lifecycle, factory := range r {
+		backends[lifecycle] = factory(config, logger)
+	}
+	return backends
+}
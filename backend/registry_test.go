@@ -0,0 +1,51 @@
+package backend_test
+
+import (
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/backend/fake_backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var registry backend.Registry
+
+	BeforeEach(func() {
+		registry = backend.DefaultRegistry()
+	})
+
+	It("contains the buildpack, docker and windows backends by default", func() {
+		backends := registry.Backends(backend.Config{}, lagertest.NewTestLogger("test"))
+		Expect(backends).To(HaveLen(3))
+		Expect(backends).To(HaveKey("buildpack"))
+		Expect(backends).To(HaveKey("docker"))
+		Expect(backends).To(HaveKey("windows"))
+	})
+
+	Context("when a new lifecycle is registered", func() {
+		var fakeBackend *fake_backend.FakeBackend
+
+		BeforeEach(func() {
+			fakeBackend = &fake_backend.FakeBackend{}
+			err := registry.Register("dotnet", func(backend.Config, lager.Logger) backend.Backend {
+				return fakeBackend
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("builds a backend for it", func() {
+			backends := registry.Backends(backend.Config{}, lagertest.NewTestLogger("test"))
+			Expect(backends).To(HaveKeyWithValue("dotnet", fakeBackend))
+		})
+	})
+
+	Context("when a lifecycle is registered twice", func() {
+		It("returns an error", func() {
+			err := registry.Register("docker", backend.NewDockerBackend)
+			Expect(err).To(MatchError("backend already registered for lifecycle 'docker'"))
+		})
+	})
+})
@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+const (
+	// Metrics
+	malformedStagingResults = metric.Counter("MalformedStagingResults")
+)
+
+// MalformedResultError is reported to CC in place of a staging result that
+// does not match the schema of its lifecycle, rather than delivering a
+// result CC cannot make sense of.
+type MalformedResultError struct {
+	Reason string
+}
+
+func (e *MalformedResultError) Error() string {
+	return fmt.Sprintf("%s: %s", diego_errors.MALFORMED_STAGING_RESULT_MESSAGE, e.Reason)
+}
+
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	objectField
+	stringMapField
+	arrayField
+)
+
+func (k fieldKind) String() string {
+	switch k {
+	case stringField:
+		return "a string"
+	case objectField:
+		return "an object"
+	case stringMapField:
+		return "an object of strings"
+	default:
+		return "an array"
+	}
+}
+
+type resultField struct {
+	name     string
+	kind     fieldKind
+	required bool
+
+	// fields are checked within object fields.
+	fields []resultField
+}
+
+// resultSchema describes the staging result written by the builder of a
+// lifecycle. Fields that are not described are not checked, so that
+// lifecycles may add to their results.
+type resultSchema struct {
+	fields []resultField
+
+	// oneOf lists fields of which the result must have at least one.
+	oneOf []string
+}
+
+var processTypesFields = []string{"process_types", "detected_start_command", "processes"}
+
+var buildpackResultSchema = resultSchema{
+	fields: []resultField{
+		{name: "lifecycle_type", kind: stringField},
+		{name: "lifecycle_metadata", kind: objectField, required: true, fields: []resultField{
+			{name: "buildpack_key", kind: stringField},
+			{name: "detected_buildpack", kind: stringField},
+		}},
+		{name: "execution_metadata", kind: stringField},
+		{name: "process_types", kind: stringMapField},
+		{name: "detected_start_command", kind: stringMapField},
+		{name: "processes", kind: arrayField},
+		{name: "sidecars", kind: arrayField},
+	},
+	oneOf: processTypesFields,
+}
+
+var dockerResultSchema = resultSchema{
+	fields: []resultField{
+		{name: "lifecycle_type", kind: stringField},
+		{name: "lifecycle_metadata", kind: objectField, required: true, fields: []resultField{
+			{name: "docker_image", kind: stringField, required: true},
+		}},
+		{name: "execution_metadata", kind: stringField},
+		{name: "process_types", kind: stringMapField},
+		{name: "detected_start_command", kind: stringMapField},
+	},
+	oneOf: processTypesFields,
+}
+
+// validate checks a staging result against the schema, returning a
+// *MalformedResultError describing the first mismatch.
+func (schema resultSchema) validate(resultJson []byte) error {
+	var result map[string]json.RawMessage
+	err := json.Unmarshal(resultJson, &result)
+	if err != nil || result == nil {
+		return &MalformedResultError{Reason: "result is not a JSON object"}
+	}
+
+	err = validateFields("", schema.fields, result)
+	if err != nil {
+		return err
+	}
+
+	if len(schema.oneOf) > 0 {
+		for _, name := range schema.oneOf {
+			if _, ok := result[name]; ok {
+				return nil
+			}
+		}
+		return &MalformedResultError{Reason: fmt.Sprintf("missing one of %s", strings.Join(schema.oneOf, ", "))}
+	}
+
+	return nil
+}
+
+func validateFields(prefix string, fields []resultField, object map[string]json.RawMessage) error {
+	for _, field := range fields {
+		name := prefix + field.name
+
+		raw, ok := object[field.name]
+		if !ok {
+			if field.required {
+				return &MalformedResultError{Reason: fmt.Sprintf("missing %s", name)}
+			}
+			continue
+		}
+
+		var err error
+		switch field.kind {
+		case stringField:
+			var value string
+			err = json.Unmarshal(raw, &value)
+		case stringMapField:
+			var value map[string]string
+			err = json.Unmarshal(raw, &value)
+		case arrayField:
+			var value []json.RawMessage
+			err = json.Unmarshal(raw, &value)
+		case objectField:
+			var value map[string]json.RawMessage
+			err = json.Unmarshal(raw, &value)
+			if err == nil && value == nil {
+				err = fmt.Errorf("%s is null", name)
+			}
+			if err == nil {
+				err = validateFields(name+".", field.fields, value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if err != nil {
+			return &MalformedResultError{Reason: fmt.Sprintf("%s must be %s", name, field.kind)}
+		}
+	}
+
+	return nil
+}
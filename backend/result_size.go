@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+const (
+	// resultFileTooLargeReason starts the failure reason the cell reports
+	// for tasks whose result file is larger than it reads.
+	resultFileTooLargeReason = "result file size exceeds allowed limit"
+
+	// Metrics
+	stagingResultsTooLarge = metric.Counter("StagingResultsTooLarge")
+)
+
+// ResultTooLargeError is reported to CC in place of a staging result larger
+// than Config.MaxResultBytes. Size is zero when the result was cut short at
+// the limit, so that its full size is not known.
+type ResultTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (e *ResultTooLargeError) Error() string {
+	if e.Size == 0 {
+		return fmt.Sprintf("%s: cut short at %d bytes", diego_errors.STAGING_RESULT_TOO_LARGE_MESSAGE, e.Max)
+	}
+	return fmt.Sprintf("%s: %d bytes (max %d)", diego_errors.STAGING_RESULT_TOO_LARGE_MESSAGE, e.Size, e.Max)
+}
+
+// checkResultSize returns a *ResultTooLargeError for results larger than
+// maxBytes, and for results of exactly maxBytes that are not valid JSON,
+// which is how results truncated at the limit arrive.
+func checkResultSize(result string, maxBytes int) error {
+	if maxBytes <= 0 {
+		return nil
+	}
+
+	if len(result) > maxBytes {
+		return &ResultTooLargeError{Size: len(result), Max: maxBytes}
+	}
+
+	if len(result) == maxBytes {
+		var value interface{}
+		if json.Unmarshal([]byte(result), &value) != nil {
+			return &ResultTooLargeError{Max: maxBytes}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	UploadExpiresKey   = "expires"
+	UploadSignatureKey = "signature"
+
+	// DefaultDropletUploadURLSlack covers the time a staging task waits to be
+	// placed on a cell and uploads its droplet beyond its staging timeout.
+	DefaultDropletUploadURLSlack = 15 * time.Minute
+)
+
+// SignUploadURI replaces the basic auth credentials CC embeds in an upload
+// URI with an expiry time and an HMAC-SHA256 signature of the path and
+// query, keyed with a secret shared with CC.
+func SignUploadURI(uploadURI string, key []byte, expires time.Time) (string, error) {
+	u, err := url.Parse(uploadURI)
+	if err != nil {
+		return "", err
+	}
+
+	u.User = nil
+
+	query := u.Query()
+	query.Del(UploadSignatureKey)
+	query.Set(UploadExpiresKey, strconv.FormatInt(expires.Unix(), 10))
+	u.RawQuery = query.Encode()
+
+	query.Set(UploadSignatureKey, uploadSignature(u, key))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func uploadSignature(u *url.URL, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(u.EscapedPath() + "?" + u.RawQuery))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,7 +1,10 @@
 package backend_test
 
 import (
+	"encoding/json"
+
 	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -17,6 +20,20 @@ func actionsFromTaskDef(taskDef *models.TaskDefinition) []*models.Action {
 	return serialAction.Actions
 }
 
+func setLifecycleDataField(request *cc_messages.StagingRequestFromCC, key string, value interface{}) {
+	data := map[string]interface{}{}
+	err := json.Unmarshal(*request.LifecycleData, &data)
+	Expect(err).NotTo(HaveOccurred())
+
+	data[key] = value
+
+	dataJSON, err := json.Marshal(data)
+	Expect(err).NotTo(HaveOccurred())
+
+	lifecycleData := json.RawMessage(dataJSON)
+	request.LifecycleData = &lifecycleData
+}
+
 func TestBackend(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Backend Suite")
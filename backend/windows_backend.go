@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+)
+
+const WindowsLifecycleName = "windows"
+
+// NewWindowsBackend returns a backend staging buildpack apps on Windows
+// cells. Requests carry the same lifecycle data as for the buildpack
+// lifecycle, and the builder of the windows lifecycle bundle configured
+// for the requested stack, e.g. windows/windows2012R2, runs against the
+// rootfs of that stack. Windows containers cannot be privileged, take no
+// resource limits and do not have the trusted system certificates
+// injected, so the staging task asks for none of them.
+func NewWindowsBackend(config Config, logger lager.Logger) Backend {
+	return &traditionalBackend{
+		config:  config,
+		logger:  logger.Session("windows"),
+		windows: true,
+	}
+}
+
+func (backend *traditionalBackend) lifecycleName() string {
+	if backend.windows {
+		return WindowsLifecycleName
+	}
+	return TraditionalLifecycleName
+}
+
+func (backend *traditionalBackend) taskDomain() string {
+	if backend.windows && backend.config.WindowsTaskDomain != "" {
+		return backend.config.WindowsTaskDomain
+	}
+	return backend.config.TaskDomain
+}
+
+func adaptTaskForWindows(taskDefinition *models.TaskDefinition) {
+	taskDefinition.Privileged = false
+	taskDefinition.TrustedSystemCertificatesPath = ""
+	taskDefinition.EnvironmentVariables = nil
+}
@@ -0,0 +1,150 @@
+package backend_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WindowsBackend", func() {
+	var (
+		config         backend.Config
+		windows        backend.Backend
+		stagingRequest cc_messages.StagingRequestFromCC
+	)
+
+	BeforeEach(func() {
+		config = backend.Config{
+			TaskDomain:           "config-task-domain",
+			StagerURL:            "http://the-stager.example.com",
+			FileServerURL:        "http://file-server.com",
+			CCUploaderURL:        "http://cc-uploader.com",
+			PrivilegedContainers: true,
+			Lifecycles: map[string]string{
+				"buildpack/cflinuxfs2":    "buildpack-compiler",
+				"windows/windows2012R2":   "windows-compiler",
+				"windows/windows2016":     "windows-2016-compiler",
+				"docker":                  "docker-compiler",
+				"buildpack/windows2012R2": "not-the-windows-compiler",
+			},
+			Sanitizer: func(msg string) *cc_messages.StagingError {
+				return &cc_messages.StagingError{Message: msg}
+			},
+		}
+
+		lifecycleDataJSON, err := json.Marshal(cc_messages.BuildpackStagingData{
+			AppBitsDownloadUri: "http://example-uri.com/bunny",
+			Buildpacks:         []cc_messages.Buildpack{{Name: "hwc", Key: "hwc-buildpack", Url: "hwc-buildpack-url"}},
+			DropletUploadUri:   "http://example-uri.com/droplet-upload",
+			Stack:              "windows2012R2",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		lifecycleData := json.RawMessage(lifecycleDataJSON)
+
+		stagingRequest = cc_messages.StagingRequestFromCC{
+			AppId:           "bunny",
+			LogGuid:         "bunny",
+			FileDescriptors: 512,
+			MemoryMB:        1024,
+			DiskMB:          2048,
+			Lifecycle:       "windows",
+			LifecycleData:   &lifecycleData,
+		}
+	})
+
+	JustBeforeEach(func() {
+		windows = backend.NewWindowsBackend(config, lagertest.NewTestLogger("test"))
+	})
+
+	It("downloads the windows lifecycle for the stack", func() {
+		taskDef, _, _, err := windows.BuildRecipe("a-staging-guid", stagingRequest)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(taskDef.CachedDependencies[0].From).To(Equal("http://file-server.com/v1/static/windows-compiler"))
+		Expect(taskDef.RootFs).To(Equal(models.PreloadedRootFS("windows2012R2")))
+	})
+
+	It("annotates the task with the windows lifecycle", func() {
+		taskDef, _, _, err := windows.BuildRecipe("a-staging-guid", stagingRequest)
+		Expect(err).NotTo(HaveOccurred())
+
+		annotation, err := backend.DecodeAnnotation(taskDef.Annotation)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(annotation.Lifecycle).To(Equal("windows"))
+		Expect(annotation.Stack).To(Equal("windows2012R2"))
+	})
+
+	It("asks for nothing Windows containers do not support", func() {
+		taskDef, _, _, err := windows.BuildRecipe("a-staging-guid", stagingRequest)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(taskDef.Privileged).To(BeFalse())
+		Expect(taskDef.TrustedSystemCertificatesPath).To(BeEmpty())
+		Expect(taskDef.EnvironmentVariables).To(BeEmpty())
+
+		var runAction *models.RunAction
+		for _, action := range actionsFromTaskDef(taskDef) {
+			if emitProgress := action.GetEmitProgressAction(); emitProgress != nil && emitProgress.Action.GetRunAction() != nil {
+				runAction = emitProgress.Action.GetRunAction()
+			}
+		}
+		Expect(runAction).NotTo(BeNil())
+		Expect(runAction.ResourceLimits).To(BeNil())
+	})
+
+	It("desires the task in the staging task domain", func() {
+		_, guid, domain, err := windows.BuildRecipe("a-staging-guid", stagingRequest)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(guid).To(Equal("a-staging-guid"))
+		Expect(domain).To(Equal("config-task-domain"))
+	})
+
+	Context("when a windows task domain is configured", func() {
+		BeforeEach(func() {
+			config.WindowsTaskDomain = "windows-task-domain"
+		})
+
+		It("desires the task in it", func() {
+			_, _, domain, err := windows.BuildRecipe("a-staging-guid", stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(domain).To(Equal("windows-task-domain"))
+		})
+	})
+
+	Context("when droplet checksums are configured", func() {
+		BeforeEach(func() {
+			config.DropletChecksums = true
+		})
+
+		It("does not checksum the droplet, for want of a shell", func() {
+			taskDef, _, _, err := windows.BuildRecipe("a-staging-guid", stagingRequest)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, action := range actionsFromTaskDef(taskDef) {
+				Expect(action.GetRunAction()).To(BeNil())
+			}
+
+			annotation, err := backend.DecodeAnnotation(taskDef.Annotation)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(annotation.DropletChecksums).To(BeFalse())
+		})
+	})
+
+	Context("when no windows lifecycle is configured for the stack", func() {
+		BeforeEach(func() {
+			delete(config.Lifecycles, "windows/windows2012R2")
+		})
+
+		It("returns an UnknownStackError", func() {
+			_, _, _, err := windows.BuildRecipe("a-staging-guid", stagingRequest)
+			Expect(err).To(Equal(&backend.UnknownStackError{Stack: "windows2012R2", Stacks: []string{"windows2016"}}))
+		})
+	})
+})
@@ -0,0 +1,78 @@
+package bbs_retry
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/circuit_breaker"
+)
+
+const (
+	MaxRetryInterval = 10 * time.Second
+
+	// Metrics
+	desireTaskRetries = metric.Counter("DesireTaskRetries")
+)
+
+type bbsClient struct {
+	bbs.Client
+	retries       int
+	retryInterval time.Duration
+	clock         clock.Clock
+}
+
+// NewBBSClient retries desiring tasks up to retries times when the BBS
+// fails, e.g. while it fails over, waiting retryInterval after the first
+// failure and doubling it after every further one. A task that already
+// exists when it is desired again was desired by a failed attempt, so it is
+// treated as desired.
+func NewBBSClient(client bbs.Client, retries int, retryInterval time.Duration, clock clock.Clock) bbs.Client {
+	if retries <= 0 {
+		return client
+	}
+
+	return &bbsClient{
+		Client:        client,
+		retries:       retries,
+		retryInterval: retryInterval,
+		clock:         clock,
+	}
+}
+
+func (c *bbsClient) DesireTask(logger lager.Logger, guid, domain string, def *models.TaskDefinition) error {
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		err = c.Client.DesireTask(logger, guid, domain, def)
+		if attempt > 0 && models.ErrResourceExists.Equal(err) {
+			logger.Info("task-desired-by-failed-attempt", lager.Data{"attempt": attempt + 1})
+			return nil
+		}
+		if err == nil || !circuit_breaker.IsBBSFailure(err) || attempt == c.retries {
+			break
+		}
+
+		logger.Error("desire-task-failed", err, lager.Data{"attempt": attempt + 1})
+		desireTaskRetries.Increment()
+		c.clock.Sleep(backoff(c.retryInterval, attempt))
+	}
+
+	return err
+}
+
+// backoff doubles the retry interval after every failed attempt, up to
+// MaxRetryInterval.
+func backoff(retryInterval time.Duration, attempt int) time.Duration {
+	interval := retryInterval
+	for i := 0; i < attempt && interval < MaxRetryInterval; i++ {
+		interval *= 2
+	}
+
+	if interval > MaxRetryInterval {
+		return MaxRetryInterval
+	}
+	return interval
+}
@@ -0,0 +1,13 @@
+package bbs_retry_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBBSRetry(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "BBS Retry Suite")
+}
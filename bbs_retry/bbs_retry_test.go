@@ -0,0 +1,147 @@
+package bbs_retry_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/bbs_retry"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BBSClient", func() {
+	var (
+		fakeBBSClient    *fake_bbs.FakeClient
+		fakeClock        *fakeclock.FakeClock
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		logger           lager.Logger
+		client           bbs.Client
+		taskDef          *models.TaskDefinition
+	)
+
+	BeforeEach(func() {
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+		taskDef = &models.TaskDefinition{}
+
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		client = bbs_retry.NewBBSClient(fakeBBSClient, 2, time.Second, fakeClock)
+	})
+
+	desireTask := func() <-chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- client.DesireTask(logger, "task-guid", "domain", taskDef)
+		}()
+		return errCh
+	}
+
+	It("desires the task", func() {
+		Expect(<-desireTask()).To(Succeed())
+
+		Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(1))
+		_, guid, domain, def := fakeBBSClient.DesireTaskArgsForCall(0)
+		Expect(guid).To(Equal("task-guid"))
+		Expect(domain).To(Equal("domain"))
+		Expect(def).To(Equal(taskDef))
+	})
+
+	Context("when the BBS fails", func() {
+		BeforeEach(func() {
+			fakeBBSClient.DesireTaskReturns(errors.New("connection refused"))
+		})
+
+		It("retries with backoff and returns the last error", func() {
+			errCh := desireTask()
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(fakeBBSClient.DesireTaskCallCount).Should(Equal(2))
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Consistently(fakeBBSClient.DesireTaskCallCount).Should(Equal(2))
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(errCh).Should(Receive(MatchError("connection refused")))
+			Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(3))
+			Expect(fakeMetricSender.GetCounter("DesireTaskRetries")).To(BeEquivalentTo(2))
+		})
+
+		Context("and then recovers", func() {
+			BeforeEach(func() {
+				fakeBBSClient.DesireTaskStub = func(lager.Logger, string, string, *models.TaskDefinition) error {
+					if fakeBBSClient.DesireTaskCallCount() == 1 {
+						return errors.New("connection refused")
+					}
+					return nil
+				}
+			})
+
+			It("succeeds", func() {
+				errCh := desireTask()
+				fakeClock.WaitForWatcherAndIncrement(time.Second)
+				Eventually(errCh).Should(Receive(BeNil()))
+			})
+		})
+
+		Context("and the task was desired anyway", func() {
+			BeforeEach(func() {
+				fakeBBSClient.DesireTaskStub = func(lager.Logger, string, string, *models.TaskDefinition) error {
+					if fakeBBSClient.DesireTaskCallCount() == 1 {
+						return errors.New("timeout awaiting response headers")
+					}
+					return models.ErrResourceExists
+				}
+			})
+
+			It("treats the task as desired", func() {
+				errCh := desireTask()
+				fakeClock.WaitForWatcherAndIncrement(time.Second)
+				Eventually(errCh).Should(Receive(BeNil()))
+			})
+		})
+	})
+
+	Context("when the task already exists", func() {
+		BeforeEach(func() {
+			fakeBBSClient.DesireTaskReturns(models.ErrResourceExists)
+		})
+
+		It("returns the error without retrying", func() {
+			Expect(<-desireTask()).To(Equal(models.ErrResourceExists))
+			Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when the BBS rejects the task", func() {
+		BeforeEach(func() {
+			fakeBBSClient.DesireTaskReturns(models.ErrBadRequest)
+		})
+
+		It("returns the error without retrying", func() {
+			Expect(<-desireTask()).To(Equal(models.ErrBadRequest))
+			Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when no retries are configured", func() {
+		BeforeEach(func() {
+			client = bbs_retry.NewBBSClient(fakeBBSClient, 0, time.Second, fakeClock)
+		})
+
+		It("returns the client unchanged", func() {
+			Expect(client).To(BeIdenticalTo(fakeBBSClient))
+		})
+	})
+})
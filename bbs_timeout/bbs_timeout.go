@@ -0,0 +1,140 @@
+package bbs_timeout
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+)
+
+const (
+	// Metrics
+	bbsCallTimeouts = metric.Counter("BBSCallTimeouts")
+)
+
+var ErrTimeout = errors.New("BBS call timed out")
+
+type bbsClient struct {
+	bbs.Client
+	timeout time.Duration
+	clock   clock.Clock
+
+	// slots bounds the calls in flight, if not nil.
+	slots chan struct{}
+}
+
+// NewBBSClient gives up on the task calls made by the stager once they take
+// longer than timeout, so that a slow BBS does not hold up the requests and
+// the watcher waiting on them. At most maxCalls calls are in flight at
+// once, including calls given up on that have not returned yet; calls
+// waiting for one to return count the wait against their timeout. A timeout
+// or maxCalls of zero or less leaves calls unbounded.
+func NewBBSClient(client bbs.Client, timeout time.Duration, maxCalls int, clock clock.Clock) bbs.Client {
+	if timeout <= 0 && maxCalls <= 0 {
+		return client
+	}
+
+	c := &bbsClient{
+		Client:  client,
+		timeout: timeout,
+		clock:   clock,
+	}
+	if maxCalls > 0 {
+		c.slots = make(chan struct{}, maxCalls)
+	}
+	return c
+}
+
+func (c *bbsClient) DesireTask(logger lager.Logger, guid, domain string, def *models.TaskDefinition) error {
+	_, err := c.call(logger, "desire-task", func() (interface{}, error) {
+		return nil, c.Client.DesireTask(logger, guid, domain, def)
+	})
+	return err
+}
+
+func (c *bbsClient) TaskByGuid(logger lager.Logger, guid string) (*models.Task, error) {
+	result, err := c.call(logger, "task-by-guid", func() (interface{}, error) {
+		return c.Client.TaskByGuid(logger, guid)
+	})
+	task, _ := result.(*models.Task)
+	return task, err
+}
+
+func (c *bbsClient) TasksByDomain(logger lager.Logger, domain string) ([]*models.Task, error) {
+	result, err := c.call(logger, "tasks-by-domain", func() (interface{}, error) {
+		return c.Client.TasksByDomain(logger, domain)
+	})
+	tasks, _ := result.([]*models.Task)
+	return tasks, err
+}
+
+func (c *bbsClient) CancelTask(logger lager.Logger, taskGuid string) error {
+	_, err := c.call(logger, "cancel-task", func() (interface{}, error) {
+		return nil, c.Client.CancelTask(logger, taskGuid)
+	})
+	return err
+}
+
+func (c *bbsClient) ResolvingTask(logger lager.Logger, taskGuid string) error {
+	_, err := c.call(logger, "resolving-task", func() (interface{}, error) {
+		return nil, c.Client.ResolvingTask(logger, taskGuid)
+	})
+	return err
+}
+
+func (c *bbsClient) DeleteTask(logger lager.Logger, taskGuid string) error {
+	_, err := c.call(logger, "delete-task", func() (interface{}, error) {
+		return nil, c.Client.DeleteTask(logger, taskGuid)
+	})
+	return err
+}
+
+type callResult struct {
+	value interface{}
+	err   error
+}
+
+// call runs f in a slot, waiting at most the timeout for both. The results
+// of calls given up on are dropped when they return.
+func (c *bbsClient) call(logger lager.Logger, name string, f func() (interface{}, error)) (interface{}, error) {
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timer := c.clock.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-timeout:
+			return nil, c.timedOut(logger, name)
+		}
+	}
+
+	done := make(chan callResult, 1)
+	go func() {
+		value, err := f()
+		if c.slots != nil {
+			<-c.slots
+		}
+		done <- callResult{value: value, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.value, result.err
+	case <-timeout:
+		return nil, c.timedOut(logger, name)
+	}
+}
+
+func (c *bbsClient) timedOut(logger lager.Logger, name string) error {
+	logger.Error("bbs-call-timed-out", ErrTimeout, lager.Data{"call": name, "timeout": c.timeout.String()})
+	bbsCallTimeouts.Increment()
+	return ErrTimeout
+}
@@ -0,0 +1,13 @@
+package bbs_timeout_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBBSTimeout(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "BBS Timeout Suite")
+}
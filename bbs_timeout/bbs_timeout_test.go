@@ -0,0 +1,137 @@
+package bbs_timeout_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/bbs_timeout"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BBSClient", func() {
+	var (
+		fakeBBSClient    *fake_bbs.FakeClient
+		fakeClock        *fakeclock.FakeClock
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		logger           lager.Logger
+		client           bbs.Client
+		release          chan struct{}
+	)
+
+	BeforeEach(func() {
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+		release = make(chan struct{})
+
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		client = bbs_timeout.NewBBSClient(fakeBBSClient, time.Second, 1, fakeClock)
+	})
+
+	AfterEach(func() {
+		close(release)
+	})
+
+	taskByGuid := func() <-chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := client.TaskByGuid(logger, "task-guid")
+			errCh <- err
+		}()
+		return errCh
+	}
+
+	blockTaskByGuid := func() {
+		fakeBBSClient.TaskByGuidStub = func(lager.Logger, string) (*models.Task, error) {
+			<-release
+			return nil, nil
+		}
+	}
+
+	It("returns what the BBS returns", func() {
+		fakeBBSClient.TaskByGuidReturns(&models.Task{TaskGuid: "task-guid"}, nil)
+
+		task, err := client.TaskByGuid(logger, "task-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(task.TaskGuid).To(Equal("task-guid"))
+	})
+
+	It("returns the errors of the BBS", func() {
+		fakeBBSClient.DesireTaskReturns(models.ErrResourceExists)
+
+		err := client.DesireTask(logger, "task-guid", "domain", &models.TaskDefinition{})
+		Expect(err).To(Equal(models.ErrResourceExists))
+	})
+
+	Context("when a call takes longer than the timeout", func() {
+		BeforeEach(func() {
+			blockTaskByGuid()
+		})
+
+		It("gives up on it", func() {
+			errCh := taskByGuid()
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(errCh).Should(Receive(Equal(bbs_timeout.ErrTimeout)))
+			Expect(fakeMetricSender.GetCounter("BBSCallTimeouts")).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("when as many calls as allowed are in flight", func() {
+		var firstErrCh <-chan error
+
+		BeforeEach(func() {
+			blockTaskByGuid()
+		})
+
+		JustBeforeEach(func() {
+			firstErrCh = taskByGuid()
+			Eventually(fakeBBSClient.TaskByGuidCallCount).Should(Equal(1))
+		})
+
+		It("waits for one to return", func() {
+			fakeBBSClient.CancelTaskReturns(errors.New("boom"))
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- client.CancelTask(logger, "task-guid")
+			}()
+
+			Consistently(fakeBBSClient.CancelTaskCallCount).Should(Equal(0))
+
+			release <- struct{}{}
+			Eventually(firstErrCh).Should(Receive(BeNil()))
+			Eventually(errCh).Should(Receive(MatchError("boom")))
+		})
+
+		It("counts the wait against the timeout", func() {
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- client.CancelTask(logger, "task-guid")
+			}()
+
+			Eventually(fakeClock.WatcherCount).Should(Equal(2))
+			fakeClock.Increment(time.Second)
+			Eventually(errCh).Should(Receive(Equal(bbs_timeout.ErrTimeout)))
+			Eventually(firstErrCh).Should(Receive(Equal(bbs_timeout.ErrTimeout)))
+			Expect(fakeBBSClient.CancelTaskCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when neither a timeout nor a maximum of calls is set", func() {
+		It("returns the client as is", func() {
+			Expect(bbs_timeout.NewBBSClient(fakeBBSClient, 0, 0, fakeClock)).To(BeIdenticalTo(fakeBBSClient))
+		})
+	})
+})
@@ -0,0 +1,106 @@
+package cache_client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const requestTimeout = 30 * time.Second
+
+var ErrInvalidAppGuid = errors.New("invalid app guid")
+
+// appGuidPattern matches the guids CC gives apps.
+var appGuidPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// CacheClient manages the build artifacts caches that CC keeps for apps
+// staged with the buildpack lifecycle.
+//
+// Upstream CC only serves uploads and downloads of those caches under
+// /staging/buildpack_cache/:guid; deleting one with a DELETE on that path
+// needs a CC that serves it, and other CCs respond with a 404.
+//
+//go:generate counterfeiter -o fakes/fake_cache_client.go . CacheClient
+type CacheClient interface {
+	DeleteBuildArtifactsCache(appGuid string, logger lager.Logger) error
+}
+
+type BadResponseError struct {
+	StatusCode int
+}
+
+func (b *BadResponseError) Error() string {
+	return fmt.Sprintf("Build artifacts cache DELETE failed with %d", b.StatusCode)
+}
+
+type cacheClient struct {
+	baseURI    string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+func NewCacheClient(baseURI string, username string, password string, tlsConfig *tls.Config) CacheClient {
+	httpClient := &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     tlsConfig,
+		},
+	}
+
+	return &cacheClient{
+		baseURI:    baseURI,
+		username:   username,
+		password:   password,
+		httpClient: httpClient,
+	}
+}
+
+func (c *cacheClient) DeleteBuildArtifactsCache(appGuid string, logger lager.Logger) error {
+	logger = logger.Session("cache-client", lager.Data{"app-guid": appGuid})
+	logger.Info("deleting-build-artifacts-cache")
+
+	if !appGuidPattern.MatchString(appGuid) {
+		logger.Error("delete-build-artifacts-cache-failed", ErrInvalidAppGuid)
+		return ErrInvalidAppGuid
+	}
+
+	request, err := http.NewRequest("DELETE", fmt.Sprintf("%s/staging/buildpack_cache/%s", c.baseURI, url.PathEscape(appGuid)), nil)
+	if err != nil {
+		return err
+	}
+
+	request.SetBasicAuth(c.username, c.password)
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		logger.Error("delete-build-artifacts-cache-failed", err)
+		return err
+	}
+
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+	default:
+		err = &BadResponseError{response.StatusCode}
+		logger.Error("delete-build-artifacts-cache-failed", err)
+		return err
+	}
+
+	logger.Info("deleted-build-artifacts-cache")
+	return nil
+}
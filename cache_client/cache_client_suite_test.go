@@ -0,0 +1,13 @@
+package cache_client_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCacheClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cache Client Suite")
+}
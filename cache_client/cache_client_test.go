@@ -0,0 +1,67 @@
+package cache_client_test
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/cache_client"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Cache Client", func() {
+	var (
+		fakeCC      *ghttp.Server
+		cacheClient cache_client.CacheClient
+		logger      *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		fakeCC = ghttp.NewServer()
+		logger = lagertest.NewTestLogger("test")
+		cacheClient = cache_client.NewCacheClient(fakeCC.URL(), "username", "password", &tls.Config{InsecureSkipVerify: true})
+	})
+
+	AfterEach(func() {
+		fakeCC.Close()
+	})
+
+	Context("when the CC deletes the cache", func() {
+		BeforeEach(func() {
+			fakeCC.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("DELETE", "/staging/buildpack_cache/the-app-guid"),
+					ghttp.VerifyBasicAuth("username", "password"),
+					ghttp.RespondWith(http.StatusNoContent, nil),
+				),
+			)
+		})
+
+		It("succeeds", func() {
+			err := cacheClient.DeleteBuildArtifactsCache("the-app-guid", logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when the app guid is not a guid", func() {
+		It("returns an error without calling the CC", func() {
+			err := cacheClient.DeleteBuildArtifactsCache("../../v2/apps", logger)
+			Expect(err).To(Equal(cache_client.ErrInvalidAppGuid))
+			Expect(fakeCC.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+
+	Context("when the CC fails to delete the cache", func() {
+		BeforeEach(func() {
+			fakeCC.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, nil))
+		})
+
+		It("returns an error with the actual status code", func() {
+			err := cacheClient.DeleteBuildArtifactsCache("the-app-guid", logger)
+			Expect(err).To(Equal(&cache_client.BadResponseError{StatusCode: http.StatusNotFound}))
+		})
+	})
+})
@@ -0,0 +1,56 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/cache_client"
+)
+
+type FakeCacheClient struct {
+	DeleteBuildArtifactsCacheStub        func(appGuid string, logger lager.Logger) error
+	deleteBuildArtifactsCacheMutex       sync.RWMutex
+	deleteBuildArtifactsCacheArgsForCall []struct {
+		appGuid string
+		logger  lager.Logger
+	}
+	deleteBuildArtifactsCacheReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeCacheClient) DeleteBuildArtifactsCache(appGuid string, logger lager.Logger) error {
+	fake.deleteBuildArtifactsCacheMutex.Lock()
+	fake.deleteBuildArtifactsCacheArgsForCall = append(fake.deleteBuildArtifactsCacheArgsForCall, struct {
+		appGuid string
+		logger  lager.Logger
+	}{appGuid, logger})
+	fake.deleteBuildArtifactsCacheMutex.Unlock()
+	if fake.DeleteBuildArtifactsCacheStub != nil {
+		return fake.DeleteBuildArtifactsCacheStub(appGuid, logger)
+	} else {
+		return fake.deleteBuildArtifactsCacheReturns.result1
+	}
+}
+
+func (fake *FakeCacheClient) DeleteBuildArtifactsCacheCallCount() int {
+	fake.deleteBuildArtifactsCacheMutex.RLock()
+	defer fake.deleteBuildArtifactsCacheMutex.RUnlock()
+	return len(fake.deleteBuildArtifactsCacheArgsForCall)
+}
+
+func (fake *FakeCacheClient) DeleteBuildArtifactsCacheArgsForCall(i int) (string, lager.Logger) {
+	fake.deleteBuildArtifactsCacheMutex.RLock()
+	defer fake.deleteBuildArtifactsCacheMutex.RUnlock()
+	return fake.deleteBuildArtifactsCacheArgsForCall[i].appGuid, fake.deleteBuildArtifactsCacheArgsForCall[i].logger
+}
+
+func (fake *FakeCacheClient) DeleteBuildArtifactsCacheReturns(result1 error) {
+	fake.DeleteBuildArtifactsCacheStub = nil
+	fake.deleteBuildArtifactsCacheReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ cache_client.CacheClient = new(FakeCacheClient)
@@ -2,29 +2,68 @@ package cc_client
 
 import (
 	"bytes"
+	"compress/gzip"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/retry_budget"
+	"code.cloudfoundry.org/stager/tracing"
+	"code.cloudfoundry.org/stager/uaa_client"
+	"github.com/cloudfoundry/dropsonde"
 )
 
 const (
-	stagingCompleteRequestTimeout = 5 * time.Second
+	DefaultRequestTimeout = 5 * time.Second
+	MaxRetryInterval      = 30 * time.Second
 )
 
 //go:generate counterfeiter -o fakes/fake_cc_client.go . CcClient
 type CcClient interface {
-	StagingComplete(stagingGuid string, completionCallback string, payload []byte, logger lager.Logger) error
+	StagingComplete(stagingGuid string, appId string, completionCallback string, requestId string, payload []byte, logger lager.Logger) error
+	StagingStarted(stagingGuid string, startedCallback string, cellId string, logger lager.Logger) error
+	SetRequestPolicy(policy RequestPolicy)
+}
+
+// RequestPolicy controls how long the client waits for CC and how it retries
+// staging completions. It can be changed while the client is in use.
+type RequestPolicy struct {
+	Timeout       time.Duration
+	Retries       int
+	RetryInterval time.Duration
+}
+
+type StagingStartedPayload struct {
+	CellId string `json:"cell_id"`
 }
 
 type ccClient struct {
-	baseURI    string
-	username   string
-	password   string
-	httpClient *http.Client
+	baseURI   string
+	username  string
+	password  string
+	uaaClient uaa_client.Client
+	transport http.RoundTripper
+	clock     clock.Clock
+
+	// retryBudget is spent on every retried staging completion, so that
+	// completions CC keeps failing count against the staging's attempts.
+	retryBudget retry_budget.RetryBudget
+
+	// gzipPayloads compresses the staging responses sent to CC.
+	gzipPayloads bool
+
+	lock           sync.RWMutex
+	requestRetries int
+	retryInterval  time.Duration
+	httpClient     *http.Client
 }
 
 type BadResponseError struct {
@@ -35,56 +74,280 @@ func (b *BadResponseError) Error() string {
 	return fmt.Sprintf("Staging response POST failed with %d", b.StatusCode)
 }
 
-func NewCcClient(baseURI string, username string, password string, skipCertVerify bool) CcClient {
-	httpClient := &http.Client{
-		Timeout: stagingCompleteRequestTimeout,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			Dial: (&net.Dialer{
-				Timeout:   10 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).Dial,
-			TLSHandshakeTimeout: 10 * time.Second,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipCertVerify,
-				MinVersion:         tls.VersionTLS10,
-			},
-		},
+// NewTLSConfig returns the TLS configuration for connecting to CC. When
+// caCertPath is set, only CC servers signed by that CA are trusted, and when
+// certPath and keyPath are set, the client certificate is presented so that
+// CC can mutually authenticate the stager.
+func NewTLSConfig(caCertPath, certPath, keyPath string, skipCertVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: skipCertVerify,
+		MinVersion:         tls.VersionTLS10,
 	}
 
-	return &ccClient{
-		baseURI:    baseURI,
-		username:   username,
-		password:   password,
-		httpClient: httpClient,
+	if caCertPath != "" {
+		caCert, err := ioutil.ReadFile(caCertPath)
+		if err != nil {
+			return nil, err
+		}
+
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in '%s'", caCertPath)
+		}
+		config.RootCAs = caPool
+	}
+
+	if certPath != "" || keyPath != "" {
+		certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
 	}
+
+	return config, nil
+}
+
+// NewCcClient returns a client authenticating to CC with the given basic
+// auth credentials or, when a UAA client is given, with the tokens it
+// fetches. Its requests only emit dropsonde HTTP events when emitHTTPEvents
+// is set, as they are dropped unless dropsonde is the metrics emitter. It
+// waits on the given clock between retries. Retries of staging completions
+// spend from the retry budget, when one is given.
+func NewCcClient(baseURI string, username string, password string, uaaClient uaa_client.Client, tlsConfig *tls.Config, requestTimeout time.Duration, requestRetries int, retryInterval time.Duration, gzipPayloads bool, emitHTTPEvents bool, retryBudget retry_budget.RetryBudget, clock clock.Clock) CcClient {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if emitHTTPEvents {
+		roundTripper = dropsonde.InstrumentedRoundTripper(transport)
+	}
+
+	cc := &ccClient{
+		baseURI:   baseURI,
+		username:  username,
+		password:  password,
+		uaaClient: uaaClient,
+		transport: roundTripper,
+		clock:     clock,
+
+		retryBudget:  retryBudget,
+		gzipPayloads: gzipPayloads,
+	}
+	cc.SetRequestPolicy(RequestPolicy{
+		Timeout:       requestTimeout,
+		Retries:       requestRetries,
+		RetryInterval: retryInterval,
+	})
+
+	return cc
+}
+
+func (cc *ccClient) SetRequestPolicy(policy RequestPolicy) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	cc.requestRetries = policy.Retries
+	cc.retryInterval = policy.RetryInterval
+	cc.httpClient = &http.Client{
+		Timeout:   policy.Timeout,
+		Transport: cc.transport,
+	}
+}
+
+func (cc *ccClient) requestPolicy() (*http.Client, int, time.Duration) {
+	cc.lock.RLock()
+	defer cc.lock.RUnlock()
+
+	return cc.httpClient, cc.requestRetries, cc.retryInterval
 }
 
-func (cc *ccClient) StagingComplete(stagingGuid string, completionCallback string, payload []byte, logger lager.Logger) error {
+// StagingComplete posts the staging response to CC, retrying failures that
+// may be temporary. Replays of journaled responses carry no app id and are
+// not budgeted.
+func (cc *ccClient) StagingComplete(stagingGuid string, appId string, completionCallback string, requestId string, payload []byte, logger lager.Logger) error {
 	logger = logger.Session("cc-client")
 	logger.Info("delivering-staging-response", lager.Data{"payload": string(payload)})
 
-	request, err := http.NewRequest("POST", cc.stagingCompleteURI(stagingGuid, completionCallback), bytes.NewReader(payload))
+	httpClient, requestRetries, retryInterval := cc.requestPolicy()
+
+	body := payload
+	if cc.gzipPayloads {
+		var err error
+		body, err = gzipPayload(payload)
+		if err != nil {
+			logger.Error("failed-to-compress-staging-response", err)
+			return err
+		}
+	}
+
+	var err error
+	for attempt := 0; attempt <= requestRetries; attempt++ {
+		err = cc.postStagingComplete(httpClient, cc.stagingCompleteURI(stagingGuid, completionCallback), requestId, body, logger)
+		if err == nil {
+			logger.Info("delivered-staging-response")
+			return nil
+		}
+
+		logger.Error("deliver-staging-response-failed", err, lager.Data{"attempt": attempt + 1})
+		if !isRetryable(err) || attempt == requestRetries {
+			break
+		}
+
+		if cc.retryBudget != nil && appId != "" {
+			budgetErr := cc.retryBudget.Spend(appId, stagingGuid)
+			if budgetErr != nil {
+				logger.Error("retry-budget-exhausted", budgetErr, lager.Data{"app-id": appId})
+				return budgetErr
+			}
+		}
+
+		cc.clock.Sleep(backoff(retryInterval, attempt))
+	}
+
+	return err
+}
+
+// backoff doubles the retry interval after every failed attempt, up to
+// MaxRetryInterval.
+func backoff(retryInterval time.Duration, attempt int) time.Duration {
+	interval := retryInterval
+	for i := 0; i < attempt && interval < MaxRetryInterval; i++ {
+		interval *= 2
+	}
+
+	if interval > MaxRetryInterval {
+		return MaxRetryInterval
+	}
+	return interval
+}
+
+func gzipPayload(payload []byte) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+
+	_, err := writer.Write(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (cc *ccClient) postStagingComplete(httpClient *http.Client, uri string, requestId string, payload []byte, logger lager.Logger) error {
+	request, err := http.NewRequest("POST", uri, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	err = cc.authorize(request, logger)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("content-type", "application/json")
+	if cc.gzipPayloads {
+		request.Header.Set("content-encoding", "gzip")
+	}
+	if requestId != "" {
+		request.Header.Set(tracing.RequestIdHeader, requestId)
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		cc.rejected(response.StatusCode)
+		return &BadResponseError{response.StatusCode}
+	}
+
+	return nil
+}
+
+func (cc *ccClient) authorize(request *http.Request, logger lager.Logger) error {
+	if cc.uaaClient == nil {
+		request.SetBasicAuth(cc.username, cc.password)
+		return nil
+	}
+
+	token, err := cc.uaaClient.Token(logger)
 	if err != nil {
 		return err
 	}
 
-	request.SetBasicAuth(cc.username, cc.password)
+	request.Header.Set("authorization", "bearer "+token)
+	return nil
+}
+
+// rejected drops a token CC did not accept, e.g. because it was revoked, so
+// that the next request fetches a new one.
+func (cc *ccClient) rejected(statusCode int) {
+	if cc.uaaClient != nil && statusCode == http.StatusUnauthorized {
+		cc.uaaClient.Invalidate()
+	}
+}
+
+func isRetryable(err error) bool {
+	if responseErr, ok := err.(*BadResponseError); ok {
+		return responseErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// StagingStarted posts the cell a staging task started running on to the
+// startedCallback URL.
+func (cc *ccClient) StagingStarted(stagingGuid string, startedCallback string, cellId string, logger lager.Logger) error {
+	logger = logger.Session("cc-client")
+	logger.Info("delivering-staging-started", lager.Data{"staging-guid": stagingGuid, "cell-id": cellId, "callback": startedCallback})
+
+	payload, err := json.Marshal(StagingStartedPayload{CellId: cellId})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", startedCallback, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	err = cc.authorize(request, logger)
+	if err != nil {
+		logger.Error("deliver-staging-started-failed", err)
+		return err
+	}
 	request.Header.Set("content-type", "application/json")
 
-	response, err := cc.httpClient.Do(request)
+	httpClient, _, _ := cc.requestPolicy()
+
+	response, err := httpClient.Do(request)
 	if err != nil {
-		logger.Error("deliver-staging-response-failed", err)
+		logger.Error("deliver-staging-started-failed", err)
 		return err
 	}
 
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
+		cc.rejected(response.StatusCode)
 		return &BadResponseError{response.StatusCode}
 	}
 
-	logger.Info("delivered-staging-response")
+	logger.Info("delivered-staging-started")
 	return nil
 }
 
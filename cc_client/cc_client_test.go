@@ -1,14 +1,24 @@
 package cc_client_test
 
 import (
+	"compress/gzip"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/retry_budget"
+	uaa_fakes "code.cloudfoundry.org/stager/uaa_client/fakes"
+	"github.com/cloudfoundry/dropsonde"
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
@@ -16,7 +26,8 @@ import (
 
 var _ = Describe("CC Client", func() {
 	var (
-		fakeCC *ghttp.Server
+		fakeCC    *ghttp.Server
+		fakeClock *fakeclock.FakeClock
 
 		logger   lager.Logger
 		ccClient cc_client.CcClient
@@ -27,11 +38,12 @@ var _ = Describe("CC Client", func() {
 
 	BeforeEach(func() {
 		fakeCC = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
 
 		logger = lager.NewLogger("fakelogger")
 		logger.RegisterSink(lager.NewWriterSink(GinkgoWriter, lager.DEBUG))
 
-		ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true)
+		ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", nil, &tls.Config{InsecureSkipVerify: true}, cc_client.DefaultRequestTimeout, 0, 0, false, false, nil, fakeClock)
 
 		stagingGuid = "the-staging-guid"
 		completionCallback = ""
@@ -43,6 +55,41 @@ var _ = Describe("CC Client", func() {
 		}
 	})
 
+	Describe("instrumentation", func() {
+		var fakeEmitter *fake.FakeEventEmitter
+
+		BeforeEach(func() {
+			fakeEmitter = fake.NewFakeEventEmitter("stager")
+			dropsonde.InitializeWithEmitter(fakeEmitter)
+
+			fakeCC.AppendHandlers(ghttp.RespondWith(http.StatusOK, `{}`))
+		})
+
+		AfterEach(func() {
+			dropsonde.InitializeWithEmitter(fake.NewFakeEventEmitter("stager"))
+		})
+
+		It("does not emit HTTP events by default", func() {
+			err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeEmitter.GetEvents()).To(BeEmpty())
+		})
+
+		Context("when told to emit HTTP events", func() {
+			BeforeEach(func() {
+				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", nil, &tls.Config{InsecureSkipVerify: true}, cc_client.DefaultRequestTimeout, 0, 0, false, true, nil, fakeClock)
+			})
+
+			It("emits HTTP events for the callback request", func() {
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeEmitter.GetEvents()).NotTo(BeEmpty())
+			})
+		})
+	})
+
 	Describe("Successfully calling the Cloud Controller", func() {
 		It("calls the callback URL if it exists", func() {
 			completionCallback = fmt.Sprintf("%s/awesome/potato/staging_complete", fakeCC.URL())
@@ -53,7 +100,7 @@ var _ = Describe("CC Client", func() {
 				),
 			)
 
-			err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+			err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -78,8 +125,131 @@ var _ = Describe("CC Client", func() {
 			})
 
 			It("sends the request payload to the CC without modification", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, expectedBody, logger)
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", expectedBody, logger)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when the staging has a request id", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid)),
+						ghttp.VerifyHeaderKV("X-Vcap-Request-Id", "the-request-id"),
+						ghttp.RespondWith(200, `{}`),
+					),
+				)
+			})
+
+			It("sends the request id to the CC", func() {
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "the-request-id", []byte(`{}`), logger)
 				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when payloads are compressed", func() {
+			var expectedBody = []byte(`{"result":"the-result"}`)
+
+			BeforeEach(func() {
+				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", nil, &tls.Config{InsecureSkipVerify: true}, cc_client.DefaultRequestTimeout, 0, 0, true, false, nil, fakeClock)
+
+				fakeCC.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid)),
+						ghttp.VerifyHeaderKV("Content-Encoding", "gzip"),
+						func(w http.ResponseWriter, req *http.Request) {
+							reader, err := gzip.NewReader(req.Body)
+							Expect(err).NotTo(HaveOccurred())
+							body, err := ioutil.ReadAll(reader)
+							Expect(err).NotTo(HaveOccurred())
+							Expect(body).To(Equal(expectedBody))
+						},
+					),
+				)
+			})
+
+			It("sends the payload compressed with gzip", func() {
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", expectedBody, logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("StagingStarted", func() {
+		BeforeEach(func() {
+			fakeCC.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", fmt.Sprintf("/staging/%s/started", stagingGuid)),
+					ghttp.VerifyBasicAuth("username", "password"),
+					ghttp.VerifyJSON(`{"cell_id":"the-cell-id"}`),
+					ghttp.RespondWith(200, `{}`),
+				),
+			)
+		})
+
+		It("posts the cell id to the started callback", func() {
+			err := ccClient.StagingStarted(stagingGuid, fmt.Sprintf("%s/staging/%s/started", fakeCC.URL(), stagingGuid), "the-cell-id", logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Describe("UAA authentication", func() {
+		var fakeUAAClient *uaa_fakes.FakeClient
+
+		BeforeEach(func() {
+			fakeUAAClient = &uaa_fakes.FakeClient{}
+			fakeUAAClient.TokenReturns("the-token", nil)
+			ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", fakeUAAClient, &tls.Config{InsecureSkipVerify: true}, cc_client.DefaultRequestTimeout, 0, 0, false, false, nil, fakeClock)
+		})
+
+		Context("when CC accepts the token", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", fmt.Sprintf("/internal/staging/%s/completed", stagingGuid)),
+						ghttp.VerifyHeaderKV("Authorization", "bearer the-token"),
+						ghttp.RespondWith(200, `{}`),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", fmt.Sprintf("/staging/%s/started", stagingGuid)),
+						ghttp.VerifyHeaderKV("Authorization", "bearer the-token"),
+						ghttp.RespondWith(200, `{}`),
+					),
+				)
+			})
+
+			It("sends the token instead of basic auth credentials", func() {
+				Expect(ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)).To(Succeed())
+				Expect(ccClient.StagingStarted(stagingGuid, fmt.Sprintf("%s/staging/%s/started", fakeCC.URL(), stagingGuid), "the-cell-id", logger)).To(Succeed())
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(2))
+				Expect(fakeUAAClient.InvalidateCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when CC rejects the token", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(ghttp.RespondWith(401, `{}`))
+			})
+
+			It("invalidates it so that the next request fetches a new one", func() {
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
+				Expect(err).To(Equal(&cc_client.BadResponseError{StatusCode: 401}))
+				Expect(fakeUAAClient.InvalidateCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when no token can be fetched", func() {
+			BeforeEach(func() {
+				fakeUAAClient.TokenReturns("", errors.New("uaa down"))
+			})
+
+			It("does not call CC", func() {
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
+				Expect(err).To(MatchError("uaa down"))
+				Expect(fakeCC.ReceivedRequests()).To(BeEmpty())
 			})
 		})
 	})
@@ -101,36 +271,174 @@ var _ = Describe("CC Client", func() {
 
 		Context("when certificate verfication is enabled", func() {
 			BeforeEach(func() {
-				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", false)
+				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", nil, &tls.Config{}, cc_client.DefaultRequestTimeout, 0, 0, false, false, nil, fakeClock)
 			})
 
 			It("fails with a self-signed certificate", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
 				Expect(err).To(HaveOccurred())
 			})
 		})
 
 		Context("when certificate verfication is disabled", func() {
 			BeforeEach(func() {
-				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", true)
+				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", nil, &tls.Config{InsecureSkipVerify: true}, cc_client.DefaultRequestTimeout, 0, 0, false, false, nil, fakeClock)
 			})
 
 			It("Attempts to validate SSL certificates", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
 				Expect(err).NotTo(HaveOccurred())
 			})
 		})
 	})
 
+	Describe("Retries", func() {
+		BeforeEach(func() {
+			ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", nil, &tls.Config{InsecureSkipVerify: true}, cc_client.DefaultRequestTimeout, 2, 50*time.Millisecond, false, false, nil, fakeClock)
+		})
+
+		Context("when the CC fails with a server error and then succeeds", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.RespondWith(503, `{}`),
+					ghttp.RespondWith(500, `{}`),
+					ghttp.RespondWith(200, `{}`),
+				)
+			})
+
+			It("backs off exponentially between attempts until the response is delivered", func() {
+				errs := make(chan error, 1)
+				go func() {
+					errs <- ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
+				}()
+
+				Eventually(fakeClock.WatcherCount).Should(Equal(1))
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+
+				fakeClock.Increment(50*time.Millisecond - time.Nanosecond)
+				Consistently(fakeCC.ReceivedRequests).Should(HaveLen(1))
+
+				fakeClock.WaitForWatcherAndIncrement(time.Nanosecond)
+				Eventually(fakeCC.ReceivedRequests).Should(HaveLen(2))
+
+				fakeClock.WaitForWatcherAndIncrement(100*time.Millisecond - time.Nanosecond)
+				Consistently(fakeCC.ReceivedRequests).Should(HaveLen(2))
+
+				fakeClock.Increment(time.Nanosecond)
+				Eventually(errs).Should(Receive(BeNil()))
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(3))
+			})
+		})
+
+		Context("when the CC keeps failing", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.RespondWith(503, `{}`),
+					ghttp.RespondWith(503, `{}`),
+					ghttp.RespondWith(503, `{}`),
+				)
+			})
+
+			It("gives up after the configured retries", func() {
+				errs := make(chan error, 1)
+				go func() {
+					errs <- ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
+				}()
+
+				fakeClock.WaitForWatcherAndIncrement(50 * time.Millisecond)
+				fakeClock.WaitForWatcherAndIncrement(100 * time.Millisecond)
+
+				Eventually(errs).Should(Receive(Equal(&cc_client.BadResponseError{StatusCode: 503})))
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(3))
+			})
+		})
+
+		Context("when a retry budget is given", func() {
+			var budget retry_budget.RetryBudget
+
+			BeforeEach(func() {
+				budget = retry_budget.NewRetryBudget(2, 0, fakeClock)
+				Expect(budget.Spend("app-id", stagingGuid)).To(Succeed())
+
+				ccClient = cc_client.NewCcClient(fakeCC.URL(), "username", "password", nil, &tls.Config{InsecureSkipVerify: true}, cc_client.DefaultRequestTimeout, 2, 50*time.Millisecond, false, false, budget, fakeClock)
+
+				fakeCC.AppendHandlers(
+					ghttp.RespondWith(503, `{}`),
+					ghttp.RespondWith(503, `{}`),
+					ghttp.RespondWith(503, `{}`),
+				)
+			})
+
+			It("spends the budget of the staging on every retry and stops once it is exhausted", func() {
+				errs := make(chan error, 1)
+				go func() {
+					errs <- ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
+				}()
+
+				fakeClock.WaitForWatcherAndIncrement(50 * time.Millisecond)
+
+				Eventually(errs).Should(Receive(Equal(retry_budget.ErrRetryBudgetExhausted)))
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(2))
+			})
+
+			It("does not budget responses without an app id", func() {
+				errs := make(chan error, 1)
+				go func() {
+					errs <- ccClient.StagingComplete(stagingGuid, "", completionCallback, "", []byte(`{}`), logger)
+				}()
+
+				fakeClock.WaitForWatcherAndIncrement(50 * time.Millisecond)
+				fakeClock.WaitForWatcherAndIncrement(100 * time.Millisecond)
+
+				Eventually(errs).Should(Receive(Equal(&cc_client.BadResponseError{StatusCode: 503})))
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(3))
+			})
+		})
+
+		Context("when the request policy is changed", func() {
+			BeforeEach(func() {
+				ccClient.SetRequestPolicy(cc_client.RequestPolicy{
+					Timeout:       cc_client.DefaultRequestTimeout,
+					Retries:       0,
+					RetryInterval: 50 * time.Millisecond,
+				})
+
+				fakeCC.AppendHandlers(
+					ghttp.RespondWith(503, `{}`),
+				)
+			})
+
+			It("uses the new policy for subsequent requests", func() {
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
+				Expect(err).To(Equal(&cc_client.BadResponseError{StatusCode: 503}))
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+
+		Context("when the CC rejects the request", func() {
+			BeforeEach(func() {
+				fakeCC.AppendHandlers(
+					ghttp.RespondWith(404, `{}`),
+				)
+			})
+
+			It("does not retry", func() {
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
+				Expect(err).To(Equal(&cc_client.BadResponseError{StatusCode: 404}))
+				Expect(fakeCC.ReceivedRequests()).To(HaveLen(1))
+			})
+		})
+	})
+
 	Describe("Error conditions", func() {
 		Context("when the request couldn't be completed", func() {
 			BeforeEach(func() {
 				bogusURL := "http://0.0.0.0.0:80"
-				ccClient = cc_client.NewCcClient(bogusURL, "username", "password", true)
+				ccClient = cc_client.NewCcClient(bogusURL, "username", "password", nil, &tls.Config{InsecureSkipVerify: true}, cc_client.DefaultRequestTimeout, 0, 0, false, false, nil, fakeClock)
 			})
 
 			It("percolates the error", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(BeAssignableToTypeOf(&url.Error{}))
 			})
@@ -147,13 +455,47 @@ var _ = Describe("CC Client", func() {
 			})
 
 			It("returns an error with the actual status code", func() {
-				err := ccClient.StagingComplete(stagingGuid, completionCallback, []byte(`{}`), logger)
+				err := ccClient.StagingComplete(stagingGuid, "app-id", completionCallback, "", []byte(`{}`), logger)
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(BeAssignableToTypeOf(&cc_client.BadResponseError{}))
 				Expect(err.(*cc_client.BadResponseError).StatusCode).To(Equal(500))
 			})
 		})
 	})
+
+	Describe("NewTLSConfig", func() {
+		It("trusts the system CAs and presents no client certificate by default", func() {
+			config, err := cc_client.NewTLSConfig("", "", "", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.RootCAs).To(BeNil())
+			Expect(config.Certificates).To(BeEmpty())
+			Expect(config.InsecureSkipVerify).To(BeFalse())
+		})
+
+		It("skips certificate verification when asked to", func() {
+			config, err := cc_client.NewTLSConfig("", "", "", true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.InsecureSkipVerify).To(BeTrue())
+		})
+
+		It("fails when the CA cert has no certificates", func() {
+			caCert, err := ioutil.TempFile("", "ca-cert")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(caCert.Name())
+
+			_, err = caCert.WriteString("not a cert")
+			Expect(err).NotTo(HaveOccurred())
+			caCert.Close()
+
+			_, err = cc_client.NewTLSConfig(caCert.Name(), "", "", false)
+			Expect(err).To(MatchError("no certificates found in '" + caCert.Name() + "'"))
+		})
+
+		It("fails when the client key pair cannot be loaded", func() {
+			_, err := cc_client.NewTLSConfig("", "/nonexistent/cert", "/nonexistent/key", false)
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
 
 type testNetError struct {
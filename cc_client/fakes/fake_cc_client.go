@@ -9,30 +9,50 @@ import (
 )
 
 type FakeCcClient struct {
-	StagingCompleteStub        func(stagingGuid string, completionCallback string, payload []byte, logger lager.Logger) error
+	StagingCompleteStub        func(stagingGuid string, appId string, completionCallback string, requestId string, payload []byte, logger lager.Logger) error
 	stagingCompleteMutex       sync.RWMutex
 	stagingCompleteArgsForCall []struct {
 		stagingGuid        string
+		appId              string
 		completionCallback string
+		requestId          string
 		payload            []byte
 		logger             lager.Logger
 	}
 	stagingCompleteReturns struct {
 		result1 error
 	}
+	StagingStartedStub        func(stagingGuid string, startedCallback string, cellId string, logger lager.Logger) error
+	stagingStartedMutex       sync.RWMutex
+	stagingStartedArgsForCall []struct {
+		stagingGuid     string
+		startedCallback string
+		cellId          string
+		logger          lager.Logger
+	}
+	stagingStartedReturns struct {
+		result1 error
+	}
+	SetRequestPolicyStub        func(policy cc_client.RequestPolicy)
+	setRequestPolicyMutex       sync.RWMutex
+	setRequestPolicyArgsForCall []struct {
+		policy cc_client.RequestPolicy
+	}
 }
 
-func (fake *FakeCcClient) StagingComplete(stagingGuid string, completionCallback string, payload []byte, logger lager.Logger) error {
+func (fake *FakeCcClient) StagingComplete(stagingGuid string, appId string, completionCallback string, requestId string, payload []byte, logger lager.Logger) error {
 	fake.stagingCompleteMutex.Lock()
 	fake.stagingCompleteArgsForCall = append(fake.stagingCompleteArgsForCall, struct {
 		stagingGuid        string
+		appId              string
 		completionCallback string
+		requestId          string
 		payload            []byte
 		logger             lager.Logger
-	}{stagingGuid, completionCallback, payload, logger})
+	}{stagingGuid, appId, completionCallback, requestId, payload, logger})
 	fake.stagingCompleteMutex.Unlock()
 	if fake.StagingCompleteStub != nil {
-		return fake.StagingCompleteStub(stagingGuid, completionCallback, payload, logger)
+		return fake.StagingCompleteStub(stagingGuid, appId, completionCallback, requestId, payload, logger)
 	} else {
 		return fake.stagingCompleteReturns.result1
 	}
@@ -57,4 +77,62 @@ func (fake *FakeCcClient) StagingCompleteReturns(result1 error) {
 	}{result1}
 }
 
+func (fake *FakeCcClient) StagingStarted(stagingGuid string, startedCallback string, cellId string, logger lager.Logger) error {
+	fake.stagingStartedMutex.Lock()
+	fake.stagingStartedArgsForCall = append(fake.stagingStartedArgsForCall, struct {
+		stagingGuid     string
+		startedCallback string
+		cellId          string
+		logger          lager.Logger
+	}{stagingGuid, startedCallback, cellId, logger})
+	fake.stagingStartedMutex.Unlock()
+	if fake.StagingStartedStub != nil {
+		return fake.StagingStartedStub(stagingGuid, startedCallback, cellId, logger)
+	} else {
+		return fake.stagingStartedReturns.result1
+	}
+}
+
+func (fake *FakeCcClient) StagingStartedCallCount() int {
+	fake.stagingStartedMutex.RLock()
+	defer fake.stagingStartedMutex.RUnlock()
+	return len(fake.stagingStartedArgsForCall)
+}
+
+func (fake *FakeCcClient) StagingStartedArgsForCall(i int) (string, string, string, lager.Logger) {
+	fake.stagingStartedMutex.RLock()
+	defer fake.stagingStartedMutex.RUnlock()
+	return fake.stagingStartedArgsForCall[i].stagingGuid, fake.stagingStartedArgsForCall[i].startedCallback, fake.stagingStartedArgsForCall[i].cellId, fake.stagingStartedArgsForCall[i].logger
+}
+
+func (fake *FakeCcClient) StagingStartedReturns(result1 error) {
+	fake.StagingStartedStub = nil
+	fake.stagingStartedReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeCcClient) SetRequestPolicy(policy cc_client.RequestPolicy) {
+	fake.setRequestPolicyMutex.Lock()
+	fake.setRequestPolicyArgsForCall = append(fake.setRequestPolicyArgsForCall, struct {
+		policy cc_client.RequestPolicy
+	}{policy})
+	fake.setRequestPolicyMutex.Unlock()
+	if fake.SetRequestPolicyStub != nil {
+		fake.SetRequestPolicyStub(policy)
+	}
+}
+
+func (fake *FakeCcClient) SetRequestPolicyCallCount() int {
+	fake.setRequestPolicyMutex.RLock()
+	defer fake.setRequestPolicyMutex.RUnlock()
+	return len(fake.setRequestPolicyArgsForCall)
+}
+
+func (fake *FakeCcClient) SetRequestPolicyArgsForCall(i int) cc_client.RequestPolicy {
+	fake.setRequestPolicyMutex.RLock()
+	defer fake.setRequestPolicyMutex.RUnlock()
+	return fake.setRequestPolicyArgsForCall[i].policy
+}
+
 var _ cc_client.CcClient = new(FakeCcClient)
@@ -0,0 +1,73 @@
+package circuit_breaker
+
+import (
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/events"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+)
+
+// IsBBSFailure treats errors the BBS returns for a well-formed response to a
+// bad request, e.g. a missing or duplicate task, as successful calls.
+func IsBBSFailure(err error) bool {
+	return !(models.ErrResourceNotFound.Equal(err) ||
+		models.ErrResourceExists.Equal(err) ||
+		models.ErrResourceConflict.Equal(err) ||
+		models.ErrBadRequest.Equal(err))
+}
+
+type bbsClient struct {
+	bbs.Client
+	breaker Breaker
+}
+
+// NewBBSClient guards the task calls made by the stager with the breaker.
+// Ping is not guarded so that health checks still see the BBS recover.
+func NewBBSClient(client bbs.Client, breaker Breaker) bbs.Client {
+	return &bbsClient{
+		Client:  client,
+		breaker: breaker,
+	}
+}
+
+func (c *bbsClient) DesireTask(logger lager.Logger, guid, domain string, def *models.TaskDefinition) error {
+	return c.breaker.Call(func() error {
+		return c.Client.DesireTask(logger, guid, domain, def)
+	})
+}
+
+func (c *bbsClient) TaskByGuid(logger lager.Logger, guid string) (*models.Task, error) {
+	var task *models.Task
+	err := c.breaker.Call(func() error {
+		var err error
+		task, err = c.Client.TaskByGuid(logger, guid)
+		return err
+	})
+	return task, err
+}
+
+func (c *bbsClient) TasksByDomain(logger lager.Logger, domain string) ([]*models.Task, error) {
+	var tasks []*models.Task
+	err := c.breaker.Call(func() error {
+		var err error
+		tasks, err = c.Client.TasksByDomain(logger, domain)
+		return err
+	})
+	return tasks, err
+}
+
+func (c *bbsClient) CancelTask(logger lager.Logger, taskGuid string) error {
+	return c.breaker.Call(func() error {
+		return c.Client.CancelTask(logger, taskGuid)
+	})
+}
+
+func (c *bbsClient) SubscribeToTaskEvents(logger lager.Logger) (events.EventSource, error) {
+	var eventSource events.EventSource
+	err := c.breaker.Call(func() error {
+		var err error
+		eventSource, err = c.Client.SubscribeToTaskEvents(logger)
+		return err
+	})
+	return eventSource, err
+}
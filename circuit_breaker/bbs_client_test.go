@@ -0,0 +1,74 @@
+package circuit_breaker_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/circuit_breaker"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BBSClient", func() {
+	var (
+		logger        *lagertest.TestLogger
+		fakeBBSClient *fake_bbs.FakeClient
+		client        bbs.Client
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		breaker := circuit_breaker.NewBreaker(logger, "BBS", 1, time.Minute, circuit_breaker.IsBBSFailure, fakeclock.NewFakeClock(time.Now()))
+		client = circuit_breaker.NewBBSClient(fakeBBSClient, breaker)
+	})
+
+	It("passes calls through to the BBS", func() {
+		fakeBBSClient.TaskByGuidReturns(&models.Task{TaskGuid: "the-task-guid"}, nil)
+
+		task, err := client.TaskByGuid(logger, "the-task-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(task.TaskGuid).To(Equal("the-task-guid"))
+	})
+
+	It("does not open for errors about the request", func() {
+		fakeBBSClient.DesireTaskReturns(models.ErrResourceExists)
+
+		Expect(client.DesireTask(logger, "guid", "domain", &models.TaskDefinition{})).To(Equal(models.ErrResourceExists))
+		Expect(client.DesireTask(logger, "guid", "domain", &models.TaskDefinition{})).To(Equal(models.ErrResourceExists))
+		Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(2))
+	})
+
+	Context("when the BBS fails", func() {
+		BeforeEach(func() {
+			fakeBBSClient.TasksByDomainReturns(nil, errors.New("connection refused"))
+			_, err := client.TasksByDomain(logger, "domain")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails task calls fast", func() {
+			err := client.DesireTask(logger, "guid", "domain", &models.TaskDefinition{})
+			Expect(err).To(BeAssignableToTypeOf(&circuit_breaker.OpenError{}))
+			Expect(fakeBBSClient.DesireTaskCallCount()).To(Equal(0))
+
+			err = client.CancelTask(logger, "guid")
+			Expect(err).To(BeAssignableToTypeOf(&circuit_breaker.OpenError{}))
+			Expect(fakeBBSClient.CancelTaskCallCount()).To(Equal(0))
+
+			_, err = client.SubscribeToTaskEvents(logger)
+			Expect(err).To(BeAssignableToTypeOf(&circuit_breaker.OpenError{}))
+			Expect(fakeBBSClient.SubscribeToTaskEventsCallCount()).To(Equal(0))
+		})
+
+		It("still pings the BBS", func() {
+			fakeBBSClient.PingReturns(true)
+			Expect(client.Ping(logger)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,184 @@
+package circuit_breaker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// OpenError is returned without making the call while the breaker is open.
+type OpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open, retry after %s", e.RetryAfter)
+}
+
+// Breaker stops making calls to a dependency after consecutive failures.
+// Once the reset timeout has passed, a single trial call is let through: the
+// breaker closes if it succeeds and opens again if it fails.
+type Breaker interface {
+	Call(fn func() error) error
+	State() State
+}
+
+// FailureClassifier decides whether an error returned by a call means the
+// dependency is unhealthy, as opposed to e.g. the request being invalid.
+type FailureClassifier func(err error) bool
+
+type breaker struct {
+	logger       lager.Logger
+	stateMetric  metric.Metric
+	tripsCounter metric.Counter
+	threshold    int
+	resetTimeout time.Duration
+	isFailure    FailureClassifier
+	clock        clock.Clock
+
+	lock     sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewBreaker returns a Breaker that opens after threshold consecutive
+// failures. A threshold of zero or less never opens. The state of the
+// breaker is emitted as the <name>CircuitBreakerState metric (0 closed, 1
+// half-open, 2 open) and every time it opens <name>CircuitBreakerTrips is
+// incremented.
+func NewBreaker(logger lager.Logger, name string, threshold int, resetTimeout time.Duration, isFailure FailureClassifier, clock clock.Clock) Breaker {
+	if threshold <= 0 {
+		return closed{}
+	}
+
+	return &breaker{
+		logger:       logger.Session("circuit-breaker", lager.Data{"name": name}),
+		stateMetric:  metric.Metric(name + "CircuitBreakerState"),
+		tripsCounter: metric.Counter(name + "CircuitBreakerTrips"),
+		threshold:    threshold,
+		resetTimeout: resetTimeout,
+		isFailure:    isFailure,
+		clock:        clock,
+	}
+}
+
+func (b *breaker) Call(fn func() error) error {
+	err := b.before()
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	b.after(err != nil && b.isFailure(err))
+	return err
+}
+
+func (b *breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
+
+func (b *breaker) before() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case Open:
+		elapsed := b.clock.Since(b.openedAt)
+		if elapsed < b.resetTimeout {
+			return &OpenError{RetryAfter: b.resetTimeout - elapsed}
+		}
+		b.transition(HalfOpen)
+		b.trial = true
+		return nil
+	case HalfOpen:
+		if b.trial {
+			return &OpenError{RetryAfter: b.resetTimeout}
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *breaker) after(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == HalfOpen {
+		b.trial = false
+		if failed {
+			b.trip()
+		} else {
+			b.failures = 0
+			b.transition(Closed)
+		}
+		return
+	}
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == Closed && b.failures >= b.threshold {
+		b.trip()
+	}
+}
+
+func (b *breaker) trip() {
+	b.openedAt = b.clock.Now()
+	b.transition(Open)
+	b.tripsCounter.Increment()
+}
+
+func (b *breaker) transition(state State) {
+	b.logger.Info("state-changed", lager.Data{"from": b.state.String(), "to": state.String(), "failures": b.failures})
+	b.state = state
+
+	err := b.stateMetric.Send(int(state))
+	if err != nil {
+		b.logger.Error("failed-to-send-state-metric", err)
+	}
+}
+
+type closed struct{}
+
+func (closed) Call(fn func() error) error {
+	return fn()
+}
+
+func (closed) State() State {
+	return Closed
+}
@@ -0,0 +1,13 @@
+package circuit_breaker_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Circuit Breaker Suite")
+}
@@ -0,0 +1,141 @@
+package circuit_breaker_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/circuit_breaker"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Breaker", func() {
+	var (
+		fakeClock    *fakeclock.FakeClock
+		metricSender *fake.FakeMetricSender
+		breaker      circuit_breaker.Breaker
+		callErr      error
+		calls        int
+	)
+
+	call := func() error {
+		return breaker.Call(func() error {
+			calls++
+			return callErr
+		})
+	}
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		metricSender = fake.NewFakeMetricSender()
+		metrics.Initialize(metricSender, nil)
+
+		isFailure := func(err error) bool {
+			return err.Error() != "not a failure"
+		}
+		breaker = circuit_breaker.NewBreaker(lagertest.NewTestLogger("test"), "Test", 2, time.Minute, isFailure, fakeClock)
+		callErr = errors.New("boom")
+		calls = 0
+	})
+
+	It("starts closed", func() {
+		Expect(breaker.State()).To(Equal(circuit_breaker.Closed))
+	})
+
+	It("opens after consecutive failures", func() {
+		Expect(call()).To(Equal(callErr))
+		Expect(breaker.State()).To(Equal(circuit_breaker.Closed))
+
+		Expect(call()).To(Equal(callErr))
+		Expect(breaker.State()).To(Equal(circuit_breaker.Open))
+		Expect(metricSender.GetValue("TestCircuitBreakerState").Value).To(BeEquivalentTo(2))
+		Expect(metricSender.GetCounter("TestCircuitBreakerTrips")).To(BeEquivalentTo(1))
+	})
+
+	It("does not count errors that are not failures", func() {
+		callErr = errors.New("not a failure")
+		Expect(call()).To(Equal(callErr))
+		Expect(call()).To(Equal(callErr))
+		Expect(breaker.State()).To(Equal(circuit_breaker.Closed))
+	})
+
+	It("resets the count after a success", func() {
+		Expect(call()).To(Equal(callErr))
+
+		callErr = nil
+		Expect(call()).To(Succeed())
+
+		callErr = errors.New("boom")
+		Expect(call()).To(Equal(callErr))
+		Expect(breaker.State()).To(Equal(circuit_breaker.Closed))
+	})
+
+	Context("when open", func() {
+		BeforeEach(func() {
+			call()
+			call()
+			calls = 0
+		})
+
+		It("fails fast without making the call", func() {
+			fakeClock.Increment(20 * time.Second)
+			Expect(call()).To(Equal(&circuit_breaker.OpenError{RetryAfter: 40 * time.Second}))
+			Expect(calls).To(Equal(0))
+		})
+
+		Context("after the reset timeout", func() {
+			BeforeEach(func() {
+				fakeClock.Increment(time.Minute)
+			})
+
+			It("closes when a trial call succeeds", func() {
+				callErr = nil
+				Expect(call()).To(Succeed())
+				Expect(calls).To(Equal(1))
+				Expect(breaker.State()).To(Equal(circuit_breaker.Closed))
+				Expect(metricSender.GetValue("TestCircuitBreakerState").Value).To(BeEquivalentTo(0))
+			})
+
+			It("opens again when a trial call fails", func() {
+				Expect(call()).To(Equal(callErr))
+				Expect(breaker.State()).To(Equal(circuit_breaker.Open))
+				Expect(metricSender.GetCounter("TestCircuitBreakerTrips")).To(BeEquivalentTo(2))
+
+				Expect(call()).To(BeAssignableToTypeOf(&circuit_breaker.OpenError{}))
+				Expect(calls).To(Equal(1))
+			})
+
+			It("lets a single trial call through at a time", func() {
+				var trialErr error
+				breaker.Call(func() error {
+					Expect(breaker.State()).To(Equal(circuit_breaker.HalfOpen))
+					trialErr = call()
+					return nil
+				})
+
+				Expect(trialErr).To(BeAssignableToTypeOf(&circuit_breaker.OpenError{}))
+				Expect(calls).To(Equal(0))
+				Expect(breaker.State()).To(Equal(circuit_breaker.Closed))
+			})
+		})
+	})
+
+	Context("when the threshold is zero", func() {
+		BeforeEach(func() {
+			breaker = circuit_breaker.NewBreaker(lagertest.NewTestLogger("test"), "Test", 0, time.Minute, circuit_breaker.IsBBSFailure, fakeClock)
+		})
+
+		It("never opens", func() {
+			for i := 0; i < 10; i++ {
+				Expect(call()).To(Equal(callErr))
+			}
+			Expect(breaker.State()).To(Equal(circuit_breaker.Closed))
+			Expect(calls).To(Equal(10))
+		})
+	})
+})
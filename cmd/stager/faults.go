@@ -0,0 +1,59 @@
+//go:build faults
+// +build faults
+
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/fault_injection"
+	"code.cloudfoundry.org/stager/nats_connection"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/http_server"
+)
+
+// Stagers built with the faults tag can have failures injected through an
+// admin endpoint, to exercise their retries in integration tests. Other
+// builds leave every dependency untouched; see no_faults.go.
+
+var faultInjectionAddress = flag.String(
+	"faultInjectionAddress",
+	"",
+	"Address from which the Stager serves the faults it injects at /faults. If empty, no faults can be injected",
+)
+
+var faultInjector = fault_injection.NewInjector()
+
+func injectBBSFaults(bbsClient bbs.Client) bbs.Client {
+	if *faultInjectionAddress == "" {
+		return bbsClient
+	}
+	return fault_injection.NewBBSClient(bbsClient, faultInjector, clock.NewClock())
+}
+
+func injectNATSFaults(logger lager.Logger, dial nats_connection.Dialer) nats_connection.Dialer {
+	if *faultInjectionAddress == "" {
+		return dial
+	}
+	return fault_injection.NewDialer(logger, dial, faultInjector)
+}
+
+func injectStagerFaults(logger lager.Logger, handler http.Handler) http.Handler {
+	if *faultInjectionAddress == "" {
+		return handler
+	}
+	return fault_injection.NewStagerHandler(logger, handler, faultInjector)
+}
+
+func initializeFaultInjectionServer(logger lager.Logger) ifrit.Runner {
+	if *faultInjectionAddress == "" {
+		return nil
+	}
+
+	logger.Info("fault-injection-enabled", lager.Data{"address": *faultInjectionAddress})
+	return http_server.New(*faultInjectionAddress, fault_injection.NewHandler(logger, faultInjector))
+}
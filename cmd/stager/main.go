@@ -1,19 +1,26 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/cloudfoundry/dropsonde"
 	"github.com/hashicorp/consul/api"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
 	"github.com/tedsuo/ifrit/http_server"
 	"github.com/tedsuo/ifrit/sigmon"
+	"github.com/tedsuo/rata"
 
 	"code.cloudfoundry.org/bbs"
 	"code.cloudfoundry.org/cflager"
@@ -24,9 +31,43 @@ import (
 	"code.cloudfoundry.org/locket"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/runtimeschema/cc_messages/flags"
+	"code.cloudfoundry.org/stager"
 	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/bbs_retry"
+	"code.cloudfoundry.org/stager/bbs_timeout"
+	"code.cloudfoundry.org/stager/cache_client"
 	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/circuit_breaker"
+	"code.cloudfoundry.org/stager/completion_dedup"
+	"code.cloudfoundry.org/stager/config"
+	"code.cloudfoundry.org/stager/dead_letter"
+	"code.cloudfoundry.org/stager/docker_registry"
+	"code.cloudfoundry.org/stager/drain"
+	"code.cloudfoundry.org/stager/enrichment"
 	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/health"
+	"code.cloudfoundry.org/stager/leader_election"
+	"code.cloudfoundry.org/stager/log_relay"
+	"code.cloudfoundry.org/stager/metadata_hooks"
+	"code.cloudfoundry.org/stager/metrics_emitter"
+	"code.cloudfoundry.org/stager/nats_connection"
+	"code.cloudfoundry.org/stager/prometheus_metrics"
+	"code.cloudfoundry.org/stager/rate_limiter"
+	"code.cloudfoundry.org/stager/redelivery"
+	"code.cloudfoundry.org/stager/request_validation"
+	"code.cloudfoundry.org/stager/response_format"
+	"code.cloudfoundry.org/stager/response_journal"
+	"code.cloudfoundry.org/stager/retry_budget"
+	"code.cloudfoundry.org/stager/route_registrar"
+	"code.cloudfoundry.org/stager/staging_audit"
+	"code.cloudfoundry.org/stager/staging_events"
+	"code.cloudfoundry.org/stager/staging_limit"
+	"code.cloudfoundry.org/stager/staging_queue"
+	"code.cloudfoundry.org/stager/standby"
+	"code.cloudfoundry.org/stager/stats"
+	"code.cloudfoundry.org/stager/task_reaper"
+	"code.cloudfoundry.org/stager/task_watcher"
+	"code.cloudfoundry.org/stager/uaa_client"
 	"code.cloudfoundry.org/stager/vars"
 )
 
@@ -48,24 +89,108 @@ var ccPassword = flag.String(
 	"Basic auth password for CC internal API",
 )
 
+var ccUAAAuth = flag.Bool(
+	"ccUAAAuth",
+	false,
+	"Authenticate to the CC internal API with tokens fetched from -uaaURL as -uaaClientId, instead of with basic auth",
+)
+
 var privilegedContainers = flag.Bool(
 	"privilegedContainers",
 	false,
 	"Whether or not to use privileged containers for  buildpack based LRPs and tasks. Containers with a docker-image-based rootfs will continue to always be unprivileged and cannot be changed.",
 )
 
+var ccRequestTimeout = flag.Duration(
+	"ccRequestTimeout",
+	cc_client.DefaultRequestTimeout,
+	"Timeout for each staging completion request to the Cloud Controller",
+)
+
+var ccRequestRetries = flag.Int(
+	"ccRequestRetries",
+	0,
+	"Number of times a staging completion request to the Cloud Controller is retried after a network or server error",
+)
+
+var ccRequestRetryInterval = flag.Duration(
+	"ccRequestRetryInterval",
+	time.Second,
+	"Interval before the first retry of a staging completion request to the Cloud Controller. Doubles after every failed retry",
+)
+
+var gzipCCPayloads = flag.Bool(
+	"gzipCCPayloads",
+	false,
+	"Compress the staging responses sent to the Cloud Controller with gzip. The Cloud Controller must accept gzip-encoded requests",
+)
+
+var completionWorkers = flag.Int(
+	"completionWorkers",
+	0,
+	"Maximum number of staging completions delivered to the Cloud Controller concurrently. If zero, deliveries are not limited",
+)
+
+var completionDedupWindow = flag.Duration(
+	"completionDedupWindow",
+	completion_dedup.DefaultWindow,
+	"Time during which a staging completion the BBS calls back with again is not delivered to the Cloud Controller again. If zero, every callback is delivered",
+)
+
+var completionDedupSize = flag.Int(
+	"completionDedupSize",
+	completion_dedup.DefaultSize,
+	"Number of recently delivered staging completions remembered to suppress duplicate deliveries",
+)
+
 var skipCertVerify = flag.Bool(
 	"skipCertVerify",
 	false,
 	"skip SSL certificate verification",
 )
 
+var ccCACert = flag.String(
+	"ccCACert",
+	"",
+	"Path to the certificate authority cert used to verify CC. If empty, the system certificate authorities are trusted",
+)
+
+var ccClientCert = flag.String(
+	"ccClientCert",
+	"",
+	"Path to the client cert presented to CC for mutually authenticated TLS",
+)
+
+var ccClientKey = flag.String(
+	"ccClientKey",
+	"",
+	"Path to the client key presented to CC for mutually authenticated TLS",
+)
+
 var dropsondePort = flag.Int(
 	"dropsondePort",
 	3457,
 	"port the local metron agent is listening on",
 )
 
+var metricsEmitter = flag.String(
+	"metricsEmitter",
+	metrics_emitter.DropsondeEmitter,
+	"Where metrics are sent: 'dropsonde' sends them to the local metron agent, 'statsd' to -statsdAddress and 'none' drops them",
+)
+
+var statsdAddress = flag.String(
+	"statsdAddress",
+	"",
+	"host:port of the statsd server metrics are sent to when -metricsEmitter is 'statsd'",
+)
+
+var statsdPrefix = flag.String(
+	"statsdPrefix",
+	"stager",
+	"Prefix of the names of the metrics sent to statsd",
+)
+
 var bbsAddress = flag.String(
 	"bbsAddress",
 	"",
@@ -78,6 +203,12 @@ var listenAddress = flag.String(
 	"Address from which the Stager serves requests",
 )
 
+var healthAddress = flag.String(
+	"healthAddress",
+	"",
+	"Address from which the Stager serves its health check at /health. If empty, the health check is disabled",
+)
+
 var stagingTaskCallbackURL = flag.String(
 	"stagingTaskCallbackURL",
 	"",
@@ -96,6 +227,54 @@ var ccUploaderURL = flag.String(
 	"URL of the cc uploader",
 )
 
+var stagingCpuWeight = flag.Uint(
+	"stagingCpuWeight",
+	0,
+	"CPU weight of every staging task. If zero, the weight is derived from the memory of the staging task",
+)
+
+var dropletUploadSigningKey = flag.String(
+	"dropletUploadSigningKey",
+	"",
+	"Secret shared with the Cloud Controller to sign droplet upload URIs instead of passing on their embedded credentials. If empty, upload URIs are passed on as given",
+)
+
+var dropletUploadURLSlack = flag.Duration(
+	"dropletUploadURLSlack",
+	backend.DefaultDropletUploadURLSlack,
+	"How long signed droplet upload URIs remain valid beyond the staging timeout of their task",
+)
+
+var appBitsBlobstoreURL = flag.String(
+	"appBitsBlobstoreURL",
+	"",
+	"URL of CC's WebDAV blobstore from which staging tasks download app packages directly, rather than through CC. If empty, app bits are downloaded from the URL CC sends",
+)
+
+var appBitsBlobstoreDirectory = flag.String(
+	"appBitsBlobstoreDirectory",
+	backend.DefaultBlobstorePackagesDirectory,
+	"Directory of the app packages in the blobstore at -appBitsBlobstoreURL",
+)
+
+var appBitsBlobstoreSecret = flag.String(
+	"appBitsBlobstoreSecret",
+	"",
+	"Secret with which download links to the blobstore at -appBitsBlobstoreURL are signed",
+)
+
+var appBitsBlobstoreURLTTL = flag.Duration(
+	"appBitsBlobstoreURLTTL",
+	backend.DefaultBlobstoreURLTTL,
+	"How long signed app bits download links to the blobstore remain valid",
+)
+
+var requireTLSForCCTransfers = flag.Bool(
+	"requireTLSForCCTransfers",
+	false,
+	"only stage apps whose bits, build artifacts and droplets are transferred over https; the cells present their own client certificates",
+)
+
 var dockerRegistryAddress = flag.String(
 	"dockerRegistryAddress",
 	"",
@@ -108,12 +287,42 @@ var consulCluster = flag.String(
 	"Consul Agent URL",
 )
 
+var consulServiceName = flag.String(
+	"consulServiceName",
+	"stager",
+	"Name of the service the stager registers with consul. If empty, the stager does not register",
+)
+
+var stackScopedRegistration = flag.Bool(
+	"stackScopedRegistration",
+	false,
+	"Register the stager for its allowed stacks only: the consul service is tagged with each stack, and each route registration URI is prefixed with each stack, so that pools of stagers for different stacks can be addressed apart",
+)
+
+var consulHealthCheckInterval = flag.Duration(
+	"consulHealthCheckInterval",
+	10*time.Second,
+	"Interval at which consul checks the health of the stager at /health, when -healthAddress is set",
+)
+
 var dockerStagingStack = flag.String(
 	"dockerStagingStack",
 	"",
 	"Stack to use for staging Docker applications",
 )
 
+var dropletChecksums = flag.Bool(
+	"dropletChecksums",
+	false,
+	"Whether buildpack staging tasks compute the sha1 and sha256 checksums of the droplet they upload, reported to CC in the lifecycle metadata of the staging result. Not supported on Windows",
+)
+
+var windowsStagingTaskDomain = flag.String(
+	"windowsStagingTaskDomain",
+	"",
+	"domain to desire staging tasks for Windows cells in, defaults to the staging task domain",
+)
+
 var bbsCACert = flag.String(
 	"bbsCACert",
 	"",
@@ -144,7 +353,484 @@ var bbsMaxIdleConnsPerHost = flag.Int(
 	"Controls the maximum number of idle (keep-alive) connctions per host. If zero, golang's default will be used",
 )
 
+var bbsCallTimeout = flag.Duration(
+	"bbsCallTimeout",
+	0,
+	"Time after which task calls to the BBS are given up on. If zero, calls are not timed out",
+)
+
+var bbsMaxConcurrentCalls = flag.Int(
+	"bbsMaxConcurrentCalls",
+	0,
+	"Maximum number of task calls to the BBS in flight at once, including calls timed out that have not returned yet. If zero, calls are not limited",
+)
+
+var bbsCircuitBreakerThreshold = flag.Int(
+	"bbsCircuitBreakerThreshold",
+	0,
+	"Consecutive BBS failures after which task calls to the BBS fail fast until the reset timeout passes. If zero, calls never fail fast",
+)
+
+var bbsCircuitBreakerResetTimeout = flag.Duration(
+	"bbsCircuitBreakerResetTimeout",
+	30*time.Second,
+	"Time to fail BBS task calls fast before trying the BBS again",
+)
+
+var bbsDesireTaskRetries = flag.Int(
+	"bbsDesireTaskRetries",
+	0,
+	"Number of times to retry desiring a staging task when the BBS fails. A task found to exist on retry is treated as desired",
+)
+
+var bbsDesireTaskRetryInterval = flag.Duration(
+	"bbsDesireTaskRetryInterval",
+	500*time.Millisecond,
+	"Time to wait before retrying to desire a staging task, doubled after every failed retry",
+)
+
+var maxStagingAttempts = flag.Int(
+	"maxStagingAttempts",
+	0,
+	"Maximum attempts per staging of an app, counting resubmitted and rejected staging requests and retried deliveries to CC. If zero, attempts are not limited",
+)
+
+var maxStagingAttemptsWindow = flag.Duration(
+	"maxStagingAttemptsWindow",
+	retry_budget.DefaultWindow,
+	"How long the attempts of a staging that has not succeeded are remembered after its last attempt. If zero, they are remembered until the staging succeeds",
+)
+
+var publishStagingStarted = flag.Bool(
+	"publishStagingStarted",
+	false,
+	"Report when a staging task starts running on a cell",
+)
+
+var ccStagingStartedURL = flag.String(
+	"ccStagingStartedURL",
+	"",
+	"URL the cell a staging task started running on is posted to, with :staging_guid replaced by the staging guid, when -publishStagingStarted is set. If empty, only a task_started staging event is emitted",
+)
+
+var detectTimeout = flag.Duration(
+	"detectTimeout",
+	0,
+	"Maximum time detecting the buildpack of a linux app may take before staging fails. Detect runs once under this timeout and again in the builder, so a staging may spend up to twice as long detecting. Buildpacks the builder clones from git are not detected beforehand and are only bounded by the staging timeout. If zero, detect is only bounded by the staging timeout",
+)
+
+var disableBuildpackCaching = flag.Bool(
+	"disableBuildpackCaching",
+	false,
+	"Download admin buildpacks in every staging task instead of letting cells cache them. Meant for debugging buildpacks",
+)
+
+var natsAddresses = flag.String(
+	"natsAddresses",
+	"",
+	"Comma-separated list of NATS addresses (ip:port) used to register routes with the gorouter",
+)
+
+var natsUsername = flag.String(
+	"natsUsername",
+	"",
+	"Username to connect to NATS",
+)
+
+var natsPassword = flag.String(
+	"natsPassword",
+	"",
+	"Password for the NATS user",
+)
+
+var natsCredentialsFile = flag.String(
+	"natsCredentialsFile",
+	"",
+	"Path to a JSON file with the NATS username and password, used instead of -natsUsername and -natsPassword. The file is reloaded and the connection rotated on SIGHUP",
+)
+
+var natsCACert = flag.String(
+	"natsCACert",
+	"",
+	"Path to the certificate authority cert used to verify the NATS servers. If set, NATS is connected to over TLS",
+)
+
+var natsClientCert = flag.String(
+	"natsClientCert",
+	"",
+	"Path to the client cert used for mutually authenticated TLS NATS communication",
+)
+
+var natsClientKey = flag.String(
+	"natsClientKey",
+	"",
+	"Path to the client key used for mutually authenticated TLS NATS communication",
+)
+
+var routeRegistrationHost = flag.String(
+	"routeRegistrationHost",
+	"",
+	"Host the gorouter should forward stager routes to. Defaults to the host of the listen address",
+)
+
+var taskEventsResubscribeInterval = flag.Duration(
+	"taskEventsResubscribeInterval",
+	task_watcher.DefaultResubscribePolicy.Interval,
+	"Interval before resubscribing to BBS task events after subscribing fails. Doubles after every consecutive failure",
+)
+
+var taskEventsMaxResubscribeInterval = flag.Duration(
+	"taskEventsMaxResubscribeInterval",
+	task_watcher.DefaultResubscribePolicy.MaxInterval,
+	"Maximum interval between attempts to resubscribe to BBS task events",
+)
+
+var taskEventsResubscribeJitter = flag.Float64(
+	"taskEventsResubscribeJitter",
+	0,
+	"Fraction of the resubscribe interval by which each attempt is randomly spread",
+)
+
+var taskEventsMaxResubscribeAttempts = flag.Int(
+	"taskEventsMaxResubscribeAttempts",
+	0,
+	"Consecutive failed attempts to subscribe to BBS task events after which the stager exits. If zero, it retries forever",
+)
+
+var checkDockerImages = flag.Bool(
+	"checkDockerImages",
+	false,
+	"Fetch the metadata of docker images from their registry before staging, to fail fast when an image does not exist",
+)
+
+var dockerImageMetadataCacheTTL = flag.Duration(
+	"dockerImageMetadataCacheTTL",
+	5*time.Minute,
+	"How long fetched docker image metadata is cached",
+)
+
+var stagingResponseFormat = flag.String(
+	"stagingResponseFormat",
+	response_format.DiegoNative,
+	"Format of the staging responses sent to the Cloud Controller: diego-native, dea-compat or capi-v3. A staging request may select another format with the X-Staging-Response-Format header",
+)
+
+var stagingEventsNATSSubject = flag.String(
+	"stagingEventsNATSSubject",
+	"",
+	"NATS subject on which to publish staging lifecycle events. Requires -natsAddresses",
+)
+
+var stagingEventsURL = flag.String(
+	"stagingEventsURL",
+	"",
+	"URL to which staging lifecycle events are POSTed",
+)
+
+var stagingEventsBufferSize = flag.Int(
+	"stagingEventsBufferSize",
+	staging_events.DefaultBufferSize,
+	"Number of staging lifecycle events queued for the sink before new events are dropped",
+)
+
+var stagingAuditSize = flag.Int(
+	"stagingAuditSize",
+	staging_audit.DefaultSize,
+	"Number of most recent staging requests, and their outcomes, served at /v1/audit. If zero, staging requests are not audited",
+)
+
+var stagingLogsNATSSubjectPrefix = flag.String(
+	"stagingLogsNATSSubjectPrefix",
+	"",
+	"Prefix of the NATS subjects on which the staging logs of each staging are relayed, as <prefix>.<staging guid>. If empty, logs are not relayed. Requires -natsAddresses and -dopplerURL",
+)
+
+var stagingLogsLockKey = flag.String(
+	"stagingLogsLockKey",
+	"",
+	"Consul lock a stager must hold to relay staging logs, so that only one of several stagers publishes each log line. If empty, every stager relays",
+)
+
+var stagingLogsLockTTL = flag.Duration(
+	"stagingLogsLockTTL",
+	locket.DefaultSessionTTL,
+	"TTL for the consul session holding the staging logs lock",
+)
+
+var dopplerURL = flag.String(
+	"dopplerURL",
+	"",
+	"Websocket URL of the loggregator traffic controller from which staging logs are relayed",
+)
+
+var uaaURL = flag.String(
+	"uaaURL",
+	"",
+	"URL of the UAA issuing the token used to read staging logs and, with -ccUAAAuth, to call the CC internal API",
+)
+
+var uaaClientId = flag.String(
+	"uaaClientId",
+	"",
+	"UAA client allowed to read the logs of any app and, with -ccUAAAuth, to call the CC internal API",
+)
+
+var uaaClientSecret = flag.String(
+	"uaaClientSecret",
+	"",
+	"Secret of the UAA client",
+)
+
+var executionMetadataHook = flag.String(
+	"executionMetadataHook",
+	"",
+	"Path to an executable that post-processes the execution metadata and detected start command of every successful staging result",
+)
+
+var executionMetadataHookTimeout = flag.Duration(
+	"executionMetadataHookTimeout",
+	10*time.Second,
+	"Maximum time the execution metadata hook may run for each staging result",
+)
+
+var routeRegistrationInterval = flag.Duration(
+	"routeRegistrationInterval",
+	20*time.Second,
+	"Interval at which the stager routes are re-registered with the gorouter",
+)
+
+var standbyLockKey = flag.String(
+	"standbyLockKey",
+	"",
+	"Consul lock the stager must hold before serving staging requests. If empty, the stager serves requests immediately",
+)
+
+var standbyLockTTL = flag.Duration(
+	"standbyLockTTL",
+	locket.DefaultSessionTTL,
+	"TTL for the consul session holding the standby lock",
+)
+
+var redeliverCompletedTasks = flag.Bool(
+	"redeliverCompletedTasks",
+	false,
+	"On startup, replay staging tasks that completed without their completion being delivered",
+)
+
+var taskWatcherLockKey = flag.String(
+	"taskWatcherLockKey",
+	"",
+	"Consul lock a stager must hold to watch staging tasks, so that only one of several stagers does. If empty, every stager watches",
+)
+
+var taskWatcherLockTTL = flag.Duration(
+	"taskWatcherLockTTL",
+	locket.DefaultSessionTTL,
+	"TTL for the consul session holding the task watcher lock",
+)
+
+var drainTimeout = flag.Duration(
+	"drainTimeout",
+	30*time.Second,
+	"Maximum time to wait on shutdown for in-flight staging requests and completion callbacks to finish",
+)
+
+var stagingRequestRate = flag.Float64(
+	"stagingRequestRate",
+	0,
+	"Maximum number of staging requests accepted per second on average. If zero, staging requests are not rate limited",
+)
+
+var stagingRequestBurst = flag.Int(
+	"stagingRequestBurst",
+	10,
+	"Maximum number of staging requests accepted in a burst when -stagingRequestRate is set",
+)
+
+var stagingQueueDepth = flag.Int(
+	"stagingQueueDepth",
+	0,
+	"Maximum number of staging requests waiting for a worker to desire their task. Requests arriving when the queue is full are asked to retry later. If zero, requests are not queued",
+)
+
+var stagingQueueWorkers = flag.Int(
+	"stagingQueueWorkers",
+	16,
+	"Number of staging requests whose task is desired concurrently when -stagingQueueDepth is set",
+)
+
+var maxOutstandingStagingTasks = flag.Int(
+	"maxOutstandingStagingTasks",
+	0,
+	"Maximum number of staging tasks desired but not yet completed across the platform. Staging requests past the limit are rejected with a PlatformBusyError. If zero, staging tasks are not limited",
+)
+
+var stagingLimitSyncInterval = flag.Duration(
+	"stagingLimitSyncInterval",
+	30*time.Second,
+	"Interval at which the outstanding staging tasks counted against -maxOutstandingStagingTasks are synced with the BBS",
+)
+
+var stagingTaskTTL = flag.Duration(
+	"stagingTaskTTL",
+	0,
+	"Time after which staging tasks still pending or running are cancelled. If zero, staging tasks are never cancelled by the stager",
+)
+
+var stagingTaskReapInterval = flag.Duration(
+	"stagingTaskReapInterval",
+	time.Minute,
+	"Interval at which staging tasks older than -stagingTaskTTL are looked for",
+)
+
+var stagingResponseJournalDir = flag.String(
+	"stagingResponseJournalDir",
+	"",
+	"Directory in which staging responses that cannot be delivered to CC are kept until CC can be reached again. If empty, undeliverable responses are retried through the task callback only",
+)
+
+var stagingResponseReplayInterval = flag.Duration(
+	"stagingResponseReplayInterval",
+	10*time.Second,
+	"Interval at which the responses kept in -stagingResponseJournalDir are delivered to CC",
+)
+
+var dryRun = flag.Bool(
+	"dryRun",
+	false,
+	"Respond to staging requests with the staging task that would be desired instead of desiring it in the BBS",
+)
+
+var configPath = flag.String(
+	"configPath",
+	"",
+	"Path to a JSON file with settings that are reloaded on SIGHUP: log_level, cc_request_timeout, cc_request_retries and cc_request_retry_interval",
+)
+
+var enablePrometheusMetrics = flag.Bool(
+	"enablePrometheusMetrics",
+	false,
+	"Serve the staging metrics in the Prometheus text format on /metrics",
+)
+
+var deadLetterDir = flag.String(
+	"deadLetterDir",
+	"",
+	"Directory in which malformed or invalid staging requests are kept for inspection and replay, with their environment and docker credentials redacted. If empty, they are discarded",
+)
+
+var deadLetterMaxCount = flag.Int(
+	"deadLetterMaxCount",
+	1000,
+	"Maximum number of staging requests kept in -deadLetterDir; the oldest are removed beyond it. If zero, they are not limited",
+)
+
+var deadLetterMaxPayloadBytes = flag.Int(
+	"deadLetterMaxPayloadBytes",
+	64*1024,
+	"Maximum size of a staging request kept in -deadLetterDir; larger requests are truncated. If zero, they are not limited",
+)
+
+var statsWindows = flag.String(
+	"statsWindows",
+	"5m,1h,24h",
+	"Comma-separated list of windows over which staging statistics are aggregated at /v1/stats",
+)
+
+var stagingDurationPercentilesInterval = flag.Duration(
+	"stagingDurationPercentilesInterval",
+	time.Minute,
+	"Interval at which the p50, p95 and p99 staging durations of each lifecycle over the shortest of -statsWindows are emitted. If zero, they are not emitted",
+)
+
+var minStagingTimeout = flag.Duration(
+	"minStagingTimeout",
+	0,
+	"Minimum staging timeout; shorter timeouts requested by the Cloud Controller are raised to it. If zero, no minimum is enforced",
+)
+
+var maxStagingTimeout = flag.Duration(
+	"maxStagingTimeout",
+	0,
+	"Maximum staging timeout; longer timeouts requested by the Cloud Controller are lowered to it. If zero, no maximum is enforced",
+)
+
+var minStagingMemoryMB = flag.Int(
+	"minStagingMemoryMB",
+	0,
+	"Minimum memory for staging tasks; smaller requests from the Cloud Controller are raised to it. If zero, no minimum is enforced",
+)
+
+var maxStagingMemoryMB = flag.Int(
+	"maxStagingMemoryMB",
+	0,
+	"Maximum memory for staging tasks; larger requests from the Cloud Controller are lowered to it. If zero, no maximum is enforced",
+)
+
+var minStagingDiskMB = flag.Int(
+	"minStagingDiskMB",
+	0,
+	"Minimum disk for staging tasks; smaller requests from the Cloud Controller are raised to it. If zero, no minimum is enforced",
+)
+
+var maxStagingDiskMB = flag.Int(
+	"maxStagingDiskMB",
+	0,
+	"Maximum disk for staging tasks; larger requests from the Cloud Controller are lowered to it. If zero, no maximum is enforced",
+)
+
+var stagingRequestValidators = flag.String(
+	"stagingRequestValidators",
+	request_validation.DefaultValidators,
+	"Comma-separated list of validators run, in order, over every staging request before a task is desired for it",
+)
+
+var maxStagingEnvironmentVariables = flag.Int(
+	"maxStagingEnvironmentVariables",
+	backend.MaxEnvironmentVariables,
+	"Maximum number of environment variables in a staging request. If zero, the number is not limited",
+)
+
+var maxStagingEnvironmentBytes = flag.Int(
+	"maxStagingEnvironmentBytes",
+	0,
+	"Maximum size in bytes of the names and values of the environment variables in a staging request. If zero, the size is not limited",
+)
+
+var maxStagingBuildpacks = flag.Int(
+	"maxStagingBuildpacks",
+	0,
+	"Maximum number of buildpacks a staging request may list. If zero, the number is not limited",
+)
+
+var maxStagingRequestBytes = flag.Int(
+	"maxStagingRequestBytes",
+	handlers.DefaultMaxStagingRequestBytes,
+	"Maximum size in bytes of a staging request body. Requests declaring a larger size are turned away unread, and other bodies are read no further than the limit. If zero, the size is not limited",
+)
+
+var maxStagingResultBytes = flag.Int(
+	"maxStagingResultBytes",
+	0,
+	"Maximum size in bytes of a staging result reported to CC. Larger results, and results cut short at the limit, are reported as a StagingResultTooLargeError. If zero, the size is not limited",
+)
+
+var allowedBuildpackURLSchemes = flag.String(
+	"allowedBuildpackURLSchemes",
+	request_validation.DefaultBuildpackURLSchemes,
+	"Comma-separated list of URL schemes custom buildpacks may be fetched with. If empty, every scheme is allowed",
+)
+
 var insecureDockerRegistries = make(vars.StringList)
+var routeRegistrationURIs = make(vars.StringList)
+var allowedStacks = make(vars.StringList)
+var deniedEnvironmentVariables = make(vars.StringList)
+var allowedBuildpackHosts = make(vars.StringList)
+var deniedBuildpackHosts = make(vars.StringList)
+var stagingResultFields = make(vars.KeyValueList)
+var stagingEnvironmentGroup = make(vars.KeyValueList)
+var stackRootFSes = make(vars.KeyValueList)
+var builderArgs = make(vars.StringList)
 
 const (
 	dropsondeOrigin = "stager"
@@ -154,24 +840,159 @@ func main() {
 	debugserver.AddFlags(flag.CommandLine)
 	cflager.AddFlags(flag.CommandLine)
 
+	flag.Var(
+		&stagingResultFields,
+		"stagingResultField",
+		"Field (key=value) added to every successful staging result sent to the Cloud Controller. (Can be specified multiple times)",
+	)
+
+	flag.Var(
+		&stagingEnvironmentGroup,
+		"stagingEnvironmentVariable",
+		"Environment variable (name=value) set for every staging task, unless the Cloud Controller staging environment group or the app sets it. (Can be specified multiple times)",
+	)
+
+	flag.Var(
+		&stackRootFSes,
+		"stackRootFS",
+		"RootFS (stack=rootfs-uri) staging tasks for the stack run on, instead of the preloaded rootfs named after the stack. (Can be specified multiple times)",
+	)
+
+	flag.Var(
+		&builderArgs,
+		"builderArg",
+		"Argument (lifecycle:-flag[=value]) to pass to the builder of the lifecycle, among the flags the stager allows operators to set. (Can be specified multiple times)",
+	)
+
+	flag.Var(
+		&routeRegistrationURIs,
+		"routeRegistrationURI",
+		"Route to register with the gorouter for the stager API. (Can be specified multiple times)",
+	)
+
 	flag.Var(
 		&insecureDockerRegistries,
 		"insecureDockerRegistry",
 		"Docker registry to allow connecting to even if not secure. (Can be specified multiple times to allow insecure connection to multiple repositories)",
 	)
 
+	flag.Var(
+		&allowedStacks,
+		"allowedStack",
+		"Stack staging requests may target. If none is given, every stack is allowed. (Can be specified multiple times)",
+	)
+
+	flag.Var(
+		&deniedEnvironmentVariables,
+		"deniedEnvironmentVariable",
+		"Environment variable staging requests may not set; a trailing '*' denies every variable with that prefix. (Can be specified multiple times)",
+	)
+
+	flag.Var(
+		&allowedBuildpackHosts,
+		"allowedBuildpackHost",
+		"Host custom buildpacks may be fetched from; a leading '*.' allows every subdomain. If none is given, every host not denied is allowed. (Can be specified multiple times)",
+	)
+
+	flag.Var(
+		&deniedBuildpackHosts,
+		"deniedBuildpackHost",
+		"Host custom buildpacks may not be fetched from; a leading '*.' denies every subdomain. (Can be specified multiple times)",
+	)
+
 	lifecycles := flags.LifecycleMap{}
 	flag.Var(&lifecycles, "lifecycle", "app lifecycle binary bundle mapping (lifecycle[/stack]:bundle-filepath-in-fileserver)")
+
+	if len(os.Args) > 1 && os.Args[1] == validateConfigCommand {
+		flag.CommandLine.Parse(os.Args[2:])
+		os.Exit(runValidateConfig(os.Stdout, lifecycles))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == tasksCommand {
+		os.Exit(runTasksCommand(os.Stdout, os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == replayCommand {
+		os.Exit(runReplayCommand(os.Stdout, os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == selfTestCommand {
+		os.Exit(runSelfTestCommand(os.Stdout, os.Args[2:]))
+	}
+
 	flag.Parse()
 
+	if errs := validateConfig(lifecycles); len(errs) > 0 {
+		reportConfigErrors(os.Stderr, errs)
+		os.Exit(1)
+	}
+
 	logger, reconfigurableSink := cflager.New("stager")
-	initializeDropsonde(logger)
+	initializeMetricsEmitter(logger)
 
-	ccClient := cc_client.NewCcClient(*ccBaseURL, *ccUsername, *ccPassword, *skipCertVerify)
+	ccTLSConfig, err := cc_client.NewTLSConfig(*ccCACert, *ccClientCert, *ccClientKey, *skipCertVerify)
+	if err != nil {
+		logger.Fatal("failed-to-load-cc-tls-config", err)
+	}
+
+	retryBudget := retry_budget.NewRetryBudget(*maxStagingAttempts, *maxStagingAttemptsWindow, clock.NewClock())
+	ccClient := cc_client.NewCcClient(*ccBaseURL, *ccUsername, *ccPassword, initializeUAAClient(), ccTLSConfig, *ccRequestTimeout, *ccRequestRetries, *ccRequestRetryInterval, *gzipCCPayloads, *metricsEmitter == metrics_emitter.DropsondeEmitter, retryBudget, clock.NewClock())
+
+	if *configPath != "" {
+		tunables, err := config.Load(*configPath)
+		if err != nil {
+			logger.Fatal("failed-to-load-config", err)
+		}
+		applyTunables(logger, ccClient, reconfigurableSink, tunables)
+	}
 
 	backends := initializeBackends(logger, lifecycles)
 
-	handler := handlers.New(logger, ccClient, initializeBBSClient(logger), backends, clock.NewClock())
+	bbsClient := initializeBBSClient(logger)
+
+	var natsConn *nats_connection.Conn
+	if *natsAddresses != "" && (len(routeRegistrationURIs) > 0 || *stagingEventsNATSSubject != "" || *stagingLogsNATSSubjectPrefix != "") {
+		natsConn = initializeNATSConn(logger)
+	}
+
+	events := initializeStagingEvents(logger, natsConn)
+
+	stagingStats := initializeStats(logger)
+
+	stagingLimit := staging_limit.NewLimit(logger, *maxOutstandingStagingTasks)
+	responseJournal := response_journal.NewJournal(*stagingResponseJournalDir, clock.NewClock())
+
+	handler := handlers.New(logger, handlers.Config{
+		CcClient:           ccClient,
+		CacheClient:        cache_client.NewCacheClient(*ccBaseURL, *ccUsername, *ccPassword, ccTLSConfig),
+		BBSClient:          bbsClient,
+		StagingTaskDomains: stagingTaskDomains(),
+		Backends:           backends,
+
+		RetryBudget:       retryBudget,
+		DeadLetters:       dead_letter.NewSpool(*deadLetterDir, *deadLetterMaxCount, *deadLetterMaxPayloadBytes, clock.NewClock()),
+		Enrichers:         initializeEnrichers(),
+		MetadataHooks:     initializeMetadataHooks(),
+		ResponseFormatter: initializeResponseFormatter(logger),
+		StagingStats:      stagingStats,
+		MetricsRegistry:   initializeMetricsRegistry(),
+		RateLimiter:       rate_limiter.NewRateLimiter(*stagingRequestRate, *stagingRequestBurst, clock.NewClock()),
+		StagingQueue:      staging_queue.NewQueue(logger, *stagingQueueDepth, *stagingQueueWorkers),
+		StagingLimit:      stagingLimit,
+		ResponseJournal:   responseJournal,
+		Completions:       completion_dedup.NewTracker(*completionDedupSize, *completionDedupWindow, clock.NewClock()),
+		Events:            events,
+		AuditLog:          staging_audit.NewLog(*stagingAuditSize, clock.NewClock()),
+		Validators:        initializeRequestValidators(logger),
+
+		DryRun:                    *dryRun,
+		MaxRequestBytes:           *maxStagingRequestBytes,
+		DeadLetterMaxPayloadBytes: *deadLetterMaxPayloadBytes,
+		CompletionWorkers:         *completionWorkers,
+
+		Clock: clock.NewClock(),
+	})
+	handler = injectStagerFaults(logger, handler)
 
 	clock := clock.NewClock()
 	consulClient, err := consuladapter.NewClientFromUrl(*consulCluster)
@@ -179,7 +1000,7 @@ func main() {
 		logger.Fatal("new-client-failed", err)
 	}
 
-	_, portString, err := net.SplitHostPort(*listenAddress)
+	listenHost, portString, err := net.SplitHostPort(*listenAddress)
 	if err != nil {
 		logger.Fatal("failed-invalid-listen-address", err)
 	}
@@ -188,17 +1009,104 @@ func main() {
 		logger.Fatal("failed-invalid-listen-port", err)
 	}
 
-	registrationRunner := initializeRegistrationRunner(logger, consulClient, portNum, clock)
+	var registrationRunner ifrit.Runner
+	if *consulServiceName != "" {
+		registrationRunner = initializeRegistrationRunner(logger, consulClient, portNum, clock)
+	}
 
-	members := grouper.Members{
-		{"server", http_server.New(*listenAddress, handler)},
-		{"registration-runner", registrationRunner},
+	var lockRunner ifrit.Runner
+	var gate standby.Gate
+	if *standbyLockKey != "" {
+		lockRunner = initializeLockRunner(logger, consulClient, clock)
+		gate = standby.NewGate(logger)
+		handler = initializeStandbyHandler(logger, handler, gate)
 	}
 
-	if dbgAddr := debugserver.DebugAddress(flag.CommandLine); dbgAddr != "" {
-		members = append(grouper.Members{
-			{"debug-server", debugserver.Runner(dbgAddr, reconfigurableSink)},
-		}, members...)
+	// The watcher always runs for its watch lag metric, but only stagers
+	// publishing started staging tasks are unhealthy when not watching.
+	// Standby stagers only start watching once they hold the standby lock,
+	// so they are healthy without watching.
+	var taskWatcher task_watcher.TaskWatcher
+	watcher := initializeTaskWatcher(logger, bbsClient, ccClient, events, clock)
+	if *taskWatcherLockKey == "" && *standbyLockKey == "" && *publishStagingStarted {
+		taskWatcher = watcher
+	}
+
+	var healthServer ifrit.Runner
+	if *healthAddress != "" {
+		healthServer = initializeHealthServer(logger, natsConn, bbsClient, taskWatcher)
+	}
+
+	drainer := drain.NewDrainer(logger, *drainTimeout, clock)
+
+	members := initializeMembers(drainer.Wrap(handler), healthServer, lockRunner, gate, events, drainer, registrationRunner, reconfigurableSink)
+
+	// The NATS connector goes first so that the connection is closed only
+	// after every member publishing on it has stopped.
+	if natsConn != nil {
+		members = append(grouper.Members{{"nats-connector", nats_connection.NewConnector(logger, natsConn, clock)}}, members...)
+	}
+
+	if natsConn != nil && len(routeRegistrationURIs) > 0 {
+		members = append(members, grouper.Member{"route-registrar", initializeRouteRegistrar(logger, natsConn, listenHost, portNum, clock)})
+	}
+
+	if natsConn != nil && *natsCredentialsFile != "" {
+		natsReloads := make(chan os.Signal, 1)
+		signal.Notify(natsReloads, syscall.SIGHUP)
+
+		members = append(members, grouper.Member{"nats-credentials-rotator", nats_connection.NewRotator(logger, natsConn, *natsCredentialsFile, natsReloads)})
+	}
+
+	logLevelAdjustments := make(chan os.Signal, 1)
+	signal.Notify(logLevelAdjustments, syscall.SIGUSR1, syscall.SIGUSR2)
+	members = append(members, grouper.Member{"log-level-adjuster", config.NewLogLevelAdjuster(logger, reconfigurableSink, logLevelAdjustments)})
+
+	if *configPath != "" {
+		reloads := make(chan os.Signal, 1)
+		signal.Notify(reloads, syscall.SIGHUP)
+
+		members = append(members, grouper.Member{"config-reloader", config.NewReloader(logger, *configPath, reloads, func(tunables config.Tunables) {
+			applyTunables(logger, ccClient, reconfigurableSink, tunables)
+		})})
+	}
+
+	if *taskWatcherLockKey != "" {
+		members = append(members, grouper.Member{"task-watcher", initializeLeaderRunner(logger, consulClient, *taskWatcherLockKey, *taskWatcherLockTTL, watcher, clock)})
+	} else {
+		members = append(members, grouper.Member{"task-watcher", watcher})
+	}
+
+	if *stagingLogsNATSSubjectPrefix != "" && natsConn != nil {
+		logRelay := initializeLogRelay(logger, bbsClient, natsConn, clock)
+		if *stagingLogsLockKey != "" {
+			logRelay = initializeLeaderRunner(logger, consulClient, *stagingLogsLockKey, *stagingLogsLockTTL, logRelay, clock)
+		}
+		members = append(members, grouper.Member{"log-relay", logRelay})
+	}
+
+	if *stagingDurationPercentilesInterval > 0 {
+		members = append(members, grouper.Member{"percentile-emitter", stats.NewPercentileEmitter(logger, stagingStats, shortestStatsWindow(logger), *stagingDurationPercentilesInterval, clock)})
+	}
+
+	if *maxOutstandingStagingTasks > 0 {
+		members = append(members, grouper.Member{"staging-limit-syncer", staging_limit.NewSyncer(logger, bbsClient, stagingTaskDomains(), stagingLimit, *stagingLimitSyncInterval, clock)})
+	}
+
+	if *stagingTaskTTL > 0 {
+		members = append(members, grouper.Member{"task-reaper", task_reaper.NewReaper(logger, bbsClient, stagingTaskDomains(), *stagingTaskTTL, *stagingTaskReapInterval, clock)})
+	}
+
+	if *stagingResponseJournalDir != "" {
+		members = append(members, grouper.Member{"response-replayer", response_journal.NewReplayer(logger, responseJournal, ccClient, *stagingResponseReplayInterval, clock)})
+	}
+
+	if *redeliverCompletedTasks {
+		members = append(members, grouper.Member{"redeliverer", redelivery.NewRedeliverer(logger, bbsClient, stagingTaskDomains(), handler)})
+	}
+
+	if faultInjectionServer := initializeFaultInjectionServer(logger); faultInjectionServer != nil {
+		members = append(members, grouper.Member{"fault-injection-server", faultInjectionServer})
 	}
 
 	logger.Info("starting")
@@ -217,12 +1125,139 @@ func main() {
 	logger.Info("stopped")
 }
 
-func initializeDropsonde(logger lager.Logger) {
-	dropsondeDestination := fmt.Sprint("localhost:", *dropsondePort)
-	err := dropsonde.Initialize(dropsondeDestination, dropsondeOrigin)
+// Members are started in order and stopped in reverse order: the consul
+// registration is withdrawn first so no new staging requests are routed here,
+// then the drainer waits up to -drainTimeout for in-flight requests and
+// completion callbacks to finish, and the debug server goes away last. The
+// server itself does not drain: stopping it only closes its listener, so
+// requests still in flight once the drainer gives up are cut off.
+//
+// When a standby lock is configured, everything after it waits in standby
+// until the lock is acquired. The health server and the server start before
+// it, so that standby stagers pass their health checks and accept the
+// completion callbacks of tasks desired before a failover; the standby gate
+// refuses every other request until the lock is held.
+func initializeMembers(handler http.Handler, healthServer, lockRunner, gate, events, drainer, registrationRunner ifrit.Runner, reconfigurableSink *lager.ReconfigurableSink) grouper.Members {
+	members := grouper.Members{}
+
+	if dbgAddr := debugserver.DebugAddress(flag.CommandLine); dbgAddr != "" {
+		members = append(members, grouper.Member{"debug-server", debugserver.Runner(dbgAddr, reconfigurableSink)})
+	}
+
+	if healthServer != nil {
+		members = append(members, grouper.Member{"health-server", healthServer})
+	}
+
+	members = append(members,
+		grouper.Member{"staging-events", events},
+		grouper.Member{"server", http_server.New(*listenAddress, handler)},
+		grouper.Member{"drainer", drainer},
+	)
+
+	if lockRunner != nil {
+		members = append(members,
+			grouper.Member{"standby-lock", lockRunner},
+			grouper.Member{"standby-gate", gate},
+		)
+	}
+
+	if registrationRunner != nil {
+		members = append(members, grouper.Member{"registration-runner", registrationRunner})
+	}
+
+	return members
+}
+
+// initializeStandbyHandler serves the completion callback route regardless of
+// the standby lock and every other route through the gate.
+func initializeStandbyHandler(logger lager.Logger, handler http.Handler, gate standby.Gate) http.Handler {
+	gated := gate.Wrap(handler)
+
+	routeHandlers := rata.Handlers{}
+	for _, route := range stager.Routes {
+		routeHandlers[route.Name] = gated
+	}
+	routeHandlers[stager.StagingCompletedRoute] = handler
+
+	router, err := rata.NewRouter(stager.Routes, routeHandlers)
+	if err != nil {
+		logger.Fatal("failed-to-initialize-standby-router", err)
+	}
+
+	return router
+}
+
+func applyTunables(logger lager.Logger, ccClient cc_client.CcClient, reconfigurableSink *lager.ReconfigurableSink, tunables config.Tunables) {
+	if tunables.LogLevel != "" {
+		level, err := config.ParseLogLevel(tunables.LogLevel)
+		if err == nil {
+			reconfigurableSink.SetMinLevel(level)
+		}
+	}
+
+	policy := cc_client.RequestPolicy{
+		Timeout:       *ccRequestTimeout,
+		Retries:       *ccRequestRetries,
+		RetryInterval: *ccRequestRetryInterval,
+	}
+	if tunables.CCRequestTimeout != nil {
+		policy.Timeout = time.Duration(*tunables.CCRequestTimeout)
+	}
+	if tunables.CCRequestRetries != nil {
+		policy.Retries = *tunables.CCRequestRetries
+	}
+	if tunables.CCRequestRetryInterval != nil {
+		policy.RetryInterval = time.Duration(*tunables.CCRequestRetryInterval)
+	}
+	ccClient.SetRequestPolicy(policy)
+
+	logger.Info("applied-config", lager.Data{
+		"log-level":                 tunables.LogLevel,
+		"cc-request-timeout":        policy.Timeout,
+		"cc-request-retries":        policy.Retries,
+		"cc-request-retry-interval": policy.RetryInterval,
+	})
+}
+
+// stagingTaskDomains are the domains the backends desire staging tasks in,
+// which every consumer of staging tasks in the BBS must look at.
+func stagingTaskDomains() []string {
+	domains := []string{cc_messages.StagingTaskDomain}
+	if *windowsStagingTaskDomain != "" && *windowsStagingTaskDomain != cc_messages.StagingTaskDomain {
+		domains = append(domains, *windowsStagingTaskDomain)
+	}
+	return domains
+}
+
+func initializeMetricsRegistry() *prometheus_metrics.Registry {
+	if !*enablePrometheusMetrics {
+		return nil
+	}
+
+	return prometheus_metrics.NewRegistry()
+}
+
+func initializeMetricsEmitter(logger lager.Logger) {
+	var emitter metrics_emitter.Emitter
+	var err error
+
+	switch *metricsEmitter {
+	case metrics_emitter.DropsondeEmitter:
+		emitter, err = metrics_emitter.NewDropsondeEmitter(fmt.Sprint("localhost:", *dropsondePort), dropsondeOrigin)
+	case metrics_emitter.StatsdEmitter:
+		emitter, err = metrics_emitter.NewStatsdEmitter(*statsdAddress, *statsdPrefix)
+	case metrics_emitter.NoopEmitter:
+		emitter = metrics_emitter.NewNoopEmitter()
+	default:
+		err = &metrics_emitter.UnknownEmitterError{Name: *metricsEmitter}
+	}
+
 	if err != nil {
-		logger.Error("failed to initialize dropsonde: %v", err)
+		logger.Error("failed-to-initialize-metrics-emitter", err, lager.Data{"emitter": *metricsEmitter})
+		emitter = metrics_emitter.NewNoopEmitter()
 	}
+
+	metrics_emitter.Install(emitter)
 }
 
 func initializeBackends(logger lager.Logger, lifecycles flags.LifecycleMap) map[string]backend.Backend {
@@ -243,8 +1278,14 @@ func initializeBackends(logger lager.Logger, lifecycles flags.LifecycleMap) map[
 		logger.Fatal("Error parsing Docker Registry address", err)
 	}
 
+	lifecycleBuilderArgs, err := backend.ParseBuilderArgs(builderArgs.Values())
+	if err != nil {
+		logger.Fatal("invalid-builder-args", err)
+	}
+
 	config := backend.Config{
 		TaskDomain:               cc_messages.StagingTaskDomain,
+		WindowsTaskDomain:        *windowsStagingTaskDomain,
 		StagerURL:                *stagingTaskCallbackURL,
 		FileServerURL:            *fileServerURL,
 		CCUploaderURL:            *ccUploaderURL,
@@ -256,12 +1297,128 @@ func initializeBackends(logger lager.Logger, lifecycles flags.LifecycleMap) map[
 		PrivilegedContainers:     *privilegedContainers,
 		Sanitizer:                backend.SanitizeErrorMessage,
 		DockerStagingStack:       *dockerStagingStack,
+		DetectTimeout:            *detectTimeout,
+		MinStagingTimeout:        *minStagingTimeout,
+		MaxStagingTimeout:        *maxStagingTimeout,
+		MinStagingMemoryMB:       *minStagingMemoryMB,
+		MaxStagingMemoryMB:       *maxStagingMemoryMB,
+		MinStagingDiskMB:         *minStagingDiskMB,
+		MaxStagingDiskMB:         *maxStagingDiskMB,
+		RequireTLS:               *requireTLSForCCTransfers,
+		StagingCpuWeight:         uint32(*stagingCpuWeight),
+		DropletUploadSigningKey:  *dropletUploadSigningKey,
+		DropletUploadURLSlack:    *dropletUploadURLSlack,
+		Clock:                    clock.NewClock(),
+		StagingEnvironmentGroup:  stagingEnvironmentGroup,
+		DisableBuildpackCaching:  *disableBuildpackCaching,
+		StackRootFSes:            stackRootFSes,
+		BuilderArgs:              lifecycleBuilderArgs,
+		DropletChecksums:         *dropletChecksums,
+		MaxResultBytes:           *maxStagingResultBytes,
+	}
+
+	if *appBitsBlobstoreURL != "" {
+		config.AppBitsURLBuilder, err = backend.NewWebDAVAppBitsURLBuilder(*appBitsBlobstoreURL, *appBitsBlobstoreDirectory, *appBitsBlobstoreSecret, *appBitsBlobstoreURLTTL, clock.NewClock())
+		if err != nil {
+			logger.Fatal("invalid-app-bits-blobstore-url", err)
+		}
+	}
+
+	if *checkDockerImages {
+		config.ImageMetadataClient = docker_registry.NewClient(*skipCertVerify, insecureDockerRegistries.Values(), *dockerImageMetadataCacheTTL, clock.NewClock())
+	}
+
+	return backend.DefaultRegistry().Backends(config, logger)
+}
+
+func initializeEnrichers() []enrichment.Enricher {
+	enrichers := []enrichment.Enricher{}
+	if len(stagingResultFields) > 0 {
+		enrichers = append(enrichers, enrichment.NewStaticFieldsEnricher(stagingResultFields))
 	}
+	return enrichers
+}
 
-	return map[string]backend.Backend{
-		"buildpack": backend.NewTraditionalBackend(config, logger),
-		"docker":    backend.NewDockerBackend(config, logger),
+func initializeUAAClient() uaa_client.Client {
+	if !*ccUAAAuth {
+		return nil
 	}
+	return uaa_client.NewClient(*uaaURL, *uaaClientId, *uaaClientSecret, *skipCertVerify, clock.NewClock())
+}
+
+func initializeRequestValidators(logger lager.Logger) []request_validation.Validator {
+	validators, err := request_validation.NewChain(strings.Split(*stagingRequestValidators, ","), requestValidationConfig())
+	if err != nil {
+		logger.Fatal("invalid-staging-request-validators", err)
+	}
+	return validators
+}
+
+func requestValidationConfig() request_validation.Config {
+	return request_validation.Config{
+		MaxEnvironmentVariables:    *maxStagingEnvironmentVariables,
+		MaxEnvironmentBytes:        *maxStagingEnvironmentBytes,
+		MaxBuildpacks:              *maxStagingBuildpacks,
+		AllowedStacks:              allowedStacks.Values(),
+		DeniedEnvironmentVariables: deniedEnvironmentVariables.Values(),
+		AllowedBuildpackURLSchemes: strings.Split(*allowedBuildpackURLSchemes, ","),
+		AllowedBuildpackHosts:      allowedBuildpackHosts.Values(),
+		DeniedBuildpackHosts:       deniedBuildpackHosts.Values(),
+	}
+}
+
+func initializeMetadataHooks() []metadata_hooks.Hook {
+	hooks := []metadata_hooks.Hook{}
+	if *executionMetadataHook != "" {
+		hooks = append(hooks, metadata_hooks.NewExecHook(*executionMetadataHook, *executionMetadataHookTimeout))
+	}
+	return hooks
+}
+
+func initializeResponseFormatter(logger lager.Logger) response_format.Formatter {
+	formatter, err := response_format.DefaultRegistry().Lookup(*stagingResponseFormat)
+	if err != nil {
+		logger.Fatal("invalid-staging-response-format", err)
+	}
+	return formatter
+}
+
+func initializeStats(logger lager.Logger) stats.Stats {
+	windows, err := parseStatsWindows(*statsWindows)
+	if err != nil {
+		logger.Fatal("invalid-stats-windows", err)
+	}
+	return stats.NewStats(windows, clock.NewClock())
+}
+
+func shortestStatsWindow(logger lager.Logger) time.Duration {
+	windows, err := parseStatsWindows(*statsWindows)
+	if err != nil {
+		logger.Fatal("invalid-stats-windows", err)
+	}
+
+	shortest := windows[0]
+	for _, window := range windows[1:] {
+		if window < shortest {
+			shortest = window
+		}
+	}
+	return shortest
+}
+
+func parseStatsWindows(value string) ([]time.Duration, error) {
+	windows := []time.Duration{}
+	for _, window := range strings.Split(value, ",") {
+		duration, err := time.ParseDuration(strings.TrimSpace(window))
+		if err != nil {
+			return nil, err
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("window must be positive: %s", window)
+		}
+		windows = append(windows, duration)
+	}
+	return windows, nil
 }
 
 func initializeBBSClient(logger lager.Logger) bbs.Client {
@@ -270,24 +1427,155 @@ func initializeBBSClient(logger lager.Logger) bbs.Client {
 		logger.Fatal("Invalid BBS URL", err)
 	}
 
+	var bbsClient bbs.Client
 	if bbsURL.Scheme != "https" {
-		return bbs.NewClient(*bbsAddress)
+		bbsClient = bbs.NewClient(*bbsAddress)
+	} else {
+		bbsClient, err = bbs.NewSecureClient(*bbsAddress, *bbsCACert, *bbsClientCert, *bbsClientKey, *bbsClientSessionCacheSize, *bbsMaxIdleConnsPerHost)
+		if err != nil {
+			logger.Fatal("Failed to configure secure BBS client", err)
+		}
 	}
 
-	bbsClient, err := bbs.NewSecureClient(*bbsAddress, *bbsCACert, *bbsClientCert, *bbsClientKey, *bbsClientSessionCacheSize, *bbsMaxIdleConnsPerHost)
-	if err != nil {
-		logger.Fatal("Failed to configure secure BBS client", err)
+	bbsClient = injectBBSFaults(bbsClient)
+	bbsClient = bbs_timeout.NewBBSClient(bbsClient, *bbsCallTimeout, *bbsMaxConcurrentCalls, clock.NewClock())
+	bbsClient = bbs_retry.NewBBSClient(bbsClient, *bbsDesireTaskRetries, *bbsDesireTaskRetryInterval, clock.NewClock())
+
+	if *bbsCircuitBreakerThreshold > 0 {
+		breaker := circuit_breaker.NewBreaker(logger, "BBS", *bbsCircuitBreakerThreshold, *bbsCircuitBreakerResetTimeout, circuit_breaker.IsBBSFailure, clock.NewClock())
+		bbsClient = circuit_breaker.NewBBSClient(bbsClient, breaker)
 	}
+
 	return bbsClient
 }
 
+func initializeNATSConn(logger lager.Logger) *nats_connection.Conn {
+	var tlsConfig *tls.Config
+	if *natsCACert != "" {
+		var err error
+		tlsConfig, err = nats_connection.NewTLSConfig(*natsCACert, *natsClientCert, *natsClientKey)
+		if err != nil {
+			logger.Fatal("failed-to-load-nats-tls-config", err)
+		}
+	}
+
+	credentials := nats_connection.Credentials{Username: *natsUsername, Password: *natsPassword}
+	if *natsCredentialsFile != "" {
+		var err error
+		credentials, err = nats_connection.LoadCredentials(*natsCredentialsFile)
+		if err != nil {
+			logger.Fatal("failed-to-load-nats-credentials", err)
+		}
+	}
+
+	dial := injectNATSFaults(logger, nats_connection.NewDialer(strings.Split(*natsAddresses, ","), tlsConfig))
+	return nats_connection.NewConn(logger, dial, credentials)
+}
+
+func initializeStagingEvents(logger lager.Logger, natsConn *nats_connection.Conn) staging_events.Emitter {
+	var sink staging_events.Sink
+	switch {
+	case *stagingEventsURL != "":
+		sink = staging_events.NewHTTPSink(*stagingEventsURL, *skipCertVerify)
+	case *stagingEventsNATSSubject != "" && natsConn != nil:
+		sink = staging_events.NewNATSSink(natsConn, *stagingEventsNATSSubject)
+	}
+
+	return staging_events.NewEmitter(logger, sink, *stagingEventsBufferSize, clock.NewClock())
+}
+
+func initializeLogRelay(logger lager.Logger, bbsClient bbs.Client, natsConn *nats_connection.Conn, clock clock.Clock) ifrit.Runner {
+	uaaClient := uaa_client.NewClient(*uaaURL, *uaaClientId, *uaaClientSecret, *skipCertVerify, clock)
+	source := log_relay.NewDopplerSource(*dopplerURL, uaaClient, *skipCertVerify)
+	return log_relay.NewRelay(logger, bbsClient, stagingTaskDomains(), source, natsConn, *stagingLogsNATSSubjectPrefix, clock)
+}
+
+func initializeRouteRegistrar(logger lager.Logger, natsConn *nats_connection.Conn, listenHost string, port int, clock clock.Clock) ifrit.Runner {
+	host := *routeRegistrationHost
+	if host == "" {
+		host = listenHost
+	}
+
+	uris := routeRegistrationURIs.Values()
+	if *stackScopedRegistration {
+		uris = route_registrar.StackScopedURIs(uris, allowedStacks.Values())
+	}
+	return route_registrar.NewRouteRegistrar(logger, natsConn, host, port, uris, *routeRegistrationInterval, clock)
+}
+
+func initializeTaskWatcher(logger lager.Logger, bbsClient bbs.Client, ccClient cc_client.CcClient, events staging_events.Emitter, clock clock.Clock) task_watcher.TaskWatcher {
+	policy := task_watcher.ResubscribePolicy{
+		Interval:    *taskEventsResubscribeInterval,
+		MaxInterval: *taskEventsMaxResubscribeInterval,
+		MaxAttempts: *taskEventsMaxResubscribeAttempts,
+		Jitter:      *taskEventsResubscribeJitter,
+	}
+
+	return task_watcher.NewTaskWatcher(logger, bbsClient, stagingTaskDomains(), ccClient, *publishStagingStarted, *ccStagingStartedURL, events, policy, clock)
+}
+
+func initializeHealthServer(logger lager.Logger, natsConn *nats_connection.Conn, bbsClient bbs.Client, taskWatcher task_watcher.TaskWatcher) ifrit.Runner {
+	checks := []health.Check{health.NewBBSCheck(bbsClient)}
+	if natsConn != nil {
+		checks = append(checks, health.NewNATSCheck(natsConn))
+	}
+	if taskWatcher != nil {
+		checks = append(checks, health.NewWatchCheck(taskWatcher))
+	}
+
+	return http_server.New(*healthAddress, health.NewHandler(logger, checks))
+}
+
+func initializeLockRunner(logger lager.Logger, consulClient consuladapter.Client, clock clock.Clock) ifrit.Runner {
+	lockValue, err := json.Marshal(map[string]string{"address": *listenAddress})
+	if err != nil {
+		logger.Fatal("failed-to-marshal-lock-value", err)
+	}
+
+	return locket.NewLock(logger, consulClient, locket.LockSchemaPath(*standbyLockKey), lockValue, clock, locket.RetryInterval, *standbyLockTTL)
+}
+
+func initializeLeaderRunner(logger lager.Logger, consulClient consuladapter.Client, lockKey string, lockTTL time.Duration, runner ifrit.Runner, clock clock.Clock) ifrit.Runner {
+	lockValue, err := json.Marshal(map[string]string{"address": *listenAddress})
+	if err != nil {
+		logger.Fatal("failed-to-marshal-lock-value", err)
+	}
+
+	lock := locket.NewLock(logger, consulClient, locket.LockSchemaPath(lockKey), lockValue, clock, locket.RetryInterval, lockTTL)
+	return leader_election.New(logger, lock, runner)
+}
+
 func initializeRegistrationRunner(logger lager.Logger, consulClient consuladapter.Client, port int, clock clock.Clock) ifrit.Runner {
 	registration := &api.AgentServiceRegistration{
-		Name: "stager",
-		Port: port,
-		Check: &api.AgentServiceCheck{
-			TTL: "3s",
-		},
+		Name:  *consulServiceName,
+		Port:  port,
+		Check: initializeServiceCheck(logger),
+	}
+	if *stackScopedRegistration {
+		registration.Tags = allowedStacks.Values()
 	}
 	return locket.NewRegistrationRunner(logger, registration, consulClient, locket.RetryInterval, clock)
 }
+
+// initializeServiceCheck has consul poll the health check when it is
+// served, so that a stager that cannot reach the BBS is no longer
+// discovered. Otherwise the registration is kept alive with a TTL check,
+// passing for as long as the stager runs.
+func initializeServiceCheck(logger lager.Logger) *api.AgentServiceCheck {
+	if *healthAddress == "" {
+		return &api.AgentServiceCheck{TTL: "3s"}
+	}
+
+	host, port, err := net.SplitHostPort(*healthAddress)
+	if err != nil {
+		logger.Fatal("failed-invalid-health-address", err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	return &api.AgentServiceCheck{
+		HTTP:     fmt.Sprintf("http://%s/health", net.JoinHostPort(host, port)),
+		Interval: consulHealthCheckInterval.String(),
+	}
+}
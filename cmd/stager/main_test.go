@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os/exec"
 	"strconv"
 	"strings"
 
@@ -72,6 +73,10 @@ var _ = Describe("Stager", func() {
 				"-lifecycle", "docker:docker/lifecycle.tgz",
 			)
 			Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+
+			fakeBBS.RouteToHandler("POST", "/v1/tasks/get_by_task_guid.r2", func(w http.ResponseWriter, req *http.Request) {
+				writeResponse(w, &models.TaskResponse{Error: models.ErrResourceNotFound})
+			})
 		})
 
 		Describe("when a buildpack staging request is received", func() {
@@ -109,11 +114,37 @@ var _ = Describe("Stager", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
 
-				Eventually(fakeBBS.ReceivedRequests).Should(HaveLen(1))
+				Eventually(fakeBBS.ReceivedRequests).Should(HaveLen(2))
 				Consistently(runner.Session()).ShouldNot(gexec.Exit())
 			})
 		})
 
+		Describe("when a staging request is POSTed", func() {
+			It("desires a staging task via the API", func() {
+				fakeBBS.RouteToHandler("POST", "/v1/tasks/desire.r2", ghttp.RespondWith(http.StatusOK, nil))
+
+				req, err := requestGenerator.CreateRequest(stager.StagePostRoute, rata.Params{"staging_guid": "my-task-guid"}, strings.NewReader(`{
+					"app_id":"my-app-guid",
+					"file_descriptors":3,
+					"memory_mb" : 1024,
+					"disk_mb" : 128,
+					"environment" : [],
+					"lifecycle": "docker",
+					"lifecycle_data": {
+					  "docker_image":"http://docker.docker/docker"
+					}
+				}`))
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("Content-Type", "application/json")
+
+				resp, err := httpClient.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+
+				Eventually(fakeBBS.ReceivedRequests).Should(HaveLen(2))
+			})
+		})
+
 		Describe("when a docker staging request is received", func() {
 			It("desires a staging task via the API", func() {
 				fakeBBS.RouteToHandler("POST", "/v1/tasks/desire.r2", func(w http.ResponseWriter, req *http.Request) {
@@ -147,7 +178,7 @@ var _ = Describe("Stager", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
 
-				Eventually(fakeBBS.ReceivedRequests).Should(HaveLen(1))
+				Eventually(fakeBBS.ReceivedRequests).Should(HaveLen(2))
 				Consistently(runner.Session()).ShouldNot(gexec.Exit())
 			})
 		})
@@ -429,7 +460,7 @@ var _ = Describe("Stager", func() {
 
 	Context("when started with -insecureDockerRegistry", func() {
 		BeforeEach(func() {
-			runner.Start("-lifecycle", "linux:lifecycle.zip", "-insecureDockerRegistry", "http://b.c", "-insecureDockerRegistry", "http://a.b")
+			runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz", "-insecureDockerRegistry", "http://b.c", "-insecureDockerRegistry", "http://a.b")
 			Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
 		})
 
@@ -438,9 +469,23 @@ var _ = Describe("Stager", func() {
 		})
 	})
 
+	Context("when NATS cannot be reached", func() {
+		BeforeEach(func() {
+			runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz", "-natsAddresses", "127.0.0.1:1", "-stagingEventsNATSSubject", "staging.events")
+			Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+		})
+
+		It("starts anyway and keeps trying to connect", func() {
+			Eventually(func() string {
+				return string(runner.Session().Out.Contents())
+			}).Should(ContainSubstring("nats-connector.failed-to-connect"))
+			Consistently(runner.Session()).ShouldNot(gexec.Exit())
+		})
+	})
+
 	Describe("service registration", func() {
 		BeforeEach(func() {
-			runner.Start("-lifecycle", "linux:lifecycle.zip")
+			runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz")
 			Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
 		})
 
@@ -476,16 +521,174 @@ var _ = Describe("Stager", func() {
 		})
 	})
 
+	Describe("-consulServiceName arg", func() {
+		Context("when it names the service", func() {
+			BeforeEach(func() {
+				runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz", "-consulServiceName", "stager-z1")
+				Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+			})
+
+			It("registers under that name", func() {
+				services, err := consulRunner.NewClient().Agent().Services()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(services).To(HaveKey("stager-z1"))
+				Expect(services).NotTo(HaveKey("stager"))
+			})
+		})
+
+		Context("when it is empty", func() {
+			BeforeEach(func() {
+				runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz", "-consulServiceName", "")
+				Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+			})
+
+			It("does not register with consul", func() {
+				services, err := consulRunner.NewClient().Agent().Services()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(services).NotTo(HaveKey("stager"))
+			})
+		})
+	})
+
+	Describe("-standbyLockKey arg", func() {
+		Context("when the lock is held by another stager", func() {
+			BeforeEach(func() {
+				client := consulRunner.NewClient()
+
+				sessionID, _, err := client.Session().Create(&api.SessionEntry{TTL: "10s"}, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				acquired, _, err := client.KV().Acquire(&api.KVPair{
+					Key:     "v1/locks/stager_lock",
+					Value:   []byte("other-stager"),
+					Session: sessionID,
+				}, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(acquired).To(BeTrue())
+
+				runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz", "-standbyLockKey", "stager_lock")
+			})
+
+			It("waits in standby without serving staging requests", func() {
+				Consistently(runner.Session(), 2).ShouldNot(gbytes.Say("Listening for staging requests!"))
+				Expect(runner.Session()).NotTo(gexec.Exit())
+			})
+
+			It("refuses staging requests while in standby", func() {
+				Eventually(func() (int, error) {
+					req, err := requestGenerator.CreateRequest(stager.StagingStatusRoute, rata.Params{"staging_guid": "my-task-guid"}, nil)
+					if err != nil {
+						return 0, err
+					}
+
+					resp, err := httpClient.Do(req)
+					if err != nil {
+						return 0, err
+					}
+					resp.Body.Close()
+					return resp.StatusCode, nil
+				}).Should(Equal(http.StatusServiceUnavailable))
+			})
+
+			It("accepts completion callbacks while in standby", func() {
+				Eventually(func() (int, error) {
+					req, err := requestGenerator.CreateRequest(stager.StagingCompletedRoute, rata.Params{"staging_guid": "my-task-guid"}, strings.NewReader("{}"))
+					if err != nil {
+						return 0, err
+					}
+
+					resp, err := httpClient.Do(req)
+					if err != nil {
+						return 0, err
+					}
+					resp.Body.Close()
+					return resp.StatusCode, nil
+				}).ShouldNot(Equal(http.StatusServiceUnavailable))
+			})
+		})
+
+		Context("when a health address is configured", func() {
+			var healthAddress string
+
+			BeforeEach(func() {
+				client := consulRunner.NewClient()
+
+				sessionID, _, err := client.Session().Create(&api.SessionEntry{TTL: "10s"}, nil)
+				Expect(err).NotTo(HaveOccurred())
+
+				acquired, _, err := client.KV().Acquire(&api.KVPair{
+					Key:     "v1/locks/stager_lock",
+					Value:   []byte("other-stager"),
+					Session: sessionID,
+				}, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(acquired).To(BeTrue())
+
+				fakeBBS.RouteToHandler("POST", "/v1/ping", func(w http.ResponseWriter, req *http.Request) {
+					writeResponse(w, &models.PingResponse{Available: true})
+				})
+
+				healthAddress = fmt.Sprintf("127.0.0.1:%d", 8790+GinkgoParallelNode())
+				runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz", "-standbyLockKey", "stager_lock", "-healthAddress", healthAddress, "-publishStagingStarted")
+			})
+
+			It("serves health checks while in standby", func() {
+				Eventually(func() error {
+					resp, err := http.Get("http://" + healthAddress + "/health")
+					if err != nil {
+						return err
+					}
+					resp.Body.Close()
+					return nil
+				}).Should(Succeed())
+			})
+
+			It("reports healthy while in standby, though it is not watching task events", func() {
+				Eventually(func() (int, error) {
+					resp, err := http.Get("http://" + healthAddress + "/health")
+					if err != nil {
+						return 0, err
+					}
+					resp.Body.Close()
+					return resp.StatusCode, nil
+				}).Should(Equal(http.StatusOK))
+				Consistently(func() (int, error) {
+					resp, err := http.Get("http://" + healthAddress + "/health")
+					if err != nil {
+						return 0, err
+					}
+					resp.Body.Close()
+					return resp.StatusCode, nil
+				}).Should(Equal(http.StatusOK))
+			})
+		})
+
+		Context("when the lock is free", func() {
+			BeforeEach(func() {
+				runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz", "-standbyLockKey", "stager_lock")
+			})
+
+			It("acquires the lock and serves staging requests", func() {
+				Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
+
+				pair, _, err := consulRunner.NewClient().KV().Get("v1/locks/stager_lock", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pair).NotTo(BeNil())
+			})
+		})
+	})
+
 	Describe("-consulCluster arg", func() {
 		Context("when started with an invalid -consulCluster arg", func() {
 			BeforeEach(func() {
 				runner.Config.ConsulCluster = "://noscheme:8500"
-				runner.Start("-lifecycle", "linux:lifecycle.zip")
+				runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz")
 			})
 
-			It("logs and errors", func() {
-				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
-				Eventually(runner.Session()).Should(gbytes.Say("Error parsing consul agent URL"))
+			It("reports the invalid configuration and errors", func() {
+				Eventually(runner.Session()).Should(gexec.Exit(1))
+				Expect(runner.Session().Err).To(gbytes.Say("configuration is invalid"))
+				Expect(runner.Session().Err.Contents()).To(ContainSubstring("-consulCluster: "))
 			})
 		})
 	})
@@ -493,7 +696,7 @@ var _ = Describe("Stager", func() {
 	Describe("-dockerRegistryAddress arg", func() {
 		Context("when started with a valid -dockerRegistryAddress arg", func() {
 			BeforeEach(func() {
-				runner.Start("-lifecycle", "linux:lifecycle.zip",
+				runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz",
 					"-dockerRegistryAddress", "docker-registry.service.cf.internal:8080")
 				Eventually(runner.Session()).Should(gbytes.Say("Listening for staging requests!"))
 			})
@@ -505,13 +708,14 @@ var _ = Describe("Stager", func() {
 
 		Context("when started with an invalid -dockerRegistryAddress arg", func() {
 			BeforeEach(func() {
-				runner.Start("-lifecycle", "linux:lifecycle.zip",
+				runner.Start("-lifecycle", "buildpack/linux:lifecycle.zip", "-lifecycle", "docker:docker/lifecycle.tgz",
 					"-dockerRegistryAddress", "://noscheme:8500")
 			})
 
-			It("logs and errors", func() {
-				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
-				Eventually(runner.Session()).Should(gbytes.Say("Error parsing Docker Registry address"))
+			It("reports the invalid configuration and errors", func() {
+				Eventually(runner.Session()).Should(gexec.Exit(1))
+				Expect(runner.Session().Err).To(gbytes.Say("configuration is invalid"))
+				Expect(runner.Session().Err.Contents()).To(ContainSubstring("-dockerRegistryAddress: "))
 			})
 		})
 	})
@@ -535,9 +739,10 @@ var _ = Describe("Stager", func() {
 				runner.Start("-stagingTaskCallbackURL", `://localhost:8080`)
 			})
 
-			It("logs and errors", func() {
-				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
-				Eventually(runner.Session()).Should(gbytes.Say("Invalid staging task callback url"))
+			It("reports the invalid configuration and errors", func() {
+				Eventually(runner.Session()).Should(gexec.Exit(1))
+				Expect(runner.Session().Err).To(gbytes.Say("configuration is invalid"))
+				Expect(runner.Session().Err.Contents()).To(ContainSubstring("-stagingTaskCallbackURL: "))
 			})
 		})
 	})
@@ -549,9 +754,11 @@ var _ = Describe("Stager", func() {
 				runner.Start()
 			})
 
-			It("logs and errors", func() {
-				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
-				Eventually(runner.Session()).Should(gbytes.Say("missing port in address"))
+			It("reports the invalid configuration and errors", func() {
+				Eventually(runner.Session()).Should(gexec.Exit(1))
+				Expect(runner.Session().Err).To(gbytes.Say("configuration is invalid"))
+				Expect(runner.Session().Err.Contents()).To(ContainSubstring("-listenAddress: "))
+				Expect(runner.Session().Err.Contents()).To(ContainSubstring("missing port in address"))
 			})
 		})
 
@@ -561,13 +768,240 @@ var _ = Describe("Stager", func() {
 				runner.Start()
 			})
 
-			It("logs and errors", func() {
-				Eventually(runner.Session().ExitCode()).ShouldNot(Equal(0))
-				Eventually(runner.Session()).Should(gbytes.Say("stager.failed-invalid-listen-port"))
+			It("reports the invalid configuration and errors", func() {
+				Eventually(runner.Session()).Should(gexec.Exit(1))
+				Expect(runner.Session().Err).To(gbytes.Say("configuration is invalid"))
+				Expect(runner.Session().Err.Contents()).To(ContainSubstring("-listenAddress: "))
 			})
 		})
 	})
 
+	Describe("a configuration that would fail while running", func() {
+		BeforeEach(func() {
+			runner.Start(
+				"-lifecycle", "buildpack/linux:lifecycle.zip",
+				"-lifecycle", "docker:docker/lifecycle.tgz",
+				"-stagingTaskTTL", "1h",
+				"-stagingTaskReapInterval", "0",
+			)
+		})
+
+		It("is reported before the stager starts", func() {
+			Eventually(runner.Session()).Should(gexec.Exit(1))
+			Expect(runner.Session().Err).To(gbytes.Say("configuration is invalid"))
+			Expect(runner.Session().Err.Contents()).To(ContainSubstring("-stagingTaskReapInterval: must be positive when -stagingTaskTTL is set"))
+			Expect(runner.Session().Out.Contents()).NotTo(ContainSubstring("Listening for staging requests!"))
+		})
+	})
+
+	Describe("validate-config", func() {
+		var session *gexec.Session
+
+		validateConfig := func(args ...string) {
+			var err error
+			session, err = gexec.Start(
+				exec.Command(stagerPath, append([]string{"validate-config"}, args...)...),
+				GinkgoWriter,
+				GinkgoWriter,
+			)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Context("when the configuration is valid", func() {
+			BeforeEach(func() {
+				validateConfig(
+					"-bbsAddress", fakeBBS.URL(),
+					"-listenAddress", "127.0.0.1:8888",
+					"-stagingTaskCallbackURL", "http://127.0.0.1:8888",
+					"-ccBaseURL", fakeCC.URL(),
+					"-dockerStagingStack", "docker-staging-stack",
+					"-consulCluster", consulRunner.URL(),
+					"-lifecycle", "buildpack/linux:lifecycle.zip",
+					"-lifecycle", "docker:docker/lifecycle.tgz",
+				)
+			})
+
+			It("reports success and exits zero", func() {
+				Eventually(session).Should(gexec.Exit(0))
+				Expect(session).To(gbytes.Say("configuration is valid"))
+			})
+		})
+
+		Context("when the configuration is invalid", func() {
+			BeforeEach(func() {
+				validateConfig(
+					"-bbsAddress", "https://bbs.example.com",
+					"-listenAddress", "portless",
+					"-lifecycle", "docker:docker/lifecycle.tgz",
+					"-stackRootFS", "linux=ftp://rootfs.example.com",
+					"-metricsEmitter", "statsd",
+					"-healthAddress", "127.0.0.1:8890",
+					"-consulHealthCheckInterval", "0",
+					"-stackScopedRegistration",
+					"-builderArg", "docker:-dockerRef=evil",
+					"-stagingAuditSize", "-1",
+					"-bbsCallTimeout", "-1s",
+					"-insecureDockerRegistry", "ftp://registry.example.com",
+					"-maxStagingAttemptsWindow", "-1s",
+					"-deadLetterMaxCount", "-1",
+					"-ccClientCert", "/nonexistent/cert",
+					"-taskEventsMaxResubscribeInterval", "0s",
+					"-taskEventsResubscribeJitter", "1.5",
+					"-dropletUploadURLSlack", "-1s",
+					"-stagingLogsLockKey", "stager_logs_lock",
+				)
+			})
+
+			It("reports every problem and exits non-zero", func() {
+				Eventually(session).Should(gexec.Exit(1))
+				Expect(session).To(gbytes.Say("configuration is invalid"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-ccBaseURL: must be set"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-listenAddress: "))
+				Expect(session.Out.Contents()).To(ContainSubstring("-dockerStagingStack: "))
+				Expect(session.Out.Contents()).To(ContainSubstring("-bbsCACert: "))
+				Expect(session.Out.Contents()).To(ContainSubstring("no buildpack lifecycle configured"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-stackRootFS: linux: unknown rootfs scheme 'ftp'"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-statsdAddress: must be set when -metricsEmitter is 'statsd'"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-consulHealthCheckInterval: must be positive when -healthAddress is set"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-stackScopedRegistration: requires -allowedStack to be set"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-builderArg: builder argument not allowed for lifecycle docker: -dockerRef=evil"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-stagingAuditSize: must not be negative"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-deadLetterMaxCount: must not be negative"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-ccClientCert: "))
+				Expect(session.Out.Contents()).To(ContainSubstring("-taskEventsMaxResubscribeInterval: must be positive"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-taskEventsResubscribeJitter: must be between 0 and 1"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-dropletUploadURLSlack: must not be negative"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-stagingLogsLockKey: requires -stagingLogsNATSSubjectPrefix"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-bbsCallTimeout: must not be negative"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-insecureDockerRegistry: invalid docker registry 'ftp://registry.example.com'"))
+				Expect(session.Out.Contents()).To(ContainSubstring("-maxStagingAttemptsWindow: must not be negative"))
+			})
+		})
+	})
+
+	Describe("tasks", func() {
+		var (
+			fakeStager *ghttp.Server
+			session    *gexec.Session
+		)
+
+		BeforeEach(func() {
+			fakeStager = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			fakeStager.Close()
+		})
+
+		runTasks := func(args ...string) {
+			var err error
+			session, err = gexec.Start(
+				exec.Command(stagerPath, append([]string{"tasks", "-stagerURL", fakeStager.URL()}, args...)...),
+				GinkgoWriter,
+				GinkgoWriter,
+			)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		It("lists the staging tasks", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v1/staging_tasks"),
+				ghttp.RespondWith(http.StatusOK, `[{"app_id":"the-app-id","task_guid":"the-task-guid","state":"Running","age_in_seconds":30}]`),
+			))
+
+			runTasks("list")
+			Eventually(session).Should(gexec.Exit(0))
+			Expect(session).To(gbytes.Say("TASK GUID"))
+			Expect(session).To(gbytes.Say(`the-task-guid\s+the-app-id\s+Running\s+30s`))
+		})
+
+		It("shows the status of a staging task", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v1/staging/the-task-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"task_guid":"the-task-guid","state":"failed","result":{"error":{"id":"StagingError","message":"staging failed"}}}`),
+			))
+
+			runTasks("status", "the-task-guid")
+			Eventually(session).Should(gexec.Exit(0))
+			Expect(session).To(gbytes.Say("state: +failed"))
+			Expect(session).To(gbytes.Say("staging failed"))
+		})
+
+		It("cancels a staging task", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("DELETE", "/v1/staging/the-task-guid"),
+				ghttp.RespondWith(http.StatusAccepted, nil),
+			))
+
+			runTasks("cancel", "the-task-guid")
+			Eventually(session).Should(gexec.Exit(0))
+			Expect(session).To(gbytes.Say("cancelling the-task-guid"))
+		})
+
+		It("fails for unknown tasks", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, nil))
+
+			runTasks("cancel", "unknown-guid")
+			Eventually(session).Should(gexec.Exit(1))
+			Expect(session).To(gbytes.Say("staging task not found"))
+		})
+
+		It("prints the usage for unknown commands", func() {
+			runTasks("frobnicate")
+			Eventually(session).Should(gexec.Exit(2))
+			Expect(session).To(gbytes.Say("usage: stager tasks"))
+		})
+	})
+
+	Describe("replay", func() {
+		var (
+			fakeStager *ghttp.Server
+			session    *gexec.Session
+		)
+
+		BeforeEach(func() {
+			fakeStager = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			fakeStager.Close()
+		})
+
+		runReplay := func(args ...string) {
+			var err error
+			session, err = gexec.Start(
+				exec.Command(stagerPath, append([]string{"replay", "-stagerURL", fakeStager.URL()}, args...)...),
+				GinkgoWriter,
+				GinkgoWriter,
+			)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		It("replays a completed staging task", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v1/staging/the-task-guid/replay"),
+				ghttp.RespondWith(http.StatusOK, nil),
+			))
+
+			runReplay("the-task-guid")
+			Eventually(session).Should(gexec.Exit(0))
+			Expect(session).To(gbytes.Say("replayed the-task-guid"))
+		})
+
+		It("fails for tasks that cannot be replayed", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusConflict, nil))
+
+			runReplay("the-task-guid")
+			Eventually(session).Should(gexec.Exit(1))
+			Expect(session).To(gbytes.Say("status code 409"))
+		})
+
+		It("prints the usage without a task guid", func() {
+			runReplay()
+			Eventually(session).Should(gexec.Exit(2))
+			Expect(session).To(gbytes.Say("usage: stager replay"))
+		})
+	})
 })
 
 func writeResponse(w http.ResponseWriter, message proto.Message) {
@@ -0,0 +1,29 @@
+//go:build !faults
+// +build !faults
+
+package main
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/nats_connection"
+	"github.com/tedsuo/ifrit"
+)
+
+func injectBBSFaults(bbsClient bbs.Client) bbs.Client {
+	return bbsClient
+}
+
+func injectNATSFaults(logger lager.Logger, dial nats_connection.Dialer) nats_connection.Dialer {
+	return dial
+}
+
+func injectStagerFaults(logger lager.Logger, handler http.Handler) http.Handler {
+	return handler
+}
+
+func initializeFaultInjectionServer(logger lager.Logger) ifrit.Runner {
+	return nil
+}
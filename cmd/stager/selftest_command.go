@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"code.cloudfoundry.org/cflager"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/nats_connection"
+	"code.cloudfoundry.org/stager/staging_selftest"
+)
+
+const selfTestCommand = "selftest"
+
+// runSelfTestCommand runs a no-op task through the BBS, and publishes to
+// NATS when -natsAddresses is set, using the stager's own flags, so that
+// operators can check a stager's dependencies before it serves stagings.
+func runSelfTestCommand(out io.Writer, args []string) int {
+	stack := flag.String("selfTestStack", "cflinuxfs2", "Stack the self test task runs on")
+	timeout := flag.Duration("selfTestTimeout", staging_selftest.DefaultTimeout, "Time to wait for the self test task to complete")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return 2
+	}
+
+	logger, _ := cflager.New("stager-selftest")
+
+	var natsConn nats_connection.Connection
+	if *natsAddresses != "" {
+		conn := initializeNATSConn(logger)
+		defer conn.Close()
+		natsConn = conn
+	}
+
+	config := staging_selftest.Config{
+		Domain:  staging_selftest.DefaultDomain,
+		RootFS:  backend.Config{StackRootFSes: stackRootFSes}.RootFS(*stack),
+		Timeout: *timeout,
+	}
+	results := staging_selftest.Run(logger, initializeBBSClient(logger), natsConn, config, clock.NewClock())
+
+	writer := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "STEP\tRESULT\tDURATION\tERROR")
+	for _, result := range results {
+		status, message := "PASS", ""
+		if result.Err != nil {
+			status, message = "FAIL", result.Err.Error()
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", result.Step, status, result.Duration, message)
+	}
+	writer.Flush()
+
+	if !staging_selftest.Passed(results) {
+		fmt.Fprintln(out, "self test failed")
+		return 1
+	}
+	fmt.Fprintln(out, "self test passed")
+	return 0
+}
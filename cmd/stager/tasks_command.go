@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/stager_client"
+)
+
+const (
+	tasksCommand  = "tasks"
+	replayCommand = "replay"
+)
+
+const tasksUsage = `usage: stager tasks [-stagerURL URL] <command>
+
+commands:
+  list            list the tasks in the staging domain
+  status <guid>   show the state of a staging task, and its result once done
+  cancel <guid>   cancel a staging task
+`
+
+const replayUsage = `usage: stager replay [-stagerURL URL] <guid>
+
+Delivers the result of a completed staging task to the cloud controller
+again, and deletes the task once delivered.
+`
+
+// runTasksCommand lets operators manage staging tasks through the API of a
+// running stager.
+func runTasksCommand(out io.Writer, args []string) int {
+	flagSet := flag.NewFlagSet(tasksCommand, flag.ContinueOnError)
+	flagSet.SetOutput(out)
+	stagerURL := flagSet.String("stagerURL", "http://127.0.0.1:8888", "URL of the stager API")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	client := newAPIClient(*stagerURL)
+	logger := lager.NewLogger(tasksCommand)
+
+	var err error
+	switch {
+	case flagSet.NArg() == 1 && flagSet.Arg(0) == "list":
+		err = listTasks(out, client, logger)
+	case flagSet.NArg() == 2 && flagSet.Arg(0) == "status":
+		err = showTaskStatus(out, client, logger, flagSet.Arg(1))
+	case flagSet.NArg() == 2 && flagSet.Arg(0) == "cancel":
+		err = cancelTask(out, client, logger, flagSet.Arg(1))
+	default:
+		fmt.Fprint(out, tasksUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(out, "error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runReplayCommand replays a completed staging task through the API of a
+// running stager, as it would be replayed on startup with
+// -redeliverCompletedTasks.
+func runReplayCommand(out io.Writer, args []string) int {
+	flagSet := flag.NewFlagSet(replayCommand, flag.ContinueOnError)
+	flagSet.SetOutput(out)
+	stagerURL := flagSet.String("stagerURL", "http://127.0.0.1:8888", "URL of the stager API")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	if flagSet.NArg() != 1 {
+		fmt.Fprint(out, replayUsage)
+		return 2
+	}
+
+	guid := flagSet.Arg(0)
+	err := newAPIClient(*stagerURL).ReplayStaging(guid, lager.NewLogger(replayCommand))
+	if err != nil {
+		fmt.Fprintf(out, "error: %s\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "replayed %s\n", guid)
+	return 0
+}
+
+func newAPIClient(stagerURL string) stager_client.Client {
+	return stager_client.NewClient(stagerURL, http.DefaultClient, 0, 0, clock.NewClock())
+}
+
+func listTasks(out io.Writer, client stager_client.Client, logger lager.Logger) error {
+	tasks, err := client.ListTasks(logger)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "TASK GUID\tAPP ID\tSTATE\tAGE")
+	for _, task := range tasks {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%ds\n", task.TaskGuid, task.AppId, task.State, task.AgeInSeconds)
+	}
+	return writer.Flush()
+}
+
+func showTaskStatus(out io.Writer, client stager_client.Client, logger lager.Logger, guid string) error {
+	status, err := client.StagingStatus(guid, logger)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "task guid: %s\nstate:     %s\n", status.TaskGuid, status.State)
+	if status.Result != nil {
+		result, err := json.MarshalIndent(status.Result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "result:\n%s\n", result)
+	}
+	return nil
+}
+
+func cancelTask(out io.Writer, client stager_client.Client, logger lager.Logger, guid string) error {
+	err := client.StopStaging(guid, logger)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "cancelling %s\n", guid)
+	return nil
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages/flags"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/config"
+	"code.cloudfoundry.org/stager/docker_registry"
+	"code.cloudfoundry.org/stager/metrics_emitter"
+	"code.cloudfoundry.org/stager/nats_connection"
+	"code.cloudfoundry.org/stager/request_validation"
+	"code.cloudfoundry.org/stager/response_format"
+)
+
+const validateConfigCommand = "validate-config"
+
+type configError struct {
+	flag string
+	err  error
+}
+
+func (e configError) Error() string {
+	return fmt.Sprintf("-%s: %s", e.flag, e.err)
+}
+
+func runValidateConfig(out io.Writer, lifecycles flags.LifecycleMap) int {
+	errs := validateConfig(lifecycles)
+	if len(errs) == 0 {
+		fmt.Fprintln(out, "configuration is valid")
+		return 0
+	}
+
+	reportConfigErrors(out, errs)
+	return 1
+}
+
+func reportConfigErrors(out io.Writer, errs []error) {
+	fmt.Fprintf(out, "configuration is invalid (%d errors):\n", len(errs))
+	for _, err := range errs {
+		fmt.Fprintf(out, "  %s\n", err)
+	}
+}
+
+func validateConfig(lifecycles flags.LifecycleMap) []error {
+	errs := []error{}
+	check := func(flag string, err error) {
+		if err != nil {
+			errs = append(errs, configError{flag: flag, err: err})
+		}
+	}
+
+	check("ccBaseURL", validateAbsoluteURL(*ccBaseURL))
+
+	if *ccCACert != "" {
+		check("ccCACert", validateCACert(*ccCACert))
+	}
+
+	if *ccClientCert != "" || *ccClientKey != "" {
+		_, err := tls.LoadX509KeyPair(*ccClientCert, *ccClientKey)
+		check("ccClientCert", err)
+	}
+
+	if *ccUAAAuth {
+		check("uaaURL", validateAbsoluteURL(*uaaURL))
+		if *uaaClientId == "" {
+			check("uaaClientId", errors.New("must be set when -ccUAAAuth is set"))
+		}
+	}
+	check("bbsAddress", validateAbsoluteURL(*bbsAddress))
+	check("stagingTaskCallbackURL", validateAbsoluteURL(*stagingTaskCallbackURL))
+	check("consulCluster", validateAbsoluteURL(*consulCluster))
+	check("listenAddress", validateListenAddress(*listenAddress))
+
+	if *healthAddress != "" {
+		check("healthAddress", validateListenAddress(*healthAddress))
+
+		if *consulServiceName != "" && *consulHealthCheckInterval <= 0 {
+			check("consulHealthCheckInterval", errors.New("must be positive when -healthAddress is set"))
+		}
+	}
+
+	if *requireTLSForCCTransfers && !strings.HasPrefix(*ccUploaderURL, "https://") {
+		check("ccUploaderURL", errors.New("must be https when -requireTLSForCCTransfers is set"))
+	}
+
+	switch *metricsEmitter {
+	case metrics_emitter.DropsondeEmitter, metrics_emitter.NoopEmitter:
+	case metrics_emitter.StatsdEmitter:
+		if *statsdAddress == "" {
+			check("statsdAddress", errors.New("must be set when -metricsEmitter is 'statsd'"))
+		}
+	default:
+		check("metricsEmitter", &metrics_emitter.UnknownEmitterError{Name: *metricsEmitter})
+	}
+
+	if *dockerStagingStack == "" {
+		check("dockerStagingStack", errors.New("dockerStagingStack cannot be blank"))
+	}
+
+	for _, registry := range insecureDockerRegistries.Values() {
+		_, err := docker_registry.RegistryHost(registry)
+		check("insecureDockerRegistry", err)
+	}
+
+	if *dockerRegistryAddress != "" {
+		_, _, err := net.SplitHostPort(*dockerRegistryAddress)
+		check("dockerRegistryAddress", err)
+	}
+
+	if *bbsDesireTaskRetries < 0 {
+		check("bbsDesireTaskRetries", errors.New("must not be negative"))
+	}
+
+	if *bbsDesireTaskRetries > 0 && *bbsDesireTaskRetryInterval <= 0 {
+		check("bbsDesireTaskRetryInterval", errors.New("must be positive when -bbsDesireTaskRetries is set"))
+	}
+
+	if *maxStagingAttempts < 0 {
+		check("maxStagingAttempts", errors.New("must not be negative"))
+	}
+
+	if *maxStagingAttemptsWindow < 0 {
+		check("maxStagingAttemptsWindow", errors.New("must not be negative"))
+	}
+
+	_, err := parseStatsWindows(*statsWindows)
+	check("statsWindows", err)
+
+	if *stagingDurationPercentilesInterval < 0 {
+		check("stagingDurationPercentilesInterval", errors.New("must not be negative"))
+	}
+
+	if *ccRequestTimeout <= 0 {
+		check("ccRequestTimeout", errors.New("must be positive"))
+	}
+
+	if *ccRequestRetries < 0 {
+		check("ccRequestRetries", errors.New("must not be negative"))
+	}
+
+	if *ccRequestRetryInterval < 0 {
+		check("ccRequestRetryInterval", errors.New("must not be negative"))
+	}
+
+	if *minStagingTimeout < 0 || *maxStagingTimeout < 0 {
+		check("maxStagingTimeout", errors.New("staging timeout bounds must not be negative"))
+	} else if *maxStagingTimeout > 0 && *minStagingTimeout > *maxStagingTimeout {
+		check("maxStagingTimeout", errors.New("must not be less than -minStagingTimeout"))
+	}
+
+	if *completionDedupWindow < 0 {
+		check("completionDedupWindow", errors.New("must not be negative"))
+	}
+
+	if *completionDedupSize < 0 {
+		check("completionDedupSize", errors.New("must not be negative"))
+	}
+
+	if *bbsCallTimeout < 0 {
+		check("bbsCallTimeout", errors.New("must not be negative"))
+	}
+
+	if *bbsMaxConcurrentCalls < 0 {
+		check("bbsMaxConcurrentCalls", errors.New("must not be negative"))
+	}
+
+	if *bbsCircuitBreakerThreshold > 0 && *bbsCircuitBreakerResetTimeout <= 0 {
+		check("bbsCircuitBreakerResetTimeout", errors.New("must be positive when -bbsCircuitBreakerThreshold is set"))
+	}
+
+	check("maxStagingMemoryMB", validateResourceBounds(*minStagingMemoryMB, *maxStagingMemoryMB, "-minStagingMemoryMB"))
+	check("maxStagingDiskMB", validateResourceBounds(*minStagingDiskMB, *maxStagingDiskMB, "-minStagingDiskMB"))
+
+	_, err = request_validation.NewChain(strings.Split(*stagingRequestValidators, ","), requestValidationConfig())
+	check("stagingRequestValidators", err)
+
+	if *maxStagingEnvironmentVariables < 0 || *maxStagingEnvironmentBytes < 0 {
+		check("maxStagingEnvironmentVariables", errors.New("environment size limits must not be negative"))
+	}
+
+	if *stackScopedRegistration && len(allowedStacks) == 0 {
+		check("stackScopedRegistration", errors.New("requires -allowedStack to be set"))
+	}
+
+	if *maxStagingBuildpacks < 0 {
+		check("maxStagingBuildpacks", errors.New("must not be negative"))
+	}
+
+	if *maxStagingRequestBytes < 0 {
+		check("maxStagingRequestBytes", errors.New("must not be negative"))
+	}
+
+	if *maxStagingResultBytes < 0 {
+		check("maxStagingResultBytes", errors.New("must not be negative"))
+	}
+
+	if *configPath != "" {
+		_, err := config.Load(*configPath)
+		check("configPath", err)
+	}
+
+	if *stagingRequestRate < 0 {
+		check("stagingRequestRate", errors.New("must not be negative"))
+	}
+
+	if *stagingRequestRate > 0 && *stagingRequestBurst < 1 {
+		check("stagingRequestBurst", errors.New("must be at least 1"))
+	}
+
+	if *stagingQueueDepth < 0 {
+		check("stagingQueueDepth", errors.New("must not be negative"))
+	}
+
+	if *stagingQueueDepth > 0 && *stagingQueueWorkers < 1 {
+		check("stagingQueueWorkers", errors.New("must be at least 1"))
+	}
+
+	if *maxOutstandingStagingTasks < 0 {
+		check("maxOutstandingStagingTasks", errors.New("must not be negative"))
+	}
+
+	if *maxOutstandingStagingTasks > 0 && *stagingLimitSyncInterval <= 0 {
+		check("stagingLimitSyncInterval", errors.New("must be positive when -maxOutstandingStagingTasks is set"))
+	}
+
+	if *stagingTaskTTL < 0 {
+		check("stagingTaskTTL", errors.New("must not be negative"))
+	}
+
+	if *stagingTaskTTL > 0 && *stagingTaskReapInterval <= 0 {
+		check("stagingTaskReapInterval", errors.New("must be positive when -stagingTaskTTL is set"))
+	}
+
+	if *stagingResponseJournalDir != "" && *stagingResponseReplayInterval <= 0 {
+		check("stagingResponseReplayInterval", errors.New("must be positive when -stagingResponseJournalDir is set"))
+	}
+
+	if *taskWatcherLockKey != "" && !*publishStagingStarted {
+		check("taskWatcherLockKey", errors.New("requires -publishStagingStarted"))
+	}
+
+	if *ccStagingStartedURL != "" {
+		if !*publishStagingStarted {
+			check("ccStagingStartedURL", errors.New("requires -publishStagingStarted"))
+		}
+		check("ccStagingStartedURL", validateAbsoluteURL(*ccStagingStartedURL))
+	}
+
+	if *stagingCpuWeight > backend.MaxCpuWeight {
+		check("stagingCpuWeight", fmt.Errorf("must not exceed %d", backend.MaxCpuWeight))
+	}
+
+	if *dropletUploadURLSlack < 0 {
+		check("dropletUploadURLSlack", errors.New("must not be negative"))
+	}
+
+	if *appBitsBlobstoreURL != "" {
+		check("appBitsBlobstoreURL", validateAbsoluteURL(*appBitsBlobstoreURL))
+
+		if *requireTLSForCCTransfers && !strings.HasPrefix(*appBitsBlobstoreURL, "https://") {
+			check("appBitsBlobstoreURL", errors.New("must be https when -requireTLSForCCTransfers is set"))
+		}
+
+		if *appBitsBlobstoreSecret == "" {
+			check("appBitsBlobstoreSecret", errors.New("must be set when -appBitsBlobstoreURL is set"))
+		}
+
+		if *appBitsBlobstoreURLTTL <= 0 {
+			check("appBitsBlobstoreURLTTL", errors.New("must be positive when -appBitsBlobstoreURL is set"))
+		}
+	}
+
+	if *drainTimeout < 0 {
+		check("drainTimeout", errors.New("must not be negative"))
+	}
+
+	if *completionWorkers < 0 {
+		check("completionWorkers", errors.New("must not be negative"))
+	}
+
+	if *detectTimeout < 0 {
+		check("detectTimeout", errors.New("must not be negative"))
+	}
+
+	if *executionMetadataHook != "" {
+		check("executionMetadataHook", validateExecutable(*executionMetadataHook))
+	}
+
+	if *executionMetadataHookTimeout < 0 {
+		check("executionMetadataHookTimeout", errors.New("must not be negative"))
+	}
+
+	if *taskEventsResubscribeInterval <= 0 {
+		check("taskEventsResubscribeInterval", errors.New("must be positive"))
+	}
+
+	if *taskEventsMaxResubscribeInterval <= 0 {
+		check("taskEventsMaxResubscribeInterval", errors.New("must be positive"))
+	} else if *taskEventsMaxResubscribeInterval < *taskEventsResubscribeInterval {
+		check("taskEventsMaxResubscribeInterval", errors.New("must not be less than -taskEventsResubscribeInterval"))
+	}
+
+	if *taskEventsResubscribeJitter < 0 || *taskEventsResubscribeJitter > 1 {
+		check("taskEventsResubscribeJitter", errors.New("must be between 0 and 1"))
+	}
+
+	if *taskEventsMaxResubscribeAttempts < 0 {
+		check("taskEventsMaxResubscribeAttempts", errors.New("must not be negative"))
+	}
+
+	_, err = response_format.DefaultRegistry().Lookup(*stagingResponseFormat)
+	check("stagingResponseFormat", err)
+
+	if *dockerImageMetadataCacheTTL < 0 {
+		check("dockerImageMetadataCacheTTL", errors.New("must not be negative"))
+	}
+
+	if *stagingEventsURL != "" {
+		check("stagingEventsURL", validateAbsoluteURL(*stagingEventsURL))
+
+		if *stagingEventsNATSSubject != "" {
+			check("stagingEventsNATSSubject", errors.New("cannot be set together with -stagingEventsURL"))
+		}
+	}
+
+	if *stagingEventsNATSSubject != "" && *natsAddresses == "" {
+		check("stagingEventsNATSSubject", errors.New("requires -natsAddresses"))
+	}
+
+	if *stagingLogsLockKey != "" && *stagingLogsNATSSubjectPrefix == "" {
+		check("stagingLogsLockKey", errors.New("requires -stagingLogsNATSSubjectPrefix"))
+	}
+
+	if *stagingLogsNATSSubjectPrefix != "" {
+		if *natsAddresses == "" {
+			check("stagingLogsNATSSubjectPrefix", errors.New("requires -natsAddresses"))
+		}
+		check("dopplerURL", validateAbsoluteURL(*dopplerURL))
+		check("uaaURL", validateAbsoluteURL(*uaaURL))
+		if *uaaClientId == "" {
+			check("uaaClientId", errors.New("required to relay staging logs"))
+		}
+	}
+
+	if *stagingEventsBufferSize < 1 {
+		check("stagingEventsBufferSize", errors.New("must be at least 1"))
+	}
+
+	if *stagingAuditSize < 0 {
+		check("stagingAuditSize", errors.New("must not be negative"))
+	}
+
+	if *deadLetterMaxCount < 0 {
+		check("deadLetterMaxCount", errors.New("must not be negative"))
+	}
+
+	if *deadLetterMaxPayloadBytes < 0 {
+		check("deadLetterMaxPayloadBytes", errors.New("must not be negative"))
+	}
+
+	if *natsAddresses != "" {
+		for _, address := range strings.Split(*natsAddresses, ",") {
+			_, _, err := net.SplitHostPort(strings.TrimSpace(address))
+			check("natsAddresses", err)
+		}
+
+		if *routeRegistrationInterval <= 0 {
+			check("routeRegistrationInterval", errors.New("must be positive"))
+		}
+
+		if *natsCredentialsFile != "" {
+			_, err := nats_connection.LoadCredentials(*natsCredentialsFile)
+			check("natsCredentialsFile", err)
+		}
+
+		if *natsCACert != "" {
+			_, err := nats_connection.NewTLSConfig(*natsCACert, *natsClientCert, *natsClientKey)
+			check("natsCACert", err)
+		} else if *natsClientCert != "" || *natsClientKey != "" {
+			check("natsCACert", errors.New("must be set when a NATS client cert or key is set"))
+		}
+	}
+
+	if strings.HasPrefix(*bbsAddress, "https") {
+		check("bbsCACert", validateCACert(*bbsCACert))
+		check("bbsClientCert", validateKeyPair(*bbsClientCert, *bbsClientKey))
+	}
+
+	for _, err := range validateLifecycles(lifecycles) {
+		check("lifecycle", err)
+	}
+	for _, err := range validateStackRootFSes(stackRootFSes) {
+		check("stackRootFS", err)
+	}
+
+	_, err = backend.ParseBuilderArgs(builderArgs.Values())
+	check("builderArg", err)
+
+	return errs
+}
+
+func validateAbsoluteURL(rawURL string) error {
+	if rawURL == "" {
+		return errors.New("must be set")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("'%s' is not an absolute URL", rawURL)
+	}
+
+	return nil
+}
+
+func validateListenAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	_, err = net.LookupPort("tcp", port)
+	return err
+}
+
+func validateResourceBounds(min, max int, minFlag string) error {
+	if min < 0 || max < 0 {
+		return errors.New("resource bounds must not be negative")
+	}
+
+	if max > backend.MaxResourceMB {
+		return fmt.Errorf("must not be more than %d", backend.MaxResourceMB)
+	}
+
+	if max > 0 && min > max {
+		return fmt.Errorf("must not be less than %s", minFlag)
+	}
+
+	return nil
+}
+
+func validateExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return fmt.Errorf("'%s' is not executable", path)
+	}
+
+	return nil
+}
+
+func validateCACert(path string) error {
+	if path == "" {
+		return errors.New("must be set when the BBS address is https")
+	}
+
+	certBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM(certBytes) {
+		return fmt.Errorf("no certificates found in '%s'", path)
+	}
+
+	return nil
+}
+
+func validateKeyPair(certPath, keyPath string) error {
+	if certPath == "" || keyPath == "" {
+		return errors.New("client cert and key must be set when the BBS address is https")
+	}
+
+	_, err := tls.LoadX509KeyPair(certPath, keyPath)
+	return err
+}
+
+func validateLifecycles(lifecycles flags.LifecycleMap) []error {
+	errs := []error{}
+
+	if _, ok := lifecycles[backend.DockerLifecycleName]; !ok {
+		errs = append(errs, errors.New("no docker lifecycle configured"))
+	}
+
+	buildpackStacks := 0
+	for name, bundle := range lifecycles {
+		if strings.HasPrefix(name, backend.TraditionalLifecycleName+"/") {
+			buildpackStacks++
+		}
+
+		u, err := url.Parse(bundle)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+			continue
+		}
+
+		switch u.Scheme {
+		case "", "http", "https":
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown scheme '%s'", name, u.Scheme))
+		}
+	}
+
+	if buildpackStacks == 0 {
+		errs = append(errs, errors.New("no buildpack lifecycle configured for any stack"))
+	}
+
+	return errs
+}
+
+func validateStackRootFSes(rootFSes map[string]string) []error {
+	errs := []error{}
+
+	for stack, rootFS := range rootFSes {
+		u, err := url.Parse(rootFS)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", stack, err))
+			continue
+		}
+
+		switch u.Scheme {
+		case "preloaded", "preloaded+layer", "docker":
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown rootfs scheme '%s'", stack, u.Scheme))
+		}
+	}
+
+	return errs
+}
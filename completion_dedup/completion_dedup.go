@@ -0,0 +1,104 @@
+package completion_dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+const (
+	DefaultSize   = 1000
+	DefaultWindow = 5 * time.Minute
+)
+
+// Tracker remembers the staging tasks whose completion was recently
+// delivered to CC, so that a completion the BBS calls back with again, e.g.
+// after it failed to resolve the task, is not delivered twice.
+type Tracker interface {
+	// Delivered records that the completion of the task was delivered.
+	Delivered(taskGuid string)
+
+	// Duplicate reports whether the completion of the task was delivered
+	// within the window.
+	Duplicate(taskGuid string) bool
+}
+
+type delivery struct {
+	taskGuid    string
+	deliveredAt time.Time
+}
+
+type tracker struct {
+	size   int
+	window time.Duration
+	clock  clock.Clock
+
+	lock       sync.Mutex
+	deliveries map[string]*list.Element
+	recent     *list.List
+}
+
+// NewTracker returns a tracker of the completions delivered within the
+// window, remembering at most size of them and forgetting the least recently
+// delivered first. A size or window of zero or less disables it.
+func NewTracker(size int, window time.Duration, clock clock.Clock) Tracker {
+	if size <= 0 || window <= 0 {
+		return disabled{}
+	}
+
+	return &tracker{
+		size:       size,
+		window:     window,
+		clock:      clock,
+		deliveries: map[string]*list.Element{},
+		recent:     list.New(),
+	}
+}
+
+func (t *tracker) Delivered(taskGuid string) {
+	now := t.clock.Now()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if element, ok := t.deliveries[taskGuid]; ok {
+		element.Value.(*delivery).deliveredAt = now
+		t.recent.MoveToFront(element)
+		return
+	}
+
+	t.deliveries[taskGuid] = t.recent.PushFront(&delivery{taskGuid: taskGuid, deliveredAt: now})
+	if t.recent.Len() > t.size {
+		t.remove(t.recent.Back())
+	}
+}
+
+func (t *tracker) Duplicate(taskGuid string) bool {
+	now := t.clock.Now()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	element, ok := t.deliveries[taskGuid]
+	if !ok {
+		return false
+	}
+
+	if now.Sub(element.Value.(*delivery).deliveredAt) >= t.window {
+		t.remove(element)
+		return false
+	}
+	return true
+}
+
+func (t *tracker) remove(element *list.Element) {
+	t.recent.Remove(element)
+	delete(t.deliveries, element.Value.(*delivery).taskGuid)
+}
+
+type disabled struct{}
+
+func (disabled) Delivered(taskGuid string)      {}
+func (disabled) Duplicate(taskGuid string) bool { return false }
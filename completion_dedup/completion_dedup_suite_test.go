@@ -0,0 +1,13 @@
+package completion_dedup_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCompletionDedup(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Completion Dedup Suite")
+}
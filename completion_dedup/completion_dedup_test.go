@@ -0,0 +1,73 @@
+package completion_dedup_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/stager/completion_dedup"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracker", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		tracker   completion_dedup.Tracker
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		tracker = completion_dedup.NewTracker(2, time.Minute, fakeClock)
+	})
+
+	It("does not report completions that were not delivered", func() {
+		Expect(tracker.Duplicate("task-1")).To(BeFalse())
+	})
+
+	It("reports completions delivered within the window", func() {
+		tracker.Delivered("task-1")
+
+		fakeClock.Increment(time.Minute - time.Second)
+		Expect(tracker.Duplicate("task-1")).To(BeTrue())
+		Expect(tracker.Duplicate("task-2")).To(BeFalse())
+	})
+
+	It("forgets completions delivered longer ago than the window", func() {
+		tracker.Delivered("task-1")
+
+		fakeClock.Increment(time.Minute)
+		Expect(tracker.Duplicate("task-1")).To(BeFalse())
+	})
+
+	It("restarts the window when a completion is delivered again", func() {
+		tracker.Delivered("task-1")
+		fakeClock.Increment(30 * time.Second)
+		tracker.Delivered("task-1")
+
+		fakeClock.Increment(45 * time.Second)
+		Expect(tracker.Duplicate("task-1")).To(BeTrue())
+	})
+
+	It("forgets the least recently delivered completions beyond its size", func() {
+		tracker.Delivered("task-1")
+		tracker.Delivered("task-2")
+		tracker.Delivered("task-1")
+		tracker.Delivered("task-3")
+
+		Expect(tracker.Duplicate("task-1")).To(BeTrue())
+		Expect(tracker.Duplicate("task-2")).To(BeFalse())
+		Expect(tracker.Duplicate("task-3")).To(BeTrue())
+	})
+
+	Context("when disabled", func() {
+		BeforeEach(func() {
+			tracker = completion_dedup.NewTracker(2, 0, fakeClock)
+		})
+
+		It("never reports duplicates", func() {
+			tracker.Delivered("task-1")
+			Expect(tracker.Duplicate("task-1")).To(BeFalse())
+		})
+	})
+})
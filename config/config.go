@@ -0,0 +1,139 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+// Tunables are the settings that can be changed without restarting the
+// stager. CC request settings left out of the config file fall back to their
+// command line flags; the log level is left as it is.
+type Tunables struct {
+	LogLevel               string    `json:"log_level,omitempty"`
+	CCRequestTimeout       *Duration `json:"cc_request_timeout,omitempty"`
+	CCRequestRetries       *int      `json:"cc_request_retries,omitempty"`
+	CCRequestRetryInterval *Duration `json:"cc_request_retry_interval,omitempty"`
+}
+
+// Duration is a time.Duration written as a string in the config file, e.g.
+// "5s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func Load(path string) (Tunables, error) {
+	var tunables Tunables
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return tunables, err
+	}
+
+	err = json.Unmarshal(contents, &tunables)
+	if err != nil {
+		return tunables, fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+
+	return tunables, tunables.Validate()
+}
+
+func (t Tunables) Validate() error {
+	if t.LogLevel != "" {
+		_, err := ParseLogLevel(t.LogLevel)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.CCRequestTimeout != nil && *t.CCRequestTimeout <= 0 {
+		return fmt.Errorf("cc_request_timeout must be positive")
+	}
+
+	if t.CCRequestRetries != nil && *t.CCRequestRetries < 0 {
+		return fmt.Errorf("cc_request_retries must not be negative")
+	}
+
+	if t.CCRequestRetryInterval != nil && *t.CCRequestRetryInterval < 0 {
+		return fmt.Errorf("cc_request_retry_interval must not be negative")
+	}
+
+	return nil
+}
+
+func ParseLogLevel(level string) (lager.LogLevel, error) {
+	switch level {
+	case "debug":
+		return lager.DEBUG, nil
+	case "info":
+		return lager.INFO, nil
+	case "error":
+		return lager.ERROR, nil
+	case "fatal":
+		return lager.FATAL, nil
+	default:
+		return lager.INFO, fmt.Errorf("unknown log level: %s", level)
+	}
+}
+
+type reloader struct {
+	logger  lager.Logger
+	path    string
+	reloads <-chan os.Signal
+	apply   func(Tunables)
+}
+
+// NewReloader returns a runner that reloads the config file and applies its
+// tunables every time a signal is received on reloads, typically SIGHUP. An
+// invalid config file is logged and leaves the current settings in place.
+func NewReloader(logger lager.Logger, path string, reloads <-chan os.Signal, apply func(Tunables)) ifrit.Runner {
+	return &reloader{
+		logger:  logger.Session("config-reloader", lager.Data{"path": path}),
+		path:    path,
+		reloads: reloads,
+		apply:   apply,
+	}
+}
+
+func (r *reloader) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-r.reloads:
+			tunables, err := Load(r.path)
+			if err != nil {
+				r.logger.Error("failed-to-reload", err)
+				continue
+			}
+
+			r.apply(tunables)
+			r.logger.Info("reloaded")
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/config"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var configPath string
+
+	writeConfig := func(contents string) {
+		Expect(ioutil.WriteFile(configPath, []byte(contents), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		configFile, err := ioutil.TempFile("", "stager-config")
+		Expect(err).NotTo(HaveOccurred())
+		configFile.Close()
+
+		configPath = configFile.Name()
+	})
+
+	AfterEach(func() {
+		os.Remove(configPath)
+	})
+
+	Describe("Load", func() {
+		It("loads the tunables from the config file", func() {
+			writeConfig(`{
+				"log_level": "debug",
+				"cc_request_timeout": "10s",
+				"cc_request_retries": 3,
+				"cc_request_retry_interval": "500ms"
+			}`)
+
+			tunables, err := config.Load(configPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tunables.LogLevel).To(Equal("debug"))
+			Expect(time.Duration(*tunables.CCRequestTimeout)).To(Equal(10 * time.Second))
+			Expect(*tunables.CCRequestRetries).To(Equal(3))
+			Expect(time.Duration(*tunables.CCRequestRetryInterval)).To(Equal(500 * time.Millisecond))
+		})
+
+		It("leaves out the tunables missing from the config file", func() {
+			writeConfig(`{}`)
+
+			tunables, err := config.Load(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tunables).To(Equal(config.Tunables{}))
+		})
+
+		It("fails on malformed config files", func() {
+			writeConfig(`{"cc_request_timeout": "forever"}`)
+
+			_, err := config.Load(configPath)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails on invalid tunables", func() {
+			writeConfig(`{"log_level": "chatty"}`)
+
+			_, err := config.Load(configPath)
+			Expect(err).To(MatchError("unknown log level: chatty"))
+		})
+	})
+
+	Describe("ParseLogLevel", func() {
+		It("parses the lager log levels", func() {
+			Expect(config.ParseLogLevel("debug")).To(Equal(lager.DEBUG))
+			Expect(config.ParseLogLevel("info")).To(Equal(lager.INFO))
+			Expect(config.ParseLogLevel("error")).To(Equal(lager.ERROR))
+			Expect(config.ParseLogLevel("fatal")).To(Equal(lager.FATAL))
+		})
+	})
+
+	Describe("Reloader", func() {
+		var (
+			reloads chan os.Signal
+			applied chan config.Tunables
+			process ifrit.Process
+		)
+
+		BeforeEach(func() {
+			writeConfig(`{"log_level": "info"}`)
+
+			reloads = make(chan os.Signal, 1)
+			applied = make(chan config.Tunables, 1)
+
+			reloader := config.NewReloader(lagertest.NewTestLogger("test"), configPath, reloads, func(tunables config.Tunables) {
+				applied <- tunables
+			})
+			process = ifrit.Invoke(reloader)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("applies the config file when told to reload", func() {
+			writeConfig(`{"log_level": "debug"}`)
+			reloads <- syscall.SIGHUP
+
+			var tunables config.Tunables
+			Eventually(applied).Should(Receive(&tunables))
+			Expect(tunables.LogLevel).To(Equal("debug"))
+		})
+
+		It("keeps the current settings when the config file is invalid", func() {
+			writeConfig(`{"log_level": "chatty"}`)
+			reloads <- syscall.SIGHUP
+
+			Consistently(applied).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"os"
+	"syscall"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+type logLevelAdjuster struct {
+	logger      lager.Logger
+	sink        *lager.ReconfigurableSink
+	adjustments <-chan os.Signal
+}
+
+// NewLogLevelAdjuster returns a runner that makes the sink more verbose
+// every time SIGUSR1 is received on adjustments, and less verbose every
+// time SIGUSR2 is, one log level at a time.
+func NewLogLevelAdjuster(logger lager.Logger, sink *lager.ReconfigurableSink, adjustments <-chan os.Signal) ifrit.Runner {
+	return &logLevelAdjuster{
+		logger:      logger.Session("log-level-adjuster"),
+		sink:        sink,
+		adjustments: adjustments,
+	}
+}
+
+func (a *logLevelAdjuster) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case signal := <-a.adjustments:
+			level := a.sink.GetMinLevel()
+			switch signal {
+			case syscall.SIGUSR1:
+				if level > lager.DEBUG {
+					level--
+				}
+			case syscall.SIGUSR2:
+				if level < lager.FATAL {
+					level++
+				}
+			default:
+				continue
+			}
+
+			a.sink.SetMinLevel(level)
+			a.logger.Info("adjusted-log-level", lager.Data{"log-level": logLevelName(level)})
+		}
+	}
+}
+
+func logLevelName(level lager.LogLevel) string {
+	switch level {
+	case lager.DEBUG:
+		return "debug"
+	case lager.INFO:
+		return "info"
+	case lager.ERROR:
+		return "error"
+	default:
+		return "fatal"
+	}
+}
@@ -0,0 +1,66 @@
+package config_test
+
+import (
+	"os"
+	"syscall"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/config"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LogLevelAdjuster", func() {
+	var (
+		sink        *lager.ReconfigurableSink
+		adjustments chan os.Signal
+		process     ifrit.Process
+	)
+
+	BeforeEach(func() {
+		sink = lager.NewReconfigurableSink(lagertest.NewTestSink(), lager.INFO)
+		adjustments = make(chan os.Signal, 1)
+
+		adjuster := config.NewLogLevelAdjuster(lagertest.NewTestLogger("test"), sink, adjustments)
+		process = ifrit.Invoke(adjuster)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("raises the verbosity on SIGUSR1", func() {
+		adjustments <- syscall.SIGUSR1
+		Eventually(sink.GetMinLevel).Should(Equal(lager.DEBUG))
+	})
+
+	It("does not go past debug", func() {
+		adjustments <- syscall.SIGUSR1
+		adjustments <- syscall.SIGUSR1
+		Eventually(sink.GetMinLevel).Should(Equal(lager.DEBUG))
+		Consistently(sink.GetMinLevel).Should(Equal(lager.DEBUG))
+	})
+
+	It("lowers the verbosity on SIGUSR2", func() {
+		adjustments <- syscall.SIGUSR2
+		Eventually(sink.GetMinLevel).Should(Equal(lager.ERROR))
+
+		adjustments <- syscall.SIGUSR2
+		Eventually(sink.GetMinLevel).Should(Equal(lager.FATAL))
+	})
+
+	It("does not go past fatal", func() {
+		sink.SetMinLevel(lager.FATAL)
+		adjustments <- syscall.SIGUSR2
+		Consistently(sink.GetMinLevel).Should(Equal(lager.FATAL))
+	})
+
+	It("ignores other signals", func() {
+		adjustments <- syscall.SIGHUP
+		Consistently(sink.GetMinLevel).Should(Equal(lager.INFO))
+	})
+})
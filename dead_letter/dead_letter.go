@@ -0,0 +1,152 @@
+package dead_letter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// Spool keeps staging requests that the stager could not accept, so that
+// operators can inspect and replay them instead of losing them to the logs.
+//
+//go:generate counterfeiter -o fakes/fake_spool.go . Spool
+type Spool interface {
+	Store(stagingGuid, reason string, payload []byte) error
+}
+
+// DeadLetter is the record written to the spool for every rejected request.
+// The payload is kept as a string as it is not necessarily valid JSON, and
+// is cut short when it is larger than the spool allows.
+type DeadLetter struct {
+	StagingGuid string    `json:"staging_guid"`
+	Reason      string    `json:"reason"`
+	ReceivedAt  time.Time `json:"received_at"`
+	Payload     string    `json:"payload"`
+	Truncated   bool      `json:"truncated,omitempty"`
+}
+
+type spool struct {
+	dir             string
+	maxLetters      int
+	maxPayloadBytes int
+	clock           clock.Clock
+}
+
+// NewSpool returns a Spool that writes one file per rejected request into
+// dir. If dir is empty, rejected requests are discarded. When maxLetters is
+// positive, the oldest letters are removed to keep at most that many, and
+// when maxPayloadBytes is positive, larger payloads are truncated.
+func NewSpool(dir string, maxLetters, maxPayloadBytes int, clock clock.Clock) Spool {
+	if dir == "" {
+		return noopSpool{}
+	}
+
+	return &spool{
+		dir:             dir,
+		maxLetters:      maxLetters,
+		maxPayloadBytes: maxPayloadBytes,
+		clock:           clock,
+	}
+}
+
+func (s *spool) Store(stagingGuid, reason string, payload []byte) error {
+	now := s.clock.Now()
+
+	truncated := false
+	if s.maxPayloadBytes > 0 && len(payload) > s.maxPayloadBytes {
+		payload = payload[:s.maxPayloadBytes]
+		truncated = true
+	}
+
+	letter, err := json.Marshal(DeadLetter{
+		StagingGuid: stagingGuid,
+		Reason:      reason,
+		ReceivedAt:  now,
+		Payload:     string(payload),
+		Truncated:   truncated,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(s.dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%d.json", filepath.Base(stagingGuid), now.UnixNano())
+	err = ioutil.WriteFile(filepath.Join(s.dir, name), letter, 0600)
+	if err != nil {
+		return err
+	}
+
+	return s.evict()
+}
+
+type letterFile struct {
+	name       string
+	receivedAt int64
+}
+
+type byReceivedAt []letterFile
+
+func (l byReceivedAt) Len() int           { return len(l) }
+func (l byReceivedAt) Less(i, j int) bool { return l[i].receivedAt < l[j].receivedAt }
+func (l byReceivedAt) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// evict removes the oldest letters beyond maxLetters, going by the time
+// they were received at as recorded in their file names.
+func (s *spool) evict() error {
+	if s.maxLetters <= 0 {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	letters := []letterFile{}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".json")
+		separator := strings.LastIndex(name, "-")
+		if file.IsDir() || name == file.Name() || separator < 0 {
+			continue
+		}
+
+		receivedAt, err := strconv.ParseInt(name[separator+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		letters = append(letters, letterFile{name: file.Name(), receivedAt: receivedAt})
+	}
+
+	if len(letters) <= s.maxLetters {
+		return nil
+	}
+
+	sort.Sort(byReceivedAt(letters))
+
+	for _, letter := range letters[:len(letters)-s.maxLetters] {
+		err := os.Remove(filepath.Join(s.dir, letter.name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type noopSpool struct{}
+
+func (noopSpool) Store(stagingGuid, reason string, payload []byte) error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package dead_letter_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/stager/dead_letter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spool", func() {
+	var (
+		spoolDir  string
+		fakeClock *fakeclock.FakeClock
+		spool     dead_letter.Spool
+	)
+
+	BeforeEach(func() {
+		var err error
+		spoolDir, err = ioutil.TempDir("", "dead-letters")
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeClock = fakeclock.NewFakeClock(time.Unix(0, 1234))
+		spool = dead_letter.NewSpool(filepath.Join(spoolDir, "spool"), 0, 0, fakeClock)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(spoolDir)
+	})
+
+	It("writes the rejected request to the spool directory", func() {
+		err := spool.Store("the-staging-guid", "some reason", []byte(`bad-json`))
+		Expect(err).NotTo(HaveOccurred())
+
+		contents, err := ioutil.ReadFile(filepath.Join(spoolDir, "spool", "the-staging-guid-1234.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		var letter dead_letter.DeadLetter
+		err = json.Unmarshal(contents, &letter)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(letter.StagingGuid).To(Equal("the-staging-guid"))
+		Expect(letter.Reason).To(Equal("some reason"))
+		Expect(letter.ReceivedAt.Equal(fakeClock.Now())).To(BeTrue())
+		Expect(letter.Payload).To(Equal("bad-json"))
+		Expect(letter.Truncated).To(BeFalse())
+	})
+
+	It("does not let the staging guid escape the spool directory", func() {
+		err := spool.Store("../escaped", "some reason", []byte(`{}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(filepath.Join(spoolDir, "spool", "escaped-1234.json")).To(BeARegularFile())
+	})
+
+	Context("when the payload is larger than allowed", func() {
+		BeforeEach(func() {
+			spool = dead_letter.NewSpool(filepath.Join(spoolDir, "spool"), 0, 3, fakeClock)
+		})
+
+		It("keeps the start of the payload and marks it as truncated", func() {
+			err := spool.Store("the-staging-guid", "some reason", []byte(`bad-json`))
+			Expect(err).NotTo(HaveOccurred())
+
+			contents, err := ioutil.ReadFile(filepath.Join(spoolDir, "spool", "the-staging-guid-1234.json"))
+			Expect(err).NotTo(HaveOccurred())
+
+			var letter dead_letter.DeadLetter
+			err = json.Unmarshal(contents, &letter)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(letter.Payload).To(Equal("bad"))
+			Expect(letter.Truncated).To(BeTrue())
+		})
+	})
+
+	Context("when the spool holds as many letters as allowed", func() {
+		BeforeEach(func() {
+			spool = dead_letter.NewSpool(filepath.Join(spoolDir, "spool"), 2, 0, fakeClock)
+
+			Expect(spool.Store("first-guid", "some reason", []byte(`{}`))).To(Succeed())
+			fakeClock.Increment(time.Second)
+			Expect(spool.Store("second-guid", "some reason", []byte(`{}`))).To(Succeed())
+			fakeClock.Increment(time.Second)
+		})
+
+		It("removes the oldest letter to make room", func() {
+			err := spool.Store("third-guid", "some reason", []byte(`{}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			files, err := ioutil.ReadDir(filepath.Join(spoolDir, "spool"))
+			Expect(err).NotTo(HaveOccurred())
+
+			names := []string{}
+			for _, file := range files {
+				names = append(names, file.Name())
+			}
+			Expect(names).To(ConsistOf("second-guid-1000001234.json", "third-guid-2000001234.json"))
+		})
+	})
+
+	Context("when no spool directory is configured", func() {
+		BeforeEach(func() {
+			spool = dead_letter.NewSpool("", 0, 0, fakeClock)
+		})
+
+		It("discards the request", func() {
+			err := spool.Store("the-staging-guid", "some reason", []byte(`bad-json`))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(filepath.Join(spoolDir, "spool")).NotTo(BeADirectory())
+		})
+	})
+})
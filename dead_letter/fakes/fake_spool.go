@@ -0,0 +1,57 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/dead_letter"
+)
+
+type FakeSpool struct {
+	StoreStub        func(stagingGuid, reason string, payload []byte) error
+	storeMutex       sync.RWMutex
+	storeArgsForCall []struct {
+		stagingGuid string
+		reason      string
+		payload     []byte
+	}
+	storeReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeSpool) Store(stagingGuid string, reason string, payload []byte) error {
+	fake.storeMutex.Lock()
+	fake.storeArgsForCall = append(fake.storeArgsForCall, struct {
+		stagingGuid string
+		reason      string
+		payload     []byte
+	}{stagingGuid, reason, payload})
+	fake.storeMutex.Unlock()
+	if fake.StoreStub != nil {
+		return fake.StoreStub(stagingGuid, reason, payload)
+	} else {
+		return fake.storeReturns.result1
+	}
+}
+
+func (fake *FakeSpool) StoreCallCount() int {
+	fake.storeMutex.RLock()
+	defer fake.storeMutex.RUnlock()
+	return len(fake.storeArgsForCall)
+}
+
+func (fake *FakeSpool) StoreArgsForCall(i int) (string, string, []byte) {
+	fake.storeMutex.RLock()
+	defer fake.storeMutex.RUnlock()
+	return fake.storeArgsForCall[i].stagingGuid, fake.storeArgsForCall[i].reason, fake.storeArgsForCall[i].payload
+}
+
+func (fake *FakeSpool) StoreReturns(result1 error) {
+	fake.StoreStub = nil
+	fake.storeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ dead_letter.Spool = new(FakeSpool)
@@ -12,4 +12,21 @@ const (
 	MISSING_DOCKER_REGISTRY               = "missing docker registry"
 	MISSING_DOCKER_CREDENTIALS            = "missing docker credentials"
 	INVALID_DOCKER_REGISTRY_ADDRESS       = "invalid docker registry address"
+	RETRY_BUDGET_EXHAUSTED_MESSAGE        = "retry budget exhausted"
+	TOO_MANY_ENVIRONMENT_VARIABLES        = "too many environment variables"
+	TOO_MANY_BUILDPACKS                   = "too many buildpacks"
+	DETECT_TIMEOUT_MESSAGE                = "no buildpack detected in time"
+	TASK_CANCELLED_MESSAGE                = "task was cancelled"
+	STAGING_CANCELLED_MESSAGE             = "staging was cancelled"
+	MALFORMED_STAGING_REQUEST_MESSAGE     = "malformed staging request"
+	STAGER_BUSY_MESSAGE                   = "stager busy, retry later"
+	PLATFORM_BUSY_MESSAGE                 = "platform busy, retry later"
+	INSECURE_TRANSFER_URL_MESSAGE         = "insecure transfer url"
+	DOCKER_IMAGE_NOT_FOUND_MESSAGE        = "docker image not found"
+	INVALID_RESOURCE_REQUEST_MESSAGE      = "invalid resource request"
+	INVALID_EGRESS_RULE_MESSAGE           = "invalid egress rule"
+	INVALID_BUILDPACK_CHECKSUM_MESSAGE    = "invalid buildpack checksum"
+	INVALID_DOCKER_IMAGE_DIGEST_MESSAGE   = "invalid docker image digest"
+	MALFORMED_STAGING_RESULT_MESSAGE      = "malformed staging result"
+	STAGING_RESULT_TOO_LARGE_MESSAGE      = "staging result too large"
 )
@@ -0,0 +1,172 @@
+package docker_registry
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	DockerHubRegistry = "registry-1.docker.io"
+	DefaultTag        = "latest"
+
+	DigestAlgorithm = "sha256"
+)
+
+var ErrInvalidDigest = errors.New("invalid docker image digest")
+
+// DockerRef identifies an image in a Docker registry. Reference is either a
+// tag or a digest.
+type DockerRef struct {
+	Registry   string
+	Repository string
+	Reference  string
+}
+
+// ParseDockerRef parses image references as given to docker pull, e.g.
+// "cloudfoundry/diego-docker-app:latest" or "my.registry:5000/app@sha256:...",
+// as well as the "docker://registry/repository#tag" form.
+func ParseDockerRef(imageURL string) (DockerRef, error) {
+	if strings.HasPrefix(imageURL, "docker://") {
+		return parseDockerURL(imageURL)
+	}
+
+	ref := DockerRef{Registry: DockerHubRegistry}
+	remainder := imageURL
+
+	parts := strings.SplitN(remainder, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry = normalizeRegistry(parts[0])
+		remainder = parts[1]
+	}
+
+	if i := strings.Index(remainder, "@"); i >= 0 {
+		ref.Reference = remainder[i+1:]
+		remainder = remainder[:i]
+	} else if i := strings.LastIndex(remainder, ":"); i > strings.LastIndex(remainder, "/") {
+		ref.Reference = remainder[i+1:]
+		remainder = remainder[:i]
+	} else {
+		ref.Reference = DefaultTag
+	}
+
+	ref.Repository = normalizeRepository(ref.Registry, remainder)
+
+	if ref.Repository == "" || ref.Reference == "" {
+		return DockerRef{}, fmt.Errorf("invalid docker image reference '%s'", imageURL)
+	}
+
+	return ref, nil
+}
+
+// IsDigest reports whether the reference is a content digest rather than a
+// tag. Tags cannot contain a colon.
+func (r DockerRef) IsDigest() bool {
+	return strings.Contains(r.Reference, ":")
+}
+
+// ValidateDigest checks that a digest is a sha256 digest in the form
+// "sha256:<64 hex digits>".
+func ValidateDigest(digest string) error {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] != DigestAlgorithm {
+		return ErrInvalidDigest
+	}
+
+	value, err := hex.DecodeString(parts[1])
+	if err != nil || len(value) != 32 {
+		return ErrInvalidDigest
+	}
+
+	return nil
+}
+
+// PinnedRef replaces the tag or digest of an image reference with the given
+// digest, so that pulling it always yields the same image. References in the
+// "docker://" form are returned unchanged, as they have no digest form.
+func PinnedRef(imageURL, digest string) string {
+	if strings.HasPrefix(imageURL, "docker://") {
+		return imageURL
+	}
+
+	name := imageURL
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+
+	return name + "@" + digest
+}
+
+// RegistryHost returns the registry named by an insecure registry setting:
+// a host, optionally with a port, that may carry the http or https scheme
+// the builder accepts.
+func RegistryHost(registry string) (string, error) {
+	host := registry
+	if strings.Contains(registry, "://") {
+		parsed, err := url.Parse(registry)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || strings.Trim(parsed.Path, "/") != "" {
+			return "", fmt.Errorf("invalid docker registry '%s'", registry)
+		}
+		host = parsed.Host
+	}
+
+	if host == "" || strings.ContainsAny(host, "/?#@") {
+		return "", fmt.Errorf("invalid docker registry '%s'", registry)
+	}
+
+	return normalizeRegistry(host), nil
+}
+
+// InsecureRegistry reports whether the image is in one of the insecure
+// registries.
+func InsecureRegistry(ref DockerRef, insecureRegistries []string) bool {
+	for _, registry := range insecureRegistries {
+		host, err := RegistryHost(registry)
+		if err == nil && host == ref.Registry {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDockerURL(imageURL string) (DockerRef, error) {
+	parsed, err := url.Parse(imageURL)
+	if err != nil {
+		return DockerRef{}, err
+	}
+
+	ref := DockerRef{
+		Registry:  normalizeRegistry(parsed.Host),
+		Reference: parsed.Fragment,
+	}
+	if ref.Reference == "" {
+		ref.Reference = DefaultTag
+	}
+
+	ref.Repository = normalizeRepository(ref.Registry, strings.TrimPrefix(parsed.Path, "/"))
+	if ref.Repository == "" {
+		return DockerRef{}, fmt.Errorf("invalid docker image reference '%s'", imageURL)
+	}
+
+	return ref, nil
+}
+
+func normalizeRegistry(registry string) string {
+	switch registry {
+	case "", "docker.io", "index.docker.io":
+		return DockerHubRegistry
+	}
+	return registry
+}
+
+// Official images on Docker Hub live in the library namespace.
+func normalizeRepository(registry, repository string) string {
+	if registry == DockerHubRegistry && repository != "" && !strings.Contains(repository, "/") {
+		return "library/" + repository
+	}
+	return repository
+}
@@ -0,0 +1,133 @@
+package docker_registry_test
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/stager/docker_registry"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseDockerRef", func() {
+	parse := func(imageURL string) docker_registry.DockerRef {
+		ref, err := docker_registry.ParseDockerRef(imageURL)
+		Expect(err).NotTo(HaveOccurred())
+		return ref
+	}
+
+	It("parses official images", func() {
+		Expect(parse("busybox")).To(Equal(docker_registry.DockerRef{
+			Registry:   docker_registry.DockerHubRegistry,
+			Repository: "library/busybox",
+			Reference:  "latest",
+		}))
+	})
+
+	It("parses user images with a tag", func() {
+		Expect(parse("cloudfoundry/diego-docker-app:v1")).To(Equal(docker_registry.DockerRef{
+			Registry:   docker_registry.DockerHubRegistry,
+			Repository: "cloudfoundry/diego-docker-app",
+			Reference:  "v1",
+		}))
+	})
+
+	It("parses images explicitly on docker hub", func() {
+		Expect(parse("docker.io/cloudfoundry/diego-docker-app")).To(Equal(docker_registry.DockerRef{
+			Registry:   docker_registry.DockerHubRegistry,
+			Repository: "cloudfoundry/diego-docker-app",
+			Reference:  "latest",
+		}))
+	})
+
+	It("parses images in private registries", func() {
+		Expect(parse("my.registry:5000/team/app:v2")).To(Equal(docker_registry.DockerRef{
+			Registry:   "my.registry:5000",
+			Repository: "team/app",
+			Reference:  "v2",
+		}))
+	})
+
+	It("parses digests", func() {
+		Expect(parse("my.registry/app@sha256:abc")).To(Equal(docker_registry.DockerRef{
+			Registry:   "my.registry",
+			Repository: "app",
+			Reference:  "sha256:abc",
+		}))
+	})
+
+	It("parses docker URLs", func() {
+		Expect(parse("docker://my.registry:5000/team/app#v3")).To(Equal(docker_registry.DockerRef{
+			Registry:   "my.registry:5000",
+			Repository: "team/app",
+			Reference:  "v3",
+		}))
+
+		Expect(parse("docker:///busybox")).To(Equal(docker_registry.DockerRef{
+			Registry:   docker_registry.DockerHubRegistry,
+			Repository: "library/busybox",
+			Reference:  "latest",
+		}))
+	})
+
+	It("rejects references without a repository", func() {
+		_, err := docker_registry.ParseDockerRef("my.registry:5000/:v1")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Digests", func() {
+	digest := "sha256:abababababababababababababababababababababababababababababababab"
+
+	It("tells digests from tags", func() {
+		ref, err := docker_registry.ParseDockerRef("my.registry/app@" + digest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ref.IsDigest()).To(BeTrue())
+
+		ref, err = docker_registry.ParseDockerRef("my.registry:5000/app:v1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ref.IsDigest()).To(BeFalse())
+	})
+
+	It("accepts sha256 digests", func() {
+		Expect(docker_registry.ValidateDigest(digest)).To(Succeed())
+	})
+
+	It("rejects malformed digests", func() {
+		Expect(docker_registry.ValidateDigest("sha256:abc")).To(Equal(docker_registry.ErrInvalidDigest))
+		Expect(docker_registry.ValidateDigest("md5:" + digest[7:])).To(Equal(docker_registry.ErrInvalidDigest))
+		Expect(docker_registry.ValidateDigest(digest[7:])).To(Equal(docker_registry.ErrInvalidDigest))
+	})
+
+	It("pins references to a digest", func() {
+		Expect(docker_registry.PinnedRef("busybox", digest)).To(Equal("busybox@" + digest))
+		Expect(docker_registry.PinnedRef("my.registry:5000/team/app:v2", digest)).To(Equal("my.registry:5000/team/app@" + digest))
+		Expect(docker_registry.PinnedRef("my.registry/app@sha256:old", digest)).To(Equal("my.registry/app@" + digest))
+		Expect(docker_registry.PinnedRef("docker://my.registry/app#v1", digest)).To(Equal("docker://my.registry/app#v1"))
+	})
+})
+
+var _ = Describe("Insecure registries", func() {
+	It("reads registry hosts with or without a scheme", func() {
+		Expect(docker_registry.RegistryHost("my.registry:5000")).To(Equal("my.registry:5000"))
+		Expect(docker_registry.RegistryHost("http://my.registry:5000")).To(Equal("my.registry:5000"))
+		Expect(docker_registry.RegistryHost("https://my.registry/")).To(Equal("my.registry"))
+		Expect(docker_registry.RegistryHost("docker.io")).To(Equal(docker_registry.DockerHubRegistry))
+	})
+
+	It("rejects settings that do not name a registry", func() {
+		for _, registry := range []string{"", "ftp://my.registry", "http://my.registry/team", "my.registry/team", "http://"} {
+			_, err := docker_registry.RegistryHost(registry)
+			Expect(err).To(MatchError(fmt.Sprintf("invalid docker registry '%s'", registry)))
+		}
+	})
+
+	It("tells whether an image is in an insecure registry", func() {
+		ref, err := docker_registry.ParseDockerRef("my.registry:5000/team/app:v1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(docker_registry.InsecureRegistry(ref, []string{"other.registry", "http://my.registry:5000"})).To(BeTrue())
+		Expect(docker_registry.InsecureRegistry(ref, []string{"my.registry"})).To(BeFalse())
+		Expect(docker_registry.InsecureRegistry(ref, nil)).To(BeFalse())
+	})
+})
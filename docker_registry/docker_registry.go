@@ -0,0 +1,438 @@
+package docker_registry
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+
+	manifestV2MediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	manifestV1MediaType = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+
+	contentDigestHeader = "Docker-Content-Digest"
+)
+
+var ErrImageNotFound = errors.New("docker image not found")
+
+// DigestMismatchError is returned when the registry serves a manifest other
+// than the one requested by digest.
+type DigestMismatchError struct {
+	Requested string
+	Served    string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("registry served manifest %s for digest %s", e.Served, e.Requested)
+}
+
+type BadResponseError struct {
+	StatusCode int
+}
+
+func (b *BadResponseError) Error() string {
+	return fmt.Sprintf("Docker registry request failed with %d", b.StatusCode)
+}
+
+// ImageMetadata is the part of an image configuration that matters when
+// running the image as an app.
+type ImageMetadata struct {
+	ExposedPorts []string
+	Entrypoint   []string
+	Cmd          []string
+	Env          []string
+
+	// Digest is the content digest of the image manifest, when the registry
+	// reports it or the image was requested by digest.
+	Digest string
+}
+
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// hash identifies the credentials in cache keys without keeping the password
+// in memory, so that metadata fetched with one password is never served to a
+// request made with another.
+func (c Credentials) hash() string {
+	sum := sha256.Sum256([]byte(c.Username + "\x00" + c.Password))
+	return hex.EncodeToString(sum[:])
+}
+
+// Client fetches image metadata from Docker Registry v2 registries.
+//
+//go:generate counterfeiter -o fakes/fake_client.go . Client
+type Client interface {
+	ImageMetadata(logger lager.Logger, imageURL string, credentials Credentials) (*ImageMetadata, error)
+}
+
+type cacheEntry struct {
+	metadata  *ImageMetadata
+	expiresAt time.Time
+}
+
+type client struct {
+	httpClient         *http.Client
+	insecureRegistries map[string]bool
+	cacheTTL           time.Duration
+	clock              clock.Clock
+
+	cacheLock sync.Mutex
+	cache     map[string]cacheEntry
+}
+
+// NewClient returns a client that caches the metadata of each image for the
+// given TTL. Insecure registries are reached over plain http.
+func NewClient(skipCertVerify bool, insecureRegistries []string, cacheTTL time.Duration, clock clock.Clock) Client {
+	httpClient := &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: skipCertVerify,
+				MinVersion:         tls.VersionTLS10,
+			},
+		},
+	}
+
+	insecure := map[string]bool{}
+	for _, registry := range insecureRegistries {
+		host, err := RegistryHost(registry)
+		if err == nil {
+			insecure[host] = true
+		}
+	}
+
+	return &client{
+		httpClient:         httpClient,
+		insecureRegistries: insecure,
+		cacheTTL:           cacheTTL,
+		clock:              clock,
+		cache:              map[string]cacheEntry{},
+	}
+}
+
+func (c *client) ImageMetadata(logger lager.Logger, imageURL string, credentials Credentials) (*ImageMetadata, error) {
+	logger = logger.Session("image-metadata", lager.Data{"image": imageURL})
+
+	ref, err := ParseDockerRef(imageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := strings.Join([]string{ref.Registry, ref.Repository, ref.Reference, credentials.hash()}, "|")
+	if metadata, ok := c.cached(cacheKey); ok {
+		logger.Debug("cache-hit")
+		return metadata, nil
+	}
+
+	session := &registrySession{
+		client:      c,
+		baseURL:     c.baseURL(ref.Registry),
+		credentials: credentials,
+	}
+
+	metadata, err := session.fetchMetadata(ref)
+	if err != nil {
+		logger.Error("failed-to-fetch", err)
+		return nil, err
+	}
+
+	c.store(cacheKey, metadata)
+	logger.Debug("fetched", lager.Data{"exposed-ports": metadata.ExposedPorts, "digest": metadata.Digest})
+
+	return metadata, nil
+}
+
+func (c *client) baseURL(registry string) string {
+	scheme := "https"
+	if c.insecureRegistries[registry] {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, registry)
+}
+
+func (c *client) cached(key string) (*ImageMetadata, bool) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
+	entry, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+
+	if !c.clock.Now().Before(entry.expiresAt) {
+		delete(c.cache, key)
+		return nil, false
+	}
+
+	return entry.metadata, true
+}
+
+func (c *client) store(key string, metadata *ImageMetadata) {
+	if c.cacheTTL <= 0 {
+		return
+	}
+
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
+	now := c.clock.Now()
+	for k, entry := range c.cache {
+		if !now.Before(entry.expiresAt) {
+			delete(c.cache, k)
+		}
+	}
+
+	c.cache[key] = cacheEntry{metadata: metadata, expiresAt: now.Add(c.cacheTTL)}
+}
+
+// registrySession holds the authorization for the requests needed to fetch
+// the metadata of a single image.
+type registrySession struct {
+	client        *client
+	baseURL       string
+	credentials   Credentials
+	authorization string
+}
+
+type manifest struct {
+	SchemaVersion int `json:"schemaVersion"`
+	Config        struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+type imageConfig struct {
+	Config struct {
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		Entrypoint   []string            `json:"Entrypoint"`
+		Cmd          []string            `json:"Cmd"`
+		Env          []string            `json:"Env"`
+	} `json:"config"`
+}
+
+func (s *registrySession) fetchMetadata(ref DockerRef) (*ImageMetadata, error) {
+	manifestPath := fmt.Sprintf("/v2/%s/manifests/%s", ref.Repository, ref.Reference)
+	manifestJson, header, err := s.get(manifestPath, manifestV2MediaType+", "+manifestV1MediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := header.Get(contentDigestHeader)
+	if ref.IsDigest() {
+		if digest != "" && digest != ref.Reference {
+			return nil, &DigestMismatchError{Requested: ref.Reference, Served: digest}
+		}
+		digest = ref.Reference
+	}
+
+	var m manifest
+	err = json.Unmarshal(manifestJson, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	var configJson []byte
+	switch {
+	case m.SchemaVersion == 2 && m.Config.Digest != "":
+		configJson, _, err = s.get(fmt.Sprintf("/v2/%s/blobs/%s", ref.Repository, m.Config.Digest), "")
+		if err != nil {
+			return nil, err
+		}
+	case len(m.History) > 0:
+		configJson = []byte(m.History[0].V1Compatibility)
+	default:
+		return nil, errors.New("unsupported image manifest")
+	}
+
+	var config imageConfig
+	err = json.Unmarshal(configJson, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := []string{}
+	for port := range config.Config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+
+	return &ImageMetadata{
+		ExposedPorts: ports,
+		Entrypoint:   config.Config.Entrypoint,
+		Cmd:          config.Config.Cmd,
+		Env:          config.Config.Env,
+		Digest:       digest,
+	}, nil
+}
+
+// get requests a path from the registry, authorizing as the registry
+// challenges when it first responds with a 401.
+func (s *registrySession) get(path, accept string) ([]byte, http.Header, error) {
+	response, err := s.do(path, accept)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if response.StatusCode == http.StatusUnauthorized && s.authorization == "" {
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+
+		err = s.authorize(challenge)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		response, err = s.do(path, accept)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		body, err := ioutil.ReadAll(response.Body)
+		return body, response.Header, err
+	case http.StatusNotFound:
+		return nil, nil, ErrImageNotFound
+	default:
+		return nil, nil, &BadResponseError{StatusCode: response.StatusCode}
+	}
+}
+
+func (s *registrySession) do(path, accept string) (*http.Response, error) {
+	request, err := http.NewRequest("GET", s.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	if s.authorization != "" {
+		request.Header.Set("Authorization", s.authorization)
+	}
+
+	return s.client.httpClient.Do(request)
+}
+
+func (s *registrySession) authorize(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if s.credentials.Username == "" {
+			return &BadResponseError{StatusCode: http.StatusUnauthorized}
+		}
+		request, _ := http.NewRequest("GET", s.baseURL, nil)
+		request.SetBasicAuth(s.credentials.Username, s.credentials.Password)
+		s.authorization = request.Header.Get("Authorization")
+		return nil
+	case "bearer":
+		token, err := s.fetchToken(params)
+		if err != nil {
+			return err
+		}
+		s.authorization = "Bearer " + token
+		return nil
+	default:
+		return &BadResponseError{StatusCode: http.StatusUnauthorized}
+	}
+}
+
+func (s *registrySession) fetchToken(params map[string]string) (string, error) {
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm '%s'", params["realm"])
+	}
+
+	query := tokenURL.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		query.Set("scope", params["scope"])
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	request, err := http.NewRequest("GET", tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if s.credentials.Username != "" {
+		request.SetBasicAuth(s.credentials.Username, s.credentials.Password)
+	}
+
+	response, err := s.client.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", &BadResponseError{StatusCode: response.StatusCode}
+	}
+
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&tokenResponse)
+	if err != nil {
+		return "", err
+	}
+
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	return tokenResponse.AccessToken, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+
+	return parts[0], params
+}
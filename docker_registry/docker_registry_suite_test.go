@@ -0,0 +1,13 @@
+package docker_registry_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDockerRegistry(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Docker Registry Suite")
+}
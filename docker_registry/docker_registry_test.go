@@ -0,0 +1,283 @@
+package docker_registry_test
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/docker_registry"
+	"github.com/onsi/gomega/ghttp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		registry    *ghttp.Server
+		fakeClock   *fakeclock.FakeClock
+		client      docker_registry.Client
+		imageURL    string
+		credentials docker_registry.Credentials
+	)
+
+	manifestV2 := `{
+		"schemaVersion": 2,
+		"config": {"digest": "sha256:config-digest"}
+	}`
+
+	imageConfig := `{
+		"config": {
+			"ExposedPorts": {"9090/tcp": {}, "8080/tcp": {}},
+			"Entrypoint": ["/bin/app"],
+			"Cmd": ["serve"],
+			"Env": ["PATH=/bin"]
+		}
+	}`
+
+	expectedMetadata := &docker_registry.ImageMetadata{
+		ExposedPorts: []string{"8080/tcp", "9090/tcp"},
+		Entrypoint:   []string{"/bin/app"},
+		Cmd:          []string{"serve"},
+		Env:          []string{"PATH=/bin"},
+	}
+
+	BeforeEach(func() {
+		registry = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		registryAddress := registry.Addr()
+		client = docker_registry.NewClient(false, []string{registryAddress}, time.Minute, fakeClock)
+		imageURL = fmt.Sprintf("%s/team/app:v1", registryAddress)
+		credentials = docker_registry.Credentials{}
+	})
+
+	AfterEach(func() {
+		registry.Close()
+	})
+
+	fetch := func() (*docker_registry.ImageMetadata, error) {
+		return client.ImageMetadata(lagertest.NewTestLogger("test"), imageURL, credentials)
+	}
+
+	Context("when the registry serves a v2 manifest", func() {
+		BeforeEach(func() {
+			registry.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/team/app/manifests/v1"),
+					ghttp.VerifyHeaderKV("Accept", "application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.v1+prettyjws"),
+					ghttp.RespondWith(http.StatusOK, manifestV2),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/team/app/blobs/sha256:config-digest"),
+					ghttp.RespondWith(http.StatusOK, imageConfig),
+				),
+			)
+		})
+
+		It("returns the metadata from the image config", func() {
+			metadata, err := fetch()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata).To(Equal(expectedMetadata))
+		})
+
+		It("caches the metadata", func() {
+			_, err := fetch()
+			Expect(err).NotTo(HaveOccurred())
+
+			metadata, err := fetch()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata).To(Equal(expectedMetadata))
+			Expect(registry.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		Context("when the cached metadata expires", func() {
+			BeforeEach(func() {
+				registry.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, manifestV2),
+					ghttp.RespondWith(http.StatusOK, imageConfig),
+				)
+			})
+
+			It("fetches it again", func() {
+				_, err := fetch()
+				Expect(err).NotTo(HaveOccurred())
+
+				fakeClock.Increment(time.Minute)
+
+				_, err = fetch()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(registry.ReceivedRequests()).To(HaveLen(4))
+			})
+		})
+
+		Context("when the image is requested with another password", func() {
+			BeforeEach(func() {
+				registry.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, manifestV2),
+					ghttp.RespondWith(http.StatusOK, imageConfig),
+				)
+			})
+
+			It("does not serve the cached metadata", func() {
+				credentials = docker_registry.Credentials{Username: "user", Password: "right"}
+				_, err := fetch()
+				Expect(err).NotTo(HaveOccurred())
+
+				credentials.Password = "wrong"
+				_, err = fetch()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(registry.ReceivedRequests()).To(HaveLen(4))
+			})
+		})
+	})
+
+	Context("when the registry reports the digest of the manifest", func() {
+		BeforeEach(func() {
+			registry.AppendHandlers(
+				ghttp.RespondWith(http.StatusOK, manifestV2, http.Header{"Docker-Content-Digest": {"sha256:abababababababababababababababababababababababababababababababab"}}),
+				ghttp.RespondWith(http.StatusOK, imageConfig),
+			)
+		})
+
+		It("returns the digest with the metadata", func() {
+			metadata, err := fetch()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.Digest).To(Equal("sha256:abababababababababababababababababababababababababababababababab"))
+		})
+	})
+
+	Context("when the image is requested by digest", func() {
+		BeforeEach(func() {
+			imageURL = fmt.Sprintf("%s/team/app@sha256:abababababababababababababababababababababababababababababababab", registry.Addr())
+		})
+
+		Context("when the registry does not report the digest", func() {
+			BeforeEach(func() {
+				registry.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/v2/team/app/manifests/sha256:abababababababababababababababababababababababababababababababab"),
+						ghttp.RespondWith(http.StatusOK, manifestV2),
+					),
+					ghttp.RespondWith(http.StatusOK, imageConfig),
+				)
+			})
+
+			It("returns the requested digest", func() {
+				metadata, err := fetch()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(metadata.Digest).To(Equal("sha256:abababababababababababababababababababababababababababababababab"))
+			})
+		})
+
+		Context("when the registry serves a manifest with another digest", func() {
+			BeforeEach(func() {
+				registry.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, manifestV2, http.Header{"Docker-Content-Digest": {"sha256:other"}}),
+				)
+			})
+
+			It("returns a DigestMismatchError", func() {
+				_, err := fetch()
+				Expect(err).To(Equal(&docker_registry.DigestMismatchError{Requested: "sha256:abababababababababababababababababababababababababababababababab", Served: "sha256:other"}))
+			})
+		})
+	})
+
+	Context("when the registry serves a v1 manifest", func() {
+		BeforeEach(func() {
+			registry.AppendHandlers(
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+					"schemaVersion": 1,
+					"history": []map[string]string{
+						{"v1Compatibility": imageConfig},
+					},
+				}),
+			)
+		})
+
+		It("returns the metadata from the latest history entry", func() {
+			metadata, err := fetch()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata).To(Equal(expectedMetadata))
+		})
+	})
+
+	Context("when the image does not exist", func() {
+		BeforeEach(func() {
+			registry.AppendHandlers(
+				ghttp.RespondWith(http.StatusNotFound, `{"errors":[{"code":"MANIFEST_UNKNOWN"}]}`),
+			)
+		})
+
+		It("returns ErrImageNotFound", func() {
+			_, err := fetch()
+			Expect(err).To(Equal(docker_registry.ErrImageNotFound))
+		})
+	})
+
+	Context("when the insecure registry is given with a scheme", func() {
+		BeforeEach(func() {
+			client = docker_registry.NewClient(false, []string{"http://" + registry.Addr()}, time.Minute, fakeClock)
+			registry.AppendHandlers(
+				ghttp.RespondWith(http.StatusNotFound, `{"errors":[{"code":"MANIFEST_UNKNOWN"}]}`),
+			)
+		})
+
+		It("reaches the registry over plain http", func() {
+			_, err := fetch()
+			Expect(err).To(Equal(docker_registry.ErrImageNotFound))
+			Expect(registry.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when the registry requires a token", func() {
+		BeforeEach(func() {
+			credentials = docker_registry.Credentials{Username: "user", Password: "pass"}
+			challenge := fmt.Sprintf(`Bearer realm="%s/token",service="the-registry",scope="repository:team/app:pull"`, registry.URL())
+
+			registry.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/team/app/manifests/v1"),
+					ghttp.RespondWith(http.StatusUnauthorized, "", http.Header{"WWW-Authenticate": {challenge}}),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/token", "scope=repository%3Ateam%2Fapp%3Apull&service=the-registry"),
+					ghttp.VerifyBasicAuth("user", "pass"),
+					ghttp.RespondWith(http.StatusOK, `{"token":"the-token"}`),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/team/app/manifests/v1"),
+					ghttp.VerifyHeaderKV("Authorization", "Bearer the-token"),
+					ghttp.RespondWith(http.StatusOK, manifestV2),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v2/team/app/blobs/sha256:config-digest"),
+					ghttp.VerifyHeaderKV("Authorization", "Bearer the-token"),
+					ghttp.RespondWith(http.StatusOK, imageConfig),
+				),
+			)
+		})
+
+		It("authorizes with a token for the image", func() {
+			metadata, err := fetch()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata).To(Equal(expectedMetadata))
+		})
+	})
+
+	Context("when the registry fails", func() {
+		BeforeEach(func() {
+			registry.AppendHandlers(
+				ghttp.RespondWith(http.StatusInternalServerError, ""),
+			)
+		})
+
+		It("returns an error with the status code", func() {
+			_, err := fetch()
+			Expect(err).To(Equal(&docker_registry.BadResponseError{StatusCode: http.StatusInternalServerError}))
+		})
+	})
+})
@@ -0,0 +1,60 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/docker_registry"
+)
+
+type FakeClient struct {
+	ImageMetadataStub        func(logger lager.Logger, imageURL string, credentials docker_registry.Credentials) (*docker_registry.ImageMetadata, error)
+	imageMetadataMutex       sync.RWMutex
+	imageMetadataArgsForCall []struct {
+		logger      lager.Logger
+		imageURL    string
+		credentials docker_registry.Credentials
+	}
+	imageMetadataReturns struct {
+		result1 *docker_registry.ImageMetadata
+		result2 error
+	}
+}
+
+func (fake *FakeClient) ImageMetadata(logger lager.Logger, imageURL string, credentials docker_registry.Credentials) (*docker_registry.ImageMetadata, error) {
+	fake.imageMetadataMutex.Lock()
+	fake.imageMetadataArgsForCall = append(fake.imageMetadataArgsForCall, struct {
+		logger      lager.Logger
+		imageURL    string
+		credentials docker_registry.Credentials
+	}{logger, imageURL, credentials})
+	fake.imageMetadataMutex.Unlock()
+	if fake.ImageMetadataStub != nil {
+		return fake.ImageMetadataStub(logger, imageURL, credentials)
+	} else {
+		return fake.imageMetadataReturns.result1, fake.imageMetadataReturns.result2
+	}
+}
+
+func (fake *FakeClient) ImageMetadataCallCount() int {
+	fake.imageMetadataMutex.RLock()
+	defer fake.imageMetadataMutex.RUnlock()
+	return len(fake.imageMetadataArgsForCall)
+}
+
+func (fake *FakeClient) ImageMetadataArgsForCall(i int) (lager.Logger, string, docker_registry.Credentials) {
+	fake.imageMetadataMutex.RLock()
+	defer fake.imageMetadataMutex.RUnlock()
+	return fake.imageMetadataArgsForCall[i].logger, fake.imageMetadataArgsForCall[i].imageURL, fake.imageMetadataArgsForCall[i].credentials
+}
+
+func (fake *FakeClient) ImageMetadataReturns(result1 *docker_registry.ImageMetadata, result2 error) {
+	fake.ImageMetadataStub = nil
+	fake.imageMetadataReturns = struct {
+		result1 *docker_registry.ImageMetadata
+		result2 error
+	}{result1, result2}
+}
+
+var _ docker_registry.Client = new(FakeClient)
@@ -0,0 +1,109 @@
+package drain
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+// Drainer tracks the requests being served by the handlers it wraps. When
+// signalled, it waits for the in-flight requests to finish, up to a deadline,
+// before exiting, so that staging completions that are already being
+// delivered to CC are not lost when the stager is stopped.
+type Drainer interface {
+	ifrit.Runner
+	Wrap(handler http.Handler) http.Handler
+}
+
+type drainer struct {
+	logger  lager.Logger
+	timeout time.Duration
+	clock   clock.Clock
+
+	lock     sync.Mutex
+	inFlight int
+	draining bool
+	drained  chan struct{}
+}
+
+func NewDrainer(logger lager.Logger, timeout time.Duration, clock clock.Clock) Drainer {
+	return &drainer{
+		logger:  logger.Session("drainer"),
+		timeout: timeout,
+		clock:   clock,
+		drained: make(chan struct{}),
+	}
+}
+
+func (d *drainer) Wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d.start()
+		defer d.finish()
+
+		handler.ServeHTTP(w, req)
+	})
+}
+
+func (d *drainer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	<-signals
+
+	d.lock.Lock()
+	inFlight := d.inFlight
+	d.draining = true
+	d.closeIfDrained()
+	d.lock.Unlock()
+
+	logger := d.logger.Session("draining", lager.Data{"in-flight": inFlight})
+	logger.Info("started")
+
+	timer := d.clock.NewTimer(d.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-d.drained:
+		logger.Info("finished")
+	case <-timer.C():
+		d.lock.Lock()
+		inFlight = d.inFlight
+		d.lock.Unlock()
+
+		logger.Info("timed-out", lager.Data{"abandoned": inFlight})
+	}
+
+	return nil
+}
+
+func (d *drainer) start() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.inFlight++
+}
+
+func (d *drainer) finish() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.inFlight--
+	d.closeIfDrained()
+}
+
+// closeIfDrained must be called with the lock held.
+func (d *drainer) closeIfDrained() {
+	if !d.draining || d.inFlight > 0 {
+		return
+	}
+
+	select {
+	case <-d.drained:
+	default:
+		close(d.drained)
+	}
+}
@@ -0,0 +1,99 @@
+package drain_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/drain"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Drainer", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		drainer   drain.Drainer
+		handler   http.Handler
+		process   ifrit.Process
+
+		requestReceived chan struct{}
+		releaseRequest  chan struct{}
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		drainer = drain.NewDrainer(lagertest.NewTestLogger("test"), 10*time.Second, fakeClock)
+
+		requestReceived = make(chan struct{})
+		releaseRequest = make(chan struct{})
+		handler = drainer.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			close(requestReceived)
+			<-releaseRequest
+			w.WriteHeader(http.StatusTeapot)
+		}))
+
+		process = ifrit.Invoke(drainer)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Kill)
+		fakeClock.Increment(time.Minute)
+	})
+
+	It("serves requests with the wrapped handler", func() {
+		close(releaseRequest)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, &http.Request{})
+		Expect(recorder.Code).To(Equal(http.StatusTeapot))
+	})
+
+	Context("when signalled with no requests in flight", func() {
+		It("exits immediately", func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+		})
+	})
+
+	Context("when signalled with requests in flight", func() {
+		var served chan struct{}
+
+		BeforeEach(func() {
+			served = make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				handler.ServeHTTP(httptest.NewRecorder(), &http.Request{})
+				close(served)
+			}()
+
+			Eventually(requestReceived).Should(BeClosed())
+			process.Signal(os.Interrupt)
+		})
+
+		It("waits for the requests to finish before exiting", func() {
+			Consistently(process.Wait()).ShouldNot(Receive())
+
+			close(releaseRequest)
+			Eventually(served).Should(BeClosed())
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+		})
+
+		It("gives up waiting after the timeout", func() {
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+
+			fakeClock.Increment(9 * time.Second)
+			Consistently(process.Wait()).ShouldNot(Receive())
+
+			fakeClock.Increment(time.Second)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+
+			close(releaseRequest)
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package enrichment
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Enricher adds fields to a successful staging result before it is delivered
+// to the Cloud Controller, e.g. to attach provenance data such as a build id
+// or an SBOM reference.
+//
+//go:generate counterfeiter -o fakes/fake_enricher.go . Enricher
+type Enricher interface {
+	Name() string
+	Enrich(stagingGuid string, fields map[string]interface{}) error
+}
+
+// Enrich runs each enricher in order over the staging result. Enrichers may
+// only add fields; fields already present in the result are left untouched.
+func Enrich(logger lager.Logger, enrichers []Enricher, stagingGuid string, result *json.RawMessage) (*json.RawMessage, error) {
+	if len(enrichers) == 0 || result == nil {
+		return result, nil
+	}
+
+	var resultFields map[string]json.RawMessage
+	err := json.Unmarshal(*result, &resultFields)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, enricher := range enrichers {
+		fields := map[string]interface{}{}
+		err := enricher.Enrich(stagingGuid, fields)
+		if err != nil {
+			return nil, fmt.Errorf("enricher %s failed: %s", enricher.Name(), err)
+		}
+
+		for key, value := range fields {
+			if _, exists := resultFields[key]; exists {
+				logger.Info("skipping-existing-result-field", lager.Data{"enricher": enricher.Name(), "field": key})
+				continue
+			}
+
+			valueJson, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			resultFields[key] = valueJson
+		}
+	}
+
+	enrichedJson, err := json.Marshal(resultFields)
+	if err != nil {
+		return nil, err
+	}
+
+	enriched := json.RawMessage(enrichedJson)
+	return &enriched, nil
+}
+
+type staticFieldsEnricher struct {
+	fields map[string]string
+}
+
+// NewStaticFieldsEnricher returns an enricher that adds the same operator
+// configured fields to every staging result.
+func NewStaticFieldsEnricher(fields map[string]string) Enricher {
+	return &staticFieldsEnricher{fields: fields}
+}
+
+func (e *staticFieldsEnricher) Name() string {
+	return "static-fields"
+}
+
+func (e *staticFieldsEnricher) Enrich(stagingGuid string, fields map[string]interface{}) error {
+	for key, value := range e.fields {
+		fields[key] = value
+	}
+	return nil
+}
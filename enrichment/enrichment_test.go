@@ -0,0 +1,84 @@
+package enrichment_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/enrichment"
+	"code.cloudfoundry.org/stager/enrichment/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Enrich", func() {
+	var (
+		enrichers []enrichment.Enricher
+		result    *json.RawMessage
+		enriched  *json.RawMessage
+		err       error
+	)
+
+	BeforeEach(func() {
+		raw := json.RawMessage(`{"detected_buildpack":"ruby","build_id":"from-lifecycle"}`)
+		result = &raw
+		enrichers = nil
+	})
+
+	JustBeforeEach(func() {
+		enriched, err = enrichment.Enrich(lagertest.NewTestLogger("test"), enrichers, "staging-guid", result)
+	})
+
+	Context("when there are no enrichers", func() {
+		It("returns the result unchanged", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(enriched).To(Equal(result))
+		})
+	})
+
+	Context("when there are enrichers", func() {
+		BeforeEach(func() {
+			enrichers = []enrichment.Enricher{
+				enrichment.NewStaticFieldsEnricher(map[string]string{
+					"sbom_ref": "s3://sboms/app",
+					"build_id": "from-enricher",
+				}),
+			}
+		})
+
+		It("adds the enriched fields without overwriting existing ones", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(*enriched)).To(MatchJSON(`{
+				"detected_buildpack": "ruby",
+				"build_id": "from-lifecycle",
+				"sbom_ref": "s3://sboms/app"
+			}`))
+		})
+	})
+
+	Context("when an enricher fails", func() {
+		BeforeEach(func() {
+			fakeEnricher := &fakes.FakeEnricher{}
+			fakeEnricher.NameReturns("fake")
+			fakeEnricher.EnrichReturns(errors.New("boom"))
+			enrichers = []enrichment.Enricher{fakeEnricher}
+		})
+
+		It("returns an error naming the enricher", func() {
+			Expect(err).To(MatchError("enricher fake failed: boom"))
+		})
+	})
+
+	Context("when the result is nil", func() {
+		BeforeEach(func() {
+			result = nil
+			enrichers = []enrichment.Enricher{enrichment.NewStaticFieldsEnricher(map[string]string{"a": "b"})}
+		})
+
+		It("returns nil", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(enriched).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,85 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/enrichment"
+)
+
+type FakeEnricher struct {
+	NameStub        func() string
+	nameMutex       sync.RWMutex
+	nameArgsForCall []struct{}
+	nameReturns     struct {
+		result1 string
+	}
+	EnrichStub        func(stagingGuid string, fields map[string]interface{}) error
+	enrichMutex       sync.RWMutex
+	enrichArgsForCall []struct {
+		stagingGuid string
+		fields      map[string]interface{}
+	}
+	enrichReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeEnricher) Name() string {
+	fake.nameMutex.Lock()
+	fake.nameArgsForCall = append(fake.nameArgsForCall, struct{}{})
+	fake.nameMutex.Unlock()
+	if fake.NameStub != nil {
+		return fake.NameStub()
+	} else {
+		return fake.nameReturns.result1
+	}
+}
+
+func (fake *FakeEnricher) NameCallCount() int {
+	fake.nameMutex.RLock()
+	defer fake.nameMutex.RUnlock()
+	return len(fake.nameArgsForCall)
+}
+
+func (fake *FakeEnricher) NameReturns(result1 string) {
+	fake.NameStub = nil
+	fake.nameReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeEnricher) Enrich(stagingGuid string, fields map[string]interface{}) error {
+	fake.enrichMutex.Lock()
+	fake.enrichArgsForCall = append(fake.enrichArgsForCall, struct {
+		stagingGuid string
+		fields      map[string]interface{}
+	}{stagingGuid, fields})
+	fake.enrichMutex.Unlock()
+	if fake.EnrichStub != nil {
+		return fake.EnrichStub(stagingGuid, fields)
+	} else {
+		return fake.enrichReturns.result1
+	}
+}
+
+func (fake *FakeEnricher) EnrichCallCount() int {
+	fake.enrichMutex.RLock()
+	defer fake.enrichMutex.RUnlock()
+	return len(fake.enrichArgsForCall)
+}
+
+func (fake *FakeEnricher) EnrichArgsForCall(i int) (string, map[string]interface{}) {
+	fake.enrichMutex.RLock()
+	defer fake.enrichMutex.RUnlock()
+	return fake.enrichArgsForCall[i].stagingGuid, fake.enrichArgsForCall[i].fields
+}
+
+func (fake *FakeEnricher) EnrichReturns(result1 error) {
+	fake.EnrichStub = nil
+	fake.enrichReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ enrichment.Enricher = new(FakeEnricher)
@@ -0,0 +1,35 @@
+package fault_injection
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+type bbsClient struct {
+	bbs.Client
+	injector Injector
+	clock    clock.Clock
+}
+
+// NewBBSClient delays the ResolvingTask calls made by the stager by the
+// injected ResolveDelay.
+func NewBBSClient(client bbs.Client, injector Injector, clock clock.Clock) bbs.Client {
+	return &bbsClient{
+		Client:   client,
+		injector: injector,
+		clock:    clock,
+	}
+}
+
+func (c *bbsClient) ResolvingTask(logger lager.Logger, taskGuid string) error {
+	delay := time.Duration(c.injector.Faults().ResolveDelay)
+	if delay > 0 {
+		logger.Info("injecting-resolve-delay", lager.Data{"task-guid": taskGuid, "delay": delay.String()})
+		c.clock.Sleep(delay)
+	}
+
+	return c.Client.ResolvingTask(logger, taskGuid)
+}
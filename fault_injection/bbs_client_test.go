@@ -0,0 +1,65 @@
+package fault_injection_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/config"
+	"code.cloudfoundry.org/stager/fault_injection"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BBSClient", func() {
+	var (
+		fakeBBSClient *fake_bbs.FakeClient
+		fakeClock     *fakeclock.FakeClock
+		injector      fault_injection.Injector
+		logger        *lagertest.TestLogger
+		client        bbs.Client
+	)
+
+	BeforeEach(func() {
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		injector = fault_injection.NewInjector()
+		logger = lagertest.NewTestLogger("test")
+		client = fault_injection.NewBBSClient(fakeBBSClient, injector, fakeClock)
+	})
+
+	It("resolves tasks right away without a resolve delay", func() {
+		fakeBBSClient.ResolvingTaskReturns(errors.New("boom"))
+
+		Expect(client.ResolvingTask(logger, "task-guid")).To(MatchError("boom"))
+		Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(1))
+		_, taskGuid := fakeBBSClient.ResolvingTaskArgsForCall(0)
+		Expect(taskGuid).To(Equal("task-guid"))
+	})
+
+	It("delays resolving tasks by the resolve delay", func() {
+		injector.SetFaults(fault_injection.Faults{ResolveDelay: config.Duration(5 * time.Second)})
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- client.ResolvingTask(logger, "task-guid")
+		}()
+
+		fakeClock.WaitForWatcherAndIncrement(4 * time.Second)
+		Consistently(fakeBBSClient.ResolvingTaskCallCount).Should(Equal(0))
+
+		fakeClock.Increment(time.Second)
+		Eventually(errCh).Should(Receive(BeNil()))
+		Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(1))
+	})
+
+	It("passes other calls through", func() {
+		_, err := client.TaskByGuid(logger, "task-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeBBSClient.TaskByGuidCallCount()).To(Equal(1))
+	})
+})
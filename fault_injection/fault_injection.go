@@ -0,0 +1,70 @@
+package fault_injection
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/config"
+)
+
+// Faults are the failures injected into the stager. Zero values inject
+// nothing.
+type Faults struct {
+	// DropNthPublish drops every Nth NATS publish, counted from when the
+	// faults were set.
+	DropNthPublish int `json:"drop_nth_publish"`
+
+	// ResolveDelay delays every ResolvingTask call to the BBS.
+	ResolveDelay config.Duration `json:"resolve_delay"`
+
+	// CorruptResults truncates the result of every completed staging task
+	// before the completion callback handles it.
+	CorruptResults bool `json:"corrupt_results"`
+}
+
+// Injector holds the faults currently injected, so that they can be changed
+// while the stager runs.
+type Injector interface {
+	Faults() Faults
+	SetFaults(faults Faults)
+
+	// DropPublish counts a NATS publish, returning true if it is to be
+	// dropped.
+	DropPublish() bool
+}
+
+type injector struct {
+	lock      sync.Mutex
+	faults    Faults
+	publishes int
+}
+
+func NewInjector() Injector {
+	return &injector{}
+}
+
+func (i *injector) Faults() Faults {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.faults
+}
+
+func (i *injector) SetFaults(faults Faults) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.faults = faults
+	i.publishes = 0
+}
+
+func (i *injector) DropPublish() bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.faults.DropNthPublish <= 0 {
+		return false
+	}
+
+	i.publishes++
+	return i.publishes%i.faults.DropNthPublish == 0
+}
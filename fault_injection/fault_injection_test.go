@@ -0,0 +1,54 @@
+package fault_injection_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/stager/config"
+	"code.cloudfoundry.org/stager/fault_injection"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Injector", func() {
+	var injector fault_injection.Injector
+
+	BeforeEach(func() {
+		injector = fault_injection.NewInjector()
+	})
+
+	It("injects no faults initially", func() {
+		Expect(injector.Faults()).To(Equal(fault_injection.Faults{}))
+		Expect(injector.DropPublish()).To(BeFalse())
+	})
+
+	It("returns the faults set", func() {
+		faults := fault_injection.Faults{
+			DropNthPublish: 2,
+			ResolveDelay:   config.Duration(time.Second),
+			CorruptResults: true,
+		}
+		injector.SetFaults(faults)
+
+		Expect(injector.Faults()).To(Equal(faults))
+	})
+
+	It("drops every Nth publish", func() {
+		injector.SetFaults(fault_injection.Faults{DropNthPublish: 3})
+
+		dropped := []bool{}
+		for i := 0; i < 6; i++ {
+			dropped = append(dropped, injector.DropPublish())
+		}
+		Expect(dropped).To(Equal([]bool{false, false, true, false, false, true}))
+	})
+
+	It("counts publishes from when the faults were set", func() {
+		injector.SetFaults(fault_injection.Faults{DropNthPublish: 2})
+		Expect(injector.DropPublish()).To(BeFalse())
+
+		injector.SetFaults(fault_injection.Faults{DropNthPublish: 2})
+		Expect(injector.DropPublish()).To(BeFalse())
+		Expect(injector.DropPublish()).To(BeTrue())
+	})
+})
@@ -0,0 +1,133 @@
+package fault_injection
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager"
+	"github.com/tedsuo/rata"
+)
+
+// Path is where the faults are read and set.
+const Path = "/faults"
+
+type faultsHandler struct {
+	logger   lager.Logger
+	injector Injector
+}
+
+// NewHandler serves the injected faults at Path: GET responds with them and
+// PUT replaces them with those in the request body.
+func NewHandler(logger lager.Logger, injector Injector) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(Path, &faultsHandler{
+		logger:   logger.Session("fault-injection"),
+		injector: injector,
+	})
+	return mux
+}
+
+func (h *faultsHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		h.writeFaults(resp)
+	case "PUT":
+		h.setFaults(resp, req)
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *faultsHandler) setFaults(resp http.ResponseWriter, req *http.Request) {
+	logger := h.logger.Session("set-faults")
+
+	var faults Faults
+	err := json.NewDecoder(req.Body).Decode(&faults)
+	if err != nil {
+		logger.Error("parsing-faults-failed", err)
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if faults.DropNthPublish < 0 || faults.ResolveDelay < 0 {
+		logger.Info("invalid-faults", lager.Data{"faults": faults})
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.injector.SetFaults(faults)
+	logger.Info("faults-set", lager.Data{"faults": faults})
+
+	h.writeFaults(resp)
+}
+
+func (h *faultsHandler) writeFaults(resp http.ResponseWriter) {
+	faultsJson, err := json.Marshal(h.injector.Faults())
+	if err != nil {
+		h.logger.Error("marshalling-faults-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(faultsJson)
+}
+
+type corruptingHandler struct {
+	logger   lager.Logger
+	handler  http.Handler
+	injector Injector
+}
+
+// NewStagerHandler passes every request on to the stager's handler,
+// corrupting the results of completion callbacks while CorruptResults is
+// injected.
+func NewStagerHandler(logger lager.Logger, handler http.Handler, injector Injector) http.Handler {
+	corrupting := &corruptingHandler{
+		logger:   logger.Session("fault-injection"),
+		handler:  handler,
+		injector: injector,
+	}
+
+	actions := rata.Handlers{}
+	for _, route := range stager.Routes {
+		actions[route.Name] = handler
+	}
+	actions[stager.StagingCompletedRoute] = corrupting
+
+	router, err := rata.NewRouter(stager.Routes, actions)
+	if err != nil {
+		panic("unable to create router: " + err.Error())
+	}
+
+	return router
+}
+
+func (h *corruptingHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if !h.injector.Faults().CorruptResults {
+		h.handler.ServeHTTP(resp, req)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		h.logger.Error("reading-callback-failed", err)
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	task := &models.TaskCallbackResponse{}
+	if json.Unmarshal(body, task) == nil && task.Result != "" {
+		h.logger.Info("corrupting-result", lager.Data{"task-guid": task.TaskGuid})
+		task.Result = task.Result[:len(task.Result)/2]
+		body, _ = json.Marshal(task)
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	h.handler.ServeHTTP(resp, req)
+}
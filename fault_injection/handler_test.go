@@ -0,0 +1,152 @@
+package fault_injection_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/config"
+	"code.cloudfoundry.org/stager/fault_injection"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		injector fault_injection.Injector
+		handler  http.Handler
+		resp     *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		injector = fault_injection.NewInjector()
+		handler = fault_injection.NewHandler(lagertest.NewTestLogger("test"), injector)
+		resp = httptest.NewRecorder()
+	})
+
+	It("responds with the injected faults", func() {
+		injector.SetFaults(fault_injection.Faults{DropNthPublish: 3})
+
+		req, err := http.NewRequest("GET", fault_injection.Path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		handler.ServeHTTP(resp, req)
+
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(resp.Body.String()).To(MatchJSON(`{"drop_nth_publish":3,"resolve_delay":"0s","corrupt_results":false}`))
+	})
+
+	It("sets the faults", func() {
+		req, err := http.NewRequest("PUT", fault_injection.Path, strings.NewReader(`{"resolve_delay":"2s","corrupt_results":true}`))
+		Expect(err).NotTo(HaveOccurred())
+		handler.ServeHTTP(resp, req)
+
+		Expect(resp.Code).To(Equal(http.StatusOK))
+		Expect(injector.Faults()).To(Equal(fault_injection.Faults{
+			ResolveDelay:   config.Duration(2 * time.Second),
+			CorruptResults: true,
+		}))
+	})
+
+	It("rejects invalid faults", func() {
+		req, err := http.NewRequest("PUT", fault_injection.Path, strings.NewReader(`{"drop_nth_publish":-1}`))
+		Expect(err).NotTo(HaveOccurred())
+		handler.ServeHTTP(resp, req)
+
+		Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		Expect(injector.Faults()).To(Equal(fault_injection.Faults{}))
+	})
+
+	It("rejects other methods", func() {
+		req, err := http.NewRequest("DELETE", fault_injection.Path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		handler.ServeHTTP(resp, req)
+
+		Expect(resp.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
+
+var _ = Describe("StagerHandler", func() {
+	var (
+		injector     fault_injection.Injector
+		handler      http.Handler
+		received     []*http.Request
+		receivedBody []string
+		callback     models.TaskCallbackResponse
+	)
+
+	BeforeEach(func() {
+		injector = fault_injection.NewInjector()
+		received = nil
+		receivedBody = nil
+		stagerHandler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			body, err := ioutil.ReadAll(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+			received = append(received, req)
+			receivedBody = append(receivedBody, string(body))
+		})
+		handler = fault_injection.NewStagerHandler(lagertest.NewTestLogger("test"), stagerHandler, injector)
+		callback = models.TaskCallbackResponse{TaskGuid: "the-guid", Result: `{"detected_buildpack":"ruby"}`}
+	})
+
+	postCallback := func() {
+		payload, err := json.Marshal(callback)
+		Expect(err).NotTo(HaveOccurred())
+
+		req, err := http.NewRequest("POST", "/v1/staging/the-guid/completed", strings.NewReader(string(payload)))
+		Expect(err).NotTo(HaveOccurred())
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	It("passes completion callbacks through untouched", func() {
+		postCallback()
+
+		Expect(receivedBody).To(HaveLen(1))
+		var passed models.TaskCallbackResponse
+		Expect(json.Unmarshal([]byte(receivedBody[0]), &passed)).To(Succeed())
+		Expect(passed).To(Equal(callback))
+	})
+
+	It("passes other requests through", func() {
+		injector.SetFaults(fault_injection.Faults{CorruptResults: true})
+
+		req, err := http.NewRequest("GET", "/v1/staging_tasks", nil)
+		Expect(err).NotTo(HaveOccurred())
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		Expect(received).To(HaveLen(1))
+		Expect(received[0].URL.Path).To(Equal("/v1/staging_tasks"))
+	})
+
+	Context("when results are corrupted", func() {
+		BeforeEach(func() {
+			injector.SetFaults(fault_injection.Faults{CorruptResults: true})
+		})
+
+		It("truncates the result of completion callbacks", func() {
+			postCallback()
+
+			Expect(receivedBody).To(HaveLen(1))
+			var corrupted models.TaskCallbackResponse
+			Expect(json.Unmarshal([]byte(receivedBody[0]), &corrupted)).To(Succeed())
+			Expect(corrupted.TaskGuid).To(Equal("the-guid"))
+			Expect(corrupted.Result).To(Equal(`{"detected_bui`))
+			Expect(received[0].ContentLength).To(Equal(int64(len(receivedBody[0]))))
+		})
+
+		It("leaves callbacks without a result alone", func() {
+			callback.Result = ""
+			callback.Failed = true
+			postCallback()
+
+			var passed models.TaskCallbackResponse
+			Expect(json.Unmarshal([]byte(receivedBody[0]), &passed)).To(Succeed())
+			Expect(passed.Failed).To(BeTrue())
+		})
+	})
+})
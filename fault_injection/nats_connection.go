@@ -0,0 +1,38 @@
+package fault_injection
+
+import (
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/nats_connection"
+)
+
+type natsConnection struct {
+	nats_connection.Connection
+	logger   lager.Logger
+	injector Injector
+}
+
+// NewDialer dials NATS connections that silently drop the publishes the
+// injector picks, as if they were lost on the way to NATS.
+func NewDialer(logger lager.Logger, dial nats_connection.Dialer, injector Injector) nats_connection.Dialer {
+	return func(credentials nats_connection.Credentials) (nats_connection.Connection, error) {
+		conn, err := dial(credentials)
+		if err != nil {
+			return nil, err
+		}
+
+		return &natsConnection{
+			Connection: conn,
+			logger:     logger.Session("fault-injection"),
+			injector:   injector,
+		}, nil
+	}
+}
+
+func (c *natsConnection) Publish(subject string, data []byte) error {
+	if c.injector.DropPublish() {
+		c.logger.Info("dropping-publish", lager.Data{"subject": subject})
+		return nil
+	}
+
+	return c.Connection.Publish(subject, data)
+}
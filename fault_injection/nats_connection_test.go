@@ -0,0 +1,61 @@
+package fault_injection_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/fault_injection"
+	"code.cloudfoundry.org/stager/nats_connection"
+	"code.cloudfoundry.org/stager/nats_connection/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dialer", func() {
+	var (
+		fakeConnection *fakes.FakeConnection
+		injector       fault_injection.Injector
+		dialErr        error
+		dial           nats_connection.Dialer
+	)
+
+	BeforeEach(func() {
+		fakeConnection = &fakes.FakeConnection{}
+		injector = fault_injection.NewInjector()
+		dialErr = nil
+		dial = fault_injection.NewDialer(lagertest.NewTestLogger("test"), func(nats_connection.Credentials) (nats_connection.Connection, error) {
+			return fakeConnection, dialErr
+		}, injector)
+	})
+
+	It("drops the publishes picked by the injector", func() {
+		injector.SetFaults(fault_injection.Faults{DropNthPublish: 2})
+
+		conn, err := dial(nats_connection.Credentials{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(conn.Publish("subject-1", []byte("one"))).To(Succeed())
+		Expect(conn.Publish("subject-2", []byte("two"))).To(Succeed())
+		Expect(conn.Publish("subject-3", []byte("three"))).To(Succeed())
+
+		Expect(fakeConnection.PublishCallCount()).To(Equal(2))
+		subject, _ := fakeConnection.PublishArgsForCall(1)
+		Expect(subject).To(Equal("subject-3"))
+	})
+
+	It("passes the connection through otherwise", func() {
+		fakeConnection.IsConnectedReturns(true)
+
+		conn, err := dial(nats_connection.Credentials{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.IsConnected()).To(BeTrue())
+	})
+
+	It("returns dial errors", func() {
+		dialErr = errors.New("boom")
+
+		_, err := dial(nats_connection.Credentials{})
+		Expect(err).To(MatchError("boom"))
+	})
+})
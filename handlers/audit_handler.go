@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/staging_audit"
+)
+
+type AuditHandler interface {
+	Audit(resp http.ResponseWriter, req *http.Request)
+}
+
+type auditHandler struct {
+	logger   lager.Logger
+	auditLog staging_audit.Log
+}
+
+func NewAuditHandler(logger lager.Logger, auditLog staging_audit.Log) AuditHandler {
+	return &auditHandler{
+		logger:   logger.Session("audit-handler"),
+		auditLog: auditLog,
+	}
+}
+
+// Audit responds with the most recent staging requests, optionally only
+// those of the app_id or with the outcome given, up to limit of them.
+func (handler *auditHandler) Audit(resp http.ResponseWriter, req *http.Request) {
+	query := staging_audit.Query{
+		AppId:   req.FormValue("app_id"),
+		Outcome: staging_audit.Outcome(req.FormValue("outcome")),
+	}
+
+	if limit := req.FormValue("limit"); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 0 {
+			handler.logger.Info("invalid-limit", lager.Data{"limit": limit})
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	entriesJson, err := json.Marshal(handler.auditLog.Entries(query))
+	if err != nil {
+		handler.logger.Error("marshal-audit-entries-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(entriesJson)
+}
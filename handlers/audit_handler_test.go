@@ -0,0 +1,86 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/staging_audit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AuditHandler", func() {
+	var (
+		auditLog         staging_audit.Log
+		path             string
+		responseRecorder *httptest.ResponseRecorder
+		handler          handlers.AuditHandler
+	)
+
+	BeforeEach(func() {
+		auditLog = staging_audit.NewLog(10, fakeclock.NewFakeClock(time.Now()))
+		auditLog.Received("guid-1", "request-1", "app-1", "buildpack")
+		auditLog.Received("guid-2", "request-2", "app-2", "docker")
+		auditLog.Rejected("guid-2", &cc_messages.StagingError{Id: "StagerBusy", Message: "stager is busy"})
+
+		path = "/v1/audit"
+		responseRecorder = httptest.NewRecorder()
+		handler = handlers.NewAuditHandler(lagertest.NewTestLogger("test"), auditLog)
+	})
+
+	JustBeforeEach(func() {
+		req, err := http.NewRequest("GET", path, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		handler.Audit(responseRecorder, req)
+	})
+
+	decodeEntries := func() []staging_audit.Entry {
+		var entries []staging_audit.Entry
+		err := json.NewDecoder(responseRecorder.Body).Decode(&entries)
+		Expect(err).NotTo(HaveOccurred())
+		return entries
+	}
+
+	It("responds with the audit entries", func() {
+		Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+		Expect(responseRecorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		entries := decodeEntries()
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].StagingGuid).To(Equal("guid-2"))
+		Expect(entries[0].Outcome).To(Equal(staging_audit.Rejected))
+		Expect(entries[0].ErrorId).To(Equal("StagerBusy"))
+		Expect(entries[1].StagingGuid).To(Equal("guid-1"))
+		Expect(entries[1].Outcome).To(Equal(staging_audit.Pending))
+	})
+
+	Context("when filtering", func() {
+		BeforeEach(func() {
+			path = "/v1/audit?app_id=app-1&outcome=pending&limit=1"
+		})
+
+		It("responds with the matching entries", func() {
+			entries := decodeEntries()
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].StagingGuid).To(Equal("guid-1"))
+		})
+	})
+
+	Context("when the limit is invalid", func() {
+		BeforeEach(func() {
+			path = "/v1/audit?limit=lots"
+		})
+
+		It("responds with bad request", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
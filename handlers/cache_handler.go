@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/cache_client"
+)
+
+type CacheHandler interface {
+	DeleteBuildArtifactsCache(resp http.ResponseWriter, req *http.Request)
+}
+
+type cacheHandler struct {
+	logger      lager.Logger
+	cacheClient cache_client.CacheClient
+}
+
+func NewCacheHandler(logger lager.Logger, cacheClient cache_client.CacheClient) CacheHandler {
+	return &cacheHandler{
+		logger:      logger.Session("cache-handler"),
+		cacheClient: cacheClient,
+	}
+}
+
+func (handler *cacheHandler) DeleteBuildArtifactsCache(resp http.ResponseWriter, req *http.Request) {
+	appGuid := req.FormValue(":app_guid")
+	logger := handler.logger.Session("delete-build-artifacts-cache", lager.Data{"app-guid": appGuid})
+
+	err := handler.cacheClient.DeleteBuildArtifactsCache(appGuid, logger)
+	if err != nil {
+		logger.Error("failed", err)
+		if err == cache_client.ErrInvalidAppGuid {
+			resp.WriteHeader(http.StatusBadRequest)
+		} else if responseErr, ok := err.(*cache_client.BadResponseError); ok && responseErr.StatusCode == http.StatusNotFound {
+			resp.WriteHeader(http.StatusNotFound)
+		} else {
+			resp.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package handlers_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/cache_client"
+	"code.cloudfoundry.org/stager/cache_client/fakes"
+	"code.cloudfoundry.org/stager/handlers"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CacheHandler", func() {
+	var (
+		fakeCacheClient  *fakes.FakeCacheClient
+		responseRecorder *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		fakeCacheClient = &fakes.FakeCacheClient{}
+		responseRecorder = httptest.NewRecorder()
+	})
+
+	JustBeforeEach(func() {
+		req, err := http.NewRequest("DELETE", "/v1/build_artifacts_cache/the-app-guid", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Form = url.Values{":app_guid": {"the-app-guid"}}
+
+		handler := handlers.NewCacheHandler(lagertest.NewTestLogger("test"), fakeCacheClient)
+		handler.DeleteBuildArtifactsCache(responseRecorder, req)
+	})
+
+	It("deletes the build artifacts cache of the app", func() {
+		Expect(fakeCacheClient.DeleteBuildArtifactsCacheCallCount()).To(Equal(1))
+		appGuid, _ := fakeCacheClient.DeleteBuildArtifactsCacheArgsForCall(0)
+		Expect(appGuid).To(Equal("the-app-guid"))
+
+		Expect(responseRecorder.Code).To(Equal(http.StatusNoContent))
+	})
+
+	Context("when the app has no cache", func() {
+		BeforeEach(func() {
+			fakeCacheClient.DeleteBuildArtifactsCacheReturns(&cache_client.BadResponseError{StatusCode: http.StatusNotFound})
+		})
+
+		It("responds with a 404", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("when the app guid is invalid", func() {
+		BeforeEach(func() {
+			fakeCacheClient.DeleteBuildArtifactsCacheReturns(cache_client.ErrInvalidAppGuid)
+		})
+
+		It("responds with a 400", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("when deleting the cache fails", func() {
+		BeforeEach(func() {
+			fakeCacheClient.DeleteBuildArtifactsCacheReturns(errors.New("boom"))
+		})
+
+		It("responds with a 502", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+		})
+	})
+})
@@ -8,25 +8,118 @@ import (
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/stager"
 	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/cache_client"
 	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/completion_dedup"
+	"code.cloudfoundry.org/stager/dead_letter"
+	"code.cloudfoundry.org/stager/enrichment"
+	"code.cloudfoundry.org/stager/metadata_hooks"
+	"code.cloudfoundry.org/stager/prometheus_metrics"
+	"code.cloudfoundry.org/stager/rate_limiter"
+	"code.cloudfoundry.org/stager/redelivery"
+	"code.cloudfoundry.org/stager/request_validation"
+	"code.cloudfoundry.org/stager/response_format"
+	"code.cloudfoundry.org/stager/response_journal"
+	"code.cloudfoundry.org/stager/retry_budget"
+	"code.cloudfoundry.org/stager/staging_audit"
+	"code.cloudfoundry.org/stager/staging_events"
+	"code.cloudfoundry.org/stager/staging_limit"
+	"code.cloudfoundry.org/stager/staging_queue"
+	"code.cloudfoundry.org/stager/stats"
 	"github.com/tedsuo/rata"
 )
 
-func New(logger lager.Logger, ccClient cc_client.CcClient, bbsClient bbs.Client, backends map[string]backend.Backend, clock clock.Clock) http.Handler {
+// Config holds the clients, collaborators and settings the handlers of the
+// stager API are built from.
+type Config struct {
+	CcClient           cc_client.CcClient
+	CacheClient        cache_client.CacheClient
+	BBSClient          bbs.Client
+	StagingTaskDomains []string
+	Backends           map[string]backend.Backend
 
-	stagingHandler := NewStagingHandler(logger, backends, bbsClient)
-	stagingCompletedHandler := NewStagingCompletionHandler(logger, ccClient, backends, clock)
+	RetryBudget       retry_budget.RetryBudget
+	DeadLetters       dead_letter.Spool
+	Enrichers         []enrichment.Enricher
+	MetadataHooks     []metadata_hooks.Hook
+	ResponseFormatter response_format.Formatter
+	StagingStats      stats.Stats
+	MetricsRegistry   *prometheus_metrics.Registry
+	RateLimiter       rate_limiter.RateLimiter
+	StagingQueue      staging_queue.Queue
+	StagingLimit      staging_limit.Limit
+	ResponseJournal   response_journal.Journal
+	Completions       completion_dedup.Tracker
+	Events            staging_events.Emitter
+	AuditLog          staging_audit.Log
+	Validators        []request_validation.Validator
+
+	DryRun                    bool
+	MaxRequestBytes           int
+	DeadLetterMaxPayloadBytes int
+	CompletionWorkers         int
+
+	Clock clock.Clock
+}
+
+func New(logger lager.Logger, config Config) http.Handler {
+	// Without a registry, the metrics endpoint is disabled but the handlers
+	// still need somewhere to record their metrics.
+	registry := config.MetricsRegistry
+	if registry == nil {
+		registry = prometheus_metrics.NewRegistry()
+	}
+
+	stagingHandler := NewStagingHandler(logger, config.Backends, config.BBSClient, config.RetryBudget, config.DeadLetters, registry, config.RateLimiter, config.StagingQueue, config.StagingLimit, config.Events, config.AuditLog, config.Validators, config.DryRun, config.MaxRequestBytes, config.DeadLetterMaxPayloadBytes)
+	stagingCompletedHandler := NewStagingCompletionHandler(logger, CompletionHandlerConfig{
+		CcClient:          config.CcClient,
+		Backends:          config.Backends,
+		RetryBudget:       config.RetryBudget,
+		Enrichers:         config.Enrichers,
+		MetadataHooks:     config.MetadataHooks,
+		ResponseFormatter: config.ResponseFormatter,
+		StagingStats:      config.StagingStats,
+		MetricsRegistry:   registry,
+		Events:            config.Events,
+		AuditLog:          config.AuditLog,
+		StagingLimit:      config.StagingLimit,
+		ResponseJournal:   config.ResponseJournal,
+		Completions:       config.Completions,
+		Workers:           config.CompletionWorkers,
+		Clock:             config.Clock,
+	})
+	statsHandler := NewStatsHandler(logger, config.StagingStats)
+	metricsHandler := NewMetricsHandler(logger, config.MetricsRegistry)
+	cacheHandler := NewCacheHandler(logger, config.CacheClient)
+	stagingTasksHandler := NewStagingTasksHandler(logger, config.BBSClient, config.StagingTaskDomains, config.Clock)
+	stagingStatusHandler := NewStagingStatusHandler(logger, config.BBSClient, config.StagingTaskDomains, config.Backends)
+	auditHandler := NewAuditHandler(logger, config.AuditLog)
+
+	// Replays go through the router, like the callbacks of the BBS, so that
+	// the completion handler finds the staging guid among the route params.
+	var router http.Handler
+	replayHandler := redelivery.NewReplayHandler(logger, config.BBSClient, config.StagingTaskDomains, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		router.ServeHTTP(resp, req)
+	}))
 
 	actions := rata.Handlers{
-		stager.StageRoute:            http.HandlerFunc(stagingHandler.Stage),
-		stager.StopStagingRoute:      http.HandlerFunc(stagingHandler.StopStaging),
-		stager.StagingCompletedRoute: http.HandlerFunc(stagingCompletedHandler.StagingComplete),
+		stager.StageRoute:                     http.HandlerFunc(stagingHandler.Stage),
+		stager.StagePostRoute:                 http.HandlerFunc(stagingHandler.Stage),
+		stager.StopStagingRoute:               http.HandlerFunc(stagingHandler.StopStaging),
+		stager.StagingStatusRoute:             http.HandlerFunc(stagingStatusHandler.StagingStatus),
+		stager.StagingCompletedRoute:          http.HandlerFunc(stagingCompletedHandler.StagingComplete),
+		stager.ReplayStagingRoute:             replayHandler,
+		stager.StatsRoute:                     http.HandlerFunc(statsHandler.Stats),
+		stager.MetricsRoute:                   http.HandlerFunc(metricsHandler.Metrics),
+		stager.DeleteBuildArtifactsCacheRoute: http.HandlerFunc(cacheHandler.DeleteBuildArtifactsCache),
+		stager.StagingTasksRoute:              http.HandlerFunc(stagingTasksHandler.StagingTasks),
+		stager.AuditRoute:                     http.HandlerFunc(auditHandler.Audit),
 	}
 
-	handler, err := rata.NewRouter(stager.Routes, actions)
+	router, err := rata.NewRouter(stager.Routes, actions)
 	if err != nil {
 		panic("unable to create router: " + err.Error())
 	}
 
-	return handler
+	return router
 }
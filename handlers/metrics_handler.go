@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/prometheus_metrics"
+)
+
+// stagingMetrics mirrors the metrics sent through dropsonde in the registry
+// exposed to Prometheus.
+type stagingMetrics struct {
+	startRequestsReceived prometheus_metrics.Counter
+	succeeded             prometheus_metrics.Counter
+	failed                prometheus_metrics.Counter
+	succeededDuration     prometheus_metrics.Summary
+	failedDuration        prometheus_metrics.Summary
+	tasksInFlight         prometheus_metrics.KeyedGauge
+}
+
+func newStagingMetrics(registry *prometheus_metrics.Registry) stagingMetrics {
+	return stagingMetrics{
+		startRequestsReceived: registry.Counter("stager_staging_start_requests_received_total", "Staging requests received from CC."),
+		succeeded:             registry.Counter("stager_staging_requests_succeeded_total", "Staging tasks that succeeded."),
+		failed:                registry.Counter("stager_staging_requests_failed_total", "Staging tasks that failed."),
+		succeededDuration:     registry.Summary("stager_staging_request_succeeded_duration_seconds", "Time taken by staging tasks that succeeded."),
+		failedDuration:        registry.Summary("stager_staging_request_failed_duration_seconds", "Time taken by staging tasks that failed."),
+		tasksInFlight:         registry.KeyedGauge("stager_staging_tasks_in_flight", "Staging tasks desired by this stager that have not completed yet."),
+	}
+}
+
+type MetricsHandler interface {
+	Metrics(resp http.ResponseWriter, req *http.Request)
+}
+
+type metricsHandler struct {
+	logger   lager.Logger
+	registry *prometheus_metrics.Registry
+}
+
+func NewMetricsHandler(logger lager.Logger, registry *prometheus_metrics.Registry) MetricsHandler {
+	return &metricsHandler{
+		logger:   logger.Session("metrics-handler"),
+		registry: registry,
+	}
+}
+
+func (handler *metricsHandler) Metrics(resp http.ResponseWriter, req *http.Request) {
+	if handler.registry == nil {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resp.Header().Set("Content-Type", prometheus_metrics.ContentType)
+	resp.WriteHeader(http.StatusOK)
+
+	err := handler.registry.WriteTo(resp)
+	if err != nil {
+		handler.logger.Error("write-metrics-failed", err)
+	}
+}
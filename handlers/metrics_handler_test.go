@@ -0,0 +1,57 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/prometheus_metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func exposition(registry *prometheus_metrics.Registry) string {
+	buffer := &bytes.Buffer{}
+	Expect(registry.WriteTo(buffer)).To(Succeed())
+	return buffer.String()
+}
+
+var _ = Describe("MetricsHandler", func() {
+	var (
+		registry         *prometheus_metrics.Registry
+		responseRecorder *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		registry = prometheus_metrics.NewRegistry()
+		registry.Counter("stager_staging_requests_succeeded_total", "Staging tasks that succeeded.").Increment()
+
+		responseRecorder = httptest.NewRecorder()
+	})
+
+	serve := func(registry *prometheus_metrics.Registry) {
+		req, err := http.NewRequest("GET", "/metrics", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		handlers.NewMetricsHandler(lagertest.NewTestLogger("test"), registry).Metrics(responseRecorder, req)
+	}
+
+	It("responds with the metrics in the Prometheus text format", func() {
+		serve(registry)
+
+		Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+		Expect(responseRecorder.Header().Get("Content-Type")).To(Equal(prometheus_metrics.ContentType))
+		Expect(responseRecorder.Body.String()).To(ContainSubstring("stager_staging_requests_succeeded_total 1\n"))
+	})
+
+	Context("when the metrics endpoint is disabled", func() {
+		It("responds with a 404", func() {
+			serve(nil)
+
+			Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -12,6 +12,18 @@ import (
 	"code.cloudfoundry.org/runtimeschema/metric"
 	"code.cloudfoundry.org/stager/backend"
 	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/completion_dedup"
+	"code.cloudfoundry.org/stager/enrichment"
+	"code.cloudfoundry.org/stager/metadata_hooks"
+	"code.cloudfoundry.org/stager/prometheus_metrics"
+	"code.cloudfoundry.org/stager/response_format"
+	"code.cloudfoundry.org/stager/response_journal"
+	"code.cloudfoundry.org/stager/retry_budget"
+	"code.cloudfoundry.org/stager/staging_audit"
+	"code.cloudfoundry.org/stager/staging_events"
+	"code.cloudfoundry.org/stager/staging_limit"
+	"code.cloudfoundry.org/stager/stats"
+	"code.cloudfoundry.org/stager/tagged_metric"
 )
 
 const (
@@ -20,6 +32,14 @@ const (
 	stagingSuccessDuration = metric.Duration("StagingRequestSucceededDuration")
 	stagingFailureCounter  = metric.Counter("StagingRequestsFailed")
 	stagingFailureDuration = metric.Duration("StagingRequestFailedDuration")
+	completionsInFlight    = metric.Metric("StagingCompletionsInFlight")
+	duplicateCompletions   = metric.Counter("DuplicateStagingCompletionsSuppressed")
+
+	// Tagged with the lifecycle and stack of the staging
+	taggedSuccessCounter  = tagged_metric.Counter("StagingRequestsSucceeded")
+	taggedSuccessDuration = tagged_metric.Duration("StagingRequestSucceededDuration")
+	taggedFailureCounter  = tagged_metric.Counter("StagingRequestsFailed")
+	taggedFailureDuration = tagged_metric.Duration("StagingRequestFailedDuration")
 )
 
 type CompletionHandler interface {
@@ -27,18 +47,70 @@ type CompletionHandler interface {
 }
 
 type completionHandler struct {
-	ccClient cc_client.CcClient
-	backends map[string]backend.Backend
-	logger   lager.Logger
-	clock    clock.Clock
+	ccClient    cc_client.CcClient
+	backends    map[string]backend.Backend
+	retryBudget retry_budget.RetryBudget
+	enrichers   []enrichment.Enricher
+	hooks       []metadata_hooks.Hook
+	formatter   response_format.Formatter
+	formats     response_format.Registry
+	stats       stats.Stats
+	workers     chan struct{}
+	metrics     stagingMetrics
+	events      staging_events.Emitter
+	auditLog    staging_audit.Log
+	limit       staging_limit.Limit
+	journal     response_journal.Journal
+	completions completion_dedup.Tracker
+	logger      lager.Logger
+	clock       clock.Clock
+}
+
+// CompletionHandlerConfig holds the collaborators and settings of the
+// completion handler. With Workers above zero, at most that many staging
+// responses are posted to CC at once.
+type CompletionHandlerConfig struct {
+	CcClient          cc_client.CcClient
+	Backends          map[string]backend.Backend
+	RetryBudget       retry_budget.RetryBudget
+	Enrichers         []enrichment.Enricher
+	MetadataHooks     []metadata_hooks.Hook
+	ResponseFormatter response_format.Formatter
+	StagingStats      stats.Stats
+	MetricsRegistry   *prometheus_metrics.Registry
+	Events            staging_events.Emitter
+	AuditLog          staging_audit.Log
+	StagingLimit      staging_limit.Limit
+	ResponseJournal   response_journal.Journal
+	Completions       completion_dedup.Tracker
+	Workers           int
+	Clock             clock.Clock
 }
 
-func NewStagingCompletionHandler(logger lager.Logger, ccClient cc_client.CcClient, backends map[string]backend.Backend, clock clock.Clock) CompletionHandler {
+func NewStagingCompletionHandler(logger lager.Logger, config CompletionHandlerConfig) CompletionHandler {
+	var workerSlots chan struct{}
+	if config.Workers > 0 {
+		workerSlots = make(chan struct{}, config.Workers)
+	}
+
 	return &completionHandler{
-		ccClient: ccClient,
-		backends: backends,
-		logger:   logger.Session("completion-handler"),
-		clock:    clock,
+		ccClient:    config.CcClient,
+		backends:    config.Backends,
+		retryBudget: config.RetryBudget,
+		enrichers:   config.Enrichers,
+		hooks:       config.MetadataHooks,
+		formatter:   config.ResponseFormatter,
+		formats:     response_format.DefaultRegistry(),
+		stats:       config.StagingStats,
+		workers:     workerSlots,
+		metrics:     newStagingMetrics(config.MetricsRegistry),
+		events:      config.Events,
+		auditLog:    config.AuditLog,
+		limit:       config.StagingLimit,
+		journal:     config.ResponseJournal,
+		completions: config.Completions,
+		logger:      logger.Session("completion-handler"),
+		clock:       config.Clock,
 	}
 }
 
@@ -62,29 +134,51 @@ func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *
 		return
 	}
 
-	var annotation cc_messages.StagingTaskAnnotation
-	err = json.Unmarshal([]byte(task.Annotation), &annotation)
+	annotation, err := backend.DecodeAnnotation(task.Annotation)
 	if err != nil {
 		res.WriteHeader(http.StatusBadRequest)
 		logger.Error("parsing-annotation-failed", err)
 		return
 	}
 
-	backend := handler.backends[annotation.Lifecycle]
-	if backend == nil {
+	logger = logger.Session("request", lager.Data{"request-id": annotation.RequestId})
+
+	if handler.completions.Duplicate(taskGuid) {
+		logger.Info("suppressed-duplicate-staging-complete")
+		duplicateCompletions.Increment()
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+
+	lifecycleBackend := handler.backends[annotation.Lifecycle]
+	if lifecycleBackend == nil {
 		res.WriteHeader(http.StatusNotFound)
 		logger.Error("get-staging-response-failed-backend-not-found", err)
 		return
 	}
 
-	response, err := backend.BuildStagingResponse(task)
+	response, err := lifecycleBackend.BuildStagingResponse(task)
 	if err != nil {
 		res.WriteHeader(http.StatusBadRequest)
 		logger.Error("get-staging-response-failed", err)
 		return
 	}
 
-	responseJson, err := json.Marshal(response)
+	response.Result, err = metadata_hooks.Apply(logger, handler.hooks, taskGuid, response.Result)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		logger.Error("apply-metadata-hooks-failed", err)
+		return
+	}
+
+	response.Result, err = enrichment.Enrich(logger, handler.enrichers, taskGuid, response.Result)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		logger.Error("enrich-staging-response-failed", err)
+		return
+	}
+
+	responseJson, err := handler.formatterFor(annotation, logger).Format(response)
 	if err != nil {
 		res.WriteHeader(http.StatusBadRequest)
 		logger.Error("get-staging-response-failed", err)
@@ -101,9 +195,14 @@ func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *
 		"payload": responseJson,
 	})
 
-	err = handler.ccClient.StagingComplete(taskGuid, annotation.CompletionCallback, responseJson, logger)
+	err = handler.deliver(taskGuid, annotation.AppId, annotation.CompletionCallback, annotation.RequestId, responseJson, logger)
 	if err != nil {
 		logger.Error("cc-staging-complete-failed", err)
+		if response_journal.Retryable(err) && handler.journalResponse(taskGuid, annotation, responseJson, logger) {
+			handler.completed(task, annotation, response)
+			res.WriteHeader(http.StatusOK)
+			return
+		}
 		if responseErr, ok := err.(*cc_client.BadResponseError); ok {
 			res.WriteHeader(responseErr.StatusCode)
 		} else {
@@ -112,25 +211,161 @@ func (handler *completionHandler) StagingComplete(res http.ResponseWriter, req *
 		return
 	}
 
-	handler.reportMetrics(task)
+	handler.completed(task, annotation, response)
 
 	logger.Info("posted-staging-complete")
 	res.WriteHeader(http.StatusOK)
 }
 
-func (handler *completionHandler) reportMetrics(task *models.TaskCallbackResponse) {
+// completed does the bookkeeping of a staging whose response was delivered
+// to CC or journaled for later delivery. Callbacks that fail before then
+// keep their staging limit slot, as the BBS calls back about them again.
+func (handler *completionHandler) completed(task *models.TaskCallbackResponse, annotation backend.StagingTaskAnnotation, response cc_messages.StagingResponseForCC) {
+	taskGuid := task.TaskGuid
+
+	handler.limit.Release(taskGuid)
+	handler.metrics.tasksInFlight.Untrack(taskGuid)
+	handler.events.Emit(staging_events.TaskCompleted, taskGuid, map[string]interface{}{
+		"app_id": annotation.AppId,
+		"failed": task.Failed,
+	})
+	handler.auditLog.Completed(taskGuid, response.Error)
+
+	handler.releaseRetryBudget(annotation.AppId, taskGuid, response)
+	handler.completions.Delivered(taskGuid)
+	handler.reportMetrics(task, annotation)
+	handler.recordStats(task, annotation, response)
+	handler.events.Emit(staging_events.ResponsePublished, taskGuid, map[string]interface{}{
+		"app_id": annotation.AppId,
+		"failed": response.Error != nil,
+	})
+}
+
+// releaseRetryBudget forgets the attempts of stagings that succeeded. The
+// attempts of failed stagings are kept, so that CC resubmitting them still
+// counts against the budget.
+func (handler *completionHandler) releaseRetryBudget(appId, taskGuid string, response cc_messages.StagingResponseForCC) {
+	if response.Error == nil {
+		handler.retryBudget.Release(appId, taskGuid)
+	}
+}
+
+// journalResponse records a response CC could not take, for it to be
+// delivered later. It reports whether the response was recorded, in which
+// case the task callback need not be retried.
+func (handler *completionHandler) journalResponse(taskGuid string, annotation backend.StagingTaskAnnotation, responseJson []byte, logger lager.Logger) bool {
+	err := handler.journal.Record(taskGuid, annotation.CompletionCallback, annotation.RequestId, responseJson)
+	if err == response_journal.ErrDisabled {
+		return false
+	}
+	if err != nil {
+		logger.Error("failed-to-journal-staging-response", err)
+		return false
+	}
+
+	logger.Info("journaled-staging-response")
+	return true
+}
+
+// formatterFor returns the formatter for the staging response format
+// requested for a staging, or the configured formatter if none was.
+func (handler *completionHandler) formatterFor(annotation backend.StagingTaskAnnotation, logger lager.Logger) response_format.Formatter {
+	if annotation.ResponseFormat == "" {
+		return handler.formatter
+	}
+
+	formatter, err := handler.formats.Lookup(annotation.ResponseFormat)
+	if err != nil {
+		logger.Error("unknown-response-format", err)
+		return handler.formatter
+	}
+	return formatter
+}
+
+// deliver posts the staging response to CC. When the number of workers is
+// limited, deliveries wait for a free worker so that bursts of completed
+// tasks do not overwhelm CC.
+func (handler *completionHandler) deliver(taskGuid, appId, completionCallback, requestId string, responseJson []byte, logger lager.Logger) error {
+	if handler.workers != nil {
+		handler.workers <- struct{}{}
+		handler.reportInFlight()
+
+		defer func() {
+			<-handler.workers
+			handler.reportInFlight()
+		}()
+	}
+
+	return handler.ccClient.StagingComplete(taskGuid, appId, completionCallback, requestId, responseJson, logger)
+}
+
+func (handler *completionHandler) reportInFlight() {
+	err := completionsInFlight.Send(len(handler.workers))
+	if err != nil {
+		handler.logger.Error("failed-to-send-completions-in-flight-metric", err)
+	}
+}
+
+func (handler *completionHandler) reportMetrics(task *models.TaskCallbackResponse, annotation backend.StagingTaskAnnotation) {
 	duration := handler.clock.Now().Sub(time.Unix(0, task.CreatedAt))
+
+	tags := []tagged_metric.Tag{
+		{Name: "lifecycle", Value: annotation.Lifecycle},
+		{Name: "stack", Value: annotation.Stack},
+	}
+
 	if task.Failed {
+		handler.metrics.failed.Increment()
+		handler.metrics.failedDuration.ObserveDuration(duration)
+
 		stagingFailureCounter.Increment()
 		err := stagingFailureDuration.Send(duration)
 		if err != nil {
 			handler.logger.Error("failed-to-send-staging-failed-duration-metric", err)
 		}
+
+		err = taggedFailureCounter.Increment(tags...)
+		if err != nil {
+			handler.logger.Error("failed-to-send-tagged-staging-failed-metric", err)
+		}
+		err = taggedFailureDuration.Send(duration, tags...)
+		if err != nil {
+			handler.logger.Error("failed-to-send-tagged-staging-failed-duration-metric", err)
+		}
 	} else {
+		handler.metrics.succeeded.Increment()
+		handler.metrics.succeededDuration.ObserveDuration(duration)
+
 		err := stagingSuccessDuration.Send(duration)
 		if err != nil {
 			handler.logger.Error("failed-to-send-staging-success-duration-metric", err)
 		}
 		stagingSuccessCounter.Increment()
+
+		err = taggedSuccessCounter.Increment(tags...)
+		if err != nil {
+			handler.logger.Error("failed-to-send-tagged-staging-success-metric", err)
+		}
+		err = taggedSuccessDuration.Send(duration, tags...)
+		if err != nil {
+			handler.logger.Error("failed-to-send-tagged-staging-success-duration-metric", err)
+		}
+	}
+}
+
+func (handler *completionHandler) recordStats(task *models.TaskCallbackResponse, annotation backend.StagingTaskAnnotation, response cc_messages.StagingResponseForCC) {
+	now := handler.clock.Now()
+	sample := stats.Sample{
+		Lifecycle:   annotation.Lifecycle,
+		Stack:       annotation.Stack,
+		Failed:      response.Error != nil,
+		Duration:    now.Sub(time.Unix(0, task.CreatedAt)),
+		CompletedAt: now,
+	}
+
+	if response.Error != nil {
+		sample.FailureCategory = response.Error.Id
 	}
+
+	handler.stats.Record(sample)
 }
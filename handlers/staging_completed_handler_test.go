@@ -19,7 +19,21 @@ import (
 	"code.cloudfoundry.org/stager/backend/fake_backend"
 	"code.cloudfoundry.org/stager/cc_client"
 	"code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/completion_dedup"
+	"code.cloudfoundry.org/stager/enrichment"
 	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/metadata_hooks"
+	"code.cloudfoundry.org/stager/prometheus_metrics"
+	"code.cloudfoundry.org/stager/response_format"
+	"code.cloudfoundry.org/stager/response_journal"
+	journal_fakes "code.cloudfoundry.org/stager/response_journal/fakes"
+	"code.cloudfoundry.org/stager/retry_budget"
+	"code.cloudfoundry.org/stager/staging_audit"
+	"code.cloudfoundry.org/stager/staging_events"
+	event_fakes "code.cloudfoundry.org/stager/staging_events/fakes"
+	"code.cloudfoundry.org/stager/staging_limit"
+	limit_fakes "code.cloudfoundry.org/stager/staging_limit/fakes"
+	"code.cloudfoundry.org/stager/stats"
 	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
 	"github.com/cloudfoundry/dropsonde/metrics"
 
@@ -37,9 +51,15 @@ var _ = Describe("StagingCompletedHandler", func() {
 		backendError        error
 		fakeClock           *fakeclock.FakeClock
 		metricSender        *fake.FakeMetricSender
+		stagingStats        stats.Stats
+		metricsRegistry     *prometheus_metrics.Registry
+		fakeEmitter         *event_fakes.FakeEmitter
+		auditLog            staging_audit.Log
+		completions         completion_dedup.Tracker
 		stagingDurationNano time.Duration
 
 		responseRecorder *httptest.ResponseRecorder
+		config           handlers.CompletionHandlerConfig
 		handler          handlers.CompletionHandler
 	)
 
@@ -56,9 +76,28 @@ var _ = Describe("StagingCompletedHandler", func() {
 		backendError = nil
 
 		fakeClock = fakeclock.NewFakeClock(time.Now())
+		stagingStats = stats.NewStats([]time.Duration{time.Hour}, fakeClock)
+		metricsRegistry = prometheus_metrics.NewRegistry()
+		fakeEmitter = &event_fakes.FakeEmitter{}
+		auditLog = staging_audit.NewLog(10, fakeClock)
+		completions = completion_dedup.NewTracker(10, time.Minute, fakeClock)
 
 		responseRecorder = httptest.NewRecorder()
-		handler = handlers.NewStagingCompletionHandler(logger, fakeCCClient, map[string]backend.Backend{"fake": fakeBackend}, fakeClock)
+		config = handlers.CompletionHandlerConfig{
+			CcClient:          fakeCCClient,
+			Backends:          map[string]backend.Backend{"fake": fakeBackend},
+			RetryBudget:       retry_budget.NewRetryBudget(0, 0, fakeClock),
+			ResponseFormatter: response_format.NewDiegoNativeFormatter(),
+			StagingStats:      stagingStats,
+			MetricsRegistry:   metricsRegistry,
+			Events:            fakeEmitter,
+			AuditLog:          auditLog,
+			StagingLimit:      staging_limit.NewLimit(logger, 0),
+			ResponseJournal:   response_journal.NewJournal("", fakeClock),
+			Completions:       completions,
+			Clock:             fakeClock,
+		}
+		handler = handlers.NewStagingCompletionHandler(logger, config)
 	})
 
 	JustBeforeEach(func() {
@@ -113,6 +152,63 @@ var _ = Describe("StagingCompletedHandler", func() {
 			Expect(fakeBackend.BuildStagingResponseArgsForCall(0)).To(Equal(taskResponse))
 		})
 
+		Context("when this stager desired the staging task", func() {
+			BeforeEach(func() {
+				metricsRegistry.KeyedGauge("stager_staging_tasks_in_flight", "").Track("the-task-guid")
+				metricsRegistry.KeyedGauge("stager_staging_tasks_in_flight", "").Track("another-task-guid")
+			})
+
+			It("no longer tracks the staging task as in flight", func() {
+				Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_tasks_in_flight 1\n"))
+			})
+
+			Context("when the CC request fails", func() {
+				BeforeEach(func() {
+					fakeCCClient.StagingCompleteReturns(&cc_client.BadResponseError{504})
+				})
+
+				It("still tracks the staging task as in flight", func() {
+					Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_tasks_in_flight 2\n"))
+				})
+			})
+
+			Context("when the BBS calls back with the completion again", func() {
+				JustBeforeEach(func() {
+					fakeClock.Increment(time.Minute)
+					handler.StagingComplete(httptest.NewRecorder(), postTask(taskResponse))
+				})
+
+				It("does not stop tracking any other staging task", func() {
+					Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_tasks_in_flight 1\n"))
+				})
+			})
+		})
+
+		Context("when staging tasks are limited", func() {
+			var fakeLimit *limit_fakes.FakeLimit
+
+			BeforeEach(func() {
+				fakeLimit = &limit_fakes.FakeLimit{}
+				config.StagingLimit = fakeLimit
+				handler = handlers.NewStagingCompletionHandler(logger, config)
+			})
+
+			It("releases the slot of the staging task", func() {
+				Expect(fakeLimit.ReleaseCallCount()).To(Equal(1))
+				Expect(fakeLimit.ReleaseArgsForCall(0)).To(Equal("the-task-guid"))
+			})
+
+			Context("when the CC request fails", func() {
+				BeforeEach(func() {
+					fakeCCClient.StagingCompleteReturns(&cc_client.BadResponseError{504})
+				})
+
+				It("keeps the slot until the BBS calls back again", func() {
+					Expect(fakeLimit.ReleaseCallCount()).To(Equal(0))
+				})
+			})
+		})
+
 		Context("when the guid in the url does not match the task guid", func() {
 			BeforeEach(func() {
 				taskJSON, err := json.Marshal(taskResponse)
@@ -206,9 +302,40 @@ var _ = Describe("StagingCompletedHandler", func() {
 				Expect(payload).To(Equal(backendResponseJson))
 			})
 
+			Context("when the annotation carries a request id", func() {
+				var requestId string
+
+				BeforeEach(func() {
+					annotation, err := backend.EncodeAnnotation(backend.StagingTaskAnnotation{
+						StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{Lifecycle: "fake"},
+						RequestId:             "the-request-id",
+					})
+					Expect(err).NotTo(HaveOccurred())
+					annotationJson = []byte(annotation)
+
+					fakeCCClient.StagingCompleteStub = func(_, _, _, id string, _ []byte, _ lager.Logger) error {
+						requestId = id
+						return nil
+					}
+				})
+
+				It("passes the request id on to CC", func() {
+					Expect(requestId).To(Equal("the-request-id"))
+				})
+			})
+
 			Context("when the CC request succeeds", func() {
 				It("increments the staging success counter", func() {
 					Expect(metricSender.GetCounter("StagingRequestsSucceeded")).To(BeEquivalentTo(1))
+					Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_requests_succeeded_total 1\n"))
+				})
+
+				It("records the time it took to stage succesfully for Prometheus", func() {
+					Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_request_succeeded_duration_seconds_count 1\n"))
+				})
+
+				It("does not count a staging task this stager did not desire", func() {
+					Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_tasks_in_flight 0\n"))
 				})
 
 				It("emits the time it took to stage succesfully", func() {
@@ -219,9 +346,120 @@ var _ = Describe("StagingCompletedHandler", func() {
 
 				})
 
+				It("emits the success count and duration tagged with the lifecycle and stack", func() {
+					Expect(metricSender.GetCounter("StagingRequestsSucceeded.lifecycle.fake.stack.none")).To(BeEquivalentTo(1))
+					Expect(metricSender.GetValue("StagingRequestSucceededDuration.lifecycle.fake.stack.none")).To(Equal(fake.Metric{
+						Value: float64(stagingDurationNano),
+						Unit:  "nanos",
+					}))
+				})
+
 				It("returns a 200", func() {
 					Expect(responseRecorder.Code).To(Equal(200))
 				})
+
+				It("records the staging in the statistics", func() {
+					window := stagingStats.Summarize().Windows[0]
+					Expect(window.Total).To(Equal(1))
+					Expect(window.Succeeded).To(Equal(1))
+					Expect(window.ByLifecycle).To(HaveKey("fake"))
+				})
+
+				It("emits task completed and response published events", func() {
+					Expect(fakeEmitter.EmitCallCount()).To(Equal(2))
+
+					eventType, guid, data := fakeEmitter.EmitArgsForCall(0)
+					Expect(eventType).To(Equal(staging_events.TaskCompleted))
+					Expect(guid).To(Equal("the-task-guid"))
+					Expect(data).To(HaveKeyWithValue("failed", false))
+
+					eventType, guid, _ = fakeEmitter.EmitArgsForCall(1)
+					Expect(eventType).To(Equal(staging_events.ResponsePublished))
+					Expect(guid).To(Equal("the-task-guid"))
+				})
+			})
+
+			Context("when the BBS calls back with the completion again", func() {
+				var duplicateRecorder *httptest.ResponseRecorder
+
+				JustBeforeEach(func() {
+					duplicateRecorder = httptest.NewRecorder()
+					handler.StagingComplete(duplicateRecorder, postTask(taskResponse))
+				})
+
+				It("does not deliver it to CC again", func() {
+					Expect(duplicateRecorder.Code).To(Equal(http.StatusOK))
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+				})
+
+				It("counts the suppressed completion", func() {
+					Expect(metricSender.GetCounter("DuplicateStagingCompletionsSuppressed")).To(BeEquivalentTo(1))
+				})
+
+				Context("once the window has passed", func() {
+					JustBeforeEach(func() {
+						fakeClock.Increment(time.Minute)
+						handler.StagingComplete(httptest.NewRecorder(), postTask(taskResponse))
+					})
+
+					It("delivers it again", func() {
+						Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(2))
+					})
+				})
+			})
+
+			Context("when the CC request fails and the BBS calls back again", func() {
+				BeforeEach(func() {
+					fakeCCClient.StagingCompleteReturns(&cc_client.BadResponseError{504})
+				})
+
+				JustBeforeEach(func() {
+					handler.StagingComplete(httptest.NewRecorder(), postTask(taskResponse))
+				})
+
+				It("retries delivering it", func() {
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(2))
+				})
+			})
+
+			Context("when the staging request was audited", func() {
+				BeforeEach(func() {
+					auditLog.Received("the-task-guid", "", "the-app-id", "fake")
+				})
+
+				It("records the outcome of the staging", func() {
+					entries := auditLog.Entries(staging_audit.Query{})
+					Expect(entries).To(HaveLen(1))
+					Expect(entries[0].Outcome).To(Equal(staging_audit.Succeeded))
+					Expect(*entries[0].CompletedAt).To(Equal(fakeClock.Now()))
+				})
+
+				Context("when the staging failed", func() {
+					BeforeEach(func() {
+						backendResponse = cc_messages.StagingResponseForCC{
+							Error: &cc_messages.StagingError{Id: cc_messages.BUILDPACK_COMPILE_FAILED, Message: "staging failed"},
+						}
+					})
+
+					It("records the staging error", func() {
+						entries := auditLog.Entries(staging_audit.Query{})
+						Expect(entries).To(HaveLen(1))
+						Expect(entries[0].Outcome).To(Equal(staging_audit.Failed))
+						Expect(entries[0].ErrorId).To(Equal(cc_messages.BUILDPACK_COMPILE_FAILED))
+					})
+				})
+
+				Context("when the CC request fails", func() {
+					BeforeEach(func() {
+						fakeCCClient.StagingCompleteReturns(&cc_client.BadResponseError{504})
+					})
+
+					It("leaves the staging pending", func() {
+						entries := auditLog.Entries(staging_audit.Query{})
+						Expect(entries).To(HaveLen(1))
+						Expect(entries[0].Outcome).To(Equal(staging_audit.Pending))
+					})
+				})
 			})
 
 			Context("when the CC request fails", func() {
@@ -232,6 +470,172 @@ var _ = Describe("StagingCompletedHandler", func() {
 				It("responds with the status code that the CC returned", func() {
 					Expect(responseRecorder.Code).To(Equal(504))
 				})
+
+				It("does not emit any event", func() {
+					Expect(fakeEmitter.EmitCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the number of completion workers is limited", func() {
+				BeforeEach(func() {
+					config.Workers = 1
+					handler = handlers.NewStagingCompletionHandler(logger, config)
+				})
+
+				It("posts the response to CC", func() {
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+
+				It("emits the number of completions in flight", func() {
+					Expect(metricSender.GetValue("StagingCompletionsInFlight")).To(Equal(fake.Metric{
+						Value: 0,
+						Unit:  "Metric",
+					}))
+				})
+			})
+
+			Context("when result enrichers are configured", func() {
+				BeforeEach(func() {
+					result := json.RawMessage(`{}`)
+					backendResponse = cc_messages.StagingResponseForCC{Result: &result}
+
+					enrichers := []enrichment.Enricher{
+						enrichment.NewStaticFieldsEnricher(map[string]string{"build_id": "the-build-id"}),
+					}
+
+					config.Enrichers = enrichers
+					handler = handlers.NewStagingCompletionHandler(logger, config)
+				})
+
+				It("posts the enriched result to CC", func() {
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+					_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+					Expect(payload).To(MatchJSON(`{"result":{"build_id":"the-build-id"}}`))
+				})
+			})
+
+			Context("when the DEA compatible response format is configured", func() {
+				BeforeEach(func() {
+					result := json.RawMessage(`{"detected_start_command":{"web":"rackup"}}`)
+					backendResponse = cc_messages.StagingResponseForCC{Result: &result}
+
+					config.ResponseFormatter = response_format.NewDEACompatFormatter()
+					handler = handlers.NewStagingCompletionHandler(logger, config)
+				})
+
+				It("posts the result to CC in that format", func() {
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+					_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+					Expect(payload).To(MatchJSON(`{"detected_start_command":"rackup"}`))
+				})
+			})
+
+			Context("when the staging requested the CC v3 response format", func() {
+				BeforeEach(func() {
+					result := json.RawMessage(`{"lifecycle_type":"buildpack","lifecycle_metadata":{"buildpack_key":"ruby-key"},"execution_metadata":"rackup"}`)
+					backendResponse = cc_messages.StagingResponseForCC{Result: &result}
+
+					annotation, err := backend.EncodeAnnotation(backend.StagingTaskAnnotation{
+						StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{Lifecycle: "fake"},
+						ResponseFormat:        response_format.CAPIV3,
+					})
+					Expect(err).NotTo(HaveOccurred())
+					annotationJson = []byte(annotation)
+				})
+
+				It("posts the result to CC in that format", func() {
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+					_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+					Expect(payload).To(MatchJSON(`{
+						"droplet": {"execution_metadata": "rackup"},
+						"lifecycle": {"type": "buildpack", "data": {"buildpack_key": "ruby-key"}}
+					}`))
+				})
+			})
+
+			Context("when metadata hooks are configured", func() {
+				BeforeEach(func() {
+					result := json.RawMessage(`{"execution_metadata":"{\"start_command\":\"rackup\"}","detected_start_command":{"web":"rackup"}}`)
+					backendResponse = cc_messages.StagingResponseForCC{Result: &result}
+
+					hooks := []metadata_hooks.Hook{
+						metadata_hooks.NewFuncHook("procfile", func(stagingGuid string, metadata *metadata_hooks.Metadata) error {
+							metadata.ExecutionMetadata["start_command"] = "bundle exec puma"
+							metadata.DetectedStartCommand["web"] = "bundle exec puma"
+							return nil
+						}),
+					}
+
+					config.MetadataHooks = hooks
+					handler = handlers.NewStagingCompletionHandler(logger, config)
+				})
+
+				It("posts the processed result to CC", func() {
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+					_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+					Expect(payload).To(MatchJSON(`{"result":{"execution_metadata":"{\"start_command\":\"bundle exec puma\"}","detected_start_command":{"web":"bundle exec puma"}}}`))
+				})
+			})
+
+			Context("when the staging was desired under a retry budget", func() {
+				var retryBudget retry_budget.RetryBudget
+
+				BeforeEach(func() {
+					var err error
+					annotationJson, err = json.Marshal(backend.StagingTaskAnnotation{
+						StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{Lifecycle: "fake"},
+						AppId:                 "the-app-id",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					retryBudget = retry_budget.NewRetryBudget(1, 0, fakeClock)
+					Expect(retryBudget.Spend("the-app-id", "the-task-guid")).To(Succeed())
+
+					config.RetryBudget = retryBudget
+					handler = handlers.NewStagingCompletionHandler(logger, config)
+				})
+
+				It("posts the result to CC without spending the budget", func() {
+					Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+					_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+
+					var response cc_messages.StagingResponseForCC
+					Expect(json.Unmarshal(payload, &response)).To(Succeed())
+					Expect(response.Error).To(BeNil())
+				})
+
+				Context("when CC is posted to", func() {
+					var appId string
+
+					BeforeEach(func() {
+						fakeCCClient.StagingCompleteStub = func(_, id, _, _ string, _ []byte, _ lager.Logger) error {
+							appId = id
+							return nil
+						}
+					})
+
+					It("passes the app id along, so that retried deliveries spend the budget", func() {
+						Expect(appId).To(Equal("the-app-id"))
+					})
+				})
+
+				It("releases the budget of the staging once it succeeded", func() {
+					Expect(retryBudget.Spend("the-app-id", "the-task-guid")).To(Succeed())
+				})
+
+				Context("when the staging failed", func() {
+					BeforeEach(func() {
+						backendResponse = cc_messages.StagingResponseForCC{
+							Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+						}
+					})
+
+					It("keeps the budget spent", func() {
+						Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
+						Expect(retryBudget.Spend("the-app-id", "the-task-guid")).To(Equal(retry_budget.ErrRetryBudgetExhausted))
+					})
+				})
 			})
 
 			Context("When an error occurs in making the CC request", func() {
@@ -247,6 +651,59 @@ var _ = Describe("StagingCompletedHandler", func() {
 					Expect(metricSender.GetCounter("StagingRequestsSucceeded")).To(BeEquivalentTo(0))
 				})
 
+				Context("when the response journal is enabled", func() {
+					var fakeJournal *journal_fakes.FakeJournal
+
+					BeforeEach(func() {
+						fakeJournal = &journal_fakes.FakeJournal{}
+						config.ResponseJournal = fakeJournal
+						handler = handlers.NewStagingCompletionHandler(logger, config)
+					})
+
+					It("records the response for later delivery", func() {
+						Expect(fakeJournal.RecordCallCount()).To(Equal(1))
+						stagingGuid, _, _, response := fakeJournal.RecordArgsForCall(0)
+						Expect(stagingGuid).To(Equal("the-task-guid"))
+
+						_, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+						Expect(response).To(Equal(payload))
+					})
+
+					It("responds with a 200 so the callback is not retried", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+
+					It("does the bookkeeping of a delivered staging", func() {
+						Expect(metricSender.GetCounter("StagingRequestsSucceeded")).To(BeEquivalentTo(1))
+						Expect(stagingStats.Summarize().Windows[0].Succeeded).To(Equal(1))
+
+						Expect(fakeEmitter.EmitCallCount()).To(Equal(2))
+						eventType, _, _ := fakeEmitter.EmitArgsForCall(1)
+						Expect(eventType).To(Equal(staging_events.ResponsePublished))
+					})
+
+					Context("when the response cannot be recorded", func() {
+						BeforeEach(func() {
+							fakeJournal.RecordReturns(errors.New("disk full"))
+						})
+
+						It("responds with a 503 error", func() {
+							Expect(responseRecorder.Code).To(Equal(503))
+						})
+					})
+
+					Context("when CC rejects the response", func() {
+						BeforeEach(func() {
+							fakeCCClient.StagingCompleteReturns(&cc_client.BadResponseError{StatusCode: http.StatusBadRequest})
+						})
+
+						It("does not record the response", func() {
+							Expect(fakeJournal.RecordCallCount()).To(Equal(0))
+							Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+						})
+					})
+				})
+
 				It("does not update the staging duration", func() {
 					Expect(metricSender.GetValue("StagingRequestSucceededDuration")).To(Equal(fake.Metric{}))
 				})
@@ -278,7 +735,8 @@ var _ = Describe("StagingCompletedHandler", func() {
 				Annotation: `{
 					"lifecycle": "fake",
 					"task_id": "the-task-id",
-					"app_id": "the-app-id"
+					"app_id": "the-app-id",
+					"stack": "cflinuxfs2"
 				}`,
 			}
 
@@ -299,6 +757,7 @@ var _ = Describe("StagingCompletedHandler", func() {
 		It("increments the staging failed counter", func() {
 			Expect(fakeCCClient.StagingCompleteCallCount()).To(Equal(1))
 			Expect(metricSender.GetCounter("StagingRequestsFailed")).To(BeEquivalentTo(1))
+			Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_requests_failed_total 1\n"))
 		})
 
 		It("emits the time it took to stage unsuccesfully", func() {
@@ -308,6 +767,14 @@ var _ = Describe("StagingCompletedHandler", func() {
 			}))
 
 		})
+
+		It("emits the failure count and duration tagged with the lifecycle and stack", func() {
+			Expect(metricSender.GetCounter("StagingRequestsFailed.lifecycle.fake.stack.cflinuxfs2")).To(BeEquivalentTo(1))
+			Expect(metricSender.GetValue("StagingRequestFailedDuration.lifecycle.fake.stack.cflinuxfs2")).To(Equal(fake.Metric{
+				Value: 900900,
+				Unit:  "nanos",
+			}))
+		})
 	})
 
 	Context("when a non-staging task is reported", func() {
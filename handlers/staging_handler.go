@@ -1,9 +1,16 @@
 package handlers
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
 
 	"code.cloudfoundry.org/bbs"
 	"code.cloudfoundry.org/bbs/models"
@@ -11,6 +18,18 @@ import (
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/runtimeschema/metric"
 	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/circuit_breaker"
+	"code.cloudfoundry.org/stager/dead_letter"
+	"code.cloudfoundry.org/stager/prometheus_metrics"
+	"code.cloudfoundry.org/stager/rate_limiter"
+	"code.cloudfoundry.org/stager/request_validation"
+	"code.cloudfoundry.org/stager/response_format"
+	"code.cloudfoundry.org/stager/retry_budget"
+	"code.cloudfoundry.org/stager/staging_audit"
+	"code.cloudfoundry.org/stager/staging_events"
+	"code.cloudfoundry.org/stager/staging_limit"
+	"code.cloudfoundry.org/stager/staging_queue"
+	"code.cloudfoundry.org/stager/tracing"
 )
 
 const (
@@ -18,6 +37,18 @@ const (
 	StagingStopRequestsReceivedCounter  = metric.Counter("StagingStopRequestsReceived")
 )
 
+// DefaultMaxStagingRequestBytes bounds the staging request bodies read into
+// memory while they are decoded.
+const DefaultMaxStagingRequestBytes = 10 * 1024 * 1024
+
+// DryRunResponse is returned for staging requests received in dry-run mode,
+// in place of desiring the staging task.
+type DryRunResponse struct {
+	TaskGuid       string                 `json:"task_guid"`
+	Domain         string                 `json:"domain"`
+	TaskDefinition *models.TaskDefinition `json:"task_definition"`
+}
+
 type StagingHandler interface {
 	Stage(resp http.ResponseWriter, req *http.Request)
 	StopStaging(resp http.ResponseWriter, req *http.Request)
@@ -27,12 +58,44 @@ type stagingHandler struct {
 	logger      lager.Logger
 	backends    map[string]backend.Backend
 	diegoClient bbs.Client
+	retryBudget retry_budget.RetryBudget
+	deadLetters dead_letter.Spool
+	metrics     stagingMetrics
+	rateLimiter rate_limiter.RateLimiter
+	queue       staging_queue.Queue
+	limit       staging_limit.Limit
+	events      staging_events.Emitter
+	auditLog    staging_audit.Log
+	validators  []request_validation.Validator
+	dryRun      bool
+
+	// maxRequestBytes bounds the staging request bodies read, if positive.
+	maxRequestBytes int
+
+	// deadLetterMaxPayloadBytes bounds the raw request bodies kept for the
+	// dead letters of rejected requests, if positive.
+	deadLetterMaxPayloadBytes int
+
+	inFlightLock sync.Mutex
+	inFlight     map[string]struct{}
 }
 
 func NewStagingHandler(
 	logger lager.Logger,
 	backends map[string]backend.Backend,
 	bbsClient bbs.Client,
+	retryBudget retry_budget.RetryBudget,
+	deadLetters dead_letter.Spool,
+	metricsRegistry *prometheus_metrics.Registry,
+	rateLimiter rate_limiter.RateLimiter,
+	queue staging_queue.Queue,
+	limit staging_limit.Limit,
+	events staging_events.Emitter,
+	auditLog staging_audit.Log,
+	validators []request_validation.Validator,
+	dryRun bool,
+	maxRequestBytes int,
+	deadLetterMaxPayloadBytes int,
 ) StagingHandler {
 	logger = logger.Session("staging-handler")
 
@@ -40,47 +103,270 @@ func NewStagingHandler(
 		logger:      logger,
 		backends:    backends,
 		diegoClient: bbsClient,
+		retryBudget: retryBudget,
+		deadLetters: deadLetters,
+		metrics:     newStagingMetrics(metricsRegistry),
+		rateLimiter: rateLimiter,
+		queue:       queue,
+		limit:       limit,
+		events:      events,
+		auditLog:    auditLog,
+		validators:  validators,
+		dryRun:      dryRun,
+		inFlight:    map[string]struct{}{},
+
+		maxRequestBytes:           maxRequestBytes,
+		deadLetterMaxPayloadBytes: deadLetterMaxPayloadBytes,
 	}
 }
 
 func (handler *stagingHandler) Stage(resp http.ResponseWriter, req *http.Request) {
 	stagingGuid := req.FormValue(":staging_guid")
-	logger := handler.logger.Session("staging-request", lager.Data{"staging-guid": stagingGuid})
+	requestId := tracing.RequestId(req)
+	logger := handler.logger.Session("staging-request", lager.Data{"staging-guid": stagingGuid, "request-id": requestId})
+	resp.Header().Set(tracing.RequestIdHeader, requestId)
 
-	requestBody, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logger.Error("read-body-failed", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+	if !handler.rateLimiter.Allow() {
+		logger.Info("rate-limited")
+		resp.Header().Set("Retry-After", "1")
+		handler.writeStagingError(resp, stagingGuid, http.StatusServiceUnavailable, backend.ErrStagerBusy.Error())
 		return
 	}
 
+	err := handler.queue.Submit(func() {
+		handler.stage(resp, req, stagingGuid, requestId, logger)
+	})
+	if err != nil {
+		logger.Info("staging-queue-full")
+		resp.Header().Set("Retry-After", "1")
+		handler.writeStagingError(resp, stagingGuid, http.StatusServiceUnavailable, backend.ErrStagerBusy.Error())
+	}
+}
+
+// UnsupportedEncodingError is returned for staging requests compressed in
+// an encoding the stager does not decompress.
+type UnsupportedEncodingError struct {
+	Encoding string
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported content encoding %q", e.Encoding)
+}
+
+// requestBody returns the staging request body, decompressed according to
+// its Content-Encoding.
+func requestBody(req *http.Request) (io.Reader, error) {
+	switch encoding := req.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+		return req.Body, nil
+	case "gzip":
+		return gzip.NewReader(req.Body)
+	default:
+		return nil, &UnsupportedEncodingError{Encoding: encoding}
+	}
+}
+
+var errRequestTooLarge = errors.New("staging request too large")
+
+// limitedBody reads a request body up to a limit, failing reads with
+// errRequestTooLarge once the body turns out to be larger.
+type limitedBody struct {
+	reader    io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errRequestTooLarge
+	}
+
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.reader.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		b.exceeded = true
+		return n, errRequestTooLarge
+	}
+
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// cappedBuffer keeps the first max bytes written to it, or all of them if
+// max is zero, dropping the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && b.Len()+len(p) > b.max {
+		b.Buffer.Write(p[:b.max-b.Len()])
+		return len(p), nil
+	}
+
+	return b.Buffer.Write(p)
+}
+
+// decodeRequest decodes the staging request body into stagingRequest,
+// turning away bodies larger than maxRequestBytes. The decoder holds the
+// whole body in memory, so the body is read no further than one byte past
+// the limit. The limit applies to compressed bodies once decompressed.
+//
+// The raw body read is returned as well, up to one byte more than dead
+// letters keep so that the spool can tell it was cut short, for rejected
+// requests to be dead-lettered as they were received.
+func (handler *stagingHandler) decodeRequest(resp http.ResponseWriter, req *http.Request, stagingRequest *cc_messages.StagingRequestFromCC) ([]byte, error) {
+	if handler.maxRequestBytes > 0 && req.ContentLength > int64(handler.maxRequestBytes) {
+		return nil, &request_validation.SizeLimitError{Limit: "staging request", Unit: "bytes", Size: int(req.ContentLength), Max: handler.maxRequestBytes}
+	}
+
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var limited *limitedBody
+	if handler.maxRequestBytes > 0 {
+		limited = &limitedBody{reader: body, remaining: int64(handler.maxRequestBytes)}
+		body = limited
+	}
+
+	raw := &cappedBuffer{}
+	if handler.deadLetterMaxPayloadBytes > 0 {
+		raw.max = handler.deadLetterMaxPayloadBytes + 1
+	}
+
+	err = json.NewDecoder(io.TeeReader(body, raw)).Decode(stagingRequest)
+	if limited != nil && limited.exceeded {
+		// The rest of the body is left unread, so the connection cannot be
+		// reused.
+		resp.Header().Set("Connection", "close")
+		return nil, &request_validation.SizeLimitError{Limit: "staging request", Unit: "bytes", Max: handler.maxRequestBytes}
+	}
+
+	return raw.Bytes(), err
+}
+
+func (handler *stagingHandler) stage(resp http.ResponseWriter, req *http.Request, stagingGuid, requestId string, logger lager.Logger) {
 	var stagingRequest cc_messages.StagingRequestFromCC
-	err = json.Unmarshal(requestBody, &stagingRequest)
+	rawRequest, err := handler.decodeRequest(resp, req, &stagingRequest)
+	if sizeErr, ok := err.(*request_validation.SizeLimitError); ok {
+		logger.Info("staging-request-too-large", lager.Data{"reason": sizeErr.Error()})
+		handler.writeStagingResponse(resp, stagingGuid, http.StatusRequestEntityTooLarge, cc_messages.StagingResponseForCC{
+			Error: &cc_messages.StagingError{Id: backend.InvalidStagingRequest, Message: sizeErr.Error()},
+		})
+		return
+	}
+	if encodingErr, ok := err.(*UnsupportedEncodingError); ok {
+		logger.Info("unsupported-content-encoding", lager.Data{"encoding": encodingErr.Encoding})
+		handler.writeStagingResponse(resp, stagingGuid, http.StatusUnsupportedMediaType, cc_messages.StagingResponseForCC{
+			Error: &cc_messages.StagingError{Id: backend.InvalidStagingRequest, Message: encodingErr.Error()},
+		})
+		return
+	}
 	if err != nil {
 		logger.Error("unmarshal-request-failed", err)
-		resp.WriteHeader(http.StatusBadRequest)
+		handler.storeDeadLetter(logger, stagingGuid, err, rawRequest)
+		handler.writeStagingError(resp, stagingGuid, http.StatusBadRequest, backend.ErrMalformedStagingRequest.Error())
 		return
 	}
 
+	// The lifecycle data is only understood by the backend of its
+	// lifecycle, so requests for unknown lifecycles are not looked into.
+	lifecycleBackend, ok := handler.backends[stagingRequest.Lifecycle]
+	if !ok {
+		logger.Error("backend-not-found", err, lager.Data{"backend": stagingRequest.Lifecycle})
+		handler.auditLog.Rejected(stagingGuid, &cc_messages.StagingError{
+			Id:      backend.InvalidStagingRequest,
+			Message: fmt.Sprintf("unknown lifecycle %q", stagingRequest.Lifecycle),
+		})
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = request_validation.Validate(logger, handler.validators, stagingRequest)
+	if err != nil {
+		handler.storeDeadLetter(logger, stagingGuid, err, rawRequest)
+		if budgetErr := handler.retryBudget.Spend(stagingRequest.AppId, stagingGuid); budgetErr != nil {
+			logger.Error("retry-budget-exhausted", budgetErr, lager.Data{"app-id": stagingRequest.AppId})
+			handler.doErrorResponse(resp, stagingGuid, budgetErr.Error())
+			return
+		}
+		handler.writeStagingResponse(resp, stagingGuid, http.StatusBadRequest, cc_messages.StagingResponseForCC{
+			Error: &cc_messages.StagingError{Id: backend.InvalidStagingRequest, Message: err.Error()},
+		})
+		return
+	}
+
+	responseFormat := req.Header.Get(response_format.FormatHeader)
+	if responseFormat != "" {
+		_, err = response_format.DefaultRegistry().Lookup(responseFormat)
+		if err != nil {
+			logger.Error("unknown-response-format", err)
+			handler.writeStagingResponse(resp, stagingGuid, http.StatusBadRequest, cc_messages.StagingResponseForCC{
+				Error: &cc_messages.StagingError{Id: backend.InvalidStagingRequest, Message: err.Error()},
+			})
+			return
+		}
+	}
+
 	envNames := []string{}
 	for _, envVar := range stagingRequest.Environment {
 		envNames = append(envNames, envVar.Name)
 	}
 	logger.Info("environment", lager.Data{"keys": envNames})
 
-	backend, ok := handler.backends[stagingRequest.Lifecycle]
-	if !ok {
-		logger.Error("backend-not-found", err, lager.Data{"backend": stagingRequest.Lifecycle})
-		resp.WriteHeader(http.StatusNotFound)
+	if handler.dryRun {
+		handler.stageDryRun(resp, lifecycleBackend, stagingGuid, requestId, responseFormat, stagingRequest, logger)
 		return
 	}
 
 	StagingStartRequestsReceivedCounter.Increment()
+	handler.metrics.startRequestsReceived.Increment()
+	handler.events.Emit(staging_events.RequestReceived, stagingGuid, map[string]interface{}{
+		"app_id":    stagingRequest.AppId,
+		"lifecycle": stagingRequest.Lifecycle,
+	})
+	handler.auditLog.Received(stagingGuid, requestId, stagingRequest.AppId, stagingRequest.Lifecycle)
+
+	if !handler.claim(stagingGuid) {
+		logger.Info("staging-request-already-in-progress")
+		resp.WriteHeader(http.StatusAccepted)
+		return
+	}
+	defer handler.unclaim(stagingGuid)
 
-	taskDef, guid, domain, err := backend.BuildRecipe(stagingGuid, stagingRequest)
+	existingTask, err := handler.diegoClient.TaskByGuid(logger, stagingGuid)
+	if err == nil && existingTask != nil {
+		logger.Info("staging-task-already-exists", lager.Data{"state": existingTask.State.String()})
+		resp.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	taskDef, guid, domain, err := handler.buildTask(lifecycleBackend, stagingGuid, requestId, responseFormat, stagingRequest, logger)
 	if err != nil {
-		logger.Error("recipe-building-failed", err, lager.Data{"staging-request": stagingRequest})
-		handler.doErrorResponse(resp, err.Error())
+		handler.doErrorResponse(resp, stagingGuid, err.Error())
+		return
+	}
+
+	if !handler.limit.Acquire(guid) {
+		logger.Info("staging-limit-reached")
+		resp.Header().Set("Retry-After", "1")
+		handler.writeStagingError(resp, stagingGuid, http.StatusServiceUnavailable, backend.ErrPlatformBusy.Error())
+		return
+	}
+
+	err = handler.retryBudget.Spend(stagingRequest.AppId, stagingGuid)
+	if err != nil {
+		logger.Error("retry-budget-exhausted", err, lager.Data{"app-id": stagingRequest.AppId})
+		handler.limit.Release(guid)
+		handler.doErrorResponse(resp, stagingGuid, err.Error())
 		return
 	}
 
@@ -91,25 +377,174 @@ func (handler *stagingHandler) Stage(resp http.ResponseWriter, req *http.Request
 
 	err = handler.diegoClient.DesireTask(logger, guid, domain, taskDef)
 	if models.ErrResourceExists.Equal(err) {
-		err = nil
+		resp.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if openErr, ok := err.(*circuit_breaker.OpenError); ok {
+		logger.Error("bbs-circuit-open", err)
+		handler.limit.Release(guid)
+		resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(openErr.RetryAfter.Seconds()))))
+		handler.writeStagingError(resp, stagingGuid, http.StatusServiceUnavailable, backend.ErrStagerBusy.Error())
+		return
 	}
 
 	if err != nil {
 		logger.Error("staging-failed", err, lager.Data{"staging-request": stagingRequest})
-		handler.doErrorResponse(resp, err.Error())
+		handler.limit.Release(guid)
+		handler.doErrorResponse(resp, stagingGuid, err.Error())
 		return
 	}
 
+	handler.metrics.tasksInFlight.Track(guid)
+	handler.events.Emit(staging_events.TaskDesired, stagingGuid, map[string]interface{}{
+		"app_id": stagingRequest.AppId,
+	})
+
 	resp.WriteHeader(http.StatusAccepted)
 }
 
-func (handler *stagingHandler) doErrorResponse(resp http.ResponseWriter, message string) {
-	response := cc_messages.StagingResponseForCC{
-		Error: backend.SanitizeErrorMessage(message),
+// buildTask builds the definition of the staging task with the backend of
+// the request's lifecycle.
+func (handler *stagingHandler) buildTask(lifecycleBackend backend.Backend, stagingGuid, requestId, responseFormat string, stagingRequest cc_messages.StagingRequestFromCC, logger lager.Logger) (*models.TaskDefinition, string, string, error) {
+	taskDef, guid, domain, err := lifecycleBackend.BuildRecipe(stagingGuid, stagingRequest)
+	if err != nil {
+		logger.Error("recipe-building-failed", err, lager.Data{"staging-request": stagingRequest})
+		return nil, "", "", err
+	}
+
+	err = backend.AnnotateRequestId(taskDef, requestId)
+	if err != nil {
+		logger.Error("failed-to-annotate-request-id", err)
+	}
+
+	if responseFormat != "" {
+		err = backend.AnnotateResponseFormat(taskDef, responseFormat)
+		if err != nil {
+			logger.Error("failed-to-annotate-response-format", err)
+		}
+	}
+
+	return taskDef, guid, domain, nil
+}
+
+// stageDryRun responds with the staging task that would be desired for the
+// request, without looking up, desiring or accounting for any task.
+func (handler *stagingHandler) stageDryRun(resp http.ResponseWriter, lifecycleBackend backend.Backend, stagingGuid, requestId, responseFormat string, stagingRequest cc_messages.StagingRequestFromCC, logger lager.Logger) {
+	taskDef, guid, domain, err := handler.buildTask(lifecycleBackend, stagingGuid, requestId, responseFormat, stagingRequest, logger)
+	if err != nil {
+		handler.doErrorResponse(resp, stagingGuid, err.Error())
+		return
+	}
+
+	logger.Info("dry-run", lager.Data{"task_guid": guid})
+
+	responseJson, err := json.Marshal(DryRunResponse{
+		TaskGuid:       guid,
+		Domain:         domain,
+		TaskDefinition: taskDef,
+	})
+	if err != nil {
+		logger.Error("failed-to-marshal-dry-run-response", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(responseJson)
+}
+
+// claim guards against concurrent requests for the same staging, e.g. when
+// CC retries a request that is still being processed, so that only one of
+// them desires the staging task.
+func (handler *stagingHandler) claim(stagingGuid string) bool {
+	handler.inFlightLock.Lock()
+	defer handler.inFlightLock.Unlock()
+
+	if _, ok := handler.inFlight[stagingGuid]; ok {
+		return false
+	}
+
+	handler.inFlight[stagingGuid] = struct{}{}
+	return true
+}
+
+func (handler *stagingHandler) unclaim(stagingGuid string) {
+	handler.inFlightLock.Lock()
+	defer handler.inFlightLock.Unlock()
+
+	delete(handler.inFlight, stagingGuid)
+}
+
+// storeDeadLetter stores a rejected staging request as it was received, with
+// its secrets redacted where the request can be parsed.
+func (handler *stagingHandler) storeDeadLetter(logger lager.Logger, stagingGuid string, reason error, rawRequest []byte) {
+	err := handler.deadLetters.Store(stagingGuid, reason.Error(), redactRawRequest(rawRequest))
+	if err != nil {
+		logger.Error("failed-to-store-dead-letter", err)
+	}
+}
+
+// redactedValue replaces the secrets of dead-lettered staging requests.
+const redactedValue = "[REDACTED]"
+
+// redactRawRequest blanks the values of the environment variables of a raw
+// staging request, and the docker registry password in its lifecycle data,
+// keeping every other field so that the request can still be told apart and
+// replayed. Requests that are not a JSON object, e.g. because they were cut
+// short, are kept as they are.
+func redactRawRequest(rawRequest []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(rawRequest))
+	decoder.UseNumber()
+
+	request := map[string]interface{}{}
+	err := decoder.Decode(&request)
+	if err != nil {
+		return rawRequest
+	}
+
+	if environment, ok := request["environment"].([]interface{}); ok {
+		for _, variable := range environment {
+			if variable, ok := variable.(map[string]interface{}); ok {
+				if _, ok := variable["value"]; ok {
+					variable["value"] = redactedValue
+				}
+			}
+		}
+	}
+
+	if lifecycleData, ok := request["lifecycle_data"].(map[string]interface{}); ok {
+		if _, ok := lifecycleData["docker_password"]; ok {
+			lifecycleData["docker_password"] = redactedValue
+		}
+	}
+
+	redacted, err := json.Marshal(request)
+	if err != nil {
+		return rawRequest
+	}
+	return redacted
+}
+
+func (handler *stagingHandler) doErrorResponse(resp http.ResponseWriter, stagingGuid, message string) {
+	handler.writeStagingError(resp, stagingGuid, http.StatusInternalServerError, message)
+}
+
+func (handler *stagingHandler) writeStagingError(resp http.ResponseWriter, stagingGuid string, statusCode int, message string) {
+	handler.writeStagingResponse(resp, stagingGuid, statusCode, cc_messages.StagingResponseForCC{
+		Error: backend.SanitizeErrorMessage(message),
+	})
+}
+
+// writeStagingResponse responds to a staging request that was turned away,
+// recording the error in the audit log.
+func (handler *stagingHandler) writeStagingResponse(resp http.ResponseWriter, stagingGuid string, statusCode int, response cc_messages.StagingResponseForCC) {
+	handler.auditLog.Rejected(stagingGuid, response.Error)
+
 	responseJson, _ := json.Marshal(response)
 
-	resp.WriteHeader(http.StatusInternalServerError)
+	resp.WriteHeader(statusCode)
 	resp.Write(responseJson)
 }
 
@@ -129,8 +564,7 @@ func (handler *stagingHandler) StopStaging(resp http.ResponseWriter, req *http.R
 		return
 	}
 
-	var annotation cc_messages.StagingTaskAnnotation
-	err = json.Unmarshal([]byte(task.Annotation), &annotation)
+	annotation, err := backend.DecodeAnnotation(task.Annotation)
 	if err != nil {
 		logger.Error("failed-to-unmarshal-task-annotation", err)
 		resp.WriteHeader(http.StatusInternalServerError)
@@ -140,7 +574,8 @@ func (handler *stagingHandler) StopStaging(resp http.ResponseWriter, req *http.R
 	resp.WriteHeader(http.StatusAccepted)
 	StagingStopRequestsReceivedCounter.Increment()
 
-	logger.Info("cancelling", lager.Data{"task_guid": taskGuid})
+	logger.Info("cancelling", lager.Data{"task_guid": taskGuid, "request-id": annotation.RequestId})
+	handler.retryBudget.Release(annotation.AppId, taskGuid)
 
 	err = handler.diegoClient.CancelTask(logger, taskGuid)
 	if err != nil {
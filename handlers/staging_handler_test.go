@@ -2,20 +2,42 @@ package handlers_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"time"
 
 	"code.cloudfoundry.org/bbs/fake_bbs"
 	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
 	"code.cloudfoundry.org/runtimeschema/cc_messages"
 	"code.cloudfoundry.org/stager/backend"
 	"code.cloudfoundry.org/stager/backend/fake_backend"
+	"code.cloudfoundry.org/stager/circuit_breaker"
+	"code.cloudfoundry.org/stager/dead_letter/fakes"
 	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/prometheus_metrics"
+	"code.cloudfoundry.org/stager/rate_limiter"
+	"code.cloudfoundry.org/stager/request_validation"
+	request_validation_fakes "code.cloudfoundry.org/stager/request_validation/fakes"
+	"code.cloudfoundry.org/stager/response_format"
+	"code.cloudfoundry.org/stager/retry_budget"
+	"code.cloudfoundry.org/stager/staging_audit"
+	"code.cloudfoundry.org/stager/staging_events"
+	event_fakes "code.cloudfoundry.org/stager/staging_events/fakes"
+	"code.cloudfoundry.org/stager/staging_limit"
+	limit_fakes "code.cloudfoundry.org/stager/staging_limit/fakes"
+	"code.cloudfoundry.org/stager/staging_queue"
+	queue_fakes "code.cloudfoundry.org/stager/staging_queue/fakes"
 	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
 	"github.com/cloudfoundry/dropsonde/metrics"
 
@@ -24,6 +46,29 @@ import (
 	"github.com/onsi/gomega/gbytes"
 )
 
+// endlessString is a never ending JSON string value, counting the bytes read
+// from it.
+type endlessString struct {
+	read int
+}
+
+func (s *endlessString) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	s.read += len(p)
+	return len(p), nil
+}
+
+func gzipped(data []byte) []byte {
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	_, err := writer.Write(data)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(writer.Close()).To(Succeed())
+	return buffer.Bytes()
+}
+
 var _ = Describe("StagingHandler", func() {
 
 	var (
@@ -32,6 +77,13 @@ var _ = Describe("StagingHandler", func() {
 		logger          lager.Logger
 		fakeDiegoClient *fake_bbs.FakeClient
 		fakeBackend     *fake_backend.FakeBackend
+		fakeDeadLetters *fakes.FakeSpool
+		fakeEmitter     *event_fakes.FakeEmitter
+		auditLog        staging_audit.Log
+		metricsRegistry *prometheus_metrics.Registry
+		validators      []request_validation.Validator
+		stagingQueue    staging_queue.Queue
+		stagingLimit    staging_limit.Limit
 
 		responseRecorder *httptest.ResponseRecorder
 		handler          handlers.StagingHandler
@@ -47,20 +99,35 @@ var _ = Describe("StagingHandler", func() {
 		fakeBackend.BuildRecipeReturns(&models.TaskDefinition{}, "", "", nil)
 
 		fakeDiegoClient = &fake_bbs.FakeClient{}
+		fakeDeadLetters = &fakes.FakeSpool{}
+		fakeEmitter = &event_fakes.FakeEmitter{}
+		auditLog = staging_audit.NewLog(10, clock.NewClock())
+		metricsRegistry = prometheus_metrics.NewRegistry()
+		validators = []request_validation.Validator{
+			request_validation.NewSizeLimitsValidator(backend.MaxEnvironmentVariables, 0, 0),
+		}
+		stagingQueue = staging_queue.NewQueue(logger, 0, 0)
+		stagingLimit = staging_limit.NewLimit(logger, 0)
 
 		responseRecorder = httptest.NewRecorder()
-		handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient)
+		handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, 0, 0)
 	})
 
 	Describe("Stage", func() {
 		var (
 			stagingRequestJson []byte
+			requestHeader      http.Header
 		)
 
+		BeforeEach(func() {
+			requestHeader = http.Header{}
+		})
+
 		JustBeforeEach(func() {
 			req, err := http.NewRequest("PUT", "/v1/staging/a-staging-guid", bytes.NewReader(stagingRequestJson))
 			Expect(err).NotTo(HaveOccurred())
 
+			req.Header = requestHeader
 			req.Form = url.Values{":staging_guid": {"a-staging-guid"}}
 
 			handler.Stage(responseRecorder, req)
@@ -82,6 +149,11 @@ var _ = Describe("StagingHandler", func() {
 
 			It("increments the counter to track arriving staging messages", func() {
 				Expect(fakeMetricSender.GetCounter("StagingStartRequestsReceived")).To(Equal(uint64(1)))
+				Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_start_requests_received_total 1\n"))
+			})
+
+			It("tracks the staging task as in flight", func() {
+				Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_tasks_in_flight 1\n"))
 			})
 
 			It("returns an Accepted response", func() {
@@ -96,6 +168,147 @@ var _ = Describe("StagingHandler", func() {
 				Expect(request).To(Equal(stagingRequest))
 			})
 
+			Context("when the request is larger than allowed", func() {
+				BeforeEach(func() {
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, len(stagingRequestJson)-1, 0)
+				})
+
+				It("turns the request away as too large", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+
+					var response cc_messages.StagingResponseForCC
+					err := json.Unmarshal(responseRecorder.Body.Bytes(), &response)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.Error.Id).To(Equal(backend.InvalidStagingRequest))
+					Expect(response.Error.Message).To(Equal(fmt.Sprintf("staging request too large: %d bytes (max %d)", len(stagingRequestJson), len(stagingRequestJson)-1)))
+				})
+
+				It("does not desire a task", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(BeZero())
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(BeZero())
+				})
+
+				It("turns away requests of unknown length once they are read past the limit", func() {
+					req, err := http.NewRequest("PUT", "/v1/staging/a-staging-guid", ioutil.NopCloser(bytes.NewReader(stagingRequestJson)))
+					Expect(err).NotTo(HaveOccurred())
+					req.Form = url.Values{":staging_guid": {"a-staging-guid"}}
+
+					recorder := httptest.NewRecorder()
+					handler.Stage(recorder, req)
+					Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+					Expect(recorder.Body.String()).To(ContainSubstring(fmt.Sprintf("staging request too large: more than %d bytes", len(stagingRequestJson)-1)))
+				})
+
+				It("stops reading bodies of unknown length past the limit", func() {
+					endless := &endlessString{}
+					body := io.MultiReader(strings.NewReader(`{"app_id":"`), endless)
+
+					req, err := http.NewRequest("PUT", "/v1/staging/a-staging-guid", ioutil.NopCloser(body))
+					Expect(err).NotTo(HaveOccurred())
+					req.Form = url.Values{":staging_guid": {"a-staging-guid"}}
+
+					recorder := httptest.NewRecorder()
+					handler.Stage(recorder, req)
+					Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+					Expect(recorder.Header().Get("Connection")).To(Equal("close"))
+					Expect(endless.read).To(BeNumerically("<=", len(stagingRequestJson)))
+				})
+			})
+
+			Context("when the request is as large as allowed", func() {
+				BeforeEach(func() {
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, len(stagingRequestJson), 0)
+				})
+
+				It("stages it", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+				})
+			})
+
+			Context("when the request is compressed with gzip", func() {
+				BeforeEach(func() {
+					stagingRequestJson = gzipped(stagingRequestJson)
+					requestHeader.Set("Content-Encoding", "gzip")
+				})
+
+				It("stages the decompressed request", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(1))
+					_, request := fakeBackend.BuildRecipeArgsForCall(0)
+					Expect(request).To(Equal(stagingRequest))
+				})
+
+				Context("when the decompressed request is larger than allowed", func() {
+					BeforeEach(func() {
+						requestJson, err := json.Marshal(stagingRequest)
+						Expect(err).NotTo(HaveOccurred())
+						handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, len(requestJson)-1, 0)
+					})
+
+					It("turns the request away as too large", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+						Expect(fakeBackend.BuildRecipeCallCount()).To(BeZero())
+					})
+				})
+			})
+
+			Context("when the request is compressed in an unsupported encoding", func() {
+				BeforeEach(func() {
+					requestHeader.Set("Content-Encoding", "zstd")
+				})
+
+				It("turns the request away", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusUnsupportedMediaType))
+
+					var response cc_messages.StagingResponseForCC
+					err := json.Unmarshal(responseRecorder.Body.Bytes(), &response)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.Error.Id).To(Equal(backend.InvalidStagingRequest))
+					Expect(response.Error.Message).To(Equal(`unsupported content encoding "zstd"`))
+					Expect(fakeBackend.BuildRecipeCallCount()).To(BeZero())
+				})
+			})
+
+			Context("in dry-run mode", func() {
+				BeforeEach(func() {
+					fakeBackend.BuildRecipeReturns(&models.TaskDefinition{RootFs: "preloaded:rabbit_hole"}, "a-guid", "a-domain", nil)
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, true, 0, 0)
+				})
+
+				It("responds with the task that would be desired", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+
+					var response handlers.DryRunResponse
+					err := json.Unmarshal(responseRecorder.Body.Bytes(), &response)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.TaskGuid).To(Equal("a-guid"))
+					Expect(response.Domain).To(Equal("a-domain"))
+					Expect(response.TaskDefinition.RootFs).To(Equal("preloaded:rabbit_hole"))
+				})
+
+				It("does not touch the BBS", func() {
+					Expect(fakeDiegoClient.TaskByGuidCallCount()).To(BeZero())
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(BeZero())
+				})
+
+				It("does not count the request as a staging", func() {
+					Expect(fakeMetricSender.GetCounter("StagingStartRequestsReceived")).To(BeZero())
+					Expect(fakeEmitter.EmitCallCount()).To(BeZero())
+				})
+
+				Context("when the recipe cannot be built", func() {
+					BeforeEach(func() {
+						fakeBackend.BuildRecipeReturns(nil, "", "", backend.ErrMissingAppId)
+					})
+
+					It("responds with an error", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+						Expect(fakeDiegoClient.DesireTaskCallCount()).To(BeZero())
+					})
+				})
+			})
+
 			Context("when the recipe was built successfully", func() {
 				var fakeTaskDef = &models.TaskDefinition{Annotation: "test annotation"}
 				BeforeEach(func() {
@@ -108,6 +321,28 @@ var _ = Describe("StagingHandler", func() {
 					Expect(resultingTaskDef).To(Equal(fakeTaskDef))
 				})
 
+				It("emits request received and task desired events", func() {
+					Expect(fakeEmitter.EmitCallCount()).To(Equal(2))
+
+					eventType, guid, data := fakeEmitter.EmitArgsForCall(0)
+					Expect(eventType).To(Equal(staging_events.RequestReceived))
+					Expect(guid).To(Equal("a-staging-guid"))
+					Expect(data).To(Equal(map[string]interface{}{"app_id": "myapp", "lifecycle": "fake-backend"}))
+
+					eventType, guid, _ = fakeEmitter.EmitArgsForCall(1)
+					Expect(eventType).To(Equal(staging_events.TaskDesired))
+					Expect(guid).To(Equal("a-staging-guid"))
+				})
+
+				It("records the request in the audit log as pending", func() {
+					entries := auditLog.Entries(staging_audit.Query{})
+					Expect(entries).To(HaveLen(1))
+					Expect(entries[0].StagingGuid).To(Equal("a-staging-guid"))
+					Expect(entries[0].AppId).To(Equal("myapp"))
+					Expect(entries[0].Lifecycle).To(Equal("fake-backend"))
+					Expect(entries[0].Outcome).To(Equal(staging_audit.Pending))
+				})
+
 				Context("when the task has already been created", func() {
 					BeforeEach(func() {
 						fakeDiegoClient.DesireTaskReturns(models.NewError(models.Error_ResourceExists, "ok, this task already exists"))
@@ -116,6 +351,77 @@ var _ = Describe("StagingHandler", func() {
 					It("does not log a failure", func() {
 						Expect(logger).NotTo(gbytes.Say("staging-failed"))
 					})
+
+					It("does not track the task as in flight again", func() {
+						Expect(exposition(metricsRegistry)).To(ContainSubstring("stager_staging_tasks_in_flight 0\n"))
+					})
+				})
+
+				Context("when the backend annotates the task", func() {
+					BeforeEach(func() {
+						annotation, err := backend.EncodeAnnotation(backend.StagingTaskAnnotation{
+							StagingTaskAnnotation: cc_messages.StagingTaskAnnotation{Lifecycle: "fake-backend"},
+						})
+						Expect(err).NotTo(HaveOccurred())
+						fakeBackend.BuildRecipeReturns(&models.TaskDefinition{Annotation: annotation}, "a-guid", "a-domain", nil)
+
+						requestHeader.Set("X-Vcap-Request-Id", "the-request-id")
+					})
+
+					It("records the request id in the task annotation", func() {
+						_, _, _, taskDef := fakeDiegoClient.DesireTaskArgsForCall(0)
+						annotation, err := backend.DecodeAnnotation(taskDef.Annotation)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(annotation.RequestId).To(Equal("the-request-id"))
+					})
+
+					It("logs the request id", func() {
+						Expect(logger).To(gbytes.Say(`"request-id":"the-request-id"`))
+					})
+
+					It("returns the request id", func() {
+						Expect(responseRecorder.Header().Get("X-Vcap-Request-Id")).To(Equal("the-request-id"))
+					})
+
+					Context("when the request selects a response format", func() {
+						BeforeEach(func() {
+							requestHeader.Set(response_format.FormatHeader, response_format.CAPIV3)
+						})
+
+						It("records the response format in the task annotation", func() {
+							_, _, _, taskDef := fakeDiegoClient.DesireTaskArgsForCall(0)
+							annotation, err := backend.DecodeAnnotation(taskDef.Annotation)
+							Expect(err).NotTo(HaveOccurred())
+							Expect(annotation.ResponseFormat).To(Equal(response_format.CAPIV3))
+						})
+					})
+
+					Context("when the request selects an unknown response format", func() {
+						BeforeEach(func() {
+							requestHeader.Set(response_format.FormatHeader, "warden")
+						})
+
+						It("rejects the request without desiring a task", func() {
+							Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+							Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+						})
+					})
+				})
+
+				Context("when the BBS circuit breaker is open", func() {
+					BeforeEach(func() {
+						fakeDiegoClient.DesireTaskReturns(&circuit_breaker.OpenError{RetryAfter: 1500 * time.Millisecond})
+					})
+
+					It("asks the cloud controller to retry once the breaker may close", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+						Expect(responseRecorder.Header().Get("Retry-After")).To(Equal("2"))
+
+						var response cc_messages.StagingResponseForCC
+						err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(response.Error.Id).To(Equal(backend.StagerBusy))
+					})
 				})
 
 				Context("create task fails for any other reason", func() {
@@ -155,6 +461,194 @@ var _ = Describe("StagingHandler", func() {
 				})
 			})
 
+			Context("when a staging task already exists for the staging guid", func() {
+				BeforeEach(func() {
+					fakeDiegoClient.TaskByGuidReturns(&models.Task{
+						TaskGuid: "a-staging-guid",
+						State:    models.Task_Running,
+					}, nil)
+				})
+
+				It("looks up the task by the staging guid", func() {
+					Expect(fakeDiegoClient.TaskByGuidCallCount()).To(Equal(1))
+					_, guid := fakeDiegoClient.TaskByGuidArgsForCall(0)
+					Expect(guid).To(Equal("a-staging-guid"))
+				})
+
+				It("does not create a duplicate task on Diego", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(0))
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+				})
+
+				It("returns an Accepted response", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusAccepted))
+				})
+			})
+
+			Context("when looking up an existing staging task fails", func() {
+				BeforeEach(func() {
+					fakeDiegoClient.TaskByGuidReturns(nil, models.ErrResourceNotFound)
+				})
+
+				It("creates the task on Diego", func() {
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(1))
+				})
+			})
+
+			Context("when staging requests arrive faster than allowed", func() {
+				BeforeEach(func() {
+					rateLimiter := rate_limiter.NewRateLimiter(1, 1, clock.NewClock())
+					Expect(rateLimiter.Allow()).To(BeTrue())
+
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rateLimiter, stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, 0, 0)
+				})
+
+				It("does not create a task on Diego", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(0))
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+				})
+
+				It("asks the cloud controller to retry later", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+					Expect(responseRecorder.Header().Get("Retry-After")).To(Equal("1"))
+
+					var response cc_messages.StagingResponseForCC
+					err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(response.Error).To(Equal(&cc_messages.StagingError{
+						Id:      backend.StagerBusy,
+						Message: "stager busy, retry later",
+					}))
+				})
+
+				It("records the rejection in the audit log", func() {
+					entries := auditLog.Entries(staging_audit.Query{})
+					Expect(entries).To(HaveLen(1))
+					Expect(entries[0].StagingGuid).To(Equal("a-staging-guid"))
+					Expect(entries[0].Outcome).To(Equal(staging_audit.Rejected))
+					Expect(entries[0].ErrorId).To(Equal(backend.StagerBusy))
+				})
+			})
+
+			Context("when the staging queue is full", func() {
+				var fakeQueue *queue_fakes.FakeQueue
+
+				BeforeEach(func() {
+					fakeQueue = &queue_fakes.FakeQueue{}
+					fakeQueue.SubmitReturns(staging_queue.ErrQueueFull)
+
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), fakeQueue, stagingLimit, fakeEmitter, auditLog, validators, false, 0, 0)
+				})
+
+				It("does not create a task on Diego", func() {
+					Expect(fakeQueue.SubmitCallCount()).To(Equal(1))
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(0))
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+				})
+
+				It("asks the cloud controller to retry later", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+					Expect(responseRecorder.Header().Get("Retry-After")).To(Equal("1"))
+
+					var response cc_messages.StagingResponseForCC
+					err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.Error.Id).To(Equal(backend.StagerBusy))
+				})
+			})
+
+			Context("when too many staging tasks are outstanding", func() {
+				var fakeLimit *limit_fakes.FakeLimit
+
+				BeforeEach(func() {
+					fakeBackend.BuildRecipeReturns(&models.TaskDefinition{}, "a-staging-guid", "", nil)
+
+					fakeLimit = &limit_fakes.FakeLimit{}
+					fakeLimit.AcquireReturns(false)
+
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, fakeLimit, fakeEmitter, auditLog, validators, false, 0, 0)
+				})
+
+				It("does not create a task on Diego", func() {
+					Expect(fakeLimit.AcquireCallCount()).To(Equal(1))
+					Expect(fakeLimit.AcquireArgsForCall(0)).To(Equal("a-staging-guid"))
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+				})
+
+				It("tells the cloud controller the platform is busy", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+					Expect(responseRecorder.Header().Get("Retry-After")).To(Equal("1"))
+
+					var response cc_messages.StagingResponseForCC
+					err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(response.Error).To(Equal(&cc_messages.StagingError{
+						Id:      backend.PlatformBusy,
+						Message: "platform busy, retry later",
+					}))
+				})
+
+				Context("when the task cannot be desired", func() {
+					BeforeEach(func() {
+						fakeLimit.AcquireReturns(true)
+						fakeDiegoClient.DesireTaskReturns(errors.New("boom"))
+					})
+
+					It("releases the slot of the staging task", func() {
+						Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(1))
+						Expect(fakeLimit.ReleaseCallCount()).To(Equal(1))
+						Expect(fakeLimit.ReleaseArgsForCall(0)).To(Equal("a-staging-guid"))
+					})
+				})
+			})
+
+			Context("when the staging is resubmitted under a retry budget", func() {
+				var retryBudget retry_budget.RetryBudget
+
+				BeforeEach(func() {
+					retryBudget = retry_budget.NewRetryBudget(2, 0, clock.NewClock())
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retryBudget, fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, 0, 0)
+				})
+
+				It("spends the budget of the staging for the task it desires", func() {
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(1))
+					Expect(retryBudget.Spend("myapp", "a-staging-guid")).To(Succeed())
+					Expect(retryBudget.Spend("myapp", "a-staging-guid")).To(Equal(retry_budget.ErrRetryBudgetExhausted))
+				})
+
+				It("does not spend the budget of the same staging guid for another app", func() {
+					Expect(retryBudget.Spend("other-app", "a-staging-guid")).To(Succeed())
+					Expect(retryBudget.Spend("other-app", "a-staging-guid")).To(Succeed())
+				})
+			})
+
+			Context("when the retry budget for the staging guid is exhausted", func() {
+				BeforeEach(func() {
+					retryBudget := retry_budget.NewRetryBudget(1, 0, clock.NewClock())
+					Expect(retryBudget.Spend("myapp", "a-staging-guid")).To(Succeed())
+
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retryBudget, fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, 0, 0)
+				})
+
+				It("does not create a task on Diego", func() {
+					Expect(fakeDiegoClient.DesireTaskCallCount()).To(Equal(0))
+				})
+
+				It("returns the retry budget exhausted error to the cloud controller", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+
+					var response cc_messages.StagingResponseForCC
+					err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(response.Error).To(Equal(&cc_messages.StagingError{
+						Id:      cc_messages.STAGING_ERROR,
+						Message: "retry budget exhausted",
+					}))
+				})
+			})
+
 			Context("when the recipe failed to be built", func() {
 				var buildRecipeError error
 
@@ -201,6 +695,74 @@ var _ = Describe("StagingHandler", func() {
 				It("returns bad request", func() {
 					Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
 				})
+
+				It("returns an invalid staging request error", func() {
+					var response cc_messages.StagingResponseForCC
+					err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(response.Error).To(Equal(&cc_messages.StagingError{
+						Id:      backend.InvalidStagingRequest,
+						Message: "malformed staging request",
+					}))
+				})
+
+				It("stores the request as it was received as a dead letter", func() {
+					Expect(fakeDeadLetters.StoreCallCount()).To(Equal(1))
+
+					stagingGuid, reason, payload := fakeDeadLetters.StoreArgsForCall(0)
+					Expect(stagingGuid).To(Equal("a-staging-guid"))
+					Expect(reason).NotTo(BeEmpty())
+					Expect(string(payload)).To(Equal("bad-json"))
+				})
+
+				Context("when the request is JSON of the wrong shape", func() {
+					BeforeEach(func() {
+						stagingRequestJson = []byte(`{
+							"app_id": "myapp",
+							"memory_mb": "lots",
+							"environment": [{"name": "SECRET", "value": "hunter2"}],
+							"lifecycle_data": {"docker_image": "busybox", "docker_password": "hunter2"}
+						}`)
+					})
+
+					It("stores the request with its secrets redacted as a dead letter", func() {
+						Expect(fakeDeadLetters.StoreCallCount()).To(Equal(1))
+
+						_, _, payload := fakeDeadLetters.StoreArgsForCall(0)
+						Expect(payload).To(MatchJSON(`{
+							"app_id": "myapp",
+							"memory_mb": "lots",
+							"environment": [{"name": "SECRET", "value": "[REDACTED]"}],
+							"lifecycle_data": {"docker_image": "busybox", "docker_password": "[REDACTED]"}
+						}`))
+					})
+				})
+
+				Context("when the request is larger than dead letters keep", func() {
+					BeforeEach(func() {
+						stagingRequestJson = []byte(`{"app_id": "myapp", "environment": [{"name": "SECRET", "value": "hunter2"}]`)
+						handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, 0, 10)
+					})
+
+					It("stores one byte more than dead letters keep, for the spool to cut short", func() {
+						Expect(fakeDeadLetters.StoreCallCount()).To(Equal(1))
+
+						_, _, payload := fakeDeadLetters.StoreArgsForCall(0)
+						Expect(string(payload)).To(Equal(`{"app_id": `))
+					})
+				})
+
+				Context("when storing the dead letter fails", func() {
+					BeforeEach(func() {
+						fakeDeadLetters.StoreReturns(errors.New("disk full"))
+					})
+
+					It("logs the failure and still returns bad request", func() {
+						Expect(logger).To(gbytes.Say("failed-to-store-dead-letter"))
+						Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+					})
+				})
 			})
 
 			Context("when a staging request is received for an unknown backend", func() {
@@ -218,6 +780,190 @@ var _ = Describe("StagingHandler", func() {
 				It("returns a Not Found response", func() {
 					Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
 				})
+
+				It("records the rejection in the audit log", func() {
+					entries := auditLog.Entries(staging_audit.Query{})
+					Expect(entries).To(HaveLen(1))
+					Expect(entries[0].Outcome).To(Equal(staging_audit.Rejected))
+					Expect(entries[0].ErrorId).To(Equal(backend.InvalidStagingRequest))
+				})
+
+				Context("with lifecycle data the validators cannot parse", func() {
+					var fakeValidator *request_validation_fakes.FakeValidator
+
+					BeforeEach(func() {
+						fakeValidator = new(request_validation_fakes.FakeValidator)
+						validators = []request_validation.Validator{fakeValidator}
+						handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, 0, 0)
+
+						lifecycleData := json.RawMessage(`["not", "an", "object"]`)
+						stagingRequestJson, _ = json.Marshal(cc_messages.StagingRequestFromCC{
+							AppId:         "myapp",
+							Lifecycle:     "unknown-backend",
+							LifecycleData: &lifecycleData,
+						})
+					})
+
+					It("does not validate the request", func() {
+						Expect(fakeValidator.ValidateCallCount()).To(BeZero())
+						Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+					})
+				})
+			})
+
+			Context("when a staging request has more environment variables than allowed", func() {
+				BeforeEach(func() {
+					environment := make([]*models.EnvironmentVariable, backend.MaxEnvironmentVariables+1)
+					for i := range environment {
+						environment[i] = &models.EnvironmentVariable{Name: "VAR", Value: "value"}
+					}
+
+					lifecycleData := json.RawMessage(`{"docker_image":"busybox","docker_user":"user","docker_password":"secret"}`)
+					stagingRequest := cc_messages.StagingRequestFromCC{
+						AppId:         "myapp",
+						Lifecycle:     "fake-backend",
+						Environment:   environment,
+						LifecycleData: &lifecycleData,
+					}
+
+					var err error
+					stagingRequestJson, err = json.Marshal(stagingRequest)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("returns a BadRequest error", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+				})
+
+				It("does not build a staging recipe", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(0))
+				})
+
+				It("returns an invalid staging request error", func() {
+					var response cc_messages.StagingResponseForCC
+					err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(response.Error).To(Equal(&cc_messages.StagingError{
+						Id:      backend.InvalidStagingRequest,
+						Message: "too many environment variables",
+					}))
+				})
+
+				It("stores the request with the environment redacted as a dead letter", func() {
+					Expect(fakeDeadLetters.StoreCallCount()).To(Equal(1))
+
+					_, reason, payload := fakeDeadLetters.StoreArgsForCall(0)
+					Expect(reason).To(Equal(backend.ErrTooManyEnvironmentVariables.Error()))
+					Expect(payload).NotTo(ContainSubstring(`"value"`))
+
+					var deadLetter cc_messages.StagingRequestFromCC
+					err := json.Unmarshal(payload, &deadLetter)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(deadLetter.AppId).To(Equal("myapp"))
+					Expect(deadLetter.Environment).To(HaveLen(backend.MaxEnvironmentVariables + 1))
+					Expect(deadLetter.Environment[0]).To(Equal(&models.EnvironmentVariable{Name: "VAR", Value: "[REDACTED]"}))
+					Expect(*deadLetter.LifecycleData).To(MatchJSON(`{"docker_image":"busybox","docker_user":"user","docker_password":"[REDACTED]"}`))
+				})
+
+				Context("when the request has fields the stager does not know", func() {
+					BeforeEach(func() {
+						var request map[string]interface{}
+						Expect(json.Unmarshal(stagingRequestJson, &request)).To(Succeed())
+						request["future_field"] = "kept"
+
+						var err error
+						stagingRequestJson, err = json.Marshal(request)
+						Expect(err).NotTo(HaveOccurred())
+					})
+
+					It("keeps them in the dead letter, for it to be replayed", func() {
+						_, _, payload := fakeDeadLetters.StoreArgsForCall(0)
+
+						var deadLetter map[string]interface{}
+						Expect(json.Unmarshal(payload, &deadLetter)).To(Succeed())
+						Expect(deadLetter).To(HaveKeyWithValue("future_field", "kept"))
+					})
+				})
+
+				Context("when the request is rejected under a retry budget", func() {
+					var retryBudget retry_budget.RetryBudget
+
+					BeforeEach(func() {
+						retryBudget = retry_budget.NewRetryBudget(2, 0, clock.NewClock())
+						handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retryBudget, fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, 0, 0)
+					})
+
+					It("spends the budget of the staging", func() {
+						Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+						Expect(retryBudget.Spend("myapp", "a-staging-guid")).To(Succeed())
+						Expect(retryBudget.Spend("myapp", "a-staging-guid")).To(Equal(retry_budget.ErrRetryBudgetExhausted))
+					})
+
+					Context("when the budget is exhausted", func() {
+						BeforeEach(func() {
+							Expect(retryBudget.Spend("myapp", "a-staging-guid")).To(Succeed())
+							Expect(retryBudget.Spend("myapp", "a-staging-guid")).To(Succeed())
+						})
+
+						It("returns the retry budget exhausted error to the cloud controller", func() {
+							Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+
+							var response cc_messages.StagingResponseForCC
+							err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+							Expect(err).NotTo(HaveOccurred())
+
+							Expect(response.Error).To(Equal(&cc_messages.StagingError{
+								Id:      cc_messages.STAGING_ERROR,
+								Message: "retry budget exhausted",
+							}))
+						})
+					})
+				})
+			})
+
+			Context("when a validator rejects the staging request", func() {
+				var fakeValidator *request_validation_fakes.FakeValidator
+
+				BeforeEach(func() {
+					fakeValidator = new(request_validation_fakes.FakeValidator)
+					fakeValidator.NameReturns("fake-validator")
+					fakeValidator.ValidateReturns(errors.New("stack not allowed: windows"))
+					validators = []request_validation.Validator{fakeValidator}
+
+					handler = handlers.NewStagingHandler(logger, map[string]backend.Backend{"fake-backend": fakeBackend}, fakeDiegoClient, retry_budget.NewRetryBudget(0, 0, clock.NewClock()), fakeDeadLetters, metricsRegistry, rate_limiter.NewRateLimiter(0, 0, clock.NewClock()), stagingQueue, stagingLimit, fakeEmitter, auditLog, validators, false, 0, 0)
+
+					stagingRequest := cc_messages.StagingRequestFromCC{
+						AppId:     "myapp",
+						Lifecycle: "fake-backend",
+					}
+
+					var err error
+					stagingRequestJson, err = json.Marshal(stagingRequest)
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("validates the request", func() {
+					Expect(fakeValidator.ValidateCallCount()).To(Equal(1))
+					Expect(fakeValidator.ValidateArgsForCall(0).AppId).To(Equal("myapp"))
+				})
+
+				It("does not build a staging recipe", func() {
+					Expect(fakeBackend.BuildRecipeCallCount()).To(Equal(0))
+				})
+
+				It("returns the rejection to the CC as an invalid staging request", func() {
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadRequest))
+
+					var response cc_messages.StagingResponseForCC
+					err := json.NewDecoder(responseRecorder.Body).Decode(&response)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(response.Error).To(Equal(&cc_messages.StagingError{
+						Id:      backend.InvalidStagingRequest,
+						Message: "stack not allowed: windows",
+					}))
+				})
 			})
 
 			Context("when a malformed staging request is received", func() {
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/api"
+	"code.cloudfoundry.org/stager/backend"
+)
+
+type StagingStatusHandler interface {
+	StagingStatus(resp http.ResponseWriter, req *http.Request)
+}
+
+type stagingStatusHandler struct {
+	logger      lager.Logger
+	diegoClient bbs.Client
+	domains     map[string]bool
+	backends    map[string]backend.Backend
+}
+
+func NewStagingStatusHandler(logger lager.Logger, bbsClient bbs.Client, stagingTaskDomains []string, backends map[string]backend.Backend) StagingStatusHandler {
+	domains := map[string]bool{}
+	for _, domain := range stagingTaskDomains {
+		domains[domain] = true
+	}
+
+	return &stagingStatusHandler{
+		logger:      logger.Session("staging-status-handler"),
+		diegoClient: bbsClient,
+		domains:     domains,
+		backends:    backends,
+	}
+}
+
+func (handler *stagingStatusHandler) StagingStatus(resp http.ResponseWriter, req *http.Request) {
+	taskGuid := req.FormValue(":staging_guid")
+	logger := handler.logger.Session("get-staging-status", lager.Data{"staging-guid": taskGuid})
+
+	task, err := handler.diegoClient.TaskByGuid(logger, taskGuid)
+	if err != nil {
+		if models.ErrResourceNotFound.Equal(err) {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		logger.Error("failed-to-get-task", err)
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if !handler.domains[task.Domain] {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	status := api.StagingStatus{
+		TaskGuid: task.TaskGuid,
+		State:    stagingState(task),
+	}
+
+	if status.State == api.StagingStateCompleted || status.State == api.StagingStateFailed {
+		status.Result, err = handler.stagingResult(task)
+		if err != nil {
+			logger.Error("failed-to-build-staging-response", err)
+			resp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	statusJson, err := json.Marshal(status)
+	if err != nil {
+		logger.Error("marshal-status-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(statusJson)
+}
+
+func (handler *stagingStatusHandler) stagingResult(task *models.Task) (*cc_messages.StagingResponseForCC, error) {
+	annotation, err := backend.DecodeAnnotation(task.Annotation)
+	if err != nil {
+		return nil, err
+	}
+
+	lifecycleBackend, ok := handler.backends[annotation.Lifecycle]
+	if !ok {
+		return nil, backend.ErrMissingLifecycleData
+	}
+
+	response, err := lifecycleBackend.BuildStagingResponse(&models.TaskCallbackResponse{
+		TaskGuid:      task.TaskGuid,
+		Failed:        task.Failed,
+		FailureReason: task.FailureReason,
+		Result:        task.Result,
+		Annotation:    task.Annotation,
+		CreatedAt:     task.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+func stagingState(task *models.Task) string {
+	switch task.State {
+	case models.Task_Pending:
+		return api.StagingStatePending
+	case models.Task_Running:
+		return api.StagingStateRunning
+	}
+
+	if task.Failed {
+		return api.StagingStateFailed
+	}
+	return api.StagingStateCompleted
+}
@@ -0,0 +1,193 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/api"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/backend/fake_backend"
+	"code.cloudfoundry.org/stager/handlers"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StagingStatusHandler", func() {
+	var (
+		fakeDiegoClient  *fake_bbs.FakeClient
+		fakeBackend      *fake_backend.FakeBackend
+		responseRecorder *httptest.ResponseRecorder
+		task             *models.Task
+	)
+
+	BeforeEach(func() {
+		fakeDiegoClient = &fake_bbs.FakeClient{}
+		fakeBackend = &fake_backend.FakeBackend{}
+		responseRecorder = httptest.NewRecorder()
+
+		task = &models.Task{
+			TaskGuid: "a-staging-guid",
+			Domain:   cc_messages.StagingTaskDomain,
+			State:    models.Task_Running,
+			TaskDefinition: &models.TaskDefinition{
+				Annotation: `{"lifecycle":"fake","app_id":"the-app-id"}`,
+			},
+		}
+		fakeDiegoClient.TaskByGuidStub = func(_ lager.Logger, _ string) (*models.Task, error) {
+			return task, nil
+		}
+	})
+
+	JustBeforeEach(func() {
+		req, err := http.NewRequest("GET", "/v1/staging/a-staging-guid", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Form = url.Values{":staging_guid": {"a-staging-guid"}}
+
+		handler := handlers.NewStagingStatusHandler(lagertest.NewTestLogger("test"), fakeDiegoClient, []string{cc_messages.StagingTaskDomain, "windows-staging"}, map[string]backend.Backend{"fake": fakeBackend})
+		handler.StagingStatus(responseRecorder, req)
+	})
+
+	decodeStatus := func() api.StagingStatus {
+		var status api.StagingStatus
+		err := json.Unmarshal(responseRecorder.Body.Bytes(), &status)
+		Expect(err).NotTo(HaveOccurred())
+		return status
+	}
+
+	It("looks up the task by its guid", func() {
+		Expect(fakeDiegoClient.TaskByGuidCallCount()).To(Equal(1))
+		_, guid := fakeDiegoClient.TaskByGuidArgsForCall(0)
+		Expect(guid).To(Equal("a-staging-guid"))
+	})
+
+	Context("when the task is running", func() {
+		It("returns the state without a result", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			Expect(responseRecorder.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(decodeStatus()).To(Equal(api.StagingStatus{
+				TaskGuid: "a-staging-guid",
+				State:    api.StagingStateRunning,
+			}))
+			Expect(fakeBackend.BuildStagingResponseCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the task is pending", func() {
+		BeforeEach(func() {
+			task.State = models.Task_Pending
+		})
+
+		It("returns the pending state", func() {
+			Expect(decodeStatus().State).To(Equal(api.StagingStatePending))
+		})
+	})
+
+	Context("when the task has completed", func() {
+		var result json.RawMessage
+
+		BeforeEach(func() {
+			task.State = models.Task_Completed
+			task.Result = `{"detected_buildpack":"ruby"}`
+			result = json.RawMessage(`{"lifecycle_type":"fake"}`)
+			fakeBackend.BuildStagingResponseReturns(cc_messages.StagingResponseForCC{Result: &result}, nil)
+		})
+
+		It("returns the staging response the backend builds from the task", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+
+			status := decodeStatus()
+			Expect(status.State).To(Equal(api.StagingStateCompleted))
+			Expect(status.Result).NotTo(BeNil())
+			Expect(*status.Result.Result).To(MatchJSON(result))
+
+			Expect(fakeBackend.BuildStagingResponseCallCount()).To(Equal(1))
+			taskResponse := fakeBackend.BuildStagingResponseArgsForCall(0)
+			Expect(taskResponse.TaskGuid).To(Equal("a-staging-guid"))
+			Expect(taskResponse.Result).To(Equal(task.Result))
+			Expect(taskResponse.Annotation).To(Equal(task.Annotation))
+		})
+
+		Context("when the backend cannot build the staging response", func() {
+			BeforeEach(func() {
+				fakeBackend.BuildStagingResponseReturns(cc_messages.StagingResponseForCC{}, errors.New("bad result"))
+			})
+
+			It("returns an internal server error", func() {
+				Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+			})
+		})
+	})
+
+	Context("when the task has failed", func() {
+		BeforeEach(func() {
+			task.State = models.Task_Resolving
+			task.Failed = true
+			task.FailureReason = "boom"
+			fakeBackend.BuildStagingResponseReturns(cc_messages.StagingResponseForCC{
+				Error: &cc_messages.StagingError{Id: cc_messages.STAGING_ERROR, Message: "staging failed"},
+			}, nil)
+		})
+
+		It("returns the failed state with the staging error", func() {
+			status := decodeStatus()
+			Expect(status.State).To(Equal(api.StagingStateFailed))
+			Expect(status.Result.Error.Message).To(Equal("staging failed"))
+
+			taskResponse := fakeBackend.BuildStagingResponseArgsForCall(0)
+			Expect(taskResponse.Failed).To(BeTrue())
+			Expect(taskResponse.FailureReason).To(Equal("boom"))
+		})
+	})
+
+	Context("when the task is in another configured staging domain", func() {
+		BeforeEach(func() {
+			task.Domain = "windows-staging"
+		})
+
+		It("returns its state", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			Expect(decodeStatus().State).To(Equal(api.StagingStateRunning))
+		})
+	})
+
+	Context("when the task is not a staging task", func() {
+		BeforeEach(func() {
+			task.Domain = "some-other-domain"
+		})
+
+		It("returns not found", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("when the task does not exist", func() {
+		BeforeEach(func() {
+			fakeDiegoClient.TaskByGuidStub = nil
+			fakeDiegoClient.TaskByGuidReturns(nil, models.ErrResourceNotFound)
+		})
+
+		It("returns not found", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("when the BBS cannot be reached", func() {
+		BeforeEach(func() {
+			fakeDiegoClient.TaskByGuidStub = nil
+			fakeDiegoClient.TaskByGuidReturns(nil, errors.New("connection refused"))
+		})
+
+		It("returns service unavailable", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
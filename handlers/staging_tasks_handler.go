@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/api"
+	"code.cloudfoundry.org/stager/backend"
+)
+
+type StagingTasksHandler interface {
+	StagingTasks(resp http.ResponseWriter, req *http.Request)
+}
+
+type stagingTasksHandler struct {
+	logger      lager.Logger
+	diegoClient bbs.Client
+	domains     []string
+	clock       clock.Clock
+}
+
+func NewStagingTasksHandler(logger lager.Logger, bbsClient bbs.Client, stagingTaskDomains []string, clock clock.Clock) StagingTasksHandler {
+	return &stagingTasksHandler{
+		logger:      logger.Session("staging-tasks-handler"),
+		diegoClient: bbsClient,
+		domains:     stagingTaskDomains,
+		clock:       clock,
+	}
+}
+
+func (handler *stagingTasksHandler) StagingTasks(resp http.ResponseWriter, req *http.Request) {
+	logger := handler.logger.Session("list-staging-tasks")
+
+	tasks := []*models.Task{}
+	for _, domain := range handler.domains {
+		domainTasks, err := handler.diegoClient.TasksByDomain(logger, domain)
+		if err != nil {
+			logger.Error("fetching-tasks-failed", err, lager.Data{"domain": domain})
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		tasks = append(tasks, domainTasks...)
+	}
+
+	now := handler.clock.Now()
+	stagingTasks := make([]api.StagingTask, 0, len(tasks))
+	for _, task := range tasks {
+		stagingTask := api.StagingTask{
+			TaskGuid:     task.TaskGuid,
+			State:        task.State.String(),
+			AgeInSeconds: int64(now.Sub(time.Unix(0, task.CreatedAt)) / time.Second),
+		}
+
+		if task.TaskDefinition != nil {
+			annotation, err := backend.DecodeAnnotation(task.Annotation)
+			if err != nil {
+				logger.Debug("parsing-annotation-failed", lager.Data{"task-guid": task.TaskGuid, "error": err.Error()})
+			}
+			stagingTask.AppId = annotation.AppId
+		}
+
+		stagingTasks = append(stagingTasks, stagingTask)
+	}
+
+	tasksJson, err := json.Marshal(stagingTasks)
+	if err != nil {
+		logger.Error("marshal-tasks-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(tasksJson)
+}
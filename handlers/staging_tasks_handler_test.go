@@ -0,0 +1,121 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/api"
+	"code.cloudfoundry.org/stager/handlers"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StagingTasksHandler", func() {
+	var (
+		fakeDiegoClient  *fake_bbs.FakeClient
+		fakeClock        *fakeclock.FakeClock
+		domains          []string
+		responseRecorder *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		fakeDiegoClient = &fake_bbs.FakeClient{}
+		fakeClock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+		domains = []string{cc_messages.StagingTaskDomain}
+		responseRecorder = httptest.NewRecorder()
+	})
+
+	JustBeforeEach(func() {
+		req, err := http.NewRequest("GET", "/v1/staging_tasks", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		handler := handlers.NewStagingTasksHandler(lagertest.NewTestLogger("test"), fakeDiegoClient, domains, fakeClock)
+		handler.StagingTasks(responseRecorder, req)
+	})
+
+	Context("when there are staging tasks", func() {
+		BeforeEach(func() {
+			fakeDiegoClient.TasksByDomainReturns([]*models.Task{
+				{
+					TaskGuid:  "running-task",
+					State:     models.Task_Running,
+					CreatedAt: time.Unix(970, 0).UnixNano(),
+					TaskDefinition: &models.TaskDefinition{
+						Annotation: `{"lifecycle":"buildpack","app_id":"the-app-id"}`,
+					},
+				},
+				{
+					TaskGuid:       "pending-task",
+					State:          models.Task_Pending,
+					CreatedAt:      time.Unix(995, 0).UnixNano(),
+					TaskDefinition: &models.TaskDefinition{},
+				},
+			}, nil)
+		})
+
+		It("lists the tasks in the staging domain", func() {
+			Expect(fakeDiegoClient.TasksByDomainCallCount()).To(Equal(1))
+			_, domain := fakeDiegoClient.TasksByDomainArgsForCall(0)
+			Expect(domain).To(Equal(cc_messages.StagingTaskDomain))
+
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			Expect(responseRecorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			var tasks []api.StagingTask
+			err := json.Unmarshal(responseRecorder.Body.Bytes(), &tasks)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tasks).To(Equal([]api.StagingTask{
+				{AppId: "the-app-id", TaskGuid: "running-task", State: "Running", AgeInSeconds: 30},
+				{TaskGuid: "pending-task", State: "Pending", AgeInSeconds: 5},
+			}))
+		})
+	})
+
+	Context("when several staging task domains are configured", func() {
+		BeforeEach(func() {
+			domains = []string{cc_messages.StagingTaskDomain, "windows-staging"}
+			fakeDiegoClient.TasksByDomainStub = func(logger lager.Logger, domain string) ([]*models.Task, error) {
+				return []*models.Task{{TaskGuid: domain + "-task", State: models.Task_Running, CreatedAt: time.Unix(990, 0).UnixNano()}}, nil
+			}
+		})
+
+		It("lists the tasks in every domain", func() {
+			Expect(fakeDiegoClient.TasksByDomainCallCount()).To(Equal(2))
+
+			var tasks []api.StagingTask
+			err := json.Unmarshal(responseRecorder.Body.Bytes(), &tasks)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tasks).To(Equal([]api.StagingTask{
+				{TaskGuid: cc_messages.StagingTaskDomain + "-task", State: "Running", AgeInSeconds: 10},
+				{TaskGuid: "windows-staging-task", State: "Running", AgeInSeconds: 10},
+			}))
+		})
+	})
+
+	Context("when there are no staging tasks", func() {
+		It("responds with an empty list", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			Expect(responseRecorder.Body.String()).To(Equal("[]"))
+		})
+	})
+
+	Context("when the BBS fails", func() {
+		BeforeEach(func() {
+			fakeDiegoClient.TasksByDomainReturns(nil, errors.New("boom"))
+		})
+
+		It("responds with a 503", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
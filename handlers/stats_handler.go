@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/stats"
+)
+
+type StatsHandler interface {
+	Stats(resp http.ResponseWriter, req *http.Request)
+}
+
+type statsHandler struct {
+	logger       lager.Logger
+	stagingStats stats.Stats
+}
+
+func NewStatsHandler(logger lager.Logger, stagingStats stats.Stats) StatsHandler {
+	return &statsHandler{
+		logger:       logger.Session("stats-handler"),
+		stagingStats: stagingStats,
+	}
+}
+
+func (handler *statsHandler) Stats(resp http.ResponseWriter, req *http.Request) {
+	summaryJson, err := json.Marshal(handler.stagingStats.Summarize())
+	if err != nil {
+		handler.logger.Error("marshal-stats-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(summaryJson)
+}
@@ -0,0 +1,57 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/handlers"
+	"code.cloudfoundry.org/stager/stats"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StatsHandler", func() {
+	var (
+		fakeClock        *fakeclock.FakeClock
+		stagingStats     stats.Stats
+		responseRecorder *httptest.ResponseRecorder
+		handler          handlers.StatsHandler
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		stagingStats = stats.NewStats([]time.Duration{time.Hour}, fakeClock)
+		stagingStats.Record(stats.Sample{
+			Lifecycle:   "buildpack",
+			Stack:       "cflinuxfs2",
+			Duration:    time.Minute,
+			CompletedAt: fakeClock.Now(),
+		})
+
+		responseRecorder = httptest.NewRecorder()
+		handler = handlers.NewStatsHandler(lagertest.NewTestLogger("test"), stagingStats)
+	})
+
+	JustBeforeEach(func() {
+		req, err := http.NewRequest("GET", "/v1/stats", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		handler.Stats(responseRecorder, req)
+	})
+
+	It("responds with the staging statistics summary", func() {
+		Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+		Expect(responseRecorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var summary stats.Summary
+		err := json.NewDecoder(responseRecorder.Body).Decode(&summary)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(summary).To(Equal(stagingStats.Summarize()))
+	})
+})
@@ -0,0 +1,84 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/health"
+)
+
+type FakeCheck struct {
+	NameStub        func() string
+	nameMutex       sync.RWMutex
+	nameArgsForCall []struct{}
+	nameReturns     struct {
+		result1 string
+	}
+	CheckStub        func(logger lager.Logger) error
+	checkMutex       sync.RWMutex
+	checkArgsForCall []struct {
+		logger lager.Logger
+	}
+	checkReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeCheck) Name() string {
+	fake.nameMutex.Lock()
+	fake.nameArgsForCall = append(fake.nameArgsForCall, struct{}{})
+	fake.nameMutex.Unlock()
+	if fake.NameStub != nil {
+		return fake.NameStub()
+	} else {
+		return fake.nameReturns.result1
+	}
+}
+
+func (fake *FakeCheck) NameCallCount() int {
+	fake.nameMutex.RLock()
+	defer fake.nameMutex.RUnlock()
+	return len(fake.nameArgsForCall)
+}
+
+func (fake *FakeCheck) NameReturns(result1 string) {
+	fake.NameStub = nil
+	fake.nameReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeCheck) Check(logger lager.Logger) error {
+	fake.checkMutex.Lock()
+	fake.checkArgsForCall = append(fake.checkArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.checkMutex.Unlock()
+	if fake.CheckStub != nil {
+		return fake.CheckStub(logger)
+	} else {
+		return fake.checkReturns.result1
+	}
+}
+
+func (fake *FakeCheck) CheckCallCount() int {
+	fake.checkMutex.RLock()
+	defer fake.checkMutex.RUnlock()
+	return len(fake.checkArgsForCall)
+}
+
+func (fake *FakeCheck) CheckArgsForCall(i int) lager.Logger {
+	fake.checkMutex.RLock()
+	defer fake.checkMutex.RUnlock()
+	return fake.checkArgsForCall[i].logger
+}
+
+func (fake *FakeCheck) CheckReturns(result1 error) {
+	fake.CheckStub = nil
+	fake.checkReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ health.Check = new(FakeCheck)
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const Path = "/health"
+
+type CheckResult struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Healthy bool                   `json:"healthy"`
+	Checks  map[string]CheckResult `json:"checks"`
+}
+
+type handler struct {
+	logger lager.Logger
+	checks []Check
+}
+
+// NewHandler serves a report of all checks at /health. It responds with 200
+// when every check passes, and 503 otherwise.
+func NewHandler(logger lager.Logger, checks []Check) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(Path, &handler{
+		logger: logger.Session("health"),
+		checks: checks,
+	})
+	return mux
+}
+
+func (h *handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	logger := h.logger.Session("check")
+
+	report := Report{
+		Healthy: true,
+		Checks:  map[string]CheckResult{},
+	}
+
+	for _, check := range h.checks {
+		result := CheckResult{Healthy: true}
+
+		err := check.Check(logger)
+		if err != nil {
+			logger.Error("check-failed", err, lager.Data{"check": check.Name()})
+			result = CheckResult{Healthy: false, Error: err.Error()}
+			report.Healthy = false
+		}
+
+		report.Checks[check.Name()] = result
+	}
+
+	reportJson, err := json.Marshal(report)
+	if err != nil {
+		logger.Error("marshal-report-failed", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	if report.Healthy {
+		resp.WriteHeader(http.StatusOK)
+	} else {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}
+	resp.Write(reportJson)
+}
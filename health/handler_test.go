@@ -0,0 +1,95 @@
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/health"
+	"code.cloudfoundry.org/stager/health/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	var (
+		natsCheck        *fakes.FakeCheck
+		bbsCheck         *fakes.FakeCheck
+		responseRecorder *httptest.ResponseRecorder
+		path             string
+	)
+
+	BeforeEach(func() {
+		natsCheck = &fakes.FakeCheck{}
+		natsCheck.NameReturns("nats")
+		bbsCheck = &fakes.FakeCheck{}
+		bbsCheck.NameReturns("bbs")
+
+		responseRecorder = httptest.NewRecorder()
+		path = "/health"
+	})
+
+	JustBeforeEach(func() {
+		req, err := http.NewRequest("GET", path, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		handler := health.NewHandler(lagertest.NewTestLogger("test"), []health.Check{natsCheck, bbsCheck})
+		handler.ServeHTTP(responseRecorder, req)
+	})
+
+	decodeReport := func() health.Report {
+		var report health.Report
+		err := json.NewDecoder(responseRecorder.Body).Decode(&report)
+		Expect(err).NotTo(HaveOccurred())
+		return report
+	}
+
+	Context("when every check passes", func() {
+		It("responds with 200 and the results", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			Expect(responseRecorder.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(decodeReport()).To(Equal(health.Report{
+				Healthy: true,
+				Checks: map[string]health.CheckResult{
+					"nats": {Healthy: true},
+					"bbs":  {Healthy: true},
+				},
+			}))
+		})
+	})
+
+	Context("when a check fails", func() {
+		BeforeEach(func() {
+			bbsCheck.CheckReturns(errors.New("no bbs"))
+		})
+
+		It("responds with 503 and the failure", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(decodeReport()).To(Equal(health.Report{
+				Healthy: false,
+				Checks: map[string]health.CheckResult{
+					"nats": {Healthy: true},
+					"bbs":  {Healthy: false, Error: "no bbs"},
+				},
+			}))
+		})
+
+		It("still runs the other checks", func() {
+			Expect(natsCheck.CheckCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when requesting another path", func() {
+		BeforeEach(func() {
+			path = "/v1/staging"
+		})
+
+		It("responds with 404", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+			Expect(bbsCheck.CheckCallCount()).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,79 @@
+package health
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/lager"
+)
+
+var (
+	ErrNATSDisconnected = errors.New("not connected to NATS")
+	ErrBBSUnreachable   = errors.New("unable to reach the BBS")
+	ErrNotWatching      = errors.New("not watching task events")
+)
+
+// Check verifies that a dependency of the stager is functional.
+//
+//go:generate counterfeiter -o fakes/fake_check.go . Check
+type Check interface {
+	Name() string
+	Check(logger lager.Logger) error
+}
+
+type NATSConnection interface {
+	IsConnected() bool
+}
+
+type WatchStatus interface {
+	Watching() bool
+}
+
+type funcCheck struct {
+	name string
+	fn   func(logger lager.Logger) error
+}
+
+// NewFuncCheck wraps a function as a check.
+func NewFuncCheck(name string, fn func(logger lager.Logger) error) Check {
+	return &funcCheck{name: name, fn: fn}
+}
+
+func (c *funcCheck) Name() string {
+	return c.name
+}
+
+func (c *funcCheck) Check(logger lager.Logger) error {
+	return c.fn(logger)
+}
+
+// NewNATSCheck fails while the connection to NATS is down.
+func NewNATSCheck(conn NATSConnection) Check {
+	return NewFuncCheck("nats", func(lager.Logger) error {
+		if !conn.IsConnected() {
+			return ErrNATSDisconnected
+		}
+		return nil
+	})
+}
+
+// NewBBSCheck fails when the BBS does not answer a ping.
+func NewBBSCheck(bbsClient bbs.Client) Check {
+	return NewFuncCheck("bbs", func(logger lager.Logger) error {
+		if !bbsClient.Ping(logger) {
+			return ErrBBSUnreachable
+		}
+		return nil
+	})
+}
+
+// NewWatchCheck fails while the task watcher is not subscribed to task
+// events.
+func NewWatchCheck(status WatchStatus) Check {
+	return NewFuncCheck("task_watch", func(lager.Logger) error {
+		if !status.Watching() {
+			return ErrNotWatching
+		}
+		return nil
+	})
+}
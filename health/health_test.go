@@ -0,0 +1,82 @@
+package health_test
+
+import (
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/health"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeNATSConnection struct {
+	connected bool
+}
+
+func (c *fakeNATSConnection) IsConnected() bool {
+	return c.connected
+}
+
+type fakeWatchStatus struct {
+	watching bool
+}
+
+func (s *fakeWatchStatus) Watching() bool {
+	return s.watching
+}
+
+var _ = Describe("Checks", func() {
+	var logger *lagertest.TestLogger
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+	})
+
+	Describe("NATS", func() {
+		It("passes while connected", func() {
+			check := health.NewNATSCheck(&fakeNATSConnection{connected: true})
+			Expect(check.Name()).To(Equal("nats"))
+			Expect(check.Check(logger)).To(Succeed())
+		})
+
+		It("fails while disconnected", func() {
+			check := health.NewNATSCheck(&fakeNATSConnection{connected: false})
+			Expect(check.Check(logger)).To(Equal(health.ErrNATSDisconnected))
+		})
+	})
+
+	Describe("BBS", func() {
+		var fakeBBSClient *fake_bbs.FakeClient
+
+		BeforeEach(func() {
+			fakeBBSClient = &fake_bbs.FakeClient{}
+		})
+
+		It("passes when the BBS answers a ping", func() {
+			fakeBBSClient.PingReturns(true)
+			check := health.NewBBSCheck(fakeBBSClient)
+			Expect(check.Name()).To(Equal("bbs"))
+			Expect(check.Check(logger)).To(Succeed())
+			Expect(fakeBBSClient.PingCallCount()).To(Equal(1))
+		})
+
+		It("fails when the BBS does not answer a ping", func() {
+			fakeBBSClient.PingReturns(false)
+			check := health.NewBBSCheck(fakeBBSClient)
+			Expect(check.Check(logger)).To(Equal(health.ErrBBSUnreachable))
+		})
+	})
+
+	Describe("task watch", func() {
+		It("passes while watching", func() {
+			check := health.NewWatchCheck(&fakeWatchStatus{watching: true})
+			Expect(check.Name()).To(Equal("task_watch"))
+			Expect(check.Check(logger)).To(Succeed())
+		})
+
+		It("fails while not watching", func() {
+			check := health.NewWatchCheck(&fakeWatchStatus{watching: false})
+			Expect(check.Check(logger)).To(Equal(health.ErrNotWatching))
+		})
+	})
+})
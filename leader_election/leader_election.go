@@ -0,0 +1,66 @@
+package leader_election
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+type leaderRunner struct {
+	logger lager.Logger
+	lock   ifrit.Runner
+	runner ifrit.Runner
+}
+
+// New returns a runner that only runs the given runner while holding the
+// lock, so that of several stagers only the leader runs it. The returned
+// runner is ready immediately. When the lock is lost the runner is stopped
+// and the lock contended for again, letting another stager take over.
+func New(logger lager.Logger, lock, runner ifrit.Runner) ifrit.Runner {
+	return &leaderRunner{
+		logger: logger.Session("leader-election"),
+		lock:   lock,
+		runner: runner,
+	}
+}
+
+func (r *leaderRunner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	for {
+		r.logger.Info("waiting-for-lock")
+		lockProcess := ifrit.Background(r.lock)
+
+		select {
+		case <-lockProcess.Ready():
+		case err := <-lockProcess.Wait():
+			r.logger.Error("lock-failed", err)
+			return err
+		case signal := <-signals:
+			lockProcess.Signal(signal)
+			<-lockProcess.Wait()
+			return nil
+		}
+
+		r.logger.Info("acquired-lock")
+		process := ifrit.Background(r.runner)
+
+		select {
+		case err := <-process.Wait():
+			lockProcess.Signal(os.Interrupt)
+			<-lockProcess.Wait()
+			return err
+		case err := <-lockProcess.Wait():
+			r.logger.Error("lost-lock", err)
+			process.Signal(os.Interrupt)
+			<-process.Wait()
+		case signal := <-signals:
+			process.Signal(signal)
+			<-process.Wait()
+			lockProcess.Signal(signal)
+			<-lockProcess.Wait()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package leader_election_test
+
+import (
+	"errors"
+	"os"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/leader_election"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Leader election", func() {
+	var (
+		acquire      chan struct{}
+		lose         chan error
+		runnerStarts chan struct{}
+		runnerStops  chan struct{}
+		runnerExit   chan error
+
+		process ifrit.Process
+	)
+
+	BeforeEach(func() {
+		acquire = make(chan struct{})
+		lose = make(chan error)
+		runnerStarts = make(chan struct{}, 10)
+		runnerStops = make(chan struct{}, 10)
+		runnerExit = make(chan error)
+
+		lock := ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+			select {
+			case <-acquire:
+				close(ready)
+			case <-signals:
+				return nil
+			}
+
+			select {
+			case err := <-lose:
+				return err
+			case <-signals:
+				return nil
+			}
+		})
+
+		runner := ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+			runnerStarts <- struct{}{}
+			close(ready)
+
+			select {
+			case err := <-runnerExit:
+				return err
+			case <-signals:
+				runnerStops <- struct{}{}
+				return nil
+			}
+		})
+
+		process = ifrit.Background(leader_election.New(lagertest.NewTestLogger("test"), lock, runner))
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("is ready without holding the lock", func() {
+		Eventually(process.Ready()).Should(BeClosed())
+		Consistently(runnerStarts).ShouldNot(Receive())
+	})
+
+	It("runs the runner once it holds the lock", func() {
+		acquire <- struct{}{}
+		Eventually(runnerStarts).Should(Receive())
+	})
+
+	Context("when the lock is lost", func() {
+		BeforeEach(func() {
+			acquire <- struct{}{}
+			Eventually(runnerStarts).Should(Receive())
+			lose <- errors.New("session expired")
+		})
+
+		It("stops the runner and contends for the lock again", func() {
+			Eventually(runnerStops).Should(Receive())
+			Consistently(runnerStarts).ShouldNot(Receive())
+
+			acquire <- struct{}{}
+			Eventually(runnerStarts).Should(Receive())
+		})
+	})
+
+	Context("when the runner exits", func() {
+		BeforeEach(func() {
+			acquire <- struct{}{}
+			Eventually(runnerStarts).Should(Receive())
+			runnerExit <- errors.New("boom")
+		})
+
+		It("releases the lock and exits with the runner's error", func() {
+			Eventually(process.Wait()).Should(Receive(MatchError("boom")))
+		})
+	})
+
+	Context("when signalled", func() {
+		BeforeEach(func() {
+			acquire <- struct{}{}
+			Eventually(runnerStarts).Should(Receive())
+			process.Signal(os.Interrupt)
+		})
+
+		It("stops the runner and exits", func() {
+			Eventually(runnerStops).Should(Receive())
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+		})
+	})
+})
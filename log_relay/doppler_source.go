@@ -0,0 +1,50 @@
+package log_relay
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/uaa_client"
+	"github.com/cloudfoundry/noaa/consumer"
+	sonde_events "github.com/cloudfoundry/sonde-go/events"
+)
+
+type dopplerSource struct {
+	dopplerURL string
+	uaaClient  uaa_client.Client
+	tlsConfig  *tls.Config
+}
+
+// NewDopplerSource returns a source that tails app logs from the doppler
+// endpoint of the loggregator traffic controller, authenticating with a
+// token from the UAA client.
+func NewDopplerSource(dopplerURL string, uaaClient uaa_client.Client, skipCertVerify bool) Source {
+	return &dopplerSource{
+		dopplerURL: dopplerURL,
+		uaaClient:  uaaClient,
+		tlsConfig: &tls.Config{
+			InsecureSkipVerify: skipCertVerify,
+			MinVersion:         tls.VersionTLS10,
+		},
+	}
+}
+
+func (s *dopplerSource) Tail(logger lager.Logger, appId string) (<-chan *sonde_events.LogMessage, io.Closer, error) {
+	token, err := s.uaaClient.Token(logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := consumer.New(s.dopplerURL, s.tlsConfig, http.ProxyFromEnvironment)
+	messages, errs := c.TailingLogs(appId, "bearer "+token)
+
+	go func() {
+		for err := range errs {
+			logger.Error("tailing-logs-failed", err, lager.Data{"app-id": appId})
+		}
+	}()
+
+	return messages, c, nil
+}
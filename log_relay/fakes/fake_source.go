@@ -0,0 +1,62 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"io"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/log_relay"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+type FakeSource struct {
+	TailStub        func(logger lager.Logger, appId string) (<-chan *events.LogMessage, io.Closer, error)
+	tailMutex       sync.RWMutex
+	tailArgsForCall []struct {
+		logger lager.Logger
+		appId  string
+	}
+	tailReturns struct {
+		result1 <-chan *events.LogMessage
+		result2 io.Closer
+		result3 error
+	}
+}
+
+func (fake *FakeSource) Tail(logger lager.Logger, appId string) (<-chan *events.LogMessage, io.Closer, error) {
+	fake.tailMutex.Lock()
+	fake.tailArgsForCall = append(fake.tailArgsForCall, struct {
+		logger lager.Logger
+		appId  string
+	}{logger, appId})
+	fake.tailMutex.Unlock()
+	if fake.TailStub != nil {
+		return fake.TailStub(logger, appId)
+	} else {
+		return fake.tailReturns.result1, fake.tailReturns.result2, fake.tailReturns.result3
+	}
+}
+
+func (fake *FakeSource) TailCallCount() int {
+	fake.tailMutex.RLock()
+	defer fake.tailMutex.RUnlock()
+	return len(fake.tailArgsForCall)
+}
+
+func (fake *FakeSource) TailArgsForCall(i int) (lager.Logger, string) {
+	fake.tailMutex.RLock()
+	defer fake.tailMutex.RUnlock()
+	return fake.tailArgsForCall[i].logger, fake.tailArgsForCall[i].appId
+}
+
+func (fake *FakeSource) TailReturns(result1 <-chan *events.LogMessage, result2 io.Closer, result3 error) {
+	fake.TailStub = nil
+	fake.tailReturns = struct {
+		result1 <-chan *events.LogMessage
+		result2 io.Closer
+		result3 error
+	}{result1, result2, result3}
+}
+
+var _ log_relay.Source = new(FakeSource)
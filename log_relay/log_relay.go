@@ -0,0 +1,298 @@
+package log_relay
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/events"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/backend"
+	sonde_events "github.com/cloudfoundry/sonde-go/events"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	// StagingLogSourceType is the source type of the logs the staging task
+	// emits, see backend.TaskLogSource.
+	StagingLogSourceType = backend.TaskLogSource
+
+	ResubscribeInterval = time.Second
+
+	// Metrics
+	stagingLogsRelayed = metric.Counter("StagingLogsRelayed")
+)
+
+// Source tails the logs of an app. The logs are streamed until the returned
+// closer is closed.
+//
+//go:generate counterfeiter -o fakes/fake_source.go . Source
+type Source interface {
+	Tail(logger lager.Logger, appId string) (<-chan *sonde_events.LogMessage, io.Closer, error)
+}
+
+// Publisher is the part of a NATS connection used to publish logs.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// LogLine is a staging log line as published on the per-staging subject.
+type LogLine struct {
+	StagingGuid    string `json:"staging_guid"`
+	AppId          string `json:"app_id"`
+	Timestamp      int64  `json:"timestamp"`
+	MessageType    string `json:"message_type"`
+	SourceInstance string `json:"source_instance,omitempty"`
+	Message        string `json:"message"`
+}
+
+type relay struct {
+	logger        lager.Logger
+	bbsClient     bbs.Client
+	domains       map[string]bool
+	source        Source
+	publisher     Publisher
+	subjectPrefix string
+	clock         clock.Clock
+
+	tails map[string]io.Closer
+}
+
+// Subject is the NATS subject on which the logs of a staging are published.
+func Subject(subjectPrefix, stagingGuid string) string {
+	return subjectPrefix + "." + stagingGuid
+}
+
+// NewRelay returns a runner that follows BBS task events and, while a
+// staging task in one of the given domains runs, re-publishes the staging
+// logs of its app on the subject returned by Subject so that clients can tail
+// a single staging.
+func NewRelay(logger lager.Logger, bbsClient bbs.Client, domains []string, source Source, publisher Publisher, subjectPrefix string, clock clock.Clock) ifrit.Runner {
+	domainSet := map[string]bool{}
+	for _, domain := range domains {
+		domainSet[domain] = true
+	}
+
+	return &relay{
+		logger:        logger.Session("log-relay"),
+		bbsClient:     bbsClient,
+		domains:       domainSet,
+		source:        source,
+		publisher:     publisher,
+		subjectPrefix: subjectPrefix,
+		clock:         clock,
+		tails:         map[string]io.Closer{},
+	}
+}
+
+func (r *relay) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+	defer r.stopAll()
+
+	for {
+		eventSource, err := r.bbsClient.SubscribeToTaskEvents(r.logger)
+		if err != nil {
+			r.logger.Error("failed-subscribing-to-task-events", err)
+
+			if r.wait(signals) {
+				return nil
+			}
+			continue
+		}
+
+		r.reconcile()
+
+		if r.watch(eventSource, signals) {
+			return nil
+		}
+	}
+}
+
+// wait waits for ResubscribeInterval, reporting whether the relay was
+// signalled meanwhile.
+func (r *relay) wait(signals <-chan os.Signal) bool {
+	timer := r.clock.NewTimer(ResubscribeInterval)
+	defer timer.Stop()
+
+	select {
+	case <-signals:
+		return true
+	case <-timer.C():
+		return false
+	}
+}
+
+// reconcile brings the tails in line with the staging tasks running now, as
+// tasks may have started or finished while the relay was not subscribed to
+// task events.
+func (r *relay) reconcile() {
+	running := map[string]*models.Task{}
+	for domain := range r.domains {
+		tasks, err := r.bbsClient.TasksByDomain(r.logger, domain)
+		if err != nil {
+			r.logger.Error("failed-to-fetch-staging-tasks", err, lager.Data{"domain": domain})
+			return
+		}
+
+		for _, task := range tasks {
+			if task.State == models.Task_Running {
+				running[task.TaskGuid] = task
+			}
+		}
+	}
+
+	for stagingGuid := range r.tails {
+		if _, ok := running[stagingGuid]; !ok {
+			r.stop(stagingGuid)
+		}
+	}
+
+	for _, task := range running {
+		r.start(task)
+	}
+}
+
+func (r *relay) watch(eventSource events.EventSource, signals <-chan os.Signal) bool {
+	defer eventSource.Close()
+
+	eventChan := make(chan models.Event)
+	errChan := make(chan error, 1)
+
+	// Closing the event source when the watch ends unblocks the reader, and
+	// done stops it from waiting on events nobody reads anymore.
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			event, err := eventSource.Next()
+			if err != nil {
+				errChan <- err
+				return
+			}
+
+			select {
+			case eventChan <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-signals:
+			return true
+		case err := <-errChan:
+			r.logger.Error("task-event-stream-failed", err)
+			return false
+		case event := <-eventChan:
+			r.handleEvent(event)
+		}
+	}
+}
+
+func (r *relay) handleEvent(event models.Event) {
+	switch event := event.(type) {
+	case *models.TaskChangedEvent:
+		task := event.After
+		if task == nil || !r.domains[task.Domain] {
+			return
+		}
+
+		switch task.State {
+		case models.Task_Running:
+			r.start(task)
+		case models.Task_Completed, models.Task_Resolving:
+			r.stop(task.TaskGuid)
+		}
+	case *models.TaskRemovedEvent:
+		if event.Task != nil {
+			r.stop(event.Task.TaskGuid)
+		}
+	}
+}
+
+func (r *relay) start(task *models.Task) {
+	if _, ok := r.tails[task.TaskGuid]; ok || task.TaskDefinition == nil {
+		return
+	}
+
+	logger := r.logger.Session("relay", lager.Data{"staging-guid": task.TaskGuid})
+
+	annotation, err := backend.DecodeAnnotation(task.Annotation)
+	if err != nil {
+		logger.Error("parsing-annotation-failed", err)
+		return
+	}
+
+	messages, closer, err := r.source.Tail(logger, annotation.AppId)
+	if err != nil {
+		logger.Error("failed-to-tail-logs", err, lager.Data{"app-id": annotation.AppId})
+		return
+	}
+
+	logger.Info("started", lager.Data{"app-id": annotation.AppId})
+	r.tails[task.TaskGuid] = closer
+
+	go r.forward(logger, task.TaskGuid, annotation.AppId, messages)
+}
+
+func (r *relay) forward(logger lager.Logger, stagingGuid, appId string, messages <-chan *sonde_events.LogMessage) {
+	subject := Subject(r.subjectPrefix, stagingGuid)
+
+	for message := range messages {
+		if message.GetSourceType() != StagingLogSourceType {
+			continue
+		}
+
+		payload, err := json.Marshal(LogLine{
+			StagingGuid:    stagingGuid,
+			AppId:          appId,
+			Timestamp:      message.GetTimestamp(),
+			MessageType:    message.GetMessageType().String(),
+			SourceInstance: message.GetSourceInstance(),
+			Message:        string(message.GetMessage()),
+		})
+		if err != nil {
+			logger.Error("failed-to-marshal-log-line", err)
+			continue
+		}
+
+		err = r.publisher.Publish(subject, payload)
+		if err != nil {
+			logger.Error("failed-to-publish-log-line", err)
+			continue
+		}
+
+		stagingLogsRelayed.Increment()
+	}
+}
+
+func (r *relay) stop(stagingGuid string) {
+	closer, ok := r.tails[stagingGuid]
+	if !ok {
+		return
+	}
+
+	delete(r.tails, stagingGuid)
+
+	err := closer.Close()
+	if err != nil {
+		r.logger.Error("failed-to-stop-tailing-logs", err, lager.Data{"staging-guid": stagingGuid})
+		return
+	}
+
+	r.logger.Info("stopped-relay", lager.Data{"staging-guid": stagingGuid})
+}
+
+func (r *relay) stopAll() {
+	for stagingGuid := range r.tails {
+		r.stop(stagingGuid)
+	}
+}
@@ -0,0 +1,13 @@
+package log_relay_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLogRelay(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Log Relay Suite")
+}
@@ -0,0 +1,254 @@
+package log_relay_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs/events/eventfakes"
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/log_relay"
+	"code.cloudfoundry.org/stager/log_relay/fakes"
+	publisher_fakes "code.cloudfoundry.org/stager/route_registrar/fakes"
+	sonde_events "github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+var _ = Describe("LogRelay", func() {
+	var (
+		fakeBBSClient   *fake_bbs.FakeClient
+		fakeEventSource *eventfakes.FakeEventSource
+		fakeSource      *fakes.FakeSource
+		fakePublisher   *publisher_fakes.FakePublisher
+		fakeClock       *fakeclock.FakeClock
+
+		events   chan models.Event
+		messages chan *sonde_events.LogMessage
+		closed   chan struct{}
+		process  ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeEventSource = &eventfakes.FakeEventSource{}
+		fakeSource = &fakes.FakeSource{}
+		fakePublisher = &publisher_fakes.FakePublisher{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		events = make(chan models.Event, 10)
+		fakeEventSource.NextStub = func() (models.Event, error) {
+			event, ok := <-events
+			if !ok {
+				return nil, errors.New("closed")
+			}
+			return event, nil
+		}
+		fakeBBSClient.SubscribeToTaskEventsReturns(fakeEventSource, nil)
+
+		messages = make(chan *sonde_events.LogMessage, 10)
+		closed = make(chan struct{}, 10)
+		fakeSource.TailStub = func(lager.Logger, string) (<-chan *sonde_events.LogMessage, io.Closer, error) {
+			return messages, closerFunc(func() error {
+				closed <- struct{}{}
+				return nil
+			}), nil
+		}
+	})
+
+	JustBeforeEach(func() {
+		relay := log_relay.NewRelay(lagertest.NewTestLogger("test"), fakeBBSClient, []string{cc_messages.StagingTaskDomain, "windows-staging"}, fakeSource, fakePublisher, "staging.logs", fakeClock)
+		process = ifrit.Invoke(relay)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	stagingTask := func(state models.Task_State) *models.Task {
+		return &models.Task{
+			TaskGuid: "the-staging-guid",
+			Domain:   cc_messages.StagingTaskDomain,
+			State:    state,
+			TaskDefinition: &models.TaskDefinition{
+				Annotation: `{"lifecycle":"buildpack","app_id":"the-app-id"}`,
+			},
+		}
+	}
+
+	logMessage := func(sourceType, message string) *sonde_events.LogMessage {
+		return &sonde_events.LogMessage{
+			Message:        []byte(message),
+			MessageType:    sonde_events.LogMessage_OUT.Enum(),
+			Timestamp:      proto.Int64(42),
+			SourceType:     proto.String(sourceType),
+			SourceInstance: proto.String("0"),
+		}
+	}
+
+	Context("when a staging task starts running", func() {
+		JustBeforeEach(func() {
+			events <- models.NewTaskChangedEvent(stagingTask(models.Task_Pending), stagingTask(models.Task_Running))
+			Eventually(fakeSource.TailCallCount).Should(Equal(1))
+		})
+
+		It("tails the logs of the app", func() {
+			_, appId := fakeSource.TailArgsForCall(0)
+			Expect(appId).To(Equal("the-app-id"))
+		})
+
+		It("publishes the staging logs on the staging subject", func() {
+			messages <- logMessage("APP", "not a staging log")
+			messages <- logMessage(log_relay.StagingLogSourceType, "-----> Downloaded app package")
+
+			Eventually(fakePublisher.PublishCallCount).Should(Equal(1))
+			Consistently(fakePublisher.PublishCallCount).Should(Equal(1))
+
+			subject, payload := fakePublisher.PublishArgsForCall(0)
+			Expect(subject).To(Equal(log_relay.Subject("staging.logs", "the-staging-guid")))
+
+			var line log_relay.LogLine
+			Expect(json.Unmarshal(payload, &line)).To(Succeed())
+			Expect(line).To(Equal(log_relay.LogLine{
+				StagingGuid:    "the-staging-guid",
+				AppId:          "the-app-id",
+				Timestamp:      42,
+				MessageType:    "OUT",
+				SourceInstance: "0",
+				Message:        "-----> Downloaded app package",
+			}))
+		})
+
+		It("stops tailing when the task completes", func() {
+			events <- models.NewTaskChangedEvent(stagingTask(models.Task_Running), stagingTask(models.Task_Completed))
+			Eventually(closed).Should(Receive())
+		})
+
+		It("stops tailing when the task is removed", func() {
+			events <- models.NewTaskRemovedEvent(stagingTask(models.Task_Running))
+			Eventually(closed).Should(Receive())
+		})
+
+		It("stops tailing when signalled", func() {
+			process.Signal(os.Interrupt)
+			Eventually(closed).Should(Receive())
+		})
+
+		It("does not tail the same task twice", func() {
+			events <- models.NewTaskChangedEvent(stagingTask(models.Task_Running), stagingTask(models.Task_Running))
+			Consistently(fakeSource.TailCallCount).Should(Equal(1))
+		})
+	})
+
+	Context("when a staging task in another configured domain starts running", func() {
+		JustBeforeEach(func() {
+			before, after := stagingTask(models.Task_Pending), stagingTask(models.Task_Running)
+			before.Domain, after.Domain = "windows-staging", "windows-staging"
+			events <- models.NewTaskChangedEvent(before, after)
+		})
+
+		It("tails its logs", func() {
+			Eventually(fakeSource.TailCallCount).Should(Equal(1))
+		})
+	})
+
+	Context("when a task in another domain starts running", func() {
+		JustBeforeEach(func() {
+			before, after := stagingTask(models.Task_Pending), stagingTask(models.Task_Running)
+			before.Domain, after.Domain = "other-domain", "other-domain"
+			events <- models.NewTaskChangedEvent(before, after)
+		})
+
+		It("does not tail its logs", func() {
+			Consistently(fakeSource.TailCallCount).Should(Equal(0))
+		})
+	})
+
+	Context("when a staging task is already running when the relay subscribes", func() {
+		BeforeEach(func() {
+			fakeBBSClient.TasksByDomainStub = func(_ lager.Logger, domain string) ([]*models.Task, error) {
+				if domain == cc_messages.StagingTaskDomain {
+					return []*models.Task{stagingTask(models.Task_Running)}, nil
+				}
+				return nil, nil
+			}
+		})
+
+		It("tails its logs", func() {
+			Eventually(fakeSource.TailCallCount).Should(Equal(1))
+			_, appId := fakeSource.TailArgsForCall(0)
+			Expect(appId).To(Equal("the-app-id"))
+		})
+	})
+
+	Context("when the task event stream fails while a task is tailed", func() {
+		var failStream chan struct{}
+
+		BeforeEach(func() {
+			failStream = make(chan struct{})
+			fakeEventSource.NextStub = func() (models.Event, error) {
+				select {
+				case event := <-events:
+					return event, nil
+				case <-failStream:
+					return nil, errors.New("stream dropped")
+				}
+			}
+		})
+
+		JustBeforeEach(func() {
+			events <- models.NewTaskChangedEvent(stagingTask(models.Task_Pending), stagingTask(models.Task_Running))
+			Eventually(fakeSource.TailCallCount).Should(Equal(1))
+		})
+
+		Context("when the task completed before the relay resubscribed", func() {
+			It("stops tailing it", func() {
+				fakeBBSClient.TasksByDomainReturns([]*models.Task{}, nil)
+				failStream <- struct{}{}
+
+				Eventually(closed).Should(Receive())
+			})
+		})
+
+		Context("when the task is still running", func() {
+			It("keeps tailing it", func() {
+				fakeBBSClient.TasksByDomainReturns([]*models.Task{stagingTask(models.Task_Running)}, nil)
+				failStream <- struct{}{}
+
+				Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+				Consistently(closed).ShouldNot(Receive())
+				Expect(fakeSource.TailCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Context("when subscribing to task events fails", func() {
+		BeforeEach(func() {
+			fakeBBSClient.SubscribeToTaskEventsReturns(nil, errors.New("bbs down"))
+		})
+
+		It("resubscribes after an interval", func() {
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(1))
+
+			fakeClock.WaitForWatcherAndIncrement(log_relay.ResubscribeInterval)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+		})
+	})
+})
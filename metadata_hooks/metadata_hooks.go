@@ -0,0 +1,231 @@
+package metadata_hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Metadata is the part of a successful staging result that hooks may
+// rewrite before it is delivered to the Cloud Controller.
+// DetectedStartCommand holds the command of every process type, not only
+// web.
+type Metadata struct {
+	ExecutionMetadata    map[string]interface{} `json:"execution_metadata"`
+	DetectedStartCommand map[string]string      `json:"detected_start_command"`
+	Sidecars             []Sidecar              `json:"sidecars,omitempty"`
+}
+
+// Sidecar is a process that newer buildpack lifecycles run alongside some of
+// the process types of the app.
+type Sidecar struct {
+	Name         string   `json:"name"`
+	ProcessTypes []string `json:"process_types"`
+	Command      string   `json:"command"`
+	MemoryMB     int      `json:"memory,omitempty"`
+}
+
+// Hook post-processes the execution metadata and detected start command of a
+// staging result, e.g. to derive the start command from the app's files
+// instead of the buildpack release output.
+type Hook interface {
+	Name() string
+	Process(stagingGuid string, metadata *Metadata) error
+}
+
+// Apply runs each hook in order over the staging result.
+func Apply(logger lager.Logger, hooks []Hook, stagingGuid string, result *json.RawMessage) (*json.RawMessage, error) {
+	if len(hooks) == 0 || result == nil {
+		return result, nil
+	}
+
+	var resultFields map[string]json.RawMessage
+	err := json.Unmarshal(*result, &resultFields)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := decodeMetadata(resultFields)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hook := range hooks {
+		err := hook.Process(stagingGuid, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("metadata hook %s failed: %s", hook.Name(), err)
+		}
+		logger.Debug("applied-metadata-hook", lager.Data{"hook": hook.Name()})
+	}
+
+	err = encodeMetadata(metadata, resultFields)
+	if err != nil {
+		return nil, err
+	}
+
+	processedJson, err := json.Marshal(resultFields)
+	if err != nil {
+		return nil, err
+	}
+
+	processed := json.RawMessage(processedJson)
+	return &processed, nil
+}
+
+// The execution metadata is itself a JSON document, sent to the Cloud
+// Controller as a string.
+func decodeMetadata(resultFields map[string]json.RawMessage) (*Metadata, error) {
+	metadata := &Metadata{
+		ExecutionMetadata:    map[string]interface{}{},
+		DetectedStartCommand: map[string]string{},
+	}
+
+	if raw, ok := resultFields["execution_metadata"]; ok {
+		var executionMetadataJson string
+		err := json.Unmarshal(raw, &executionMetadataJson)
+		if err != nil {
+			return nil, err
+		}
+
+		if executionMetadataJson != "" {
+			err = json.Unmarshal([]byte(executionMetadataJson), &metadata.ExecutionMetadata)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	startCommands, ok := resultFields["detected_start_command"]
+	if !ok {
+		startCommands, ok = resultFields["process_types"]
+	}
+	if ok {
+		err := json.Unmarshal(startCommands, &metadata.DetectedStartCommand)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if raw, ok := resultFields["sidecars"]; ok {
+		err := json.Unmarshal(raw, &metadata.Sidecars)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return metadata, nil
+}
+
+func encodeMetadata(metadata *Metadata, resultFields map[string]json.RawMessage) error {
+	executionMetadataJson, err := json.Marshal(metadata.ExecutionMetadata)
+	if err != nil {
+		return err
+	}
+
+	resultFields["execution_metadata"], err = json.Marshal(string(executionMetadataJson))
+	if err != nil {
+		return err
+	}
+
+	startCommandsJson, err := json.Marshal(metadata.DetectedStartCommand)
+	if err != nil {
+		return err
+	}
+
+	resultFields["detected_start_command"] = startCommandsJson
+	if _, ok := resultFields["process_types"]; ok {
+		resultFields["process_types"] = startCommandsJson
+	}
+
+	delete(resultFields, "sidecars")
+	if len(metadata.Sidecars) > 0 {
+		resultFields["sidecars"], err = json.Marshal(metadata.Sidecars)
+	}
+	return err
+}
+
+type funcHook struct {
+	name    string
+	process func(stagingGuid string, metadata *Metadata) error
+}
+
+// NewFuncHook returns a hook that calls the given function.
+func NewFuncHook(name string, process func(stagingGuid string, metadata *Metadata) error) Hook {
+	return &funcHook{name: name, process: process}
+}
+
+func (h *funcHook) Name() string {
+	return h.name
+}
+
+func (h *funcHook) Process(stagingGuid string, metadata *Metadata) error {
+	return h.process(stagingGuid, metadata)
+}
+
+type execHook struct {
+	path    string
+	timeout time.Duration
+}
+
+// NewExecHook returns a hook that runs an operator provided executable with
+// the staging guid as its only argument. The metadata is written to its stdin
+// as JSON, and the executable must write the processed metadata to stdout.
+func NewExecHook(path string, timeout time.Duration) Hook {
+	return &execHook{path: path, timeout: timeout}
+}
+
+func (h *execHook) Name() string {
+	return h.path
+}
+
+func (h *execHook) Process(stagingGuid string, metadata *Metadata) error {
+	input, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.Command(h.path, stagingGuid)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	if h.timeout > 0 {
+		timer := time.AfterFunc(h.timeout, func() {
+			cmd.Process.Kill()
+		})
+		defer timer.Stop()
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	processed := Metadata{}
+	err = json.Unmarshal(stdout.Bytes(), &processed)
+	if err != nil {
+		return err
+	}
+
+	if processed.ExecutionMetadata == nil {
+		processed.ExecutionMetadata = map[string]interface{}{}
+	}
+	if processed.DetectedStartCommand == nil {
+		processed.DetectedStartCommand = map[string]string{}
+	}
+
+	*metadata = processed
+	return nil
+}
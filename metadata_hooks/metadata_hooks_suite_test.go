@@ -0,0 +1,13 @@
+package metadata_hooks_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestMetadataHooks(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Metadata Hooks Suite")
+}
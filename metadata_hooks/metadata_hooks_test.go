@@ -0,0 +1,204 @@
+package metadata_hooks_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/metadata_hooks"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Apply", func() {
+	var (
+		hooks     []metadata_hooks.Hook
+		result    *json.RawMessage
+		processed *json.RawMessage
+		err       error
+	)
+
+	BeforeEach(func() {
+		raw := json.RawMessage(`{
+			"detected_buildpack": "ruby",
+			"execution_metadata": "{\"start_command\":\"rackup\"}",
+			"detected_start_command": {"web": "rackup"}
+		}`)
+		result = &raw
+		hooks = nil
+	})
+
+	JustBeforeEach(func() {
+		processed, err = metadata_hooks.Apply(lagertest.NewTestLogger("test"), hooks, "staging-guid", result)
+	})
+
+	Context("when there are no hooks", func() {
+		It("returns the result unchanged", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(processed).To(Equal(result))
+		})
+	})
+
+	Context("when there are hooks", func() {
+		var stagingGuids []string
+
+		BeforeEach(func() {
+			stagingGuids = nil
+			hooks = []metadata_hooks.Hook{
+				metadata_hooks.NewFuncHook("procfile", func(stagingGuid string, metadata *metadata_hooks.Metadata) error {
+					stagingGuids = append(stagingGuids, stagingGuid)
+					metadata.ExecutionMetadata["start_command"] = "bundle exec puma"
+					metadata.DetectedStartCommand["web"] = "bundle exec puma"
+					return nil
+				}),
+				metadata_hooks.NewFuncHook("ports", func(stagingGuid string, metadata *metadata_hooks.Metadata) error {
+					stagingGuids = append(stagingGuids, stagingGuid)
+					metadata.ExecutionMetadata["ports"] = []int{8080}
+					return nil
+				}),
+			}
+		})
+
+		It("runs the hooks in order over the execution metadata and start command", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stagingGuids).To(Equal([]string{"staging-guid", "staging-guid"}))
+			Expect(string(*processed)).To(MatchJSON(`{
+				"detected_buildpack": "ruby",
+				"execution_metadata": "{\"ports\":[8080],\"start_command\":\"bundle exec puma\"}",
+				"detected_start_command": {"web": "bundle exec puma"}
+			}`))
+		})
+	})
+
+	Context("when the result lists process types and sidecars", func() {
+		BeforeEach(func() {
+			raw := json.RawMessage(`{
+				"execution_metadata": "",
+				"process_types": {"web": "rackup", "worker": "sidekiq"},
+				"sidecars": [{"name": "envoy", "process_types": ["web"], "command": "envoy", "memory": 64}]
+			}`)
+			result = &raw
+			hooks = []metadata_hooks.Hook{
+				metadata_hooks.NewFuncHook("worker", func(stagingGuid string, metadata *metadata_hooks.Metadata) error {
+					Expect(metadata.DetectedStartCommand).To(Equal(map[string]string{"web": "rackup", "worker": "sidekiq"}))
+					Expect(metadata.Sidecars).To(Equal([]metadata_hooks.Sidecar{
+						{Name: "envoy", ProcessTypes: []string{"web"}, Command: "envoy", MemoryMB: 64},
+					}))
+
+					metadata.DetectedStartCommand["worker"] = "bundle exec sidekiq"
+					return nil
+				}),
+			}
+		})
+
+		It("passes every process type and sidecar to the hooks and preserves them", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(*processed)).To(MatchJSON(`{
+				"execution_metadata": "{}",
+				"detected_start_command": {"web": "rackup", "worker": "bundle exec sidekiq"},
+				"process_types": {"web": "rackup", "worker": "bundle exec sidekiq"},
+				"sidecars": [{"name": "envoy", "process_types": ["web"], "command": "envoy", "memory": 64}]
+			}`))
+		})
+	})
+
+	Context("when a hook fails", func() {
+		BeforeEach(func() {
+			hooks = []metadata_hooks.Hook{
+				metadata_hooks.NewFuncHook("broken", func(string, *metadata_hooks.Metadata) error {
+					return errors.New("boom")
+				}),
+			}
+		})
+
+		It("returns an error naming the hook", func() {
+			Expect(err).To(MatchError("metadata hook broken failed: boom"))
+		})
+	})
+
+	Context("when the execution metadata is not valid JSON", func() {
+		BeforeEach(func() {
+			raw := json.RawMessage(`{"execution_metadata": "{"}`)
+			result = &raw
+			hooks = []metadata_hooks.Hook{
+				metadata_hooks.NewFuncHook("noop", func(string, *metadata_hooks.Metadata) error { return nil }),
+			}
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("ExecHook", func() {
+	var (
+		tmpDir   string
+		metadata *metadata_hooks.Metadata
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "metadata-hooks")
+		Expect(err).NotTo(HaveOccurred())
+
+		metadata = &metadata_hooks.Metadata{
+			ExecutionMetadata:    map[string]interface{}{"start_command": "rackup"},
+			DetectedStartCommand: map[string]string{"web": "rackup"},
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	writeScript := func(body string) string {
+		path := filepath.Join(tmpDir, "hook")
+		err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755)
+		Expect(err).NotTo(HaveOccurred())
+		return path
+	}
+
+	It("replaces the metadata with the output of the executable", func() {
+		path := writeScript(`echo "{\"execution_metadata\":{\"staging_guid\":\"$1\"},\"detected_start_command\":{\"web\":\"./start\"}}"`)
+
+		err := metadata_hooks.NewExecHook(path, time.Second).Process("staging-guid", metadata)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*metadata).To(Equal(metadata_hooks.Metadata{
+			ExecutionMetadata:    map[string]interface{}{"staging_guid": "staging-guid"},
+			DetectedStartCommand: map[string]string{"web": "./start"},
+		}))
+	})
+
+	It("passes the metadata on stdin", func() {
+		path := writeScript(`cat`)
+
+		err := metadata_hooks.NewExecHook(path, time.Second).Process("staging-guid", metadata)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metadata.DetectedStartCommand).To(Equal(map[string]string{"web": "rackup"}))
+	})
+
+	Context("when the executable fails", func() {
+		It("returns an error with its stderr", func() {
+			path := writeScript(`echo "no procfile" >&2; exit 1`)
+
+			err := metadata_hooks.NewExecHook(path, time.Second).Process("staging-guid", metadata)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("no procfile"))
+		})
+	})
+
+	Context("when the executable runs longer than the timeout", func() {
+		It("kills it and returns an error", func() {
+			path := writeScript(`exec sleep 10`)
+
+			err := metadata_hooks.NewExecHook(path, 100*time.Millisecond).Process("staging-guid", metadata)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,35 @@
+package metrics_emitter
+
+import (
+	"github.com/cloudfoundry/dropsonde"
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+)
+
+type dropsondeEmitter struct {
+	sender metric_sender.MetricSender
+}
+
+// NewDropsondeEmitter returns an Emitter that sends metrics to the metron
+// agent listening on destination, as coming from origin.
+func NewDropsondeEmitter(destination, origin string) (Emitter, error) {
+	err := dropsonde.Initialize(destination, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dropsondeEmitter{
+		sender: metric_sender.NewMetricSender(dropsonde.AutowiredEmitter()),
+	}, nil
+}
+
+func (e *dropsondeEmitter) SendValue(name string, value float64, unit string) error {
+	return e.sender.SendValue(name, value, unit)
+}
+
+func (e *dropsondeEmitter) IncrementCounter(name string) error {
+	return e.sender.IncrementCounter(name)
+}
+
+func (e *dropsondeEmitter) AddToCounter(name string, delta uint64) error {
+	return e.sender.AddToCounter(name, delta)
+}
@@ -0,0 +1,139 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/metrics_emitter"
+)
+
+type FakeEmitter struct {
+	SendValueStub        func(name string, value float64, unit string) error
+	sendValueMutex       sync.RWMutex
+	sendValueArgsForCall []struct {
+		name  string
+		value float64
+		unit  string
+	}
+	sendValueReturns struct {
+		result1 error
+	}
+	IncrementCounterStub        func(name string) error
+	incrementCounterMutex       sync.RWMutex
+	incrementCounterArgsForCall []struct {
+		name string
+	}
+	incrementCounterReturns struct {
+		result1 error
+	}
+	AddToCounterStub        func(name string, delta uint64) error
+	addToCounterMutex       sync.RWMutex
+	addToCounterArgsForCall []struct {
+		name  string
+		delta uint64
+	}
+	addToCounterReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeEmitter) SendValue(name string, value float64, unit string) error {
+	fake.sendValueMutex.Lock()
+	fake.sendValueArgsForCall = append(fake.sendValueArgsForCall, struct {
+		name  string
+		value float64
+		unit  string
+	}{name, value, unit})
+	fake.sendValueMutex.Unlock()
+	if fake.SendValueStub != nil {
+		return fake.SendValueStub(name, value, unit)
+	} else {
+		return fake.sendValueReturns.result1
+	}
+}
+
+func (fake *FakeEmitter) SendValueCallCount() int {
+	fake.sendValueMutex.RLock()
+	defer fake.sendValueMutex.RUnlock()
+	return len(fake.sendValueArgsForCall)
+}
+
+func (fake *FakeEmitter) SendValueArgsForCall(i int) (string, float64, string) {
+	fake.sendValueMutex.RLock()
+	defer fake.sendValueMutex.RUnlock()
+	return fake.sendValueArgsForCall[i].name, fake.sendValueArgsForCall[i].value, fake.sendValueArgsForCall[i].unit
+}
+
+func (fake *FakeEmitter) SendValueReturns(result1 error) {
+	fake.SendValueStub = nil
+	fake.sendValueReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEmitter) IncrementCounter(name string) error {
+	fake.incrementCounterMutex.Lock()
+	fake.incrementCounterArgsForCall = append(fake.incrementCounterArgsForCall, struct {
+		name string
+	}{name})
+	fake.incrementCounterMutex.Unlock()
+	if fake.IncrementCounterStub != nil {
+		return fake.IncrementCounterStub(name)
+	} else {
+		return fake.incrementCounterReturns.result1
+	}
+}
+
+func (fake *FakeEmitter) IncrementCounterCallCount() int {
+	fake.incrementCounterMutex.RLock()
+	defer fake.incrementCounterMutex.RUnlock()
+	return len(fake.incrementCounterArgsForCall)
+}
+
+func (fake *FakeEmitter) IncrementCounterArgsForCall(i int) string {
+	fake.incrementCounterMutex.RLock()
+	defer fake.incrementCounterMutex.RUnlock()
+	return fake.incrementCounterArgsForCall[i].name
+}
+
+func (fake *FakeEmitter) IncrementCounterReturns(result1 error) {
+	fake.IncrementCounterStub = nil
+	fake.incrementCounterReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEmitter) AddToCounter(name string, delta uint64) error {
+	fake.addToCounterMutex.Lock()
+	fake.addToCounterArgsForCall = append(fake.addToCounterArgsForCall, struct {
+		name  string
+		delta uint64
+	}{name, delta})
+	fake.addToCounterMutex.Unlock()
+	if fake.AddToCounterStub != nil {
+		return fake.AddToCounterStub(name, delta)
+	} else {
+		return fake.addToCounterReturns.result1
+	}
+}
+
+func (fake *FakeEmitter) AddToCounterCallCount() int {
+	fake.addToCounterMutex.RLock()
+	defer fake.addToCounterMutex.RUnlock()
+	return len(fake.addToCounterArgsForCall)
+}
+
+func (fake *FakeEmitter) AddToCounterArgsForCall(i int) (string, uint64) {
+	fake.addToCounterMutex.RLock()
+	defer fake.addToCounterMutex.RUnlock()
+	return fake.addToCounterArgsForCall[i].name, fake.addToCounterArgsForCall[i].delta
+}
+
+func (fake *FakeEmitter) AddToCounterReturns(result1 error) {
+	fake.AddToCounterStub = nil
+	fake.addToCounterReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ metrics_emitter.Emitter = new(FakeEmitter)
@@ -0,0 +1,70 @@
+package metrics_emitter
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry/dropsonde/metric_sender"
+	"github.com/cloudfoundry/dropsonde/metrics"
+)
+
+const (
+	DropsondeEmitter = "dropsonde"
+	StatsdEmitter    = "statsd"
+	NoopEmitter      = "none"
+)
+
+// Emitter sends the stager's metrics to wherever they are collected.
+//
+//go:generate counterfeiter -o fakes/fake_emitter.go . Emitter
+type Emitter interface {
+	SendValue(name string, value float64, unit string) error
+	IncrementCounter(name string) error
+	AddToCounter(name string, delta uint64) error
+}
+
+// UnknownEmitterError is returned for an emitter name that is not one of
+// DropsondeEmitter, StatsdEmitter or NoopEmitter.
+type UnknownEmitterError struct {
+	Name string
+}
+
+func (e *UnknownEmitterError) Error() string {
+	return fmt.Sprintf("unknown metrics emitter '%s' (available emitters: %s, %s, %s)", e.Name, DropsondeEmitter, StatsdEmitter, NoopEmitter)
+}
+
+// Install sends every metric emitted through the metrics package, which the
+// runtimeschema metric types use, to the given emitter.
+func Install(emitter Emitter) {
+	metrics.Initialize(&sender{emitter: emitter}, nil)
+}
+
+// sender adapts an Emitter to the dropsonde metric sender the metrics
+// package sends through. Only the methods used to emit values and counters
+// are implemented.
+type sender struct {
+	metric_sender.MetricSender
+	emitter Emitter
+}
+
+func (s *sender) SendValue(name string, value float64, unit string) error {
+	return s.emitter.SendValue(name, value, unit)
+}
+
+func (s *sender) IncrementCounter(name string) error {
+	return s.emitter.IncrementCounter(name)
+}
+
+func (s *sender) AddToCounter(name string, delta uint64) error {
+	return s.emitter.AddToCounter(name, delta)
+}
+
+// NewNoopEmitter returns an Emitter that drops every metric.
+func NewNoopEmitter() Emitter {
+	return noopEmitter{}
+}
+
+type noopEmitter struct{}
+
+func (noopEmitter) SendValue(name string, value float64, unit string) error { return nil }
+func (noopEmitter) IncrementCounter(name string) error                      { return nil }
+func (noopEmitter) AddToCounter(name string, delta uint64) error            { return nil }
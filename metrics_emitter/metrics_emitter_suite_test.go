@@ -0,0 +1,13 @@
+package metrics_emitter_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestMetricsEmitter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Metrics Emitter Suite")
+}
@@ -0,0 +1,65 @@
+package metrics_emitter_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/metrics_emitter"
+	"code.cloudfoundry.org/stager/metrics_emitter/fakes"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Install", func() {
+	var fakeEmitter *fakes.FakeEmitter
+
+	BeforeEach(func() {
+		fakeEmitter = &fakes.FakeEmitter{}
+		metrics_emitter.Install(fakeEmitter)
+	})
+
+	AfterEach(func() {
+		metrics.Initialize(fake_metric_sender.NewFakeMetricSender(), nil)
+	})
+
+	It("sends counters to the emitter", func() {
+		metric.Counter("SomeCounter").Increment()
+		metric.Counter("SomeCounter").Add(3)
+
+		Expect(fakeEmitter.IncrementCounterCallCount()).To(Equal(1))
+		Expect(fakeEmitter.IncrementCounterArgsForCall(0)).To(Equal("SomeCounter"))
+
+		Expect(fakeEmitter.AddToCounterCallCount()).To(Equal(1))
+		name, delta := fakeEmitter.AddToCounterArgsForCall(0)
+		Expect(name).To(Equal("SomeCounter"))
+		Expect(delta).To(BeEquivalentTo(3))
+	})
+
+	It("sends values to the emitter", func() {
+		metric.Metric("SomeMetric").Send(42)
+		metric.Duration("SomeDuration").Send(time.Second)
+
+		Expect(fakeEmitter.SendValueCallCount()).To(Equal(2))
+
+		name, value, _ := fakeEmitter.SendValueArgsForCall(0)
+		Expect(name).To(Equal("SomeMetric"))
+		Expect(value).To(Equal(float64(42)))
+
+		name, value, unit := fakeEmitter.SendValueArgsForCall(1)
+		Expect(name).To(Equal("SomeDuration"))
+		Expect(value).To(Equal(float64(time.Second)))
+		Expect(unit).To(Equal("nanos"))
+	})
+})
+
+var _ = Describe("NoopEmitter", func() {
+	It("accepts every metric", func() {
+		emitter := metrics_emitter.NewNoopEmitter()
+		Expect(emitter.SendValue("SomeMetric", 1, "Metric")).To(Succeed())
+		Expect(emitter.IncrementCounter("SomeCounter")).To(Succeed())
+		Expect(emitter.AddToCounter("SomeCounter", 2)).To(Succeed())
+	})
+})
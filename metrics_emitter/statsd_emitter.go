@@ -0,0 +1,56 @@
+package metrics_emitter
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// durationUnit is the unit of the durations sent by the runtimeschema
+// metric types.
+const durationUnit = "nanos"
+
+type statsdEmitter struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsdEmitter returns an Emitter that sends metrics to the statsd
+// server listening on address over UDP, with their names prefixed by
+// prefix. Durations are sent as timings in milliseconds and other values
+// as gauges.
+func NewStatsdEmitter(address, prefix string) (Emitter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix != "" {
+		prefix += "."
+	}
+
+	return &statsdEmitter{
+		conn:   conn,
+		prefix: prefix,
+	}, nil
+}
+
+func (e *statsdEmitter) SendValue(name string, value float64, unit string) error {
+	if unit == durationUnit {
+		return e.send(name, value/1e6, "ms")
+	}
+	return e.send(name, value, "g")
+}
+
+func (e *statsdEmitter) IncrementCounter(name string) error {
+	return e.AddToCounter(name, 1)
+}
+
+func (e *statsdEmitter) AddToCounter(name string, delta uint64) error {
+	return e.send(name, float64(delta), "c")
+}
+
+func (e *statsdEmitter) send(name string, value float64, kind string) error {
+	_, err := fmt.Fprintf(e.conn, "%s%s:%s|%s", e.prefix, name, strconv.FormatFloat(value, 'f', -1, 64), kind)
+	return err
+}
@@ -0,0 +1,70 @@
+package metrics_emitter_test
+
+import (
+	"net"
+	"time"
+
+	"code.cloudfoundry.org/stager/metrics_emitter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StatsdEmitter", func() {
+	var (
+		listener net.PacketConn
+		emitter  metrics_emitter.Emitter
+	)
+
+	receive := func() string {
+		buffer := make([]byte, 1024)
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := listener.ReadFrom(buffer)
+		Expect(err).NotTo(HaveOccurred())
+		return string(buffer[:n])
+	}
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		emitter, err = metrics_emitter.NewStatsdEmitter(listener.LocalAddr().String(), "stager")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	It("sends values as gauges", func() {
+		Expect(emitter.SendValue("StagingQueueDepth", 3, "Metric")).To(Succeed())
+		Expect(receive()).To(Equal("stager.StagingQueueDepth:3|g"))
+	})
+
+	It("sends durations as timings in milliseconds", func() {
+		Expect(emitter.SendValue("StagingRequestSucceededDuration", float64(1500*time.Millisecond), "nanos")).To(Succeed())
+		Expect(receive()).To(Equal("stager.StagingRequestSucceededDuration:1500|ms"))
+	})
+
+	It("sends counters", func() {
+		Expect(emitter.IncrementCounter("StagingStartRequestsReceived")).To(Succeed())
+		Expect(receive()).To(Equal("stager.StagingStartRequestsReceived:1|c"))
+
+		Expect(emitter.AddToCounter("StagingLogsRelayed", 5)).To(Succeed())
+		Expect(receive()).To(Equal("stager.StagingLogsRelayed:5|c"))
+	})
+
+	Context("without a prefix", func() {
+		BeforeEach(func() {
+			var err error
+			emitter, err = metrics_emitter.NewStatsdEmitter(listener.LocalAddr().String(), "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("sends the bare metric names", func() {
+			Expect(emitter.IncrementCounter("StagingStartRequestsReceived")).To(Succeed())
+			Expect(receive()).To(Equal("StagingStartRequestsReceived:1|c"))
+		})
+	})
+})
@@ -0,0 +1,138 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/nats_connection"
+)
+
+type FakeConnection struct {
+	PublishStub        func(subject string, data []byte) error
+	publishMutex       sync.RWMutex
+	publishArgsForCall []struct {
+		subject string
+		data    []byte
+	}
+	publishReturns struct {
+		result1 error
+	}
+	IsConnectedStub        func() bool
+	isConnectedMutex       sync.RWMutex
+	isConnectedArgsForCall []struct{}
+	isConnectedReturns     struct {
+		result1 bool
+	}
+	FlushStub        func() error
+	flushMutex       sync.RWMutex
+	flushArgsForCall []struct{}
+	flushReturns     struct {
+		result1 error
+	}
+	CloseStub        func()
+	closeMutex       sync.RWMutex
+	closeArgsForCall []struct{}
+}
+
+func (fake *FakeConnection) Publish(subject string, data []byte) error {
+	var dataCopy []byte
+	if data != nil {
+		dataCopy = make([]byte, len(data))
+		copy(dataCopy, data)
+	}
+	fake.publishMutex.Lock()
+	fake.publishArgsForCall = append(fake.publishArgsForCall, struct {
+		subject string
+		data    []byte
+	}{subject, dataCopy})
+	fake.publishMutex.Unlock()
+	if fake.PublishStub != nil {
+		return fake.PublishStub(subject, data)
+	} else {
+		return fake.publishReturns.result1
+	}
+}
+
+func (fake *FakeConnection) PublishCallCount() int {
+	fake.publishMutex.RLock()
+	defer fake.publishMutex.RUnlock()
+	return len(fake.publishArgsForCall)
+}
+
+func (fake *FakeConnection) PublishArgsForCall(i int) (string, []byte) {
+	fake.publishMutex.RLock()
+	defer fake.publishMutex.RUnlock()
+	return fake.publishArgsForCall[i].subject, fake.publishArgsForCall[i].data
+}
+
+func (fake *FakeConnection) PublishReturns(result1 error) {
+	fake.PublishStub = nil
+	fake.publishReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeConnection) IsConnected() bool {
+	fake.isConnectedMutex.Lock()
+	fake.isConnectedArgsForCall = append(fake.isConnectedArgsForCall, struct{}{})
+	fake.isConnectedMutex.Unlock()
+	if fake.IsConnectedStub != nil {
+		return fake.IsConnectedStub()
+	} else {
+		return fake.isConnectedReturns.result1
+	}
+}
+
+func (fake *FakeConnection) IsConnectedCallCount() int {
+	fake.isConnectedMutex.RLock()
+	defer fake.isConnectedMutex.RUnlock()
+	return len(fake.isConnectedArgsForCall)
+}
+
+func (fake *FakeConnection) IsConnectedReturns(result1 bool) {
+	fake.IsConnectedStub = nil
+	fake.isConnectedReturns = struct {
+		result1 bool
+	}{result1}
+}
+
+func (fake *FakeConnection) Flush() error {
+	fake.flushMutex.Lock()
+	fake.flushArgsForCall = append(fake.flushArgsForCall, struct{}{})
+	fake.flushMutex.Unlock()
+	if fake.FlushStub != nil {
+		return fake.FlushStub()
+	} else {
+		return fake.flushReturns.result1
+	}
+}
+
+func (fake *FakeConnection) FlushCallCount() int {
+	fake.flushMutex.RLock()
+	defer fake.flushMutex.RUnlock()
+	return len(fake.flushArgsForCall)
+}
+
+func (fake *FakeConnection) FlushReturns(result1 error) {
+	fake.FlushStub = nil
+	fake.flushReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeConnection) Close() {
+	fake.closeMutex.Lock()
+	fake.closeArgsForCall = append(fake.closeArgsForCall, struct{}{})
+	fake.closeMutex.Unlock()
+	if fake.CloseStub != nil {
+		fake.CloseStub()
+	}
+}
+
+func (fake *FakeConnection) CloseCallCount() int {
+	fake.closeMutex.RLock()
+	defer fake.closeMutex.RUnlock()
+	return len(fake.closeArgsForCall)
+}
+
+var _ nats_connection.Connection = new(FakeConnection)
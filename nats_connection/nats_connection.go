@@ -0,0 +1,323 @@
+package nats_connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/nats-io/nats"
+	"github.com/tedsuo/ifrit"
+)
+
+// Credentials authenticate the stager with NATS. In a credentials file they
+// are written as {"username": "...", "password": "..."}.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func LoadCredentials(path string) (Credentials, error) {
+	var credentials Credentials
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return credentials, err
+	}
+
+	err = json.Unmarshal(contents, &credentials)
+	if err != nil {
+		return credentials, fmt.Errorf("invalid NATS credentials file %s: %s", path, err)
+	}
+
+	return credentials, nil
+}
+
+// Connection is the part of a NATS connection used by the stager.
+//
+//go:generate counterfeiter -o fakes/fake_connection.go . Connection
+type Connection interface {
+	Publish(subject string, data []byte) error
+	IsConnected() bool
+	Flush() error
+	Close()
+}
+
+// Dialer connects to NATS with the given credentials.
+type Dialer func(Credentials) (Connection, error)
+
+// NewDialer returns a dialer connecting to the NATS servers at the given
+// addresses (ip:port), over TLS when tlsConfig is not nil.
+func NewDialer(addresses []string, tlsConfig *tls.Config) Dialer {
+	natsURLs := []string{}
+	for _, address := range addresses {
+		natsURLs = append(natsURLs, fmt.Sprintf("nats://%s", strings.TrimSpace(address)))
+	}
+
+	return func(credentials Credentials) (Connection, error) {
+		options := []nats.Option{nats.UserInfo(credentials.Username, credentials.Password)}
+		if tlsConfig != nil {
+			options = append(options, nats.Secure(tlsConfig))
+		}
+
+		conn, err := nats.Connect(strings.Join(natsURLs, ","), options...)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// NewTLSConfig returns the TLS configuration for connecting to NATS with a
+// client certificate, trusting the servers signed by the given CA.
+func NewTLSConfig(caCertPath, certPath, keyPath string) (*tls.Config, error) {
+	caCert, err := ioutil.ReadFile(caCertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in '%s'", caCertPath)
+	}
+
+	config := &tls.Config{RootCAs: caPool}
+
+	if certPath != "" || keyPath != "" {
+		certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// ErrNotConnected is returned when publishing or flushing before the first
+// connection to NATS has been made.
+var ErrNotConnected = errors.New("not connected to NATS")
+
+// ConnectRetryInterval is how long the connector waits before dialing NATS
+// again after failing to connect.
+const ConnectRetryInterval = 5 * time.Second
+
+// Conn is a NATS connection whose credentials can be rotated. Rotating
+// connects again with the new credentials and only then retires the current
+// connection, so publishing is never interrupted.
+type Conn struct {
+	logger lager.Logger
+	dial   Dialer
+
+	lock        sync.RWMutex
+	conn        Connection
+	credentials Credentials
+}
+
+// NewConn returns a connection that is not connected yet. Until Connect
+// succeeds, publishing and flushing fail with ErrNotConnected.
+func NewConn(logger lager.Logger, dial Dialer, credentials Credentials) *Conn {
+	return &Conn{
+		logger:      logger.Session("nats-connection"),
+		dial:        dial,
+		credentials: credentials,
+	}
+}
+
+func Dial(logger lager.Logger, dial Dialer, credentials Credentials) (*Conn, error) {
+	conn := NewConn(logger, dial, credentials)
+
+	err := conn.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Connect connects with the current credentials, unless already connected.
+func (c *Conn) Connect() error {
+	c.lock.RLock()
+	connected := c.conn != nil
+	credentials := c.credentials
+	c.lock.RUnlock()
+
+	if connected {
+		return nil
+	}
+
+	conn, err := c.dial(credentials)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// The credentials may have been rotated while dialing, connecting on
+	// their own.
+	if c.conn != nil {
+		conn.Close()
+		return nil
+	}
+
+	c.conn = conn
+	return nil
+}
+
+func (c *Conn) Publish(subject string, data []byte) error {
+	conn := c.current()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return conn.Publish(subject, data)
+}
+
+func (c *Conn) IsConnected() bool {
+	conn := c.current()
+	return conn != nil && conn.IsConnected()
+}
+
+func (c *Conn) Flush() error {
+	conn := c.current()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return conn.Flush()
+}
+
+func (c *Conn) Close() {
+	conn := c.current()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Rotate replaces the connection with one authenticated with the given
+// credentials. If connecting fails, the current connection is kept.
+func (c *Conn) Rotate(credentials Credentials) error {
+	conn, err := c.dial(credentials)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	old := c.conn
+	c.conn = conn
+	c.credentials = credentials
+	c.lock.Unlock()
+
+	if old == nil {
+		return nil
+	}
+
+	err = old.Flush()
+	if err != nil {
+		c.logger.Error("failed-to-flush-retired-connection", err)
+	}
+	old.Close()
+
+	return nil
+}
+
+func (c *Conn) current() Connection {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.conn
+}
+
+type connector struct {
+	logger lager.Logger
+	conn   *Conn
+	clock  clock.Clock
+}
+
+// NewConnector returns a runner that is ready at once and connects in the
+// background, dialing again every ConnectRetryInterval until NATS can be
+// reached, so that the stager starts while NATS is down. The connection is
+// closed when the runner is signalled.
+func NewConnector(logger lager.Logger, conn *Conn, clock clock.Clock) ifrit.Runner {
+	return &connector{
+		logger: logger.Session("nats-connector"),
+		conn:   conn,
+		clock:  clock,
+	}
+}
+
+func (c *connector) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+	defer c.conn.Close()
+
+	for {
+		err := c.conn.Connect()
+		if err == nil {
+			c.logger.Info("connected")
+			break
+		}
+		c.logger.Error("failed-to-connect", err)
+
+		timer := c.clock.NewTimer(ConnectRetryInterval)
+		select {
+		case <-signals:
+			timer.Stop()
+			return nil
+		case <-timer.C():
+		}
+	}
+
+	<-signals
+	return nil
+}
+
+type rotator struct {
+	logger  lager.Logger
+	conn    *Conn
+	path    string
+	reloads <-chan os.Signal
+}
+
+// NewRotator returns a runner that reloads the NATS credentials file and
+// rotates the connection to them every time a signal is received on reloads,
+// typically SIGHUP. Failing to reload or reconnect is logged and leaves the
+// current connection in place.
+func NewRotator(logger lager.Logger, conn *Conn, path string, reloads <-chan os.Signal) ifrit.Runner {
+	return &rotator{
+		logger:  logger.Session("nats-credentials-rotator", lager.Data{"path": path}),
+		conn:    conn,
+		path:    path,
+		reloads: reloads,
+	}
+}
+
+func (r *rotator) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-r.reloads:
+			credentials, err := LoadCredentials(r.path)
+			if err != nil {
+				r.logger.Error("failed-to-reload", err)
+				continue
+			}
+
+			err = r.conn.Rotate(credentials)
+			if err != nil {
+				r.logger.Error("failed-to-reconnect", err)
+				continue
+			}
+
+			r.logger.Info("rotated")
+		}
+	}
+}
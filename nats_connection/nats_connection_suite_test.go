@@ -0,0 +1,13 @@
+package nats_connection_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNATSConnection(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "NATS Connection Suite")
+}
@@ -0,0 +1,261 @@
+package nats_connection_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/nats_connection"
+	"code.cloudfoundry.org/stager/nats_connection/fakes"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NATSConnection", func() {
+	var (
+		credentialsPath string
+		connections     []*fakes.FakeConnection
+		dialed          []nats_connection.Credentials
+		dialErr         error
+		dial            nats_connection.Dialer
+	)
+
+	writeCredentials := func(contents string) {
+		Expect(ioutil.WriteFile(credentialsPath, []byte(contents), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		credentialsFile, err := ioutil.TempFile("", "nats-credentials")
+		Expect(err).NotTo(HaveOccurred())
+		credentialsFile.Close()
+		credentialsPath = credentialsFile.Name()
+
+		connections = nil
+		dialed = nil
+		dialErr = nil
+		dial = func(credentials nats_connection.Credentials) (nats_connection.Connection, error) {
+			if dialErr != nil {
+				return nil, dialErr
+			}
+
+			dialed = append(dialed, credentials)
+			conn := &fakes.FakeConnection{}
+			conn.IsConnectedReturns(true)
+			connections = append(connections, conn)
+			return conn, nil
+		}
+	})
+
+	AfterEach(func() {
+		os.Remove(credentialsPath)
+	})
+
+	Describe("LoadCredentials", func() {
+		It("loads the credentials from the file", func() {
+			writeCredentials(`{"username": "the-user", "password": "the-password"}`)
+
+			credentials, err := nats_connection.LoadCredentials(credentialsPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials).To(Equal(nats_connection.Credentials{Username: "the-user", Password: "the-password"}))
+		})
+
+		It("fails on an invalid file", func() {
+			writeCredentials(`nope`)
+
+			_, err := nats_connection.LoadCredentials(credentialsPath)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("NewTLSConfig", func() {
+		It("fails when the CA cert has no certificates", func() {
+			writeCredentials(`not a cert`)
+
+			_, err := nats_connection.NewTLSConfig(credentialsPath, "", "")
+			Expect(err).To(MatchError("no certificates found in '" + credentialsPath + "'"))
+		})
+	})
+
+	Describe("Conn", func() {
+		var conn *nats_connection.Conn
+
+		BeforeEach(func() {
+			var err error
+			conn, err = nats_connection.Dial(lagertest.NewTestLogger("test"), dial, nats_connection.Credentials{Username: "old-user", Password: "old-password"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("connects with the credentials", func() {
+			Expect(dialed).To(Equal([]nats_connection.Credentials{{Username: "old-user", Password: "old-password"}}))
+		})
+
+		It("publishes on the connection", func() {
+			Expect(conn.Publish("a-subject", []byte("data"))).To(Succeed())
+
+			Expect(connections[0].PublishCallCount()).To(Equal(1))
+			subject, data := connections[0].PublishArgsForCall(0)
+			Expect(subject).To(Equal("a-subject"))
+			Expect(data).To(Equal([]byte("data")))
+		})
+
+		It("flushes the connection", func() {
+			Expect(conn.Flush()).To(Succeed())
+			Expect(connections[0].FlushCallCount()).To(Equal(1))
+		})
+
+		Describe("Rotate", func() {
+			It("publishes on a connection with the new credentials", func() {
+				Expect(conn.Rotate(nats_connection.Credentials{Username: "new-user", Password: "new-password"})).To(Succeed())
+				Expect(dialed[1]).To(Equal(nats_connection.Credentials{Username: "new-user", Password: "new-password"}))
+
+				Expect(conn.Publish("a-subject", []byte("data"))).To(Succeed())
+				Expect(connections[0].PublishCallCount()).To(Equal(0))
+				Expect(connections[1].PublishCallCount()).To(Equal(1))
+			})
+
+			It("flushes and closes the retired connection", func() {
+				Expect(conn.Rotate(nats_connection.Credentials{Username: "new-user"})).To(Succeed())
+
+				Expect(connections[0].FlushCallCount()).To(Equal(1))
+				Expect(connections[0].CloseCallCount()).To(Equal(1))
+				Expect(connections[1].CloseCallCount()).To(Equal(0))
+			})
+
+			Context("when connecting with the new credentials fails", func() {
+				BeforeEach(func() {
+					dialErr = errors.New("authorization violation")
+				})
+
+				It("keeps the current connection", func() {
+					Expect(conn.Rotate(nats_connection.Credentials{Username: "bad-user"})).To(MatchError("authorization violation"))
+
+					Expect(conn.IsConnected()).To(BeTrue())
+					Expect(connections[0].CloseCallCount()).To(Equal(0))
+				})
+			})
+		})
+
+		Describe("NewRotator", func() {
+			var (
+				reloads chan os.Signal
+				process ifrit.Process
+			)
+
+			BeforeEach(func() {
+				reloads = make(chan os.Signal, 1)
+				process = ifrit.Invoke(nats_connection.NewRotator(lagertest.NewTestLogger("test"), conn, credentialsPath, reloads))
+			})
+
+			AfterEach(func() {
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive())
+			})
+
+			It("rotates to the credentials in the file when told to reload", func() {
+				writeCredentials(`{"username": "new-user", "password": "new-password"}`)
+				reloads <- syscall.SIGHUP
+
+				Eventually(func() int { return connections[0].CloseCallCount() }).Should(Equal(1))
+				Expect(dialed[1]).To(Equal(nats_connection.Credentials{Username: "new-user", Password: "new-password"}))
+			})
+
+			It("keeps the current connection when the file is invalid", func() {
+				writeCredentials(`nope`)
+				reloads <- syscall.SIGHUP
+
+				Consistently(func() int { return connections[0].CloseCallCount() }).Should(Equal(0))
+			})
+		})
+	})
+
+	Describe("NewConn", func() {
+		var conn *nats_connection.Conn
+
+		BeforeEach(func() {
+			conn = nats_connection.NewConn(lagertest.NewTestLogger("test"), dial, nats_connection.Credentials{Username: "user"})
+		})
+
+		It("does not connect", func() {
+			Expect(dialed).To(BeEmpty())
+			Expect(conn.IsConnected()).To(BeFalse())
+			Expect(conn.Publish("a-subject", []byte("data"))).To(Equal(nats_connection.ErrNotConnected))
+			Expect(conn.Flush()).To(Equal(nats_connection.ErrNotConnected))
+		})
+
+		It("connects with the credentials when told to", func() {
+			Expect(conn.Connect()).To(Succeed())
+			Expect(dialed).To(Equal([]nats_connection.Credentials{{Username: "user"}}))
+			Expect(conn.IsConnected()).To(BeTrue())
+
+			Expect(conn.Connect()).To(Succeed())
+			Expect(dialed).To(HaveLen(1))
+		})
+
+		It("connects with rotated credentials", func() {
+			Expect(conn.Rotate(nats_connection.Credentials{Username: "new-user"})).To(Succeed())
+			Expect(conn.IsConnected()).To(BeTrue())
+
+			Expect(conn.Connect()).To(Succeed())
+			Expect(dialed).To(Equal([]nats_connection.Credentials{{Username: "new-user"}}))
+		})
+	})
+
+	Describe("NewConnector", func() {
+		var (
+			conn      *nats_connection.Conn
+			fakeClock *fakeclock.FakeClock
+			process   ifrit.Process
+		)
+
+		BeforeEach(func() {
+			fakeClock = fakeclock.NewFakeClock(time.Now())
+			conn = nats_connection.NewConn(lagertest.NewTestLogger("test"), dial, nats_connection.Credentials{Username: "user"})
+		})
+
+		JustBeforeEach(func() {
+			process = ifrit.Invoke(nats_connection.NewConnector(lagertest.NewTestLogger("test"), conn, fakeClock))
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+		})
+
+		It("connects", func() {
+			Eventually(conn.IsConnected).Should(BeTrue())
+		})
+
+		It("closes the connection when signalled", func() {
+			Eventually(conn.IsConnected).Should(BeTrue())
+
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			Expect(connections[0].CloseCallCount()).To(Equal(1))
+		})
+
+		Context("when NATS cannot be reached", func() {
+			BeforeEach(func() {
+				dialErr = errors.New("no servers available")
+			})
+
+			It("is ready anyway", func() {
+				Expect(conn.IsConnected()).To(BeFalse())
+			})
+
+			It("dials again after an interval", func() {
+				fakeClock.WaitForWatcherAndIncrement(nats_connection.ConnectRetryInterval)
+				Consistently(conn.IsConnected).Should(BeFalse())
+
+				dialErr = nil
+				fakeClock.WaitForWatcherAndIncrement(nats_connection.ConnectRetryInterval)
+				Eventually(conn.IsConnected).Should(BeTrue())
+			})
+		})
+	})
+})
@@ -0,0 +1,184 @@
+package prometheus_metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	counterType = "counter"
+	gaugeType   = "gauge"
+	summaryType = "summary"
+
+	// ContentType is the content type of the Prometheus text exposition format
+	// written by Registry.WriteTo.
+	ContentType = "text/plain; version=0.0.4"
+)
+
+// Registry keeps the stager's metrics in process so they can be scraped by
+// Prometheus, for operators that do not run Loggregator to receive the
+// metrics sent through dropsonde.
+type Registry struct {
+	lock    sync.Mutex
+	metrics map[string]*metric
+}
+
+type metric struct {
+	lock  sync.Mutex
+	name  string
+	help  string
+	kind  string
+	value float64
+	count uint64
+	keys  map[string]struct{}
+}
+
+type Counter struct{ metric *metric }
+type Gauge struct{ metric *metric }
+type Summary struct{ metric *metric }
+
+// KeyedGauge is a gauge of the distinct keys tracked in it, so that a key
+// tracked or untracked more than once, or untracked without having been
+// tracked, does not skew it.
+type KeyedGauge struct{ metric *metric }
+
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: map[string]*metric{},
+	}
+}
+
+// Counter returns the counter with the given name, registering it first if
+// needed, so that several components can share it.
+func (r *Registry) Counter(name, help string) Counter {
+	return Counter{r.register(name, help, counterType)}
+}
+
+func (r *Registry) Gauge(name, help string) Gauge {
+	return Gauge{r.register(name, help, gaugeType)}
+}
+
+func (r *Registry) KeyedGauge(name, help string) KeyedGauge {
+	return KeyedGauge{r.register(name, help, gaugeType)}
+}
+
+func (r *Registry) Summary(name, help string) Summary {
+	return Summary{r.register(name, help, summaryType)}
+}
+
+func (r *Registry) register(name, help, kind string) *metric {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	m, ok := r.metrics[name]
+	if !ok {
+		m = &metric{name: name, help: help, kind: kind}
+		r.metrics[name] = m
+	} else if m.kind != kind {
+		panic(fmt.Sprintf("metric %s is already registered as a %s", name, m.kind))
+	}
+
+	return m
+}
+
+func (c Counter) Increment() {
+	c.metric.add(1)
+}
+
+func (g Gauge) Add(delta float64) {
+	g.metric.add(delta)
+}
+
+func (g Gauge) Set(value float64) {
+	g.metric.lock.Lock()
+	defer g.metric.lock.Unlock()
+
+	g.metric.value = value
+}
+
+func (g KeyedGauge) Track(key string) {
+	g.metric.lock.Lock()
+	defer g.metric.lock.Unlock()
+
+	if g.metric.keys == nil {
+		g.metric.keys = map[string]struct{}{}
+	}
+	g.metric.keys[key] = struct{}{}
+	g.metric.value = float64(len(g.metric.keys))
+}
+
+func (g KeyedGauge) Untrack(key string) {
+	g.metric.lock.Lock()
+	defer g.metric.lock.Unlock()
+
+	delete(g.metric.keys, key)
+	g.metric.value = float64(len(g.metric.keys))
+}
+
+func (s Summary) ObserveDuration(duration time.Duration) {
+	s.metric.lock.Lock()
+	defer s.metric.lock.Unlock()
+
+	s.metric.value += duration.Seconds()
+	s.metric.count++
+}
+
+func (m *metric) add(delta float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.value += delta
+}
+
+// WriteTo writes every registered metric, sorted by name, in the Prometheus
+// text exposition format.
+func (r *Registry) WriteTo(w io.Writer) error {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := r.metrics
+	r.lock.Unlock()
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := metrics[name].writeTo(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *metric) writeTo(w io.Writer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	if err != nil {
+		return err
+	}
+
+	if m.kind == summaryType {
+		_, err = fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", m.name, formatValue(m.value), m.name, m.count)
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s %s\n", m.name, formatValue(m.value))
+	return err
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,78 @@
+package prometheus_metrics_test
+
+import (
+	"bytes"
+	"time"
+
+	"code.cloudfoundry.org/stager/prometheus_metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var registry *prometheus_metrics.Registry
+
+	BeforeEach(func() {
+		registry = prometheus_metrics.NewRegistry()
+	})
+
+	exposition := func() string {
+		buffer := &bytes.Buffer{}
+		Expect(registry.WriteTo(buffer)).To(Succeed())
+		return buffer.String()
+	}
+
+	It("writes counters, gauges and summaries in the text exposition format, sorted by name", func() {
+		registry.Counter("b_total", "A counter.").Increment()
+		registry.Gauge("a_in_flight", "A gauge.").Add(3)
+		registry.Summary("c_seconds", "A summary.").ObserveDuration(1500 * time.Millisecond)
+
+		Expect(exposition()).To(Equal(`# HELP a_in_flight A gauge.
+# TYPE a_in_flight gauge
+a_in_flight 3
+# HELP b_total A counter.
+# TYPE b_total counter
+b_total 1
+# HELP c_seconds A summary.
+# TYPE c_seconds summary
+c_seconds_sum 1.5
+c_seconds_count 1
+`))
+	})
+
+	It("shares metrics registered more than once under the same name", func() {
+		registry.Counter("requests_total", "Requests.").Increment()
+		registry.Counter("requests_total", "Requests.").Increment()
+
+		Expect(exposition()).To(ContainSubstring("requests_total 2\n"))
+	})
+
+	It("sets gauges", func() {
+		gauge := registry.Gauge("in_flight", "In flight.")
+		gauge.Add(5)
+		gauge.Set(2)
+
+		Expect(exposition()).To(ContainSubstring("in_flight 2\n"))
+	})
+
+	It("counts the distinct keys tracked in keyed gauges", func() {
+		gauge := registry.KeyedGauge("tasks_in_flight", "Tasks in flight.")
+		gauge.Track("a")
+		gauge.Track("a")
+		gauge.Track("b")
+		gauge.Untrack("b")
+		gauge.Untrack("b")
+		gauge.Untrack("never-tracked")
+
+		Expect(exposition()).To(ContainSubstring("# TYPE tasks_in_flight gauge\ntasks_in_flight 1\n"))
+	})
+
+	It("panics when a name is registered with another type", func() {
+		registry.Counter("requests_total", "Requests.")
+
+		Expect(func() {
+			registry.Gauge("requests_total", "Requests.")
+		}).To(Panic())
+	})
+})
@@ -0,0 +1,70 @@
+package rate_limiter
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// RateLimiter is a token bucket: tokens are added at a fixed rate up to the
+// burst size, and every allowed request takes one.
+type RateLimiter interface {
+	Allow() bool
+}
+
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	clock clock.Clock
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows rate requests per second
+// on average and bursts of up to burst requests. A rate of zero or less
+// allows every request.
+func NewRateLimiter(rate float64, burst int, clock clock.Clock) RateLimiter {
+	if rate <= 0 {
+		return unlimited{}
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		clock:  clock,
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+func (l *rateLimiter) Allow() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.clock.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
+
+type unlimited struct{}
+
+func (unlimited) Allow() bool {
+	return true
+}
@@ -0,0 +1,61 @@
+package rate_limiter_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/stager/rate_limiter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var fakeClock *fakeclock.FakeClock
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	It("allows a burst of requests and then rejects the excess", func() {
+		limiter := rate_limiter.NewRateLimiter(2, 3, fakeClock)
+
+		Expect(limiter.Allow()).To(BeTrue())
+		Expect(limiter.Allow()).To(BeTrue())
+		Expect(limiter.Allow()).To(BeTrue())
+		Expect(limiter.Allow()).To(BeFalse())
+	})
+
+	It("refills at the configured rate", func() {
+		limiter := rate_limiter.NewRateLimiter(2, 1, fakeClock)
+
+		Expect(limiter.Allow()).To(BeTrue())
+		Expect(limiter.Allow()).To(BeFalse())
+
+		fakeClock.Increment(250 * time.Millisecond)
+		Expect(limiter.Allow()).To(BeFalse())
+
+		fakeClock.Increment(250 * time.Millisecond)
+		Expect(limiter.Allow()).To(BeTrue())
+	})
+
+	It("does not refill beyond the burst", func() {
+		limiter := rate_limiter.NewRateLimiter(10, 2, fakeClock)
+
+		fakeClock.Increment(time.Hour)
+
+		Expect(limiter.Allow()).To(BeTrue())
+		Expect(limiter.Allow()).To(BeTrue())
+		Expect(limiter.Allow()).To(BeFalse())
+	})
+
+	Context("when the rate is zero", func() {
+		It("allows every request", func() {
+			limiter := rate_limiter.NewRateLimiter(0, 0, fakeClock)
+
+			for i := 0; i < 100; i++ {
+				Expect(limiter.Allow()).To(BeTrue())
+			}
+		})
+	})
+})
@@ -0,0 +1,189 @@
+package redelivery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/rata"
+)
+
+const (
+	// Metrics
+	stagingTasksRedelivered = metric.Counter("StagingTasksRedelivered")
+	stagingResolveConflicts = metric.Counter("StagingResolveConflicts")
+)
+
+// ErrTaskAlreadyResolving is returned for tasks the BBS is already calling
+// back about, or another stager is already replaying.
+var ErrTaskAlreadyResolving = errors.New("staging task is already being resolved")
+
+// CompletionRejectedError is returned for tasks the completion handler did
+// not accept, which are left for the BBS to call back about again.
+type CompletionRejectedError struct {
+	StatusCode int
+}
+
+func (e *CompletionRejectedError) Error() string {
+	return fmt.Sprintf("completion handler responded with status code %d", e.StatusCode)
+}
+
+type redeliverer struct {
+	logger            lager.Logger
+	bbsClient         bbs.Client
+	domains           []string
+	completionHandler http.Handler
+	requestGenerator  *rata.RequestGenerator
+}
+
+// NewRedeliverer returns a runner that, before becoming ready, replays every
+// completed staging task in the given domains through the completion handler and then deletes it
+// from the BBS. Tasks are claimed with ResolvingTask first, so a task the BBS
+// is already calling back about, or another stager is replaying, is left
+// alone. Failing to list the tasks does not stop the stager from starting.
+func NewRedeliverer(logger lager.Logger, bbsClient bbs.Client, domains []string, completionHandler http.Handler) ifrit.Runner {
+	return &redeliverer{
+		logger:            logger.Session("redeliverer"),
+		bbsClient:         bbsClient,
+		domains:           domains,
+		completionHandler: completionHandler,
+		requestGenerator:  rata.NewRequestGenerator("", stager.Routes),
+	}
+}
+
+func (r *redeliverer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	r.redeliver()
+	close(ready)
+
+	<-signals
+	return nil
+}
+
+func (r *redeliverer) redeliver() {
+	logger := r.logger.Session("redeliver")
+	logger.Info("starting")
+	defer logger.Info("finished")
+
+	for _, domain := range r.domains {
+		tasks, err := r.bbsClient.TasksByDomain(logger, domain)
+		if err != nil {
+			logger.Error("fetching-tasks-failed", err, lager.Data{"domain": domain})
+			continue
+		}
+
+		for _, task := range tasks {
+			if task.State != models.Task_Completed {
+				continue
+			}
+
+			r.redeliverTask(logger.Session("task", lager.Data{"task-guid": task.TaskGuid}), task)
+		}
+	}
+}
+
+// redeliverTask replays a completed task through the completion handler and
+// deletes it once the handler has accepted it.
+func (r *redeliverer) redeliverTask(logger lager.Logger, task *models.Task) error {
+	err := r.bbsClient.ResolvingTask(logger, task.TaskGuid)
+	if isResolveConflict(err) {
+		logger.Info("task-already-resolving", lager.Data{"lost-task-guid": task.TaskGuid, "error": err.Error()})
+		stagingResolveConflicts.Increment()
+		return ErrTaskAlreadyResolving
+	}
+	if err != nil {
+		logger.Error("resolving-task-failed", err)
+		return err
+	}
+
+	statusCode, err := r.replay(task)
+	if err != nil {
+		logger.Error("replay-failed", err)
+		return err
+	}
+
+	if statusCode < 200 || statusCode >= 300 {
+		logger.Info("completion-handler-rejected-task", lager.Data{"status": statusCode})
+		return &CompletionRejectedError{StatusCode: statusCode}
+	}
+
+	err = r.bbsClient.DeleteTask(logger, task.TaskGuid)
+	if err != nil {
+		logger.Error("delete-task-failed", err)
+		return err
+	}
+
+	logger.Info("redelivered")
+	stagingTasksRedelivered.Increment()
+	return nil
+}
+
+// isResolveConflict reports whether ResolvingTask failed because the task
+// had already been claimed, rather than because the BBS could not be
+// reached.
+func isResolveConflict(err error) bool {
+	if err == nil {
+		return false
+	}
+	return models.ConvertError(err).Type == models.Error_InvalidStateTransition
+}
+
+func (r *redeliverer) replay(task *models.Task) (int, error) {
+	callback := &models.TaskCallbackResponse{
+		TaskGuid:      task.TaskGuid,
+		Failed:        task.Failed,
+		FailureReason: task.FailureReason,
+		Result:        task.Result,
+		CreatedAt:     task.CreatedAt,
+	}
+	if task.TaskDefinition != nil {
+		callback.Annotation = task.Annotation
+	}
+
+	payload, err := json.Marshal(callback)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := r.requestGenerator.CreateRequest(stager.StagingCompletedRoute, rata.Params{"staging_guid": task.TaskGuid}, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	resp := &statusRecorder{header: http.Header{}, status: http.StatusOK}
+	r.completionHandler.ServeHTTP(resp, req)
+	return resp.status, nil
+}
+
+// statusRecorder is the least http.ResponseWriter needed to learn how the
+// completion handler answered.
+type statusRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *statusRecorder) Write(body []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return len(body), nil
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.status = status
+	r.wroteHeader = true
+}
@@ -0,0 +1,13 @@
+package redelivery_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRedelivery(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Redelivery Suite")
+}
@@ -0,0 +1,193 @@
+package redelivery_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/redelivery"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redeliverer", func() {
+	var (
+		fakeBBSClient *fake_bbs.FakeClient
+		metricSender  *fake.FakeMetricSender
+
+		replayed     []*models.TaskCallbackResponse
+		replayedPath []string
+		replayStatus int
+
+		domains []string
+		process ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		metricSender = fake.NewFakeMetricSender()
+		metrics.Initialize(metricSender, nil)
+
+		replayed = nil
+		replayedPath = nil
+		replayStatus = http.StatusOK
+		domains = []string{cc_messages.StagingTaskDomain}
+
+		fakeBBSClient.TasksByDomainReturns([]*models.Task{
+			{
+				TaskGuid: "completed-task",
+				State:    models.Task_Completed,
+				Result:   "the-result",
+				TaskDefinition: &models.TaskDefinition{
+					Annotation: `{"lifecycle":"buildpack"}`,
+				},
+			},
+			{
+				TaskGuid:       "running-task",
+				State:          models.Task_Running,
+				TaskDefinition: &models.TaskDefinition{},
+			},
+			{
+				TaskGuid:       "resolving-task",
+				State:          models.Task_Resolving,
+				TaskDefinition: &models.TaskDefinition{},
+			},
+		}, nil)
+	})
+
+	JustBeforeEach(func() {
+		completionHandler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			callback := &models.TaskCallbackResponse{}
+			Expect(json.NewDecoder(req.Body).Decode(callback)).To(Succeed())
+
+			replayed = append(replayed, callback)
+			replayedPath = append(replayedPath, req.Method+" "+req.URL.Path)
+			resp.WriteHeader(replayStatus)
+		})
+
+		runner := redelivery.NewRedeliverer(lagertest.NewTestLogger("test"), fakeBBSClient, domains, completionHandler)
+		process = ifrit.Background(runner)
+		Eventually(process.Ready()).Should(BeClosed())
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	It("lists the tasks in the staging domain", func() {
+		Expect(fakeBBSClient.TasksByDomainCallCount()).To(Equal(1))
+		_, domain := fakeBBSClient.TasksByDomainArgsForCall(0)
+		Expect(domain).To(Equal(cc_messages.StagingTaskDomain))
+	})
+
+	It("claims only the completed tasks", func() {
+		Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(1))
+		_, guid := fakeBBSClient.ResolvingTaskArgsForCall(0)
+		Expect(guid).To(Equal("completed-task"))
+	})
+
+	It("replays completed tasks through the completion handler", func() {
+		Expect(replayedPath).To(Equal([]string{"POST /v1/staging/completed-task/completed"}))
+		Expect(replayed).To(HaveLen(1))
+		Expect(replayed[0].TaskGuid).To(Equal("completed-task"))
+		Expect(replayed[0].Result).To(Equal("the-result"))
+		Expect(replayed[0].Annotation).To(Equal(`{"lifecycle":"buildpack"}`))
+	})
+
+	It("deletes the replayed tasks", func() {
+		Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(1))
+		_, guid := fakeBBSClient.DeleteTaskArgsForCall(0)
+		Expect(guid).To(Equal("completed-task"))
+		Expect(metricSender.GetCounter("StagingTasksRedelivered")).To(BeEquivalentTo(1))
+	})
+
+	Context("when the task has already been claimed", func() {
+		BeforeEach(func() {
+			fakeBBSClient.ResolvingTaskReturns(models.NewTaskTransitionError(models.Task_Resolving, models.Task_Resolving))
+		})
+
+		It("leaves the task alone", func() {
+			Expect(replayed).To(BeEmpty())
+			Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(0))
+		})
+
+		It("counts the conflict", func() {
+			Expect(metricSender.GetCounter("StagingResolveConflicts")).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("when the task cannot be claimed", func() {
+		BeforeEach(func() {
+			fakeBBSClient.ResolvingTaskReturns(models.ErrBadRequest)
+		})
+
+		It("leaves the task alone without counting a conflict", func() {
+			Expect(replayed).To(BeEmpty())
+			Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(0))
+			Expect(metricSender.GetCounter("StagingResolveConflicts")).To(BeEquivalentTo(0))
+		})
+	})
+
+	Context("when the completion handler rejects the task", func() {
+		BeforeEach(func() {
+			replayStatus = http.StatusServiceUnavailable
+		})
+
+		It("does not delete the task", func() {
+			Expect(replayed).To(HaveLen(1))
+			Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the tasks cannot be listed", func() {
+		BeforeEach(func() {
+			fakeBBSClient.TasksByDomainReturns(nil, errors.New("bbs down"))
+		})
+
+		It("becomes ready anyway", func() {
+			Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when several staging task domains are configured", func() {
+		BeforeEach(func() {
+			domains = []string{"windows-staging", cc_messages.StagingTaskDomain}
+		})
+
+		It("lists the tasks in every domain", func() {
+			Expect(fakeBBSClient.TasksByDomainCallCount()).To(Equal(2))
+			_, domain := fakeBBSClient.TasksByDomainArgsForCall(0)
+			Expect(domain).To(Equal("windows-staging"))
+		})
+
+		Context("when the tasks of a domain cannot be listed", func() {
+			BeforeEach(func() {
+				fakeBBSClient.TasksByDomainStub = func(logger lager.Logger, domain string) ([]*models.Task, error) {
+					if domain == "windows-staging" {
+						return nil, errors.New("bbs down")
+					}
+					return []*models.Task{{
+						TaskGuid:       "completed-task",
+						State:          models.Task_Completed,
+						TaskDefinition: &models.TaskDefinition{},
+					}}, nil
+				}
+			})
+
+			It("replays the tasks of the other domains", func() {
+				Expect(replayedPath).To(Equal([]string{"POST /v1/staging/completed-task/completed"}))
+			})
+		})
+	})
+})
@@ -0,0 +1,85 @@
+package redelivery
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager"
+	"github.com/tedsuo/rata"
+)
+
+type replayHandler struct {
+	logger      lager.Logger
+	redeliverer *redeliverer
+	domains     map[string]bool
+}
+
+// NewReplayHandler returns a handler that redelivers a single completed
+// staging task the way the redeliverer does on startup, so that operators
+// can replay a task stuck in the BBS without restarting a stager. It answers
+// 404 for tasks outside the given domains and 409 for tasks that have not
+// completed or are already being resolved.
+func NewReplayHandler(logger lager.Logger, bbsClient bbs.Client, domains []string, completionHandler http.Handler) http.Handler {
+	domainSet := map[string]bool{}
+	for _, domain := range domains {
+		domainSet[domain] = true
+	}
+
+	return &replayHandler{
+		logger: logger.Session("replay-handler"),
+		redeliverer: &redeliverer{
+			logger:            logger,
+			bbsClient:         bbsClient,
+			domains:           domains,
+			completionHandler: completionHandler,
+			requestGenerator:  rata.NewRequestGenerator("", stager.Routes),
+		},
+		domains: domainSet,
+	}
+}
+
+func (h *replayHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	taskGuid := req.FormValue(":staging_guid")
+	logger := h.logger.Session("replay", lager.Data{"task-guid": taskGuid})
+
+	task, err := h.redeliverer.bbsClient.TaskByGuid(logger, taskGuid)
+	if err != nil {
+		if models.ErrResourceNotFound.Equal(err) {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		logger.Error("failed-to-get-task", err)
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if !h.domains[task.Domain] {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if task.State != models.Task_Completed {
+		logger.Info("task-not-completed", lager.Data{"state": task.State.String()})
+		resp.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	err = h.redeliverer.redeliverTask(logger, task)
+	if err == ErrTaskAlreadyResolving {
+		resp.WriteHeader(http.StatusConflict)
+		return
+	}
+	if _, ok := err.(*CompletionRejectedError); ok {
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	resp.WriteHeader(http.StatusOK)
+}
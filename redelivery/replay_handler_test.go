@@ -0,0 +1,165 @@
+package redelivery_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/redelivery"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReplayHandler", func() {
+	var (
+		fakeBBSClient *fake_bbs.FakeClient
+		task          *models.Task
+
+		replayed     []*models.TaskCallbackResponse
+		replayStatus int
+
+		responseRecorder *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		replayed = nil
+		replayStatus = http.StatusOK
+
+		task = &models.Task{
+			TaskGuid: "completed-task",
+			Domain:   cc_messages.StagingTaskDomain,
+			State:    models.Task_Completed,
+			Result:   "the-result",
+			TaskDefinition: &models.TaskDefinition{
+				Annotation: `{"lifecycle":"buildpack"}`,
+			},
+		}
+		fakeBBSClient.TaskByGuidStub = func(lager.Logger, string) (*models.Task, error) {
+			return task, nil
+		}
+	})
+
+	JustBeforeEach(func() {
+		completionHandler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			callback := &models.TaskCallbackResponse{}
+			Expect(json.NewDecoder(req.Body).Decode(callback)).To(Succeed())
+
+			replayed = append(replayed, callback)
+			resp.WriteHeader(replayStatus)
+		})
+
+		handler := redelivery.NewReplayHandler(lagertest.NewTestLogger("test"), fakeBBSClient, []string{cc_messages.StagingTaskDomain}, completionHandler)
+
+		req, err := http.NewRequest("POST", "/v1/staging/completed-task/replay", nil)
+		Expect(err).NotTo(HaveOccurred())
+		req.Form = url.Values{":staging_guid": {"completed-task"}}
+
+		responseRecorder = httptest.NewRecorder()
+		handler.ServeHTTP(responseRecorder, req)
+	})
+
+	It("fetches the task by its guid", func() {
+		Expect(fakeBBSClient.TaskByGuidCallCount()).To(Equal(1))
+		_, guid := fakeBBSClient.TaskByGuidArgsForCall(0)
+		Expect(guid).To(Equal("completed-task"))
+	})
+
+	It("replays the task through the completion handler and deletes it", func() {
+		Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+
+		Expect(replayed).To(HaveLen(1))
+		Expect(replayed[0].TaskGuid).To(Equal("completed-task"))
+		Expect(replayed[0].Result).To(Equal("the-result"))
+
+		Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(1))
+		Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(1))
+	})
+
+	Context("when the task does not exist", func() {
+		BeforeEach(func() {
+			fakeBBSClient.TaskByGuidStub = func(lager.Logger, string) (*models.Task, error) {
+				return nil, models.ErrResourceNotFound
+			}
+		})
+
+		It("responds with 404", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+			Expect(replayed).To(BeEmpty())
+		})
+	})
+
+	Context("when the task is in another domain", func() {
+		BeforeEach(func() {
+			task.Domain = "some-other-domain"
+		})
+
+		It("responds with 404", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusNotFound))
+			Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the task cannot be fetched", func() {
+		BeforeEach(func() {
+			fakeBBSClient.TaskByGuidStub = func(lager.Logger, string) (*models.Task, error) {
+				return nil, errors.New("bbs down")
+			}
+		})
+
+		It("responds with 503", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+
+	Context("when the task has not completed", func() {
+		BeforeEach(func() {
+			task.State = models.Task_Running
+		})
+
+		It("responds with 409 and leaves the task alone", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusConflict))
+			Expect(fakeBBSClient.ResolvingTaskCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the task is already being resolved", func() {
+		BeforeEach(func() {
+			fakeBBSClient.ResolvingTaskReturns(models.NewTaskTransitionError(models.Task_Resolving, models.Task_Resolving))
+		})
+
+		It("responds with 409", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusConflict))
+			Expect(replayed).To(BeEmpty())
+		})
+	})
+
+	Context("when the completion handler rejects the task", func() {
+		BeforeEach(func() {
+			replayStatus = http.StatusServiceUnavailable
+		})
+
+		It("responds with 500 and does not delete the task", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+			Expect(fakeBBSClient.DeleteTaskCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the task cannot be deleted", func() {
+		BeforeEach(func() {
+			fakeBBSClient.DeleteTaskReturns(errors.New("bbs down"))
+		})
+
+		It("responds with 503", func() {
+			Expect(responseRecorder.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
@@ -0,0 +1,106 @@
+package request_validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/backend"
+)
+
+const DefaultBuildpackURLSchemes = "https,git"
+
+type buildpackURLValidator struct {
+	schemes      map[string]bool
+	allowedHosts []string
+	deniedHosts  []string
+}
+
+// NewBuildpackURLValidator returns a validator that rejects requests for
+// custom buildpacks whose URL uses a scheme other than the allowed schemes,
+// or whose host is denied or, when allowed hosts are given, not allowed. A
+// host starting with "*." matches every subdomain of the rest. Admin
+// buildpacks are served by the CC and are not checked, nor are requests for
+// lifecycles other than buildpack and windows.
+func NewBuildpackURLValidator(allowedSchemes, allowedHosts, deniedHosts []string) Validator {
+	schemes := map[string]bool{}
+	for _, scheme := range allowedSchemes {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme != "" {
+			schemes[scheme] = true
+		}
+	}
+	return &buildpackURLValidator{schemes: schemes, allowedHosts: allowedHosts, deniedHosts: deniedHosts}
+}
+
+func (v *buildpackURLValidator) Name() string {
+	return BuildpackURLValidatorName
+}
+
+func (v *buildpackURLValidator) Validate(request cc_messages.StagingRequestFromCC) error {
+	if request.Lifecycle != backend.TraditionalLifecycleName && request.Lifecycle != backend.WindowsLifecycleName {
+		return nil
+	}
+	if request.LifecycleData == nil {
+		return nil
+	}
+
+	var lifecycleData struct {
+		Buildpacks []cc_messages.Buildpack `json:"buildpacks"`
+	}
+	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
+	if err != nil {
+		return backend.ErrMalformedStagingRequest
+	}
+
+	for _, buildpack := range lifecycleData.Buildpacks {
+		if buildpack.Name != cc_messages.CUSTOM_BUILDPACK {
+			continue
+		}
+
+		err := v.validateURL(buildpack.Url)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *buildpackURLValidator) validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid buildpack URL: %s", rawURL)
+	}
+
+	if len(v.schemes) > 0 && !v.schemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("buildpack URL scheme not allowed: %s", u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if matchesAnyHost(host, v.deniedHosts) {
+		return fmt.Errorf("buildpack URL host not allowed: %s", host)
+	}
+
+	if len(v.allowedHosts) > 0 && !matchesAnyHost(host, v.allowedHosts) {
+		return fmt.Errorf("buildpack URL host not allowed: %s", host)
+	}
+
+	return nil
+}
+
+func matchesAnyHost(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
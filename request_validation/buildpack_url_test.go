@@ -0,0 +1,122 @@
+package request_validation_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/request_validation"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BuildpackURLValidator", func() {
+	var (
+		validator  request_validation.Validator
+		buildpacks []cc_messages.Buildpack
+		request    cc_messages.StagingRequestFromCC
+	)
+
+	BeforeEach(func() {
+		validator = request_validation.NewBuildpackURLValidator([]string{"https", "git"}, nil, nil)
+		buildpacks = []cc_messages.Buildpack{
+			{Name: "go_buildpack", Key: "go-buildpack-key", Url: "http://file-server/go-buildpack.zip"},
+			{Name: cc_messages.CUSTOM_BUILDPACK, Key: "https://github.com/org/buildpack.git", Url: "https://github.com/org/buildpack.git"},
+		}
+	})
+
+	JustBeforeEach(func() {
+		data, err := json.Marshal(cc_messages.BuildpackStagingData{Buildpacks: buildpacks})
+		Expect(err).NotTo(HaveOccurred())
+
+		lifecycleData := json.RawMessage(data)
+		request = cc_messages.StagingRequestFromCC{AppId: "the-app-id", Lifecycle: "buildpack", LifecycleData: &lifecycleData}
+	})
+
+	It("is named after the policy", func() {
+		Expect(validator.Name()).To(Equal(request_validation.BuildpackURLValidatorName))
+	})
+
+	It("accepts custom buildpacks with an allowed scheme", func() {
+		Expect(validator.Validate(request)).To(Succeed())
+	})
+
+	It("does not check admin buildpacks", func() {
+		buildpacks = buildpacks[:1]
+		validator = request_validation.NewBuildpackURLValidator([]string{"https"}, []string{"github.com"}, nil)
+		Expect(validator.Validate(request)).To(Succeed())
+	})
+
+	Context("when a custom buildpack uses another scheme", func() {
+		BeforeEach(func() {
+			buildpacks[1].Url = "http://example.com/buildpack.zip"
+		})
+
+		It("rejects the request", func() {
+			Expect(validator.Validate(request)).To(MatchError("buildpack URL scheme not allowed: http"))
+		})
+
+		It("accepts the request when no schemes are configured", func() {
+			validator = request_validation.NewBuildpackURLValidator([]string{""}, nil, nil)
+			Expect(validator.Validate(request)).To(Succeed())
+		})
+	})
+
+	Context("when a custom buildpack URL is not absolute", func() {
+		BeforeEach(func() {
+			buildpacks[1].Url = "buildpack.zip"
+		})
+
+		It("rejects the request", func() {
+			Expect(validator.Validate(request)).To(MatchError("invalid buildpack URL: buildpack.zip"))
+		})
+	})
+
+	Context("when hosts are allowed", func() {
+		It("accepts allowed hosts", func() {
+			validator = request_validation.NewBuildpackURLValidator(nil, []string{"github.com"}, nil)
+			Expect(validator.Validate(request)).To(Succeed())
+		})
+
+		It("accepts subdomains of wildcard hosts", func() {
+			buildpacks[1].Url = "https://buildpacks.example.com/buildpack.zip"
+			validator = request_validation.NewBuildpackURLValidator(nil, []string{"*.example.com"}, nil)
+			Expect(validator.Validate(request)).To(Succeed())
+		})
+
+		It("rejects other hosts", func() {
+			validator = request_validation.NewBuildpackURLValidator(nil, []string{"*.example.com"}, nil)
+			Expect(validator.Validate(request)).To(MatchError("buildpack URL host not allowed: github.com"))
+		})
+	})
+
+	Context("when hosts are denied", func() {
+		It("rejects denied hosts, even if allowed", func() {
+			validator = request_validation.NewBuildpackURLValidator(nil, []string{"github.com"}, []string{"GitHub.com"})
+			Expect(validator.Validate(request)).To(MatchError("buildpack URL host not allowed: github.com"))
+		})
+
+		It("accepts other hosts", func() {
+			validator = request_validation.NewBuildpackURLValidator(nil, nil, []string{"*.example.com"})
+			Expect(validator.Validate(request)).To(Succeed())
+		})
+	})
+
+	Context("when the lifecycle data is malformed", func() {
+		It("rejects the request", func() {
+			lifecycleData := json.RawMessage(`{"buildpacks":"nope"}`)
+			request.LifecycleData = &lifecycleData
+			Expect(validator.Validate(request)).To(Equal(backend.ErrMalformedStagingRequest))
+		})
+	})
+
+	Context("when the request is for another lifecycle", func() {
+		It("does not look into its lifecycle data", func() {
+			lifecycleData := json.RawMessage(`{"buildpacks":"nope"}`)
+			request.Lifecycle = "kpack"
+			request.LifecycleData = &lifecycleData
+			Expect(validator.Validate(request)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,84 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/request_validation"
+)
+
+type FakeValidator struct {
+	NameStub        func() string
+	nameMutex       sync.RWMutex
+	nameArgsForCall []struct{}
+	nameReturns     struct {
+		result1 string
+	}
+	ValidateStub        func(request cc_messages.StagingRequestFromCC) error
+	validateMutex       sync.RWMutex
+	validateArgsForCall []struct {
+		request cc_messages.StagingRequestFromCC
+	}
+	validateReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeValidator) Name() string {
+	fake.nameMutex.Lock()
+	fake.nameArgsForCall = append(fake.nameArgsForCall, struct{}{})
+	fake.nameMutex.Unlock()
+	if fake.NameStub != nil {
+		return fake.NameStub()
+	} else {
+		return fake.nameReturns.result1
+	}
+}
+
+func (fake *FakeValidator) NameCallCount() int {
+	fake.nameMutex.RLock()
+	defer fake.nameMutex.RUnlock()
+	return len(fake.nameArgsForCall)
+}
+
+func (fake *FakeValidator) NameReturns(result1 string) {
+	fake.NameStub = nil
+	fake.nameReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeValidator) Validate(request cc_messages.StagingRequestFromCC) error {
+	fake.validateMutex.Lock()
+	fake.validateArgsForCall = append(fake.validateArgsForCall, struct {
+		request cc_messages.StagingRequestFromCC
+	}{request})
+	fake.validateMutex.Unlock()
+	if fake.ValidateStub != nil {
+		return fake.ValidateStub(request)
+	} else {
+		return fake.validateReturns.result1
+	}
+}
+
+func (fake *FakeValidator) ValidateCallCount() int {
+	fake.validateMutex.RLock()
+	defer fake.validateMutex.RUnlock()
+	return len(fake.validateArgsForCall)
+}
+
+func (fake *FakeValidator) ValidateArgsForCall(i int) cc_messages.StagingRequestFromCC {
+	fake.validateMutex.RLock()
+	defer fake.validateMutex.RUnlock()
+	return fake.validateArgsForCall[i].request
+}
+
+func (fake *FakeValidator) ValidateReturns(result1 error) {
+	fake.ValidateStub = nil
+	fake.validateReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ request_validation.Validator = new(FakeValidator)
@@ -0,0 +1,246 @@
+package request_validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/backend"
+)
+
+const (
+	SizeLimitsValidatorName        = "size-limits"
+	StackValidatorName             = "stack"
+	EnvironmentPolicyValidatorName = "environment-policy"
+	BuildpackURLValidatorName      = "buildpack-url"
+
+	DefaultValidators = SizeLimitsValidatorName + "," + StackValidatorName + "," + EnvironmentPolicyValidatorName + "," + BuildpackURLValidatorName
+
+	// Metrics
+	stagingRequestsRejected = metric.Counter("StagingRequestsRejected")
+	rejectionMetricPrefix   = "StagingRequestsRejectedBy"
+)
+
+// Validator checks a staging request before a task is desired for it, e.g.
+// against operator policy. Rejected requests are reported to CC as invalid.
+//
+//go:generate counterfeiter -o fakes/fake_validator.go . Validator
+type Validator interface {
+	Name() string
+	Validate(request cc_messages.StagingRequestFromCC) error
+}
+
+// RejectionError is returned for staging requests rejected by a validator.
+// It reads as the validator's error so that CC sees the reason.
+type RejectionError struct {
+	Validator string
+	Err       error
+}
+
+func (e *RejectionError) Error() string {
+	return e.Err.Error()
+}
+
+// SizeLimitError is returned for staging requests that exceed one of the
+// size limits. Size is zero when the request was turned away before its
+// full size was known.
+type SizeLimitError struct {
+	Limit string
+	Unit  string
+	Size  int
+	Max   int
+}
+
+func (e *SizeLimitError) Error() string {
+	if e.Size == 0 {
+		return fmt.Sprintf("%s too large: more than %d %s", e.Limit, e.Max, e.Unit)
+	}
+	return fmt.Sprintf("%s too large: %d %s (max %d)", e.Limit, e.Size, e.Unit, e.Max)
+}
+
+// Validate runs each validator in order over the request and returns the
+// first rejection, counting it against the rejecting validator.
+func Validate(logger lager.Logger, validators []Validator, request cc_messages.StagingRequestFromCC) error {
+	for _, validator := range validators {
+		err := validator.Validate(request)
+		if err != nil {
+			logger.Info("staging-request-rejected", lager.Data{"validator": validator.Name(), "reason": err.Error()})
+			stagingRequestsRejected.Increment()
+			metric.Counter(RejectionMetricName(validator.Name())).Increment()
+			return &RejectionError{Validator: validator.Name(), Err: err}
+		}
+	}
+
+	return nil
+}
+
+// RejectionMetricName is the name of the counter of the requests rejected by
+// a validator, e.g. StagingRequestsRejectedBySizeLimits.
+func RejectionMetricName(validatorName string) string {
+	name := rejectionMetricPrefix
+	for _, word := range strings.Split(validatorName, "-") {
+		name += strings.Title(word)
+	}
+	return name
+}
+
+// Config holds the operator policy the validators enforce. Empty values are
+// not enforced.
+type Config struct {
+	MaxEnvironmentVariables    int
+	MaxEnvironmentBytes        int
+	MaxBuildpacks              int
+	AllowedStacks              []string
+	DeniedEnvironmentVariables []string
+	AllowedBuildpackURLSchemes []string
+	AllowedBuildpackHosts      []string
+	DeniedBuildpackHosts       []string
+}
+
+// NewChain returns the named validators, in order.
+func NewChain(names []string, config Config) ([]Validator, error) {
+	validators := []Validator{}
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case SizeLimitsValidatorName:
+			validators = append(validators, NewSizeLimitsValidator(config.MaxEnvironmentVariables, config.MaxEnvironmentBytes, config.MaxBuildpacks))
+		case StackValidatorName:
+			validators = append(validators, NewStackValidator(config.AllowedStacks))
+		case EnvironmentPolicyValidatorName:
+			validators = append(validators, NewEnvironmentPolicyValidator(config.DeniedEnvironmentVariables))
+		case BuildpackURLValidatorName:
+			validators = append(validators, NewBuildpackURLValidator(config.AllowedBuildpackURLSchemes, config.AllowedBuildpackHosts, config.DeniedBuildpackHosts))
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown staging request validator: %s", name)
+		}
+	}
+
+	return validators, nil
+}
+
+type sizeLimitsValidator struct {
+	maxVariables  int
+	maxBytes      int
+	maxBuildpacks int
+}
+
+// NewSizeLimitsValidator returns a validator that rejects requests whose
+// environment has more than maxVariables variables or takes more than
+// maxBytes bytes of names and values, or that list more than maxBuildpacks
+// buildpacks.
+func NewSizeLimitsValidator(maxVariables, maxBytes, maxBuildpacks int) Validator {
+	return &sizeLimitsValidator{maxVariables: maxVariables, maxBytes: maxBytes, maxBuildpacks: maxBuildpacks}
+}
+
+func (v *sizeLimitsValidator) Name() string {
+	return SizeLimitsValidatorName
+}
+
+func (v *sizeLimitsValidator) Validate(request cc_messages.StagingRequestFromCC) error {
+	if v.maxVariables > 0 && len(request.Environment) > v.maxVariables {
+		return backend.ErrTooManyEnvironmentVariables
+	}
+
+	if v.maxBytes > 0 {
+		size := 0
+		for _, envVar := range request.Environment {
+			size += len(envVar.Name) + len(envVar.Value)
+		}
+		if size > v.maxBytes {
+			return &SizeLimitError{Limit: "environment", Unit: "bytes", Size: size, Max: v.maxBytes}
+		}
+	}
+
+	if v.maxBuildpacks > 0 && request.LifecycleData != nil {
+		var lifecycleData struct {
+			Buildpacks []json.RawMessage `json:"buildpacks"`
+		}
+		err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
+		if err != nil {
+			return backend.ErrMalformedStagingRequest
+		}
+
+		if len(lifecycleData.Buildpacks) > v.maxBuildpacks {
+			return &SizeLimitError{Limit: "buildpack list", Unit: "buildpacks", Size: len(lifecycleData.Buildpacks), Max: v.maxBuildpacks}
+		}
+	}
+
+	return nil
+}
+
+type stackValidator struct {
+	allowed map[string]bool
+}
+
+// NewStackValidator returns a validator that only accepts requests for the
+// allowed stacks. Requests without a stack, e.g. for docker, are accepted.
+func NewStackValidator(allowedStacks []string) Validator {
+	allowed := map[string]bool{}
+	for _, stack := range allowedStacks {
+		allowed[stack] = true
+	}
+	return &stackValidator{allowed: allowed}
+}
+
+func (v *stackValidator) Name() string {
+	return StackValidatorName
+}
+
+func (v *stackValidator) Validate(request cc_messages.StagingRequestFromCC) error {
+	if len(v.allowed) == 0 || request.LifecycleData == nil {
+		return nil
+	}
+
+	var lifecycleData struct {
+		Stack string `json:"stack"`
+	}
+	err := json.Unmarshal(*request.LifecycleData, &lifecycleData)
+	if err != nil {
+		return backend.ErrMalformedStagingRequest
+	}
+
+	if lifecycleData.Stack != "" && !v.allowed[lifecycleData.Stack] {
+		return fmt.Errorf("stack not allowed: %s", lifecycleData.Stack)
+	}
+
+	return nil
+}
+
+type environmentPolicyValidator struct {
+	denied []string
+}
+
+// NewEnvironmentPolicyValidator returns a validator that rejects requests
+// setting any of the denied environment variables. A denied name ending in
+// '*' denies every variable with that prefix.
+func NewEnvironmentPolicyValidator(deniedNames []string) Validator {
+	return &environmentPolicyValidator{denied: deniedNames}
+}
+
+func (v *environmentPolicyValidator) Name() string {
+	return EnvironmentPolicyValidatorName
+}
+
+func (v *environmentPolicyValidator) Validate(request cc_messages.StagingRequestFromCC) error {
+	for _, envVar := range request.Environment {
+		for _, denied := range v.denied {
+			if matchesName(envVar.Name, denied) {
+				return errors.New("environment variable not allowed: " + envVar.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func matchesName(name, pattern string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))
+	}
+	return name == pattern
+}
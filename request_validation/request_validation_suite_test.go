@@ -0,0 +1,13 @@
+package request_validation_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRequestValidation(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Request Validation Suite")
+}
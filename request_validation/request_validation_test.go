@@ -0,0 +1,186 @@
+package request_validation_test
+
+import (
+	"encoding/json"
+	"errors"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/request_validation"
+	"code.cloudfoundry.org/stager/request_validation/fakes"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestValidation", func() {
+	var request cc_messages.StagingRequestFromCC
+
+	BeforeEach(func() {
+		lifecycleData := json.RawMessage(`{"stack":"cflinuxfs2"}`)
+		request = cc_messages.StagingRequestFromCC{
+			AppId:         "the-app-id",
+			Lifecycle:     "buildpack",
+			LifecycleData: &lifecycleData,
+			Environment: []*models.EnvironmentVariable{
+				{Name: "VCAP_APPLICATION", Value: "foo"},
+				{Name: "HTTP_PROXY", Value: "proxy"},
+			},
+		}
+	})
+
+	Describe("Validate", func() {
+		var (
+			first, second *fakes.FakeValidator
+			metricSender  *fake.FakeMetricSender
+		)
+
+		BeforeEach(func() {
+			metricSender = fake.NewFakeMetricSender()
+			metrics.Initialize(metricSender, nil)
+
+			first = &fakes.FakeValidator{}
+			first.NameReturns("first-policy")
+			second = &fakes.FakeValidator{}
+			second.NameReturns("second-policy")
+		})
+
+		It("runs the validators in order", func() {
+			err := request_validation.Validate(lagertest.NewTestLogger("test"), []request_validation.Validator{first, second}, request)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(first.ValidateCallCount()).To(Equal(1))
+			Expect(second.ValidateCallCount()).To(Equal(1))
+			Expect(first.ValidateArgsForCall(0)).To(Equal(request))
+		})
+
+		Context("when a validator rejects the request", func() {
+			BeforeEach(func() {
+				first.ValidateReturns(errors.New("nope"))
+			})
+
+			It("stops at the first rejection", func() {
+				err := request_validation.Validate(lagertest.NewTestLogger("test"), []request_validation.Validator{first, second}, request)
+				Expect(err).To(Equal(&request_validation.RejectionError{Validator: "first-policy", Err: errors.New("nope")}))
+				Expect(err.Error()).To(Equal("nope"))
+				Expect(second.ValidateCallCount()).To(Equal(0))
+			})
+
+			It("counts the rejection against the validator", func() {
+				request_validation.Validate(lagertest.NewTestLogger("test"), []request_validation.Validator{first, second}, request)
+				Expect(metricSender.GetCounter("StagingRequestsRejected")).To(BeEquivalentTo(1))
+				Expect(metricSender.GetCounter("StagingRequestsRejectedByFirstPolicy")).To(BeEquivalentTo(1))
+			})
+		})
+	})
+
+	Describe("NewChain", func() {
+		It("builds the named validators in order", func() {
+			validators, err := request_validation.NewChain([]string{"environment-policy", "size-limits"}, request_validation.Config{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(validators).To(HaveLen(2))
+			Expect(validators[0].Name()).To(Equal(request_validation.EnvironmentPolicyValidatorName))
+			Expect(validators[1].Name()).To(Equal(request_validation.SizeLimitsValidatorName))
+		})
+
+		It("rejects unknown validators", func() {
+			_, err := request_validation.NewChain([]string{"size-limits", "bogus"}, request_validation.Config{})
+			Expect(err).To(MatchError("unknown staging request validator: bogus"))
+		})
+	})
+
+	Describe("size limits", func() {
+		It("accepts requests within the limits", func() {
+			Expect(request_validation.NewSizeLimitsValidator(2, 100, 1).Validate(request)).To(Succeed())
+		})
+
+		It("rejects too many environment variables", func() {
+			err := request_validation.NewSizeLimitsValidator(1, 0, 0).Validate(request)
+			Expect(err).To(Equal(backend.ErrTooManyEnvironmentVariables))
+		})
+
+		It("rejects environments that are too large", func() {
+			err := request_validation.NewSizeLimitsValidator(0, 20, 0).Validate(request)
+			Expect(err).To(MatchError("environment too large: 34 bytes (max 20)"))
+			Expect(err).To(BeAssignableToTypeOf(&request_validation.SizeLimitError{}))
+		})
+
+		Context("when the request lists buildpacks", func() {
+			BeforeEach(func() {
+				lifecycleData := json.RawMessage(`{"stack":"cflinuxfs2","buildpacks":[{"name":"a"},{"name":"b"},{"name":"c"}]}`)
+				request.LifecycleData = &lifecycleData
+			})
+
+			It("accepts as many buildpacks as allowed", func() {
+				Expect(request_validation.NewSizeLimitsValidator(0, 0, 3).Validate(request)).To(Succeed())
+			})
+
+			It("rejects more buildpacks than allowed", func() {
+				err := request_validation.NewSizeLimitsValidator(0, 0, 2).Validate(request)
+				Expect(err).To(Equal(&request_validation.SizeLimitError{Limit: "buildpack list", Unit: "buildpacks", Size: 3, Max: 2}))
+				Expect(err).To(MatchError("buildpack list too large: 3 buildpacks (max 2)"))
+			})
+		})
+
+		It("rejects malformed lifecycle data when limiting buildpacks", func() {
+			lifecycleData := json.RawMessage(`{"buildpacks":"nope"}`)
+			request.LifecycleData = &lifecycleData
+
+			err := request_validation.NewSizeLimitsValidator(0, 0, 2).Validate(request)
+			Expect(err).To(Equal(backend.ErrMalformedStagingRequest))
+		})
+	})
+
+	Describe("SizeLimitError", func() {
+		It("reads as the limit exceeded", func() {
+			err := &request_validation.SizeLimitError{Limit: "staging request", Unit: "bytes", Size: 2048, Max: 1024}
+			Expect(err).To(MatchError("staging request too large: 2048 bytes (max 1024)"))
+		})
+
+		It("does without the size when it is not known", func() {
+			err := &request_validation.SizeLimitError{Limit: "staging request", Unit: "bytes", Max: 1024}
+			Expect(err).To(MatchError("staging request too large: more than 1024 bytes"))
+		})
+	})
+
+	Describe("stack", func() {
+		It("accepts allowed stacks", func() {
+			Expect(request_validation.NewStackValidator([]string{"cflinuxfs2"}).Validate(request)).To(Succeed())
+		})
+
+		It("rejects other stacks", func() {
+			err := request_validation.NewStackValidator([]string{"cflinuxfs3"}).Validate(request)
+			Expect(err).To(MatchError("stack not allowed: cflinuxfs2"))
+		})
+
+		It("accepts every stack when none are configured", func() {
+			Expect(request_validation.NewStackValidator(nil).Validate(request)).To(Succeed())
+		})
+
+		It("accepts requests without a stack", func() {
+			lifecycleData := json.RawMessage(`{"docker_image":"busybox"}`)
+			request.LifecycleData = &lifecycleData
+			Expect(request_validation.NewStackValidator([]string{"cflinuxfs3"}).Validate(request)).To(Succeed())
+		})
+	})
+
+	Describe("environment policy", func() {
+		It("rejects denied variables", func() {
+			err := request_validation.NewEnvironmentPolicyValidator([]string{"HTTP_PROXY"}).Validate(request)
+			Expect(err).To(MatchError("environment variable not allowed: HTTP_PROXY"))
+		})
+
+		It("rejects variables matching a denied prefix", func() {
+			err := request_validation.NewEnvironmentPolicyValidator([]string{"HTTP_*"}).Validate(request)
+			Expect(err).To(MatchError("environment variable not allowed: HTTP_PROXY"))
+		})
+
+		It("accepts other variables", func() {
+			Expect(request_validation.NewEnvironmentPolicyValidator([]string{"CF_INSTANCE_*"}).Validate(request)).To(Succeed())
+		})
+	})
+})
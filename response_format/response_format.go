@@ -0,0 +1,188 @@
+package response_format
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+const (
+	DiegoNative = "diego-native"
+	DEACompat   = "dea-compat"
+	CAPIV3      = "capi-v3"
+
+	// FormatHeader is the header of a staging request selecting the format
+	// of its staging response, overriding the configured format.
+	FormatHeader = "X-Staging-Response-Format"
+)
+
+// Formatter encodes the staging response that is delivered to CC.
+type Formatter interface {
+	Format(response cc_messages.StagingResponseForCC) ([]byte, error)
+}
+
+// Registry maps format names, as configured by operators, to formatters.
+type Registry map[string]Formatter
+
+// DefaultRegistry returns a registry containing the Diego, DEA compatible
+// and CC v3 formats.
+func DefaultRegistry() Registry {
+	return Registry{
+		DiegoNative: NewDiegoNativeFormatter(),
+		DEACompat:   NewDEACompatFormatter(),
+		CAPIV3:      NewCAPIV3Formatter(),
+	}
+}
+
+// Register adds a formatter for a format. It is an error to register a
+// format twice.
+func (r Registry) Register(name string, formatter Formatter) error {
+	if _, exists := r[name]; exists {
+		return fmt.Errorf("response format %s is already registered", name)
+	}
+	r[name] = formatter
+	return nil
+}
+
+func (r Registry) Lookup(name string) (Formatter, error) {
+	formatter, ok := r[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown response format '%s', expected one of: %s", name, strings.Join(r.names(), ", "))
+	}
+	return formatter, nil
+}
+
+func (r Registry) names() []string {
+	names := []string{}
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type diegoNativeFormatter struct{}
+
+// NewDiegoNativeFormatter returns the formatter for the response CC expects
+// from Diego staging.
+func NewDiegoNativeFormatter() Formatter {
+	return diegoNativeFormatter{}
+}
+
+func (diegoNativeFormatter) Format(response cc_messages.StagingResponseForCC) ([]byte, error) {
+	return json.Marshal(response)
+}
+
+type deaCompatFormatter struct{}
+
+// NewDEACompatFormatter returns the formatter for the flat response CC
+// expects from DEA staging: the result fields are at the top level, the
+// start command is a string and the error is described by error_info.
+func NewDEACompatFormatter() Formatter {
+	return deaCompatFormatter{}
+}
+
+type deaErrorInfo struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+func (deaCompatFormatter) Format(response cc_messages.StagingResponseForCC) ([]byte, error) {
+	fields := map[string]interface{}{}
+
+	if response.Result != nil {
+		var result map[string]json.RawMessage
+		err := json.Unmarshal(*response.Result, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range result {
+			fields[key] = value
+		}
+
+		if raw, ok := result["lifecycle_metadata"]; ok {
+			var lifecycleMetadata map[string]json.RawMessage
+			err := json.Unmarshal(raw, &lifecycleMetadata)
+			if err != nil {
+				return nil, err
+			}
+
+			delete(fields, "lifecycle_metadata")
+			for key, value := range lifecycleMetadata {
+				if _, exists := fields[key]; !exists {
+					fields[key] = value
+				}
+			}
+		}
+
+		if raw, ok := result["detected_start_command"]; ok {
+			var startCommands map[string]string
+			err := json.Unmarshal(raw, &startCommands)
+			if err != nil {
+				return nil, err
+			}
+			fields["detected_start_command"] = startCommands["web"]
+		}
+	}
+
+	if response.Error != nil {
+		fields["error"] = response.Error.Message
+		fields["error_info"] = deaErrorInfo{
+			Type:    response.Error.Id,
+			Message: response.Error.Message,
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+type capiV3Formatter struct{}
+
+// NewCAPIV3Formatter returns the formatter for the response the CC v3
+// internal staging completed endpoint expects: the droplet fields and the
+// lifecycle, with its type and metadata, are structured separately.
+func NewCAPIV3Formatter() Formatter {
+	return capiV3Formatter{}
+}
+
+type capiV3Lifecycle struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type capiV3Response struct {
+	Droplet   map[string]json.RawMessage `json:"droplet,omitempty"`
+	Lifecycle *capiV3Lifecycle           `json:"lifecycle,omitempty"`
+	Error     *cc_messages.StagingError  `json:"error,omitempty"`
+}
+
+func (capiV3Formatter) Format(response cc_messages.StagingResponseForCC) ([]byte, error) {
+	v3Response := capiV3Response{Error: response.Error}
+
+	if response.Result != nil {
+		var result map[string]json.RawMessage
+		err := json.Unmarshal(*response.Result, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		lifecycle := &capiV3Lifecycle{Data: result["lifecycle_metadata"]}
+		if raw, ok := result["lifecycle_type"]; ok {
+			err := json.Unmarshal(raw, &lifecycle.Type)
+			if err != nil {
+				return nil, err
+			}
+		}
+		delete(result, "lifecycle_type")
+		delete(result, "lifecycle_metadata")
+
+		v3Response.Droplet = result
+		v3Response.Lifecycle = lifecycle
+	}
+
+	return json.Marshal(v3Response)
+}
@@ -0,0 +1,13 @@
+package response_format_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestResponseFormat(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Response Format Suite")
+}
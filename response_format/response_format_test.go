@@ -0,0 +1,119 @@
+package response_format_test
+
+import (
+	"encoding/json"
+
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/response_format"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Response formats", func() {
+	var successResponse, failureResponse cc_messages.StagingResponseForCC
+
+	BeforeEach(func() {
+		result := json.RawMessage(`{
+			"lifecycle_type": "buildpack",
+			"lifecycle_metadata": {
+				"buildpack_key": "ruby-key",
+				"detected_buildpack": "Ruby"
+			},
+			"execution_metadata": "{\"start_command\":\"rackup\"}",
+			"detected_start_command": {"web": "rackup"}
+		}`)
+		successResponse = cc_messages.StagingResponseForCC{Result: &result}
+
+		failureResponse = cc_messages.StagingResponseForCC{
+			Error: &cc_messages.StagingError{Id: cc_messages.BUILDPACK_DETECT_FAILED, Message: "staging failed"},
+		}
+	})
+
+	Describe("Registry", func() {
+		It("looks up the default formats", func() {
+			registry := response_format.DefaultRegistry()
+
+			_, err := registry.Lookup(response_format.DiegoNative)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = registry.Lookup(response_format.DEACompat)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = registry.Lookup(response_format.CAPIV3)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("fails to look up unknown formats", func() {
+			_, err := response_format.DefaultRegistry().Lookup("warden")
+			Expect(err).To(MatchError("unknown response format 'warden', expected one of: capi-v3, dea-compat, diego-native"))
+		})
+
+		It("does not register a format twice", func() {
+			err := response_format.DefaultRegistry().Register(response_format.DEACompat, response_format.NewDEACompatFormatter())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("diego-native", func() {
+		It("encodes the response as is", func() {
+			payload, err := response_format.NewDiegoNativeFormatter().Format(successResponse)
+			Expect(err).NotTo(HaveOccurred())
+
+			expected, err := json.Marshal(successResponse)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(payload).To(MatchJSON(expected))
+		})
+	})
+
+	Describe("dea-compat", func() {
+		It("flattens successful results", func() {
+			payload, err := response_format.NewDEACompatFormatter().Format(successResponse)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(payload).To(MatchJSON(`{
+				"lifecycle_type": "buildpack",
+				"buildpack_key": "ruby-key",
+				"detected_buildpack": "Ruby",
+				"execution_metadata": "{\"start_command\":\"rackup\"}",
+				"detected_start_command": "rackup"
+			}`))
+		})
+
+		It("describes failures with error_info", func() {
+			payload, err := response_format.NewDEACompatFormatter().Format(failureResponse)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(payload).To(MatchJSON(`{
+				"error": "staging failed",
+				"error_info": {"type": "NoAppDetectedError", "message": "staging failed"}
+			}`))
+		})
+	})
+
+	Describe("capi-v3", func() {
+		It("structures successful results into the droplet and the lifecycle", func() {
+			payload, err := response_format.NewCAPIV3Formatter().Format(successResponse)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(payload).To(MatchJSON(`{
+				"droplet": {
+					"execution_metadata": "{\"start_command\":\"rackup\"}",
+					"detected_start_command": {"web": "rackup"}
+				},
+				"lifecycle": {
+					"type": "buildpack",
+					"data": {
+						"buildpack_key": "ruby-key",
+						"detected_buildpack": "Ruby"
+					}
+				}
+			}`))
+		})
+
+		It("passes failures through", func() {
+			payload, err := response_format.NewCAPIV3Formatter().Format(failureResponse)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(payload).To(MatchJSON(`{
+				"error": {"id": "NoAppDetectedError", "message": "staging failed"}
+			}`))
+		})
+	})
+})
@@ -0,0 +1,136 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/response_journal"
+)
+
+type FakeJournal struct {
+	RecordStub        func(stagingGuid, completionCallback, requestId string, response []byte) error
+	recordMutex       sync.RWMutex
+	recordArgsForCall []struct {
+		stagingGuid        string
+		completionCallback string
+		requestId          string
+		response           []byte
+	}
+	recordReturns struct {
+		result1 error
+	}
+	PendingStub        func() ([]response_journal.Entry, error)
+	pendingMutex       sync.RWMutex
+	pendingArgsForCall []struct{}
+	pendingReturns     struct {
+		result1 []response_journal.Entry
+		result2 error
+	}
+	RemoveStub        func(stagingGuid string) error
+	removeMutex       sync.RWMutex
+	removeArgsForCall []struct {
+		stagingGuid string
+	}
+	removeReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeJournal) Record(stagingGuid string, completionCallback string, requestId string, response []byte) error {
+	var responseCopy []byte
+	if response != nil {
+		responseCopy = make([]byte, len(response))
+		copy(responseCopy, response)
+	}
+	fake.recordMutex.Lock()
+	fake.recordArgsForCall = append(fake.recordArgsForCall, struct {
+		stagingGuid        string
+		completionCallback string
+		requestId          string
+		response           []byte
+	}{stagingGuid, completionCallback, requestId, responseCopy})
+	fake.recordMutex.Unlock()
+	if fake.RecordStub != nil {
+		return fake.RecordStub(stagingGuid, completionCallback, requestId, response)
+	} else {
+		return fake.recordReturns.result1
+	}
+}
+
+func (fake *FakeJournal) RecordCallCount() int {
+	fake.recordMutex.RLock()
+	defer fake.recordMutex.RUnlock()
+	return len(fake.recordArgsForCall)
+}
+
+func (fake *FakeJournal) RecordArgsForCall(i int) (string, string, string, []byte) {
+	fake.recordMutex.RLock()
+	defer fake.recordMutex.RUnlock()
+	return fake.recordArgsForCall[i].stagingGuid, fake.recordArgsForCall[i].completionCallback, fake.recordArgsForCall[i].requestId, fake.recordArgsForCall[i].response
+}
+
+func (fake *FakeJournal) RecordReturns(result1 error) {
+	fake.RecordStub = nil
+	fake.recordReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeJournal) Pending() ([]response_journal.Entry, error) {
+	fake.pendingMutex.Lock()
+	fake.pendingArgsForCall = append(fake.pendingArgsForCall, struct{}{})
+	fake.pendingMutex.Unlock()
+	if fake.PendingStub != nil {
+		return fake.PendingStub()
+	} else {
+		return fake.pendingReturns.result1, fake.pendingReturns.result2
+	}
+}
+
+func (fake *FakeJournal) PendingCallCount() int {
+	fake.pendingMutex.RLock()
+	defer fake.pendingMutex.RUnlock()
+	return len(fake.pendingArgsForCall)
+}
+
+func (fake *FakeJournal) PendingReturns(result1 []response_journal.Entry, result2 error) {
+	fake.PendingStub = nil
+	fake.pendingReturns = struct {
+		result1 []response_journal.Entry
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeJournal) Remove(stagingGuid string) error {
+	fake.removeMutex.Lock()
+	fake.removeArgsForCall = append(fake.removeArgsForCall, struct {
+		stagingGuid string
+	}{stagingGuid})
+	fake.removeMutex.Unlock()
+	if fake.RemoveStub != nil {
+		return fake.RemoveStub(stagingGuid)
+	} else {
+		return fake.removeReturns.result1
+	}
+}
+
+func (fake *FakeJournal) RemoveCallCount() int {
+	fake.removeMutex.RLock()
+	defer fake.removeMutex.RUnlock()
+	return len(fake.removeArgsForCall)
+}
+
+func (fake *FakeJournal) RemoveArgsForCall(i int) string {
+	fake.removeMutex.RLock()
+	defer fake.removeMutex.RUnlock()
+	return fake.removeArgsForCall[i].stagingGuid
+}
+
+func (fake *FakeJournal) RemoveReturns(result1 error) {
+	fake.RemoveStub = nil
+	fake.removeReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ response_journal.Journal = new(FakeJournal)
@@ -0,0 +1,114 @@
+package response_journal
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/cc_client"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	// Metrics
+	stagingResponsesPending  = metric.Metric("StagingResponsesPending")
+	stagingResponsesReplayed = metric.Counter("StagingResponsesReplayed")
+)
+
+type replayer struct {
+	logger   lager.Logger
+	journal  Journal
+	ccClient cc_client.CcClient
+	interval time.Duration
+	clock    clock.Clock
+}
+
+// NewReplayer returns a runner that delivers the responses in the journal to
+// CC at every interval, oldest first. A pass stops at the first response CC
+// cannot take yet, and responses CC rejects outright are dropped.
+func NewReplayer(logger lager.Logger, journal Journal, ccClient cc_client.CcClient, interval time.Duration, clock clock.Clock) ifrit.Runner {
+	return &replayer{
+		logger:   logger.Session("response-replayer"),
+		journal:  journal,
+		ccClient: ccClient,
+		interval: interval,
+		clock:    clock,
+	}
+}
+
+func (r *replayer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C():
+			r.replay()
+		}
+	}
+}
+
+func (r *replayer) replay() {
+	logger := r.logger.Session("replay")
+
+	entries, err := r.journal.Pending()
+	if err != nil {
+		logger.Error("failed-to-read-journal", err)
+		return
+	}
+	r.reportPending(len(entries))
+
+	if len(entries) == 0 {
+		return
+	}
+
+	logger.Info("starting", lager.Data{"pending": len(entries)})
+	defer logger.Info("finished")
+
+	for i, entry := range entries {
+		entryLogger := logger.Session("entry", lager.Data{"staging-guid": entry.StagingGuid, "request-id": entry.RequestId})
+
+		err := r.ccClient.StagingComplete(entry.StagingGuid, "", entry.CompletionCallback, entry.RequestId, entry.Response, entryLogger)
+		if err != nil && Retryable(err) {
+			entryLogger.Info("cc-unavailable", lager.Data{"error": err.Error()})
+			r.reportPending(len(entries) - i)
+			return
+		}
+
+		if err != nil {
+			entryLogger.Error("cc-rejected-response", err)
+		} else {
+			stagingResponsesReplayed.Increment()
+		}
+
+		err = r.journal.Remove(entry.StagingGuid)
+		if err != nil {
+			entryLogger.Error("failed-to-remove-entry", err)
+		}
+	}
+
+	r.reportPending(0)
+}
+
+func (r *replayer) reportPending(pending int) {
+	err := stagingResponsesPending.Send(pending)
+	if err != nil {
+		r.logger.Error("failed-to-send-pending-responses-metric", err)
+	}
+}
+
+// Retryable reports whether a failure to deliver a staging response to CC
+// may succeed later: CC could not be reached or failed with a server error.
+func Retryable(err error) bool {
+	if responseErr, ok := err.(*cc_client.BadResponseError); ok {
+		return responseErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package response_journal_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/cc_client"
+	cc_fakes "code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/response_journal"
+	"code.cloudfoundry.org/stager/response_journal/fakes"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replayer", func() {
+	var (
+		fakeJournal      *fakes.FakeJournal
+		fakeCCClient     *cc_fakes.FakeCcClient
+		fakeClock        *fakeclock.FakeClock
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		process          ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeJournal = &fakes.FakeJournal{}
+		fakeJournal.PendingReturns([]response_journal.Entry{
+			{StagingGuid: "guid-1", CompletionCallback: "callback-1", RequestId: "request-1", Response: []byte(`{"one":1}`)},
+			{StagingGuid: "guid-2", RequestId: "request-2", Response: []byte(`{"two":2}`)},
+		}, nil)
+
+		fakeCCClient = &cc_fakes.FakeCcClient{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+	})
+
+	JustBeforeEach(func() {
+		replayer := response_journal.NewReplayer(lagertest.NewTestLogger("test"), fakeJournal, fakeCCClient, time.Minute, fakeClock)
+		process = ifrit.Invoke(replayer)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("does nothing until the interval has passed", func() {
+		Consistently(fakeJournal.PendingCallCount).Should(BeZero())
+	})
+
+	Context("when the interval passes", func() {
+		JustBeforeEach(func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(fakeJournal.PendingCallCount).Should(Equal(1))
+		})
+
+		It("delivers the pending responses in order", func() {
+			Eventually(fakeCCClient.StagingCompleteCallCount).Should(Equal(2))
+
+			guid, payload, _ := fakeCCClient.StagingCompleteArgsForCall(0)
+			Expect(guid).To(Equal("guid-1"))
+			Expect(payload).To(MatchJSON(`{"one":1}`))
+
+			guid, payload, _ = fakeCCClient.StagingCompleteArgsForCall(1)
+			Expect(guid).To(Equal("guid-2"))
+			Expect(payload).To(MatchJSON(`{"two":2}`))
+		})
+
+		It("removes the delivered responses from the journal", func() {
+			Eventually(fakeJournal.RemoveCallCount).Should(Equal(2))
+			Expect(fakeJournal.RemoveArgsForCall(0)).To(Equal("guid-1"))
+			Expect(fakeJournal.RemoveArgsForCall(1)).To(Equal("guid-2"))
+		})
+
+		It("emits the replayed and pending response metrics", func() {
+			Eventually(func() uint64 {
+				return fakeMetricSender.GetCounter("StagingResponsesReplayed")
+			}).Should(BeEquivalentTo(2))
+			Eventually(func() float64 {
+				return fakeMetricSender.GetValue("StagingResponsesPending").Value
+			}).Should(Equal(float64(0)))
+		})
+
+		Context("when CC cannot be reached", func() {
+			BeforeEach(func() {
+				fakeCCClient.StagingCompleteReturns(&cc_client.BadResponseError{StatusCode: 503})
+			})
+
+			It("stops at the first response and keeps it", func() {
+				Eventually(fakeCCClient.StagingCompleteCallCount).Should(Equal(1))
+				Consistently(fakeCCClient.StagingCompleteCallCount).Should(Equal(1))
+				Expect(fakeJournal.RemoveCallCount()).To(BeZero())
+			})
+
+			It("reports the responses still pending", func() {
+				Eventually(func() float64 {
+					return fakeMetricSender.GetValue("StagingResponsesPending").Value
+				}).Should(Equal(float64(2)))
+			})
+
+			It("tries again at the next interval", func() {
+				Eventually(fakeCCClient.StagingCompleteCallCount).Should(Equal(1))
+				fakeClock.WaitForWatcherAndIncrement(time.Minute)
+				Eventually(fakeCCClient.StagingCompleteCallCount).Should(Equal(2))
+			})
+		})
+
+		Context("when CC rejects a response", func() {
+			BeforeEach(func() {
+				fakeCCClient.StagingCompleteStub = func(stagingGuid, appId, completionCallback, requestId string, payload []byte, logger lager.Logger) error {
+					if stagingGuid == "guid-1" {
+						return &cc_client.BadResponseError{StatusCode: 400}
+					}
+					return nil
+				}
+			})
+
+			It("drops it and carries on", func() {
+				Eventually(fakeJournal.RemoveCallCount).Should(Equal(2))
+				Expect(fakeMetricSender.GetCounter("StagingResponsesReplayed")).To(BeEquivalentTo(1))
+			})
+		})
+
+		Context("when the journal cannot be read", func() {
+			BeforeEach(func() {
+				fakeJournal.PendingReturns(nil, errors.New("disk gone"))
+			})
+
+			It("delivers nothing", func() {
+				Consistently(fakeCCClient.StagingCompleteCallCount).Should(BeZero())
+			})
+		})
+	})
+})
+
+var _ = Describe("Retryable", func() {
+	It("retries errors reaching CC", func() {
+		Expect(response_journal.Retryable(errors.New("connection refused"))).To(BeTrue())
+	})
+
+	It("retries server errors", func() {
+		Expect(response_journal.Retryable(&cc_client.BadResponseError{StatusCode: 502})).To(BeTrue())
+	})
+
+	It("does not retry responses CC rejected", func() {
+		Expect(response_journal.Retryable(&cc_client.BadResponseError{StatusCode: 404})).To(BeFalse())
+	})
+})
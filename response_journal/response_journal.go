@@ -0,0 +1,164 @@
+package response_journal
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+var ErrDisabled = errors.New("response journal is disabled")
+
+// Entry is a staging response waiting to be delivered to CC.
+type Entry struct {
+	StagingGuid        string          `json:"staging_guid"`
+	CompletionCallback string          `json:"completion_callback,omitempty"`
+	RequestId          string          `json:"request_id,omitempty"`
+	Response           json.RawMessage `json:"response"`
+	RecordedAt         time.Time       `json:"recorded_at"`
+}
+
+// Journal durably keeps the staging responses that could not be delivered to
+// CC, so that they can be delivered once CC can be reached again rather than
+// being lost with the completed task.
+//
+//go:generate counterfeiter -o fakes/fake_journal.go . Journal
+type Journal interface {
+	// Record keeps the response until it is removed. Recording a response
+	// for a staging guid again replaces the previous one.
+	Record(stagingGuid, completionCallback, requestId string, response []byte) error
+
+	// Pending returns the recorded responses, oldest first.
+	Pending() ([]Entry, error)
+
+	Remove(stagingGuid string) error
+}
+
+type journal struct {
+	dir   string
+	clock clock.Clock
+}
+
+// NewJournal returns a Journal that writes one file per response into dir.
+// If dir is empty, the journal is disabled and Record returns ErrDisabled.
+func NewJournal(dir string, clock clock.Clock) Journal {
+	if dir == "" {
+		return disabledJournal{}
+	}
+
+	return &journal{
+		dir:   dir,
+		clock: clock,
+	}
+}
+
+func (j *journal) Record(stagingGuid, completionCallback, requestId string, response []byte) error {
+	entry, err := json.Marshal(Entry{
+		StagingGuid:        stagingGuid,
+		CompletionCallback: completionCallback,
+		RequestId:          requestId,
+		Response:           response,
+		RecordedAt:         j.clock.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(j.dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a crash never leaves a
+	// truncated entry behind.
+	tmp, err := ioutil.TempFile(j.dir, ".entry-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(entry)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), j.path(stagingGuid))
+}
+
+func (j *journal) Pending() ([]Entry, error) {
+	files, err := ioutil.ReadDir(j.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		entryJson, err := ioutil.ReadFile(filepath.Join(j.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var entry Entry
+		err = json.Unmarshal(entryJson, &entry)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.Sort(byRecordedAt(entries))
+	return entries, nil
+}
+
+func (j *journal) Remove(stagingGuid string) error {
+	err := os.Remove(j.path(stagingGuid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (j *journal) path(stagingGuid string) string {
+	return filepath.Join(j.dir, filepath.Base(stagingGuid)+".json")
+}
+
+type byRecordedAt []Entry
+
+func (e byRecordedAt) Len() int           { return len(e) }
+func (e byRecordedAt) Swap(i, k int)      { e[i], e[k] = e[k], e[i] }
+func (e byRecordedAt) Less(i, k int) bool { return e[i].RecordedAt.Before(e[k].RecordedAt) }
+
+type disabledJournal struct{}
+
+func (disabledJournal) Record(stagingGuid, completionCallback, requestId string, response []byte) error {
+	return ErrDisabled
+}
+
+func (disabledJournal) Pending() ([]Entry, error) {
+	return nil, nil
+}
+
+func (disabledJournal) Remove(stagingGuid string) error {
+	return nil
+}
@@ -0,0 +1,13 @@
+package response_journal_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestResponseJournal(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Response Journal Suite")
+}
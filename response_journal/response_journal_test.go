@@ -0,0 +1,136 @@
+package response_journal_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/stager/response_journal"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal", func() {
+	var (
+		dir       string
+		fakeClock *fakeclock.FakeClock
+		journal   response_journal.Journal
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "response-journal")
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeClock = fakeclock.NewFakeClock(time.Unix(1000, 0))
+		journal = response_journal.NewJournal(filepath.Join(dir, "journal"), fakeClock)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("has nothing pending before anything is recorded", func() {
+		entries, err := journal.Pending()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("returns recorded responses oldest first", func() {
+		err := journal.Record("guid-2", "callback", "request-2", []byte(`{"result":"two"}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		fakeClock.Increment(time.Second)
+		err = journal.Record("guid-1", "", "request-1", []byte(`{"result":"one"}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		entries, err := journal.Pending()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+
+		Expect(entries[0].StagingGuid).To(Equal("guid-2"))
+		Expect(entries[0].CompletionCallback).To(Equal("callback"))
+		Expect(entries[0].RequestId).To(Equal("request-2"))
+		Expect(entries[0].Response).To(MatchJSON(`{"result":"two"}`))
+		Expect(entries[0].RecordedAt.Equal(time.Unix(1000, 0))).To(BeTrue())
+
+		Expect(entries[1].StagingGuid).To(Equal("guid-1"))
+		Expect(entries[1].Response).To(MatchJSON(`{"result":"one"}`))
+	})
+
+	It("replaces the response recorded for the same staging guid", func() {
+		Expect(journal.Record("guid", "", "", []byte(`{"result":"old"}`))).To(Succeed())
+		Expect(journal.Record("guid", "", "", []byte(`{"result":"new"}`))).To(Succeed())
+
+		entries, err := journal.Pending()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Response).To(MatchJSON(`{"result":"new"}`))
+	})
+
+	It("keeps responses across journals on the same directory", func() {
+		Expect(journal.Record("guid", "", "", []byte(`{}`))).To(Succeed())
+
+		reopened := response_journal.NewJournal(filepath.Join(dir, "journal"), fakeClock)
+		entries, err := reopened.Pending()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	It("ignores partially written entries", func() {
+		Expect(journal.Record("guid", "", "", []byte(`{}`))).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "journal", ".entry-123"), []byte(`{"stag`), 0600)).To(Succeed())
+
+		entries, err := journal.Pending()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+	})
+
+	Describe("Remove", func() {
+		It("removes the recorded response", func() {
+			Expect(journal.Record("guid", "", "", []byte(`{}`))).To(Succeed())
+			Expect(journal.Remove("guid")).To(Succeed())
+
+			entries, err := journal.Pending()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("succeeds when nothing was recorded", func() {
+			Expect(journal.Remove("unknown")).To(Succeed())
+		})
+	})
+
+	Context("when a recorded entry is corrupt", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "journal"), 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "journal", "guid.json"), []byte(`{"stag`), 0600)).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			_, err := journal.Pending()
+			Expect(err).To(BeAssignableToTypeOf(&json.SyntaxError{}))
+		})
+	})
+
+	Context("when no directory is given", func() {
+		BeforeEach(func() {
+			journal = response_journal.NewJournal("", fakeClock)
+		})
+
+		It("refuses to record responses", func() {
+			err := journal.Record("guid", "", "", []byte(`{}`))
+			Expect(err).To(Equal(response_journal.ErrDisabled))
+		})
+
+		It("has nothing pending", func() {
+			entries, err := journal.Pending()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+	})
+})
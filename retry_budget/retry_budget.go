@@ -0,0 +1,100 @@
+package retry_budget
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/stager/diego_errors"
+)
+
+var ErrRetryBudgetExhausted = errors.New(diego_errors.RETRY_BUDGET_EXHAUSTED_MESSAGE)
+
+const DefaultWindow = 24 * time.Hour
+
+// RetryBudget tracks the attempts made for each staging of an app, keyed by
+// the app id and the staging guid: requests turned away by validation, staging
+// tasks desired, and retried deliveries of the staging response to CC all
+// spend from the same budget, so that a staging that keeps failing is
+// eventually reported as exhausted instead of being retried forever. A max of
+// zero means attempts are never limited. Stagings that make no attempt for
+// longer than the window are forgotten, so stagings that never complete do
+// not pile up; a window of zero or less keeps them until released.
+type RetryBudget interface {
+	Spend(appId, stagingGuid string) error
+	Release(appId, stagingGuid string)
+}
+
+type stagingAttempts struct {
+	attempts    int
+	lastAttempt time.Time
+}
+
+type retryBudget struct {
+	maxAttempts int
+	window      time.Duration
+	clock       clock.Clock
+
+	lock     sync.Mutex
+	stagings map[string]*stagingAttempts
+}
+
+func NewRetryBudget(maxAttempts int, window time.Duration, clock clock.Clock) RetryBudget {
+	return &retryBudget{
+		maxAttempts: maxAttempts,
+		window:      window,
+		clock:       clock,
+		stagings:    map[string]*stagingAttempts{},
+	}
+}
+
+func stagingKey(appId, stagingGuid string) string {
+	return appId + "/" + stagingGuid
+}
+
+func (b *retryBudget) Spend(appId, stagingGuid string) error {
+	if b.maxAttempts <= 0 {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.clock.Now()
+	b.expire(now)
+
+	key := stagingKey(appId, stagingGuid)
+	staging, ok := b.stagings[key]
+	if !ok {
+		staging = &stagingAttempts{}
+		b.stagings[key] = staging
+	}
+
+	if staging.attempts >= b.maxAttempts {
+		return ErrRetryBudgetExhausted
+	}
+
+	staging.attempts++
+	staging.lastAttempt = now
+	return nil
+}
+
+func (b *retryBudget) expire(now time.Time) {
+	if b.window <= 0 {
+		return
+	}
+
+	for key, staging := range b.stagings {
+		if now.Sub(staging.lastAttempt) > b.window {
+			delete(b.stagings, key)
+		}
+	}
+}
+
+func (b *retryBudget) Release(appId, stagingGuid string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.stagings, stagingKey(appId, stagingGuid))
+}
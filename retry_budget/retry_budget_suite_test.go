@@ -0,0 +1,13 @@
+package retry_budget_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRetryBudget(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Retry Budget Suite")
+}
@@ -0,0 +1,95 @@
+package retry_budget_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/stager/retry_budget"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryBudget", func() {
+	var (
+		budget    retry_budget.RetryBudget
+		fakeClock *fakeclock.FakeClock
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	Context("when the max attempts is zero", func() {
+		BeforeEach(func() {
+			budget = retry_budget.NewRetryBudget(0, time.Hour, fakeClock)
+		})
+
+		It("never exhausts the budget", func() {
+			for i := 0; i < 100; i++ {
+				Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+			}
+		})
+	})
+
+	Context("when the max attempts is positive", func() {
+		BeforeEach(func() {
+			budget = retry_budget.NewRetryBudget(2, time.Hour, fakeClock)
+		})
+
+		It("allows attempts up to the max", func() {
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+			Expect(budget.Spend("app-id", "staging-guid")).To(Equal(retry_budget.ErrRetryBudgetExhausted))
+		})
+
+		It("tracks each staging guid separately", func() {
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+			Expect(budget.Spend("app-id", "other-staging-guid")).To(Succeed())
+		})
+
+		It("tracks the stagings of each app separately", func() {
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+			Expect(budget.Spend("other-app-id", "staging-guid")).To(Succeed())
+		})
+
+		It("forgets stagings with no attempts within the window", func() {
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+
+			fakeClock.Increment(time.Hour)
+			Expect(budget.Spend("app-id", "staging-guid")).To(Equal(retry_budget.ErrRetryBudgetExhausted))
+
+			fakeClock.Increment(time.Second)
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+		})
+
+		It("keeps stagings when the window is zero", func() {
+			budget = retry_budget.NewRetryBudget(1, 0, fakeClock)
+			Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+
+			fakeClock.Increment(1000 * time.Hour)
+			Expect(budget.Spend("app-id", "staging-guid")).To(Equal(retry_budget.ErrRetryBudgetExhausted))
+		})
+
+		Context("when the staging guid is released", func() {
+			BeforeEach(func() {
+				Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+				Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+				budget.Release("app-id", "staging-guid")
+			})
+
+			It("resets the budget", func() {
+				Expect(budget.Spend("app-id", "staging-guid")).To(Succeed())
+			})
+
+			It("leaves the budget of the same staging guid for another app alone", func() {
+				Expect(budget.Spend("other-app-id", "staging-guid")).To(Succeed())
+				Expect(budget.Spend("other-app-id", "staging-guid")).To(Succeed())
+				budget.Release("app-id", "staging-guid")
+				Expect(budget.Spend("other-app-id", "staging-guid")).To(Equal(retry_budget.ErrRetryBudgetExhausted))
+			})
+		})
+	})
+})
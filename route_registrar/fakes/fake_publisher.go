@@ -0,0 +1,55 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/route_registrar"
+)
+
+type FakePublisher struct {
+	PublishStub        func(subject string, data []byte) error
+	publishMutex       sync.RWMutex
+	publishArgsForCall []struct {
+		subject string
+		data    []byte
+	}
+	publishReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakePublisher) Publish(subject string, data []byte) error {
+	fake.publishMutex.Lock()
+	fake.publishArgsForCall = append(fake.publishArgsForCall, struct {
+		subject string
+		data    []byte
+	}{subject, data})
+	fake.publishMutex.Unlock()
+	if fake.PublishStub != nil {
+		return fake.PublishStub(subject, data)
+	} else {
+		return fake.publishReturns.result1
+	}
+}
+
+func (fake *FakePublisher) PublishCallCount() int {
+	fake.publishMutex.RLock()
+	defer fake.publishMutex.RUnlock()
+	return len(fake.publishArgsForCall)
+}
+
+func (fake *FakePublisher) PublishArgsForCall(i int) (string, []byte) {
+	fake.publishMutex.RLock()
+	defer fake.publishMutex.RUnlock()
+	return fake.publishArgsForCall[i].subject, fake.publishArgsForCall[i].data
+}
+
+func (fake *FakePublisher) PublishReturns(result1 error) {
+	fake.PublishStub = nil
+	fake.publishReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ route_registrar.Publisher = new(FakePublisher)
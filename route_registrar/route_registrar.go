@@ -0,0 +1,99 @@
+package route_registrar
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	RegisterSubject   = "router.register"
+	UnregisterSubject = "router.unregister"
+)
+
+//go:generate counterfeiter -o fakes/fake_publisher.go . Publisher
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+type RegistryMessage struct {
+	Host                    string   `json:"host"`
+	Port                    int      `json:"port"`
+	URIs                    []string `json:"uris"`
+	StaleThresholdInSeconds int      `json:"stale_threshold_in_seconds,omitempty"`
+}
+
+type routeRegistrar struct {
+	logger    lager.Logger
+	publisher Publisher
+	message   RegistryMessage
+	interval  time.Duration
+	clock     clock.Clock
+}
+
+// NewRouteRegistrar returns a runner that periodically registers the given
+// routes with the gorouter, and unregisters them when signalled. Routes are
+// considered stale by the router after three missed registrations.
+func NewRouteRegistrar(logger lager.Logger, publisher Publisher, host string, port int, uris []string, interval time.Duration, clock clock.Clock) ifrit.Runner {
+	return &routeRegistrar{
+		logger:    logger.Session("route-registrar"),
+		publisher: publisher,
+		message: RegistryMessage{
+			Host:                    host,
+			Port:                    port,
+			URIs:                    uris,
+			StaleThresholdInSeconds: int(3 * interval / time.Second),
+		},
+		interval: interval,
+		clock:    clock,
+	}
+}
+
+func (r *routeRegistrar) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	payload, err := json.Marshal(r.message)
+	if err != nil {
+		return err
+	}
+
+	r.publish(RegisterSubject, payload)
+	close(ready)
+
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			r.publish(RegisterSubject, payload)
+		case <-signals:
+			r.publish(UnregisterSubject, payload)
+			return nil
+		}
+	}
+}
+
+func (r *routeRegistrar) publish(subject string, payload []byte) {
+	err := r.publisher.Publish(subject, payload)
+	if err != nil {
+		r.logger.Error("failed-to-publish", err, lager.Data{"subject": subject})
+	}
+}
+
+// StackScopedURIs returns each of the URIs prefixed with each of the
+// stacks, e.g. windows2012r2.stager.example.com for stager.example.com and
+// windows2012R2, so that a pool of stagers staging some stacks only can be
+// addressed apart from the others.
+func StackScopedURIs(uris, stacks []string) []string {
+	scoped := []string{}
+	for _, stack := range stacks {
+		for _, uri := range uris {
+			scoped = append(scoped, strings.ToLower(stack)+"."+uri)
+		}
+	}
+	return scoped
+}
@@ -0,0 +1,13 @@
+package route_registrar_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRouteRegistrar(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Route Registrar Suite")
+}
@@ -0,0 +1,95 @@
+package route_registrar_test
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/route_registrar"
+	"code.cloudfoundry.org/stager/route_registrar/fakes"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RouteRegistrar", func() {
+	var (
+		fakePublisher *fakes.FakePublisher
+		fakeClock     *fakeclock.FakeClock
+		process       ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakePublisher = &fakes.FakePublisher{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		registrar := route_registrar.NewRouteRegistrar(
+			lagertest.NewTestLogger("test"),
+			fakePublisher,
+			"10.0.0.1",
+			8888,
+			[]string{"stager.example.com"},
+			20*time.Second,
+			fakeClock,
+		)
+		process = ifrit.Invoke(registrar)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("registers the routes immediately", func() {
+		Expect(fakePublisher.PublishCallCount()).To(Equal(1))
+
+		subject, data := fakePublisher.PublishArgsForCall(0)
+		Expect(subject).To(Equal(route_registrar.RegisterSubject))
+
+		var message route_registrar.RegistryMessage
+		Expect(json.Unmarshal(data, &message)).To(Succeed())
+		Expect(message).To(Equal(route_registrar.RegistryMessage{
+			Host:                    "10.0.0.1",
+			Port:                    8888,
+			URIs:                    []string{"stager.example.com"},
+			StaleThresholdInSeconds: 60,
+		}))
+	})
+
+	It("re-registers the routes every interval", func() {
+		fakeClock.WaitForWatcherAndIncrement(20 * time.Second)
+		Eventually(fakePublisher.PublishCallCount).Should(Equal(2))
+
+		fakeClock.WaitForWatcherAndIncrement(20 * time.Second)
+		Eventually(fakePublisher.PublishCallCount).Should(Equal(3))
+	})
+
+	Context("when signalled", func() {
+		It("unregisters the routes", func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+
+			subject, _ := fakePublisher.PublishArgsForCall(fakePublisher.PublishCallCount() - 1)
+			Expect(subject).To(Equal(route_registrar.UnregisterSubject))
+		})
+	})
+})
+
+var _ = Describe("StackScopedURIs", func() {
+	It("prefixes every URI with every stack", func() {
+		uris := route_registrar.StackScopedURIs([]string{"stager.example.com", "stager.internal"}, []string{"cflinuxfs2", "windows2012R2"})
+		Expect(uris).To(Equal([]string{
+			"cflinuxfs2.stager.example.com",
+			"cflinuxfs2.stager.internal",
+			"windows2012r2.stager.example.com",
+			"windows2012r2.stager.internal",
+		}))
+	})
+
+	It("returns no URIs without stacks", func() {
+		Expect(route_registrar.StackScopedURIs([]string{"stager.example.com"}, nil)).To(BeEmpty())
+	})
+})
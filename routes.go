@@ -3,13 +3,29 @@ package stager
 import "github.com/tedsuo/rata"
 
 const (
-	StageRoute            = "Stage"
-	StopStagingRoute      = "StopStaging"
-	StagingCompletedRoute = "StagingCompleted"
+	StageRoute                     = "Stage"
+	StagePostRoute                 = "StagePost"
+	StopStagingRoute               = "StopStaging"
+	StagingStatusRoute             = "StagingStatus"
+	StagingCompletedRoute          = "StagingCompleted"
+	ReplayStagingRoute             = "ReplayStaging"
+	StatsRoute                     = "Stats"
+	MetricsRoute                   = "Metrics"
+	DeleteBuildArtifactsCacheRoute = "DeleteBuildArtifactsCache"
+	StagingTasksRoute              = "StagingTasks"
+	AuditRoute                     = "Audit"
 )
 
 var Routes = rata.Routes{
 	{Path: "/v1/staging/:staging_guid", Method: "PUT", Name: StageRoute},
+	{Path: "/v1/staging/:staging_guid", Method: "POST", Name: StagePostRoute},
 	{Path: "/v1/staging/:staging_guid", Method: "DELETE", Name: StopStagingRoute},
+	{Path: "/v1/staging/:staging_guid", Method: "GET", Name: StagingStatusRoute},
 	{Path: "/v1/staging/:staging_guid/completed", Method: "POST", Name: StagingCompletedRoute},
+	{Path: "/v1/staging/:staging_guid/replay", Method: "POST", Name: ReplayStagingRoute},
+	{Path: "/v1/stats", Method: "GET", Name: StatsRoute},
+	{Path: "/metrics", Method: "GET", Name: MetricsRoute},
+	{Path: "/v1/build_artifacts_cache/:app_guid", Method: "DELETE", Name: DeleteBuildArtifactsCacheRoute},
+	{Path: "/v1/staging_tasks", Method: "GET", Name: StagingTasksRoute},
+	{Path: "/v1/audit", Method: "GET", Name: AuditRoute},
 }
@@ -0,0 +1,230 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/api"
+	"code.cloudfoundry.org/stager/stager_client"
+)
+
+type FakeClient struct {
+	StageStub        func(stagingGuid string, request cc_messages.StagingRequestFromCC, logger lager.Logger) error
+	stageMutex       sync.RWMutex
+	stageArgsForCall []struct {
+		stagingGuid string
+		request     cc_messages.StagingRequestFromCC
+		logger      lager.Logger
+	}
+	stageReturns struct {
+		result1 error
+	}
+	StopStagingStub        func(stagingGuid string, logger lager.Logger) error
+	stopStagingMutex       sync.RWMutex
+	stopStagingArgsForCall []struct {
+		stagingGuid string
+		logger      lager.Logger
+	}
+	stopStagingReturns struct {
+		result1 error
+	}
+	ReplayStagingStub        func(stagingGuid string, logger lager.Logger) error
+	replayStagingMutex       sync.RWMutex
+	replayStagingArgsForCall []struct {
+		stagingGuid string
+		logger      lager.Logger
+	}
+	replayStagingReturns struct {
+		result1 error
+	}
+	StagingStatusStub        func(stagingGuid string, logger lager.Logger) (*api.StagingStatus, error)
+	stagingStatusMutex       sync.RWMutex
+	stagingStatusArgsForCall []struct {
+		stagingGuid string
+		logger      lager.Logger
+	}
+	stagingStatusReturns struct {
+		result1 *api.StagingStatus
+		result2 error
+	}
+	ListTasksStub        func(logger lager.Logger) ([]api.StagingTask, error)
+	listTasksMutex       sync.RWMutex
+	listTasksArgsForCall []struct {
+		logger lager.Logger
+	}
+	listTasksReturns struct {
+		result1 []api.StagingTask
+		result2 error
+	}
+}
+
+func (fake *FakeClient) Stage(stagingGuid string, request cc_messages.StagingRequestFromCC, logger lager.Logger) error {
+	fake.stageMutex.Lock()
+	fake.stageArgsForCall = append(fake.stageArgsForCall, struct {
+		stagingGuid string
+		request     cc_messages.StagingRequestFromCC
+		logger      lager.Logger
+	}{stagingGuid, request, logger})
+	fake.stageMutex.Unlock()
+	if fake.StageStub != nil {
+		return fake.StageStub(stagingGuid, request, logger)
+	} else {
+		return fake.stageReturns.result1
+	}
+}
+
+func (fake *FakeClient) StageCallCount() int {
+	fake.stageMutex.RLock()
+	defer fake.stageMutex.RUnlock()
+	return len(fake.stageArgsForCall)
+}
+
+func (fake *FakeClient) StageArgsForCall(i int) (string, cc_messages.StagingRequestFromCC, lager.Logger) {
+	fake.stageMutex.RLock()
+	defer fake.stageMutex.RUnlock()
+	return fake.stageArgsForCall[i].stagingGuid, fake.stageArgsForCall[i].request, fake.stageArgsForCall[i].logger
+}
+
+func (fake *FakeClient) StageReturns(result1 error) {
+	fake.StageStub = nil
+	fake.stageReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) StopStaging(stagingGuid string, logger lager.Logger) error {
+	fake.stopStagingMutex.Lock()
+	fake.stopStagingArgsForCall = append(fake.stopStagingArgsForCall, struct {
+		stagingGuid string
+		logger      lager.Logger
+	}{stagingGuid, logger})
+	fake.stopStagingMutex.Unlock()
+	if fake.StopStagingStub != nil {
+		return fake.StopStagingStub(stagingGuid, logger)
+	} else {
+		return fake.stopStagingReturns.result1
+	}
+}
+
+func (fake *FakeClient) StopStagingCallCount() int {
+	fake.stopStagingMutex.RLock()
+	defer fake.stopStagingMutex.RUnlock()
+	return len(fake.stopStagingArgsForCall)
+}
+
+func (fake *FakeClient) StopStagingArgsForCall(i int) (string, lager.Logger) {
+	fake.stopStagingMutex.RLock()
+	defer fake.stopStagingMutex.RUnlock()
+	return fake.stopStagingArgsForCall[i].stagingGuid, fake.stopStagingArgsForCall[i].logger
+}
+
+func (fake *FakeClient) StopStagingReturns(result1 error) {
+	fake.StopStagingStub = nil
+	fake.stopStagingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) ReplayStaging(stagingGuid string, logger lager.Logger) error {
+	fake.replayStagingMutex.Lock()
+	fake.replayStagingArgsForCall = append(fake.replayStagingArgsForCall, struct {
+		stagingGuid string
+		logger      lager.Logger
+	}{stagingGuid, logger})
+	fake.replayStagingMutex.Unlock()
+	if fake.ReplayStagingStub != nil {
+		return fake.ReplayStagingStub(stagingGuid, logger)
+	} else {
+		return fake.replayStagingReturns.result1
+	}
+}
+
+func (fake *FakeClient) ReplayStagingCallCount() int {
+	fake.replayStagingMutex.RLock()
+	defer fake.replayStagingMutex.RUnlock()
+	return len(fake.replayStagingArgsForCall)
+}
+
+func (fake *FakeClient) ReplayStagingArgsForCall(i int) (string, lager.Logger) {
+	fake.replayStagingMutex.RLock()
+	defer fake.replayStagingMutex.RUnlock()
+	return fake.replayStagingArgsForCall[i].stagingGuid, fake.replayStagingArgsForCall[i].logger
+}
+
+func (fake *FakeClient) ReplayStagingReturns(result1 error) {
+	fake.ReplayStagingStub = nil
+	fake.replayStagingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) StagingStatus(stagingGuid string, logger lager.Logger) (*api.StagingStatus, error) {
+	fake.stagingStatusMutex.Lock()
+	fake.stagingStatusArgsForCall = append(fake.stagingStatusArgsForCall, struct {
+		stagingGuid string
+		logger      lager.Logger
+	}{stagingGuid, logger})
+	fake.stagingStatusMutex.Unlock()
+	if fake.StagingStatusStub != nil {
+		return fake.StagingStatusStub(stagingGuid, logger)
+	} else {
+		return fake.stagingStatusReturns.result1, fake.stagingStatusReturns.result2
+	}
+}
+
+func (fake *FakeClient) StagingStatusCallCount() int {
+	fake.stagingStatusMutex.RLock()
+	defer fake.stagingStatusMutex.RUnlock()
+	return len(fake.stagingStatusArgsForCall)
+}
+
+func (fake *FakeClient) StagingStatusArgsForCall(i int) (string, lager.Logger) {
+	fake.stagingStatusMutex.RLock()
+	defer fake.stagingStatusMutex.RUnlock()
+	return fake.stagingStatusArgsForCall[i].stagingGuid, fake.stagingStatusArgsForCall[i].logger
+}
+
+func (fake *FakeClient) StagingStatusReturns(result1 *api.StagingStatus, result2 error) {
+	fake.StagingStatusStub = nil
+	fake.stagingStatusReturns = struct {
+		result1 *api.StagingStatus
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) ListTasks(logger lager.Logger) ([]api.StagingTask, error) {
+	fake.listTasksMutex.Lock()
+	fake.listTasksArgsForCall = append(fake.listTasksArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.listTasksMutex.Unlock()
+	if fake.ListTasksStub != nil {
+		return fake.ListTasksStub(logger)
+	} else {
+		return fake.listTasksReturns.result1, fake.listTasksReturns.result2
+	}
+}
+
+func (fake *FakeClient) ListTasksCallCount() int {
+	fake.listTasksMutex.RLock()
+	defer fake.listTasksMutex.RUnlock()
+	return len(fake.listTasksArgsForCall)
+}
+
+func (fake *FakeClient) ListTasksArgsForCall(i int) lager.Logger {
+	fake.listTasksMutex.RLock()
+	defer fake.listTasksMutex.RUnlock()
+	return fake.listTasksArgsForCall[i].logger
+}
+
+func (fake *FakeClient) ListTasksReturns(result1 []api.StagingTask, result2 error) {
+	fake.ListTasksStub = nil
+	fake.listTasksReturns = struct {
+		result1 []api.StagingTask
+		result2 error
+	}{result1, result2}
+}
+
+var _ stager_client.Client = new(FakeClient)
@@ -0,0 +1,221 @@
+package stager_client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager"
+	"code.cloudfoundry.org/stager/api"
+	"github.com/tedsuo/rata"
+)
+
+const MaxRetryInterval = 30 * time.Second
+
+var ErrNotFound = errors.New("staging task not found")
+
+// StagingRequestError is returned for staging requests the stager turned
+// away, with the error it reported for CC.
+type StagingRequestError struct {
+	StatusCode   int
+	StagingError *cc_messages.StagingError
+}
+
+func (e *StagingRequestError) Error() string {
+	if e.StagingError == nil {
+		return fmt.Sprintf("stager turned the staging request away with status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("stager turned the staging request away with status code %d: %s", e.StatusCode, e.StagingError.Message)
+}
+
+type BadResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *BadResponseError) Error() string {
+	return fmt.Sprintf("stager responded with status code %d: %s", e.StatusCode, e.Body)
+}
+
+//go:generate counterfeiter -o fakes/fake_client.go . Client
+type Client interface {
+	Stage(stagingGuid string, request cc_messages.StagingRequestFromCC, logger lager.Logger) error
+	StopStaging(stagingGuid string, logger lager.Logger) error
+	ReplayStaging(stagingGuid string, logger lager.Logger) error
+	StagingStatus(stagingGuid string, logger lager.Logger) (*api.StagingStatus, error)
+	ListTasks(logger lager.Logger) ([]api.StagingTask, error)
+}
+
+type client struct {
+	requestGenerator *rata.RequestGenerator
+	httpClient       *http.Client
+	retries          int
+	retryInterval    time.Duration
+	clock            clock.Clock
+}
+
+// NewClient returns a client of the stager API at stagerURL. Requests that
+// fail to reach the stager, or that it answers with a 502, 503 or 504, are
+// retried up to retries times, waiting retryInterval after the first failure
+// and doubling it after every further one. Every call of the API is safe to
+// retry, as stagings are identified by their guid.
+func NewClient(stagerURL string, httpClient *http.Client, retries int, retryInterval time.Duration, clock clock.Clock) Client {
+	return &client{
+		requestGenerator: rata.NewRequestGenerator(stagerURL, stager.Routes),
+		httpClient:       httpClient,
+		retries:          retries,
+		retryInterval:    retryInterval,
+		clock:            clock,
+	}
+}
+
+func (c *client) Stage(stagingGuid string, request cc_messages.StagingRequestFromCC, logger lager.Logger) error {
+	logger = logger.Session("stage", lager.Data{"staging-guid": stagingGuid})
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.do(logger, stager.StageRoute, rata.Params{"staging_guid": stagingGuid}, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusAccepted {
+		return nil
+	}
+
+	responseBody, _ := ioutil.ReadAll(response.Body)
+
+	var stagingResponse cc_messages.StagingResponseForCC
+	if json.Unmarshal(responseBody, &stagingResponse) == nil && stagingResponse.Error != nil {
+		return &StagingRequestError{StatusCode: response.StatusCode, StagingError: stagingResponse.Error}
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return &StagingRequestError{StatusCode: response.StatusCode}
+	}
+	return &BadResponseError{StatusCode: response.StatusCode, Body: string(responseBody)}
+}
+
+func (c *client) StopStaging(stagingGuid string, logger lager.Logger) error {
+	logger = logger.Session("stop-staging", lager.Data{"staging-guid": stagingGuid})
+	return c.call(logger, stager.StopStagingRoute, rata.Params{"staging_guid": stagingGuid}, http.StatusAccepted, nil)
+}
+
+// ReplayStaging delivers the result of a completed staging task to the
+// cloud controller again. It returns ErrNotFound for unknown stagings, and a
+// BadResponseError with status code 409 for stagings that have not completed
+// or are already being delivered.
+func (c *client) ReplayStaging(stagingGuid string, logger lager.Logger) error {
+	logger = logger.Session("replay-staging", lager.Data{"staging-guid": stagingGuid})
+	return c.call(logger, stager.ReplayStagingRoute, rata.Params{"staging_guid": stagingGuid}, http.StatusOK, nil)
+}
+
+func (c *client) StagingStatus(stagingGuid string, logger lager.Logger) (*api.StagingStatus, error) {
+	logger = logger.Session("staging-status", lager.Data{"staging-guid": stagingGuid})
+
+	status := &api.StagingStatus{}
+	err := c.call(logger, stager.StagingStatusRoute, rata.Params{"staging_guid": stagingGuid}, http.StatusOK, status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (c *client) ListTasks(logger lager.Logger) ([]api.StagingTask, error) {
+	logger = logger.Session("list-tasks")
+
+	var tasks []api.StagingTask
+	err := c.call(logger, stager.StagingTasksRoute, nil, http.StatusOK, &tasks)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// call calls a route of the API that has no request body, decoding the
+// response into result if it is not nil.
+func (c *client) call(logger lager.Logger, route string, params rata.Params, expectedStatus int, result interface{}) error {
+	response, err := c.do(logger, route, params, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if response.StatusCode != expectedStatus {
+		body, _ := ioutil.ReadAll(response.Body)
+		return &BadResponseError{StatusCode: response.StatusCode, Body: string(body)}
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func (c *client) do(logger lager.Logger, route string, params rata.Params, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+
+		request, err := c.requestGenerator.CreateRequest(route, params, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+
+		response, err := c.httpClient.Do(request)
+		if attempt == c.retries || (err == nil && !retryable(response.StatusCode)) {
+			return response, err
+		}
+
+		if err != nil {
+			logger.Error("request-failed", err, lager.Data{"attempt": attempt + 1})
+		} else {
+			logger.Info("stager-unavailable", lager.Data{"attempt": attempt + 1, "status-code": response.StatusCode})
+			response.Body.Close()
+		}
+
+		c.clock.Sleep(backoff(c.retryInterval, attempt))
+	}
+}
+
+func retryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff doubles the retry interval after every failed attempt, up to
+// MaxRetryInterval.
+func backoff(retryInterval time.Duration, attempt int) time.Duration {
+	interval := retryInterval
+	for i := 0; i < attempt && interval < MaxRetryInterval; i++ {
+		interval *= 2
+	}
+
+	if interval > MaxRetryInterval {
+		return MaxRetryInterval
+	}
+	return interval
+}
@@ -0,0 +1,13 @@
+package stager_client_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStagerClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Stager Client Suite")
+}
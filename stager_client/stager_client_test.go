@@ -0,0 +1,194 @@
+package stager_client_test
+
+import (
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/api"
+	"code.cloudfoundry.org/stager/stager_client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		fakeStager *ghttp.Server
+		fakeClock  *fakeclock.FakeClock
+		logger     lager.Logger
+		client     stager_client.Client
+	)
+
+	BeforeEach(func() {
+		fakeStager = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+		client = stager_client.NewClient(fakeStager.URL(), http.DefaultClient, 0, time.Second, fakeClock)
+	})
+
+	AfterEach(func() {
+		fakeStager.Close()
+	})
+
+	Describe("Stage", func() {
+		var request cc_messages.StagingRequestFromCC
+
+		BeforeEach(func() {
+			request = cc_messages.StagingRequestFromCC{AppId: "the-app-id", Lifecycle: "buildpack"}
+		})
+
+		It("puts the staging request", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("PUT", "/v1/staging/the-staging-guid"),
+				ghttp.VerifyJSONRepresenting(request),
+				ghttp.RespondWith(http.StatusAccepted, nil),
+			))
+
+			Expect(client.Stage("the-staging-guid", request, logger)).To(Succeed())
+		})
+
+		It("returns the staging error of requests turned away", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusBadRequest, `{"error":{"id":"InvalidStagingRequest","message":"bad request"}}`))
+
+			err := client.Stage("the-staging-guid", request, logger)
+			Expect(err).To(Equal(&stager_client.StagingRequestError{
+				StatusCode:   http.StatusBadRequest,
+				StagingError: &cc_messages.StagingError{Id: "InvalidStagingRequest", Message: "bad request"},
+			}))
+		})
+
+		It("reports requests for unknown lifecycles", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, nil))
+
+			err := client.Stage("the-staging-guid", request, logger)
+			Expect(err).To(Equal(&stager_client.StagingRequestError{StatusCode: http.StatusNotFound}))
+		})
+	})
+
+	Describe("StopStaging", func() {
+		It("deletes the staging", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("DELETE", "/v1/staging/the-staging-guid"),
+				ghttp.RespondWith(http.StatusAccepted, nil),
+			))
+
+			Expect(client.StopStaging("the-staging-guid", logger)).To(Succeed())
+		})
+
+		It("returns ErrNotFound for unknown stagings", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, nil))
+
+			Expect(client.StopStaging("the-staging-guid", logger)).To(Equal(stager_client.ErrNotFound))
+		})
+	})
+
+	Describe("ReplayStaging", func() {
+		It("replays the staging", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v1/staging/the-staging-guid/replay"),
+				ghttp.RespondWith(http.StatusOK, nil),
+			))
+
+			Expect(client.ReplayStaging("the-staging-guid", logger)).To(Succeed())
+		})
+
+		It("reports stagings that cannot be replayed", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusConflict, nil))
+
+			err := client.ReplayStaging("the-staging-guid", logger)
+			Expect(err).To(Equal(&stager_client.BadResponseError{StatusCode: http.StatusConflict, Body: ""}))
+		})
+	})
+
+	Describe("StagingStatus", func() {
+		It("returns the status of the staging", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v1/staging/the-staging-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"task_guid":"the-staging-guid","state":"running"}`),
+			))
+
+			status, err := client.StagingStatus("the-staging-guid", logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(&api.StagingStatus{TaskGuid: "the-staging-guid", State: "running"}))
+		})
+
+		It("returns the response of unexpected responses", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, "boom"))
+
+			_, err := client.StagingStatus("the-staging-guid", logger)
+			Expect(err).To(Equal(&stager_client.BadResponseError{StatusCode: http.StatusInternalServerError, Body: "boom"}))
+		})
+	})
+
+	Describe("ListTasks", func() {
+		It("returns the staging tasks", func() {
+			fakeStager.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v1/staging_tasks"),
+				ghttp.RespondWith(http.StatusOK, `[{"app_id":"the-app-id","task_guid":"the-task-guid","state":"Running","age_in_seconds":30}]`),
+			))
+
+			tasks, err := client.ListTasks(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tasks).To(Equal([]api.StagingTask{
+				{AppId: "the-app-id", TaskGuid: "the-task-guid", State: "Running", AgeInSeconds: 30},
+			}))
+		})
+	})
+
+	Context("when retries are configured", func() {
+		BeforeEach(func() {
+			client = stager_client.NewClient(fakeStager.URL(), http.DefaultClient, 2, time.Second, fakeClock)
+		})
+
+		listTasks := func() <-chan error {
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := client.ListTasks(logger)
+				errCh <- err
+			}()
+			return errCh
+		}
+
+		It("retries while the stager is unavailable, backing off", func() {
+			fakeStager.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, nil),
+				ghttp.RespondWith(http.StatusBadGateway, nil),
+				ghttp.RespondWith(http.StatusOK, `[]`),
+			)
+
+			errCh := listTasks()
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Consistently(errCh).ShouldNot(Receive())
+			fakeClock.WaitForWatcherAndIncrement(2 * time.Second)
+
+			Eventually(errCh).Should(Receive(BeNil()))
+			Expect(fakeStager.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("gives up once the retries are exhausted", func() {
+			fakeStager.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, nil),
+				ghttp.RespondWith(http.StatusServiceUnavailable, nil),
+				ghttp.RespondWith(http.StatusServiceUnavailable, "busy"),
+			)
+
+			errCh := listTasks()
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			fakeClock.WaitForWatcherAndIncrement(2 * time.Second)
+
+			Eventually(errCh).Should(Receive(Equal(&stager_client.BadResponseError{StatusCode: http.StatusServiceUnavailable, Body: "busy"})))
+		})
+
+		It("does not retry other failures", func() {
+			fakeStager.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, nil))
+
+			Eventually(listTasks()).Should(Receive(HaveOccurred()))
+			Expect(fakeStager.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,204 @@
+package staging_audit
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+)
+
+type Outcome string
+
+const (
+	Pending   Outcome = "pending"
+	Rejected  Outcome = "rejected"
+	Succeeded Outcome = "succeeded"
+	Failed    Outcome = "failed"
+)
+
+const DefaultSize = 100
+
+// Entry is a staging request received by the stager, and what became of it.
+type Entry struct {
+	StagingGuid  string     `json:"staging_guid"`
+	RequestId    string     `json:"request_id,omitempty"`
+	AppId        string     `json:"app_id,omitempty"`
+	Lifecycle    string     `json:"lifecycle,omitempty"`
+	Outcome      Outcome    `json:"outcome"`
+	ErrorId      string     `json:"error_id,omitempty"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	ReceivedAt   *time.Time `json:"received_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+}
+
+// Query selects audit entries. Empty fields match every entry, and a limit
+// of zero or less returns all matching entries.
+type Query struct {
+	AppId   string
+	Outcome Outcome
+	Limit   int
+}
+
+func (q Query) matches(entry Entry) bool {
+	if q.AppId != "" && entry.AppId != q.AppId {
+		return false
+	}
+	if q.Outcome != "" && entry.Outcome != q.Outcome {
+		return false
+	}
+	return true
+}
+
+// Log keeps the most recent staging requests in memory so that operators
+// can see what became of them without searching the stager logs.
+type Log interface {
+	// Received records a staging request about to be turned into a task.
+	Received(stagingGuid, requestId, appId, lifecycle string)
+
+	// Rejected records that a staging request was turned away with the
+	// given error. Requests that were not recorded as received are
+	// recorded on their own.
+	Rejected(stagingGuid string, stagingErr *cc_messages.StagingError)
+
+	// Completed records the outcome of the staging task of every pending
+	// request for the staging, failed if the error is not nil.
+	Completed(stagingGuid string, stagingErr *cc_messages.StagingError)
+
+	// Entries returns the entries matching the query, most recent first.
+	Entries(query Query) []Entry
+}
+
+type auditLog struct {
+	clock clock.Clock
+
+	lock    sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewLog returns a log of the given number of most recent staging requests.
+// A size of zero or less disables the log.
+func NewLog(size int, clock clock.Clock) Log {
+	if size <= 0 {
+		return disabled{}
+	}
+
+	return &auditLog{
+		clock:   clock,
+		entries: make([]Entry, size),
+	}
+}
+
+func (l *auditLog) Received(stagingGuid, requestId, appId, lifecycle string) {
+	now := l.clock.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.add(Entry{
+		StagingGuid: stagingGuid,
+		RequestId:   requestId,
+		AppId:       appId,
+		Lifecycle:   lifecycle,
+		Outcome:     Pending,
+		ReceivedAt:  &now,
+	})
+}
+
+func (l *auditLog) Rejected(stagingGuid string, stagingErr *cc_messages.StagingError) {
+	now := l.clock.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i := l.count() - 1; i >= 0; i-- {
+		entry := l.at(i)
+		if entry.StagingGuid == stagingGuid && entry.Outcome == Pending {
+			resolve(entry, Rejected, stagingErr, now)
+			return
+		}
+	}
+
+	entry := Entry{StagingGuid: stagingGuid, ReceivedAt: &now}
+	resolve(&entry, Rejected, stagingErr, now)
+	l.add(entry)
+}
+
+func (l *auditLog) Completed(stagingGuid string, stagingErr *cc_messages.StagingError) {
+	now := l.clock.Now()
+
+	outcome := Succeeded
+	if stagingErr != nil {
+		outcome = Failed
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i := 0; i < l.count(); i++ {
+		entry := l.at(i)
+		if entry.StagingGuid == stagingGuid && entry.Outcome == Pending {
+			resolve(entry, outcome, stagingErr, now)
+		}
+	}
+}
+
+func (l *auditLog) Entries(query Query) []Entry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entries := []Entry{}
+	for i := l.count() - 1; i >= 0; i-- {
+		if query.Limit > 0 && len(entries) >= query.Limit {
+			break
+		}
+
+		entry := *l.at(i)
+		if query.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+func (l *auditLog) add(entry Entry) {
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+func (l *auditLog) count() int {
+	if l.full {
+		return len(l.entries)
+	}
+	return l.next
+}
+
+// at returns the i-th entry in the order recorded, oldest first.
+func (l *auditLog) at(i int) *Entry {
+	if l.full {
+		i = (l.next + i) % len(l.entries)
+	}
+	return &l.entries[i]
+}
+
+func resolve(entry *Entry, outcome Outcome, stagingErr *cc_messages.StagingError, now time.Time) {
+	entry.Outcome = outcome
+	entry.CompletedAt = &now
+	if stagingErr != nil {
+		entry.ErrorId = stagingErr.Id
+		entry.ErrorMessage = stagingErr.Message
+	}
+}
+
+type disabled struct{}
+
+func (disabled) Received(stagingGuid, requestId, appId, lifecycle string)           {}
+func (disabled) Rejected(stagingGuid string, stagingErr *cc_messages.StagingError)  {}
+func (disabled) Completed(stagingGuid string, stagingErr *cc_messages.StagingError) {}
+func (disabled) Entries(query Query) []Entry                                        { return []Entry{} }
@@ -0,0 +1,13 @@
+package staging_audit_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStagingAudit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Staging Audit Suite")
+}
@@ -0,0 +1,163 @@
+package staging_audit_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/staging_audit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Log", func() {
+	var (
+		fakeClock *fakeclock.FakeClock
+		size      int
+		auditLog  staging_audit.Log
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		size = 3
+	})
+
+	JustBeforeEach(func() {
+		auditLog = staging_audit.NewLog(size, fakeClock)
+	})
+
+	guids := func(entries []staging_audit.Entry) []string {
+		result := []string{}
+		for _, entry := range entries {
+			result = append(result, entry.StagingGuid)
+		}
+		return result
+	}
+
+	It("records received requests as pending", func() {
+		receivedAt := fakeClock.Now()
+		auditLog.Received("staging-guid", "request-id", "app-id", "buildpack")
+
+		entries := auditLog.Entries(staging_audit.Query{})
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].StagingGuid).To(Equal("staging-guid"))
+		Expect(entries[0].RequestId).To(Equal("request-id"))
+		Expect(entries[0].AppId).To(Equal("app-id"))
+		Expect(entries[0].Lifecycle).To(Equal("buildpack"))
+		Expect(entries[0].Outcome).To(Equal(staging_audit.Pending))
+		Expect(*entries[0].ReceivedAt).To(Equal(receivedAt))
+		Expect(entries[0].CompletedAt).To(BeNil())
+	})
+
+	It("returns the most recent entries first", func() {
+		auditLog.Received("guid-1", "", "app-id", "buildpack")
+		auditLog.Received("guid-2", "", "app-id", "buildpack")
+
+		Expect(guids(auditLog.Entries(staging_audit.Query{}))).To(Equal([]string{"guid-2", "guid-1"}))
+	})
+
+	It("keeps only the most recent requests", func() {
+		for _, guid := range []string{"guid-1", "guid-2", "guid-3", "guid-4", "guid-5"} {
+			auditLog.Received(guid, "", "app-id", "buildpack")
+		}
+
+		Expect(guids(auditLog.Entries(staging_audit.Query{}))).To(Equal([]string{"guid-5", "guid-4", "guid-3"}))
+	})
+
+	Describe("Rejected", func() {
+		It("resolves the pending request for the staging", func() {
+			auditLog.Received("staging-guid", "", "app-id", "buildpack")
+			fakeClock.Increment(time.Second)
+			auditLog.Rejected("staging-guid", &cc_messages.StagingError{Id: "StagerBusy", Message: "stager is busy"})
+
+			entries := auditLog.Entries(staging_audit.Query{})
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].AppId).To(Equal("app-id"))
+			Expect(entries[0].Outcome).To(Equal(staging_audit.Rejected))
+			Expect(entries[0].ErrorId).To(Equal("StagerBusy"))
+			Expect(entries[0].ErrorMessage).To(Equal("stager is busy"))
+			Expect(*entries[0].CompletedAt).To(Equal(fakeClock.Now()))
+		})
+
+		It("records requests that were not received on their own", func() {
+			auditLog.Rejected("staging-guid", &cc_messages.StagingError{Id: "StagerBusy", Message: "stager is busy"})
+
+			entries := auditLog.Entries(staging_audit.Query{})
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].StagingGuid).To(Equal("staging-guid"))
+			Expect(entries[0].Outcome).To(Equal(staging_audit.Rejected))
+			Expect(*entries[0].ReceivedAt).To(Equal(fakeClock.Now()))
+		})
+	})
+
+	Describe("Completed", func() {
+		BeforeEach(func() {
+			size = 5
+		})
+
+		JustBeforeEach(func() {
+			auditLog.Received("staging-guid", "", "app-id", "buildpack")
+			auditLog.Received("other-guid", "", "app-id", "buildpack")
+			auditLog.Received("staging-guid", "", "app-id", "buildpack")
+			fakeClock.Increment(time.Minute)
+		})
+
+		It("succeeds every pending request for the staging", func() {
+			auditLog.Completed("staging-guid", nil)
+
+			entries := auditLog.Entries(staging_audit.Query{})
+			Expect(entries[0].Outcome).To(Equal(staging_audit.Succeeded))
+			Expect(*entries[0].CompletedAt).To(Equal(fakeClock.Now()))
+			Expect(entries[1].Outcome).To(Equal(staging_audit.Pending))
+			Expect(entries[2].Outcome).To(Equal(staging_audit.Succeeded))
+		})
+
+		It("fails them with the staging error", func() {
+			auditLog.Completed("staging-guid", &cc_messages.StagingError{Id: cc_messages.BUILDPACK_COMPILE_FAILED, Message: "staging failed"})
+
+			entries := auditLog.Entries(staging_audit.Query{Outcome: staging_audit.Failed})
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].ErrorId).To(Equal(cc_messages.BUILDPACK_COMPILE_FAILED))
+			Expect(entries[0].ErrorMessage).To(Equal("staging failed"))
+		})
+	})
+
+	Describe("Entries", func() {
+		BeforeEach(func() {
+			size = 5
+		})
+
+		JustBeforeEach(func() {
+			auditLog.Received("guid-1", "", "app-1", "buildpack")
+			auditLog.Received("guid-2", "", "app-2", "docker")
+			auditLog.Received("guid-3", "", "app-1", "buildpack")
+			auditLog.Rejected("guid-3", &cc_messages.StagingError{Id: "StagerBusy"})
+		})
+
+		It("filters by app id", func() {
+			Expect(guids(auditLog.Entries(staging_audit.Query{AppId: "app-1"}))).To(Equal([]string{"guid-3", "guid-1"}))
+		})
+
+		It("filters by outcome", func() {
+			Expect(guids(auditLog.Entries(staging_audit.Query{Outcome: staging_audit.Pending}))).To(Equal([]string{"guid-2", "guid-1"}))
+		})
+
+		It("limits the number of entries", func() {
+			Expect(guids(auditLog.Entries(staging_audit.Query{Limit: 2}))).To(Equal([]string{"guid-3", "guid-2"}))
+		})
+	})
+
+	Context("when the size is zero", func() {
+		BeforeEach(func() {
+			size = 0
+		})
+
+		It("records nothing", func() {
+			auditLog.Received("staging-guid", "", "app-id", "buildpack")
+			auditLog.Rejected("other-guid", &cc_messages.StagingError{Id: "StagerBusy"})
+
+			Expect(auditLog.Entries(staging_audit.Query{})).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,88 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/stager/staging_events"
+)
+
+type FakeEmitter struct {
+	RunStub        func(signals <-chan os.Signal, ready chan<- struct{}) error
+	runMutex       sync.RWMutex
+	runArgsForCall []struct {
+		signals <-chan os.Signal
+		ready   chan<- struct{}
+	}
+	runReturns struct {
+		result1 error
+	}
+	EmitStub        func(eventType staging_events.Type, stagingGuid string, data map[string]interface{})
+	emitMutex       sync.RWMutex
+	emitArgsForCall []struct {
+		eventType   staging_events.Type
+		stagingGuid string
+		data        map[string]interface{}
+	}
+}
+
+func (fake *FakeEmitter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	fake.runMutex.Lock()
+	fake.runArgsForCall = append(fake.runArgsForCall, struct {
+		signals <-chan os.Signal
+		ready   chan<- struct{}
+	}{signals, ready})
+	fake.runMutex.Unlock()
+	if fake.RunStub != nil {
+		return fake.RunStub(signals, ready)
+	} else {
+		return fake.runReturns.result1
+	}
+}
+
+func (fake *FakeEmitter) RunCallCount() int {
+	fake.runMutex.RLock()
+	defer fake.runMutex.RUnlock()
+	return len(fake.runArgsForCall)
+}
+
+func (fake *FakeEmitter) RunArgsForCall(i int) (<-chan os.Signal, chan<- struct{}) {
+	fake.runMutex.RLock()
+	defer fake.runMutex.RUnlock()
+	return fake.runArgsForCall[i].signals, fake.runArgsForCall[i].ready
+}
+
+func (fake *FakeEmitter) RunReturns(result1 error) {
+	fake.RunStub = nil
+	fake.runReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeEmitter) Emit(eventType staging_events.Type, stagingGuid string, data map[string]interface{}) {
+	fake.emitMutex.Lock()
+	fake.emitArgsForCall = append(fake.emitArgsForCall, struct {
+		eventType   staging_events.Type
+		stagingGuid string
+		data        map[string]interface{}
+	}{eventType, stagingGuid, data})
+	fake.emitMutex.Unlock()
+	if fake.EmitStub != nil {
+		fake.EmitStub(eventType, stagingGuid, data)
+	}
+}
+
+func (fake *FakeEmitter) EmitCallCount() int {
+	fake.emitMutex.RLock()
+	defer fake.emitMutex.RUnlock()
+	return len(fake.emitArgsForCall)
+}
+
+func (fake *FakeEmitter) EmitArgsForCall(i int) (staging_events.Type, string, map[string]interface{}) {
+	fake.emitMutex.RLock()
+	defer fake.emitMutex.RUnlock()
+	return fake.emitArgsForCall[i].eventType, fake.emitArgsForCall[i].stagingGuid, fake.emitArgsForCall[i].data
+}
+
+var _ staging_events.Emitter = new(FakeEmitter)
@@ -0,0 +1,53 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/staging_events"
+)
+
+type FakeSink struct {
+	SendStub        func(payload []byte) error
+	sendMutex       sync.RWMutex
+	sendArgsForCall []struct {
+		payload []byte
+	}
+	sendReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeSink) Send(payload []byte) error {
+	fake.sendMutex.Lock()
+	fake.sendArgsForCall = append(fake.sendArgsForCall, struct {
+		payload []byte
+	}{payload})
+	fake.sendMutex.Unlock()
+	if fake.SendStub != nil {
+		return fake.SendStub(payload)
+	} else {
+		return fake.sendReturns.result1
+	}
+}
+
+func (fake *FakeSink) SendCallCount() int {
+	fake.sendMutex.RLock()
+	defer fake.sendMutex.RUnlock()
+	return len(fake.sendArgsForCall)
+}
+
+func (fake *FakeSink) SendArgsForCall(i int) []byte {
+	fake.sendMutex.RLock()
+	defer fake.sendMutex.RUnlock()
+	return fake.sendArgsForCall[i].payload
+}
+
+func (fake *FakeSink) SendReturns(result1 error) {
+	fake.SendStub = nil
+	fake.sendReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ staging_events.Sink = new(FakeSink)
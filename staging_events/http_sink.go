@@ -0,0 +1,71 @@
+package staging_events
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Keep a hung firehose from holding up the event queue for long.
+const httpSinkTimeout = 5 * time.Second
+
+type BadResponseError struct {
+	StatusCode int
+}
+
+func (b *BadResponseError) Error() string {
+	return fmt.Sprintf("Staging event POST failed with %d", b.StatusCode)
+}
+
+type httpSink struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewHTTPSink returns a sink that POSTs each event as JSON to the given URL.
+func NewHTTPSink(url string, skipCertVerify bool) Sink {
+	httpClient := &http.Client{
+		Timeout: httpSinkTimeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   httpSinkTimeout,
+				KeepAlive: 30 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: httpSinkTimeout,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: skipCertVerify,
+				MinVersion:         tls.VersionTLS10,
+			},
+		},
+	}
+
+	return &httpSink{
+		url:        url,
+		httpClient: httpClient,
+	}
+}
+
+func (s *httpSink) Send(payload []byte) error {
+	request, err := http.NewRequest("POST", s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &BadResponseError{StatusCode: response.StatusCode}
+	}
+
+	return nil
+}
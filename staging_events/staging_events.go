@@ -0,0 +1,151 @@
+package staging_events
+
+import (
+	"encoding/json"
+	"os"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"github.com/tedsuo/ifrit"
+)
+
+type Type string
+
+const (
+	RequestReceived   Type = "request_received"
+	TaskDesired       Type = "task_desired"
+	TaskStarted       Type = "task_started"
+	TaskCompleted     Type = "task_completed"
+	ResponsePublished Type = "response_published"
+
+	DefaultBufferSize = 1024
+
+	// Metrics
+	stagingEventsDropped = metric.Counter("StagingEventsDropped")
+)
+
+// Event is a step in the lifecycle of a staging request, as published to
+// the configured sink.
+type Event struct {
+	Type        Type                   `json:"type"`
+	StagingGuid string                 `json:"staging_guid"`
+	Timestamp   int64                  `json:"timestamp"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+//go:generate counterfeiter -o fakes/fake_sink.go . Sink
+type Sink interface {
+	Send(payload []byte) error
+}
+
+// Emitter publishes staging lifecycle events without blocking the caller.
+// Events are queued and sent to the sink in order while the emitter runs;
+// when the queue is full, new events are dropped.
+//
+//go:generate counterfeiter -o fakes/fake_emitter.go . Emitter
+type Emitter interface {
+	ifrit.Runner
+	Emit(eventType Type, stagingGuid string, data map[string]interface{})
+}
+
+type emitter struct {
+	logger lager.Logger
+	sink   Sink
+	queue  chan Event
+	clock  clock.Clock
+}
+
+// NewEmitter returns an emitter that sends events to the given sink. With a
+// nil sink, events are discarded.
+func NewEmitter(logger lager.Logger, sink Sink, bufferSize int, clock clock.Clock) Emitter {
+	var queue chan Event
+	if sink != nil {
+		queue = make(chan Event, bufferSize)
+	}
+
+	return &emitter{
+		logger: logger.Session("staging-events"),
+		sink:   sink,
+		queue:  queue,
+		clock:  clock,
+	}
+}
+
+func (e *emitter) Emit(eventType Type, stagingGuid string, data map[string]interface{}) {
+	if e.queue == nil {
+		return
+	}
+
+	event := Event{
+		Type:        eventType,
+		StagingGuid: stagingGuid,
+		Timestamp:   e.clock.Now().UnixNano(),
+		Data:        data,
+	}
+
+	select {
+	case e.queue <- event:
+	default:
+		e.logger.Info("dropped-event", lager.Data{"type": eventType, "staging-guid": stagingGuid})
+		stagingEventsDropped.Increment()
+	}
+}
+
+func (e *emitter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			e.flush()
+			return nil
+		case event := <-e.queue:
+			e.send(event)
+		}
+	}
+}
+
+// flush sends the events queued before the emitter was signalled.
+func (e *emitter) flush() {
+	for {
+		select {
+		case event := <-e.queue:
+			e.send(event)
+		default:
+			return
+		}
+	}
+}
+
+func (e *emitter) send(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		e.logger.Error("failed-to-marshal-event", err)
+		return
+	}
+
+	err = e.sink.Send(payload)
+	if err != nil {
+		e.logger.Error("failed-to-send-event", err, lager.Data{"type": event.Type, "staging-guid": event.StagingGuid})
+	}
+}
+
+// Publisher is the part of a NATS connection used to publish events.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+type natsSink struct {
+	publisher Publisher
+	subject   string
+}
+
+// NewNATSSink returns a sink that publishes each event on the given subject.
+func NewNATSSink(publisher Publisher, subject string) Sink {
+	return &natsSink{publisher: publisher, subject: subject}
+}
+
+func (s *natsSink) Send(payload []byte) error {
+	return s.publisher.Publish(s.subject, payload)
+}
@@ -0,0 +1,13 @@
+package staging_events_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStagingEvents(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Staging Events Suite")
+}
@@ -0,0 +1,177 @@
+package staging_events_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/route_registrar/fakes"
+	"code.cloudfoundry.org/stager/staging_events"
+	sink_fakes "code.cloudfoundry.org/stager/staging_events/fakes"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Emitter", func() {
+	var (
+		fakeSink     *sink_fakes.FakeSink
+		sink         staging_events.Sink
+		fakeClock    *fakeclock.FakeClock
+		metricSender *fake.FakeMetricSender
+		bufferSize   int
+		emitter      staging_events.Emitter
+	)
+
+	BeforeEach(func() {
+		fakeSink = &sink_fakes.FakeSink{}
+		sink = fakeSink
+		fakeClock = fakeclock.NewFakeClock(time.Unix(100, 0))
+		metricSender = fake.NewFakeMetricSender()
+		metrics.Initialize(metricSender, nil)
+		bufferSize = 10
+	})
+
+	JustBeforeEach(func() {
+		emitter = staging_events.NewEmitter(lagertest.NewTestLogger("test"), sink, bufferSize, fakeClock)
+	})
+
+	Context("when running", func() {
+		var process ifrit.Process
+
+		JustBeforeEach(func() {
+			process = ifrit.Invoke(emitter)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("sends each event to the sink as JSON", func() {
+			emitter.Emit(staging_events.TaskDesired, "the-staging-guid", map[string]interface{}{"lifecycle": "buildpack"})
+
+			Eventually(fakeSink.SendCallCount).Should(Equal(1))
+			Expect(fakeSink.SendArgsForCall(0)).To(MatchJSON(`{
+				"type": "task_desired",
+				"staging_guid": "the-staging-guid",
+				"timestamp": 100000000000,
+				"data": {"lifecycle": "buildpack"}
+			}`))
+		})
+
+		Context("when the sink fails", func() {
+			BeforeEach(func() {
+				fakeSink.SendReturns(errors.New("boom"))
+			})
+
+			It("carries on with the next event", func() {
+				emitter.Emit(staging_events.RequestReceived, "first", nil)
+				emitter.Emit(staging_events.RequestReceived, "second", nil)
+
+				Eventually(fakeSink.SendCallCount).Should(Equal(2))
+			})
+		})
+	})
+
+	Context("when the queue is full", func() {
+		BeforeEach(func() {
+			bufferSize = 1
+		})
+
+		It("drops new events", func() {
+			emitter.Emit(staging_events.RequestReceived, "first", nil)
+			emitter.Emit(staging_events.RequestReceived, "second", nil)
+
+			Expect(metricSender.GetCounter("StagingEventsDropped")).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("when signalled", func() {
+		It("sends the queued events before exiting", func() {
+			emitter.Emit(staging_events.RequestReceived, "first", nil)
+			emitter.Emit(staging_events.TaskDesired, "first", nil)
+
+			signals := make(chan os.Signal, 1)
+			signals <- os.Interrupt
+			err := emitter.Run(signals, make(chan struct{}))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeSink.SendCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("without a sink", func() {
+		BeforeEach(func() {
+			sink = nil
+		})
+
+		It("discards events", func() {
+			Expect(func() {
+				emitter.Emit(staging_events.RequestReceived, "the-staging-guid", nil)
+			}).NotTo(Panic())
+		})
+	})
+})
+
+var _ = Describe("NATSSink", func() {
+	It("publishes events on the subject", func() {
+		publisher := &fakes.FakePublisher{}
+		natsSink := staging_events.NewNATSSink(publisher, "staging.events")
+
+		err := natsSink.Send([]byte(`{"type":"task_desired"}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(publisher.PublishCallCount()).To(Equal(1))
+		subject, data := publisher.PublishArgsForCall(0)
+		Expect(subject).To(Equal("staging.events"))
+		Expect(data).To(MatchJSON(`{"type":"task_desired"}`))
+	})
+})
+
+var _ = Describe("HTTPSink", func() {
+	var server *ghttp.Server
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("posts events to the URL", func() {
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("POST", "/events"),
+			ghttp.VerifyContentType("application/json"),
+			func(w http.ResponseWriter, req *http.Request) {
+				body, err := ioutil.ReadAll(req.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(body).To(MatchJSON(`{"type":"task_desired"}`))
+			},
+		))
+
+		sink := staging_events.NewHTTPSink(server.URL()+"/events", false)
+		err := sink.Send([]byte(`{"type":"task_desired"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	Context("when the firehose rejects the event", func() {
+		It("returns an error with the status code", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusServiceUnavailable, nil))
+
+			sink := staging_events.NewHTTPSink(server.URL()+"/events", false)
+			err := sink.Send([]byte(`{}`))
+			Expect(err).To(Equal(&staging_events.BadResponseError{StatusCode: http.StatusServiceUnavailable}))
+		})
+	})
+})
@@ -0,0 +1,114 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/staging_limit"
+)
+
+type FakeLimit struct {
+	AcquireStub        func(stagingGuid string) bool
+	acquireMutex       sync.RWMutex
+	acquireArgsForCall []struct {
+		stagingGuid string
+	}
+	acquireReturns struct {
+		result1 bool
+	}
+	ReleaseStub        func(stagingGuid string)
+	releaseMutex       sync.RWMutex
+	releaseArgsForCall []struct {
+		stagingGuid string
+	}
+	ResetStub        func(stagingGuids []string)
+	resetMutex       sync.RWMutex
+	resetArgsForCall []struct {
+		stagingGuids []string
+	}
+}
+
+func (fake *FakeLimit) Acquire(stagingGuid string) bool {
+	fake.acquireMutex.Lock()
+	fake.acquireArgsForCall = append(fake.acquireArgsForCall, struct {
+		stagingGuid string
+	}{stagingGuid})
+	fake.acquireMutex.Unlock()
+	if fake.AcquireStub != nil {
+		return fake.AcquireStub(stagingGuid)
+	} else {
+		return fake.acquireReturns.result1
+	}
+}
+
+func (fake *FakeLimit) AcquireCallCount() int {
+	fake.acquireMutex.RLock()
+	defer fake.acquireMutex.RUnlock()
+	return len(fake.acquireArgsForCall)
+}
+
+func (fake *FakeLimit) AcquireArgsForCall(i int) string {
+	fake.acquireMutex.RLock()
+	defer fake.acquireMutex.RUnlock()
+	return fake.acquireArgsForCall[i].stagingGuid
+}
+
+func (fake *FakeLimit) AcquireReturns(result1 bool) {
+	fake.AcquireStub = nil
+	fake.acquireReturns = struct {
+		result1 bool
+	}{result1}
+}
+
+func (fake *FakeLimit) Release(stagingGuid string) {
+	fake.releaseMutex.Lock()
+	fake.releaseArgsForCall = append(fake.releaseArgsForCall, struct {
+		stagingGuid string
+	}{stagingGuid})
+	fake.releaseMutex.Unlock()
+	if fake.ReleaseStub != nil {
+		fake.ReleaseStub(stagingGuid)
+	}
+}
+
+func (fake *FakeLimit) ReleaseCallCount() int {
+	fake.releaseMutex.RLock()
+	defer fake.releaseMutex.RUnlock()
+	return len(fake.releaseArgsForCall)
+}
+
+func (fake *FakeLimit) ReleaseArgsForCall(i int) string {
+	fake.releaseMutex.RLock()
+	defer fake.releaseMutex.RUnlock()
+	return fake.releaseArgsForCall[i].stagingGuid
+}
+
+func (fake *FakeLimit) Reset(stagingGuids []string) {
+	var stagingGuidsCopy []string
+	if stagingGuids != nil {
+		stagingGuidsCopy = make([]string, len(stagingGuids))
+		copy(stagingGuidsCopy, stagingGuids)
+	}
+	fake.resetMutex.Lock()
+	fake.resetArgsForCall = append(fake.resetArgsForCall, struct {
+		stagingGuids []string
+	}{stagingGuidsCopy})
+	fake.resetMutex.Unlock()
+	if fake.ResetStub != nil {
+		fake.ResetStub(stagingGuids)
+	}
+}
+
+func (fake *FakeLimit) ResetCallCount() int {
+	fake.resetMutex.RLock()
+	defer fake.resetMutex.RUnlock()
+	return len(fake.resetArgsForCall)
+}
+
+func (fake *FakeLimit) ResetArgsForCall(i int) []string {
+	fake.resetMutex.RLock()
+	defer fake.resetMutex.RUnlock()
+	return fake.resetArgsForCall[i].stagingGuids
+}
+
+var _ staging_limit.Limit = new(FakeLimit)
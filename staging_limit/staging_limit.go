@@ -0,0 +1,103 @@
+package staging_limit
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+)
+
+const (
+	// Metrics
+	stagingTasksOutstanding = metric.Metric("StagingTasksOutstanding")
+)
+
+// Limit caps the number of staging tasks that have been desired but have not
+// completed, so that a stampede of staging requests cannot swamp a small
+// Diego deployment. Staging tasks are tracked by their staging guid.
+//
+//go:generate counterfeiter -o fakes/fake_limit.go . Limit
+type Limit interface {
+	// Acquire takes a slot for the staging task, or returns false if every
+	// slot is taken. Acquiring a slot the staging task already holds
+	// succeeds.
+	Acquire(stagingGuid string) bool
+
+	// Release frees the slot held by the staging task, if any.
+	Release(stagingGuid string)
+
+	// Reset replaces the tracked staging tasks, e.g. with the incomplete
+	// staging tasks found in the BBS.
+	Reset(stagingGuids []string)
+}
+
+type limit struct {
+	logger lager.Logger
+	max    int
+
+	lock        sync.Mutex
+	outstanding map[string]struct{}
+}
+
+// NewLimit returns a limit of max outstanding staging tasks. A max of zero
+// or less disables the limit.
+func NewLimit(logger lager.Logger, max int) Limit {
+	if max <= 0 {
+		return unlimited{}
+	}
+
+	return &limit{
+		logger:      logger.Session("staging-limit"),
+		max:         max,
+		outstanding: map[string]struct{}{},
+	}
+}
+
+func (l *limit) Acquire(stagingGuid string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, ok := l.outstanding[stagingGuid]; ok {
+		return true
+	}
+
+	if len(l.outstanding) >= l.max {
+		return false
+	}
+
+	l.outstanding[stagingGuid] = struct{}{}
+	l.report()
+	return true
+}
+
+func (l *limit) Release(stagingGuid string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.outstanding, stagingGuid)
+	l.report()
+}
+
+func (l *limit) Reset(stagingGuids []string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.outstanding = make(map[string]struct{}, len(stagingGuids))
+	for _, stagingGuid := range stagingGuids {
+		l.outstanding[stagingGuid] = struct{}{}
+	}
+	l.report()
+}
+
+func (l *limit) report() {
+	err := stagingTasksOutstanding.Send(len(l.outstanding))
+	if err != nil {
+		l.logger.Error("failed-to-send-outstanding-tasks-metric", err)
+	}
+}
+
+type unlimited struct{}
+
+func (unlimited) Acquire(string) bool { return true }
+func (unlimited) Release(string)      {}
+func (unlimited) Reset([]string)      {}
@@ -0,0 +1,13 @@
+package staging_limit_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStagingLimit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Staging Limit Suite")
+}
@@ -0,0 +1,68 @@
+package staging_limit_test
+
+import (
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/staging_limit"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limit", func() {
+	var (
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		limit            staging_limit.Limit
+	)
+
+	BeforeEach(func() {
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		limit = staging_limit.NewLimit(lagertest.NewTestLogger("test"), 2)
+	})
+
+	It("allows up to the maximum number of outstanding staging tasks", func() {
+		Expect(limit.Acquire("guid-1")).To(BeTrue())
+		Expect(limit.Acquire("guid-2")).To(BeTrue())
+		Expect(limit.Acquire("guid-3")).To(BeFalse())
+		Expect(fakeMetricSender.GetValue("StagingTasksOutstanding").Value).To(Equal(float64(2)))
+	})
+
+	It("lets a staging task acquire its slot again", func() {
+		Expect(limit.Acquire("guid-1")).To(BeTrue())
+		Expect(limit.Acquire("guid-2")).To(BeTrue())
+		Expect(limit.Acquire("guid-1")).To(BeTrue())
+	})
+
+	It("frees the slot of a released staging task", func() {
+		Expect(limit.Acquire("guid-1")).To(BeTrue())
+		Expect(limit.Acquire("guid-2")).To(BeTrue())
+
+		limit.Release("guid-1")
+		Expect(fakeMetricSender.GetValue("StagingTasksOutstanding").Value).To(Equal(float64(1)))
+		Expect(limit.Acquire("guid-3")).To(BeTrue())
+	})
+
+	It("replaces the outstanding staging tasks on reset", func() {
+		Expect(limit.Acquire("guid-1")).To(BeTrue())
+
+		limit.Reset([]string{"guid-2", "guid-3"})
+		Expect(fakeMetricSender.GetValue("StagingTasksOutstanding").Value).To(Equal(float64(2)))
+		Expect(limit.Acquire("guid-1")).To(BeFalse())
+		Expect(limit.Acquire("guid-2")).To(BeTrue())
+	})
+
+	Context("when the maximum is zero", func() {
+		BeforeEach(func() {
+			limit = staging_limit.NewLimit(lagertest.NewTestLogger("test"), 0)
+		})
+
+		It("allows every staging task", func() {
+			for _, guid := range []string{"guid-1", "guid-2", "guid-3"} {
+				Expect(limit.Acquire(guid)).To(BeTrue())
+			}
+		})
+	})
+})
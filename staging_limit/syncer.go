@@ -0,0 +1,78 @@
+package staging_limit
+
+import (
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+type syncer struct {
+	logger    lager.Logger
+	bbsClient bbs.Client
+	domains   []string
+	limit     Limit
+	interval  time.Duration
+	clock     clock.Clock
+}
+
+// NewSyncer returns a runner that resets the limit to the incomplete staging
+// tasks in the given domains of the BBS on start and then at every interval.
+// The limit then counts the staging tasks desired by every stager, and
+// recovers slots whose completion callback never arrived.
+func NewSyncer(logger lager.Logger, bbsClient bbs.Client, domains []string, limit Limit, interval time.Duration, clock clock.Clock) ifrit.Runner {
+	return &syncer{
+		logger:    logger.Session("staging-limit-syncer"),
+		bbsClient: bbsClient,
+		domains:   domains,
+		limit:     limit,
+		interval:  interval,
+		clock:     clock,
+	}
+}
+
+func (s *syncer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	s.sync()
+	close(ready)
+
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C():
+			s.sync()
+		}
+	}
+}
+
+func (s *syncer) sync() {
+	logger := s.logger.Session("sync")
+
+	// The limit is only reset once every domain is listed, as resetting it
+	// to the tasks of some domains would free the slots of the others.
+	stagingGuids := []string{}
+	for _, domain := range s.domains {
+		tasks, err := s.bbsClient.TasksByDomain(logger, domain)
+		if err != nil {
+			logger.Error("fetching-tasks-failed", err, lager.Data{"domain": domain})
+			return
+		}
+
+		for _, task := range tasks {
+			if task.State == models.Task_Completed || task.State == models.Task_Resolving {
+				continue
+			}
+			stagingGuids = append(stagingGuids, task.TaskGuid)
+		}
+	}
+
+	s.limit.Reset(stagingGuids)
+	logger.Debug("synced", lager.Data{"outstanding": len(stagingGuids)})
+}
@@ -0,0 +1,111 @@
+package staging_limit_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/staging_limit"
+	"code.cloudfoundry.org/stager/staging_limit/fakes"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Syncer", func() {
+	var (
+		fakeBBSClient *fake_bbs.FakeClient
+		fakeLimit     *fakes.FakeLimit
+		fakeClock     *fakeclock.FakeClock
+		domains       []string
+		process       ifrit.Process
+	)
+
+	BeforeEach(func() {
+		domains = []string{cc_messages.StagingTaskDomain}
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeBBSClient.TasksByDomainReturns([]*models.Task{
+			{TaskGuid: "pending", State: models.Task_Pending},
+			{TaskGuid: "running", State: models.Task_Running},
+			{TaskGuid: "completed", State: models.Task_Completed},
+			{TaskGuid: "resolving", State: models.Task_Resolving},
+		}, nil)
+
+		fakeLimit = &fakes.FakeLimit{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	JustBeforeEach(func() {
+		syncer := staging_limit.NewSyncer(lagertest.NewTestLogger("test"), fakeBBSClient, domains, fakeLimit, time.Minute, fakeClock)
+		process = ifrit.Invoke(syncer)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("resets the limit to the incomplete staging tasks before becoming ready", func() {
+		Expect(fakeBBSClient.TasksByDomainCallCount()).To(Equal(1))
+		_, domain := fakeBBSClient.TasksByDomainArgsForCall(0)
+		Expect(domain).To(Equal(cc_messages.StagingTaskDomain))
+
+		Expect(fakeLimit.ResetCallCount()).To(Equal(1))
+		Expect(fakeLimit.ResetArgsForCall(0)).To(Equal([]string{"pending", "running"}))
+	})
+
+	It("resets the limit again every interval", func() {
+		fakeClock.WaitForWatcherAndIncrement(time.Minute)
+		Eventually(fakeLimit.ResetCallCount).Should(Equal(2))
+	})
+
+	Context("when the tasks cannot be fetched", func() {
+		BeforeEach(func() {
+			fakeBBSClient.TasksByDomainReturns(nil, errors.New("bbs down"))
+		})
+
+		It("leaves the limit alone", func() {
+			Expect(fakeLimit.ResetCallCount()).To(BeZero())
+		})
+	})
+
+	Context("when several staging task domains are configured", func() {
+		BeforeEach(func() {
+			domains = []string{cc_messages.StagingTaskDomain, "windows-staging"}
+			fakeBBSClient.TasksByDomainStub = func(logger lager.Logger, domain string) ([]*models.Task, error) {
+				if domain == "windows-staging" {
+					return []*models.Task{{TaskGuid: "windows-running", State: models.Task_Running}}, nil
+				}
+				return []*models.Task{{TaskGuid: "running", State: models.Task_Running}}, nil
+			}
+		})
+
+		It("resets the limit to the incomplete staging tasks of every domain", func() {
+			Expect(fakeBBSClient.TasksByDomainCallCount()).To(Equal(2))
+			Expect(fakeLimit.ResetCallCount()).To(Equal(1))
+			Expect(fakeLimit.ResetArgsForCall(0)).To(Equal([]string{"running", "windows-running"}))
+		})
+
+		Context("when the tasks of a domain cannot be fetched", func() {
+			BeforeEach(func() {
+				fakeBBSClient.TasksByDomainStub = func(logger lager.Logger, domain string) ([]*models.Task, error) {
+					if domain == "windows-staging" {
+						return nil, errors.New("bbs down")
+					}
+					return []*models.Task{{TaskGuid: "running", State: models.Task_Running}}, nil
+				}
+			})
+
+			It("leaves the limit alone", func() {
+				Expect(fakeLimit.ResetCallCount()).To(BeZero())
+			})
+		})
+	})
+})
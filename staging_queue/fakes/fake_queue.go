@@ -0,0 +1,53 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/stager/staging_queue"
+)
+
+type FakeQueue struct {
+	SubmitStub        func(work func()) error
+	submitMutex       sync.RWMutex
+	submitArgsForCall []struct {
+		work func()
+	}
+	submitReturns struct {
+		result1 error
+	}
+}
+
+func (fake *FakeQueue) Submit(work func()) error {
+	fake.submitMutex.Lock()
+	fake.submitArgsForCall = append(fake.submitArgsForCall, struct {
+		work func()
+	}{work})
+	fake.submitMutex.Unlock()
+	if fake.SubmitStub != nil {
+		return fake.SubmitStub(work)
+	} else {
+		return fake.submitReturns.result1
+	}
+}
+
+func (fake *FakeQueue) SubmitCallCount() int {
+	fake.submitMutex.RLock()
+	defer fake.submitMutex.RUnlock()
+	return len(fake.submitArgsForCall)
+}
+
+func (fake *FakeQueue) SubmitArgsForCall(i int) func() {
+	fake.submitMutex.RLock()
+	defer fake.submitMutex.RUnlock()
+	return fake.submitArgsForCall[i].work
+}
+
+func (fake *FakeQueue) SubmitReturns(result1 error) {
+	fake.SubmitStub = nil
+	fake.submitReturns = struct {
+		result1 error
+	}{result1}
+}
+
+var _ staging_queue.Queue = new(FakeQueue)
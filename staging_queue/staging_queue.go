@@ -0,0 +1,106 @@
+package staging_queue
+
+import (
+	"errors"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+)
+
+const (
+	// Metrics
+	stagingQueueDepth = metric.Metric("StagingQueueDepth")
+)
+
+var ErrQueueFull = errors.New("staging queue is full")
+
+// Queue bounds the staging requests being turned into tasks: up to workers
+// requests are processed at once, and up to depth more wait for a worker.
+// Requests arriving when the queue is full are turned away, so that a burst
+// of staging requests pushes back on CC rather than on the BBS.
+//
+//go:generate counterfeiter -o fakes/fake_queue.go . Queue
+type Queue interface {
+	// Submit runs work once a worker is free, or returns ErrQueueFull
+	// without running it.
+	Submit(work func()) error
+}
+
+type queue struct {
+	logger  lager.Logger
+	depth   int
+	workers chan struct{}
+
+	lock    sync.Mutex
+	waiting int
+}
+
+// NewQueue returns a queue of the given depth in front of the given number
+// of workers. A depth of zero or less disables the queue: work is run
+// immediately.
+func NewQueue(logger lager.Logger, depth, workers int) Queue {
+	if depth <= 0 {
+		return unbounded{}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &queue{
+		logger:  logger.Session("staging-queue"),
+		depth:   depth,
+		workers: make(chan struct{}, workers),
+	}
+}
+
+func (q *queue) Submit(work func()) error {
+	select {
+	case q.workers <- struct{}{}:
+	default:
+		if !q.wait() {
+			return ErrQueueFull
+		}
+	}
+	defer func() { <-q.workers }()
+
+	work()
+	return nil
+}
+
+// wait blocks until a worker is free, unless depth requests are already
+// waiting for one.
+func (q *queue) wait() bool {
+	q.lock.Lock()
+	if q.waiting >= q.depth {
+		q.lock.Unlock()
+		return false
+	}
+	q.waiting++
+	q.reportDepth(q.waiting)
+	q.lock.Unlock()
+
+	q.workers <- struct{}{}
+
+	q.lock.Lock()
+	q.waiting--
+	q.reportDepth(q.waiting)
+	q.lock.Unlock()
+
+	return true
+}
+
+func (q *queue) reportDepth(depth int) {
+	err := stagingQueueDepth.Send(depth)
+	if err != nil {
+		q.logger.Error("failed-to-send-queue-depth-metric", err)
+	}
+}
+
+type unbounded struct{}
+
+func (unbounded) Submit(work func()) error {
+	work()
+	return nil
+}
@@ -0,0 +1,13 @@
+package staging_queue_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStagingQueue(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Staging Queue Suite")
+}
@@ -0,0 +1,100 @@
+package staging_queue_test
+
+import (
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/staging_queue"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StagingQueue", func() {
+	var (
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		queue            staging_queue.Queue
+		release          chan struct{}
+	)
+
+	// submitBlocking submits work that runs until released, and returns the
+	// channel the result of Submit is sent on.
+	submitBlocking := func(started chan<- struct{}) <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			result <- queue.Submit(func() {
+				if started != nil {
+					close(started)
+				}
+				<-release
+			})
+		}()
+		return result
+	}
+
+	BeforeEach(func() {
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+
+		release = make(chan struct{})
+		queue = staging_queue.NewQueue(lagertest.NewTestLogger("test"), 1, 1)
+	})
+
+	It("runs the work", func() {
+		ran := false
+		Expect(queue.Submit(func() { ran = true })).To(Succeed())
+		Expect(ran).To(BeTrue())
+	})
+
+	Context("when every worker is busy", func() {
+		var first <-chan error
+
+		BeforeEach(func() {
+			started := make(chan struct{})
+			first = submitBlocking(started)
+			Eventually(started).Should(BeClosed())
+		})
+
+		It("queues the work until a worker is free", func() {
+			second := submitBlocking(nil)
+			Eventually(func() float64 { return fakeMetricSender.GetValue("StagingQueueDepth").Value }).Should(Equal(float64(1)))
+			Consistently(second).ShouldNot(Receive())
+
+			close(release)
+			Eventually(first).Should(Receive(BeNil()))
+			Eventually(second).Should(Receive(BeNil()))
+			Expect(fakeMetricSender.GetValue("StagingQueueDepth").Value).To(Equal(float64(0)))
+		})
+
+		It("turns work away once the queue is full", func() {
+			second := submitBlocking(nil)
+			Eventually(func() float64 { return fakeMetricSender.GetValue("StagingQueueDepth").Value }).Should(Equal(float64(1)))
+
+			ran := false
+			err := queue.Submit(func() { ran = true })
+			Expect(err).To(Equal(staging_queue.ErrQueueFull))
+			Expect(ran).To(BeFalse())
+
+			close(release)
+			Eventually(second).Should(Receive(BeNil()))
+		})
+	})
+
+	Context("when the depth is zero", func() {
+		BeforeEach(func() {
+			queue = staging_queue.NewQueue(lagertest.NewTestLogger("test"), 0, 1)
+		})
+
+		It("runs the work immediately", func() {
+			started := make(chan struct{})
+			submitBlocking(started)
+			Eventually(started).Should(BeClosed())
+
+			ran := false
+			Expect(queue.Submit(func() { ran = true })).To(Succeed())
+			Expect(ran).To(BeTrue())
+
+			close(release)
+		})
+	})
+})
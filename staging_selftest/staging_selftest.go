@@ -0,0 +1,203 @@
+package staging_selftest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/nats_connection"
+)
+
+const (
+	DefaultDomain       = "stager-selftest"
+	DefaultSubject      = "stager.selftest"
+	DefaultTimeout      = 2 * time.Minute
+	DefaultPollInterval = time.Second
+)
+
+var ErrBBSUnreachable = errors.New("unable to reach the BBS")
+
+type Config struct {
+	// Domain is the domain of the self test task, kept apart from the
+	// staging domain so that running stagers leave the task alone.
+	Domain string
+	RootFS string
+
+	// Timeout bounds how long the task may take to complete.
+	Timeout      time.Duration
+	PollInterval time.Duration
+}
+
+// Result is the outcome of a step of the self test.
+type Result struct {
+	Step     string
+	Duration time.Duration
+	Err      error
+}
+
+type selfTest struct {
+	logger    lager.Logger
+	bbsClient bbs.Client
+	natsConn  nats_connection.Connection
+	config    Config
+	clock     clock.Clock
+}
+
+// Run exercises the dependencies staging relies on: it publishes to NATS,
+// when a connection is given, pings the BBS, then desires a trivial task and
+// waits for a cell to run it to completion. The steps run in order until one
+// fails, and the task is deleted whatever the outcome.
+func Run(logger lager.Logger, bbsClient bbs.Client, natsConn nats_connection.Connection, config Config, clock clock.Clock) []Result {
+	if config.Domain == "" {
+		config.Domain = DefaultDomain
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+
+	t := &selfTest{
+		logger:    logger.Session("selftest"),
+		bbsClient: bbsClient,
+		natsConn:  natsConn,
+		config:    config,
+		clock:     clock,
+	}
+
+	return t.run()
+}
+
+// Passed reports whether every step of the self test succeeded.
+func Passed(results []Result) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+func (t *selfTest) run() []Result {
+	guid, err := taskGuid()
+	if err != nil {
+		return []Result{{Step: "generate-task-guid", Err: err}}
+	}
+
+	results := []Result{}
+	step := func(name string, fn func() error) bool {
+		start := t.clock.Now()
+		err := fn()
+		results = append(results, Result{Step: name, Duration: t.clock.Now().Sub(start), Err: err})
+		if err != nil {
+			t.logger.Error("step-failed", err, lager.Data{"step": name})
+		}
+		return err == nil
+	}
+
+	if t.natsConn != nil && !step("publish-to-nats", t.publish) {
+		return results
+	}
+
+	if !step("ping-bbs", t.ping) {
+		return results
+	}
+
+	if !step("desire-task", func() error { return t.desire(guid) }) {
+		return results
+	}
+
+	completed := step("complete-task", func() error { return t.awaitCompletion(guid) })
+	if !completed {
+		t.cancel(guid)
+	}
+
+	step("delete-task", func() error { return t.delete(guid) })
+
+	return results
+}
+
+func (t *selfTest) publish() error {
+	err := t.natsConn.Publish(DefaultSubject, []byte(`{"selftest":true}`))
+	if err != nil {
+		return err
+	}
+	return t.natsConn.Flush()
+}
+
+func (t *selfTest) ping() error {
+	if !t.bbsClient.Ping(t.logger) {
+		return ErrBBSUnreachable
+	}
+	return nil
+}
+
+func (t *selfTest) desire(guid string) error {
+	taskDefinition := &models.TaskDefinition{
+		RootFs:   t.config.RootFS,
+		MemoryMb: 32,
+		DiskMb:   32,
+		LogGuid:  guid,
+		Action: models.WrapAction(&models.RunAction{
+			Path: "/bin/true",
+			User: "vcap",
+		}),
+	}
+
+	return t.bbsClient.DesireTask(t.logger, guid, t.config.Domain, taskDefinition)
+}
+
+func (t *selfTest) awaitCompletion(guid string) error {
+	deadline := t.clock.Now().Add(t.config.Timeout)
+
+	for {
+		task, err := t.bbsClient.TaskByGuid(t.logger, guid)
+		if err != nil {
+			return err
+		}
+
+		if task.State == models.Task_Completed {
+			if task.Failed {
+				return fmt.Errorf("task failed: %s", task.FailureReason)
+			}
+			return nil
+		}
+
+		if !t.clock.Now().Before(deadline) {
+			return fmt.Errorf("task did not complete within %s, last seen %s", t.config.Timeout, task.State)
+		}
+
+		t.clock.Sleep(t.config.PollInterval)
+	}
+}
+
+func (t *selfTest) cancel(guid string) {
+	err := t.bbsClient.CancelTask(t.logger, guid)
+	if err != nil {
+		t.logger.Error("failed-to-cancel-task", err)
+	}
+}
+
+func (t *selfTest) delete(guid string) error {
+	err := t.bbsClient.ResolvingTask(t.logger, guid)
+	if err != nil {
+		return err
+	}
+	return t.bbsClient.DeleteTask(t.logger, guid)
+}
+
+func taskGuid() (string, error) {
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return "selftest-" + hex.EncodeToString(random), nil
+}
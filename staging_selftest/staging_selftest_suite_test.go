@@ -0,0 +1,13 @@
+package staging_selftest_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStagingSelftest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Staging Selftest Suite")
+}
@@ -0,0 +1,164 @@
+package staging_selftest_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/nats_connection"
+	"code.cloudfoundry.org/stager/nats_connection/fakes"
+	"code.cloudfoundry.org/stager/staging_selftest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Selftest", func() {
+	var (
+		fakeBBS   *fake_bbs.FakeClient
+		fakeNATS  *fakes.FakeConnection
+		natsConn  nats_connection.Connection
+		fakeClock *fakeclock.FakeClock
+		config    staging_selftest.Config
+		results   []staging_selftest.Result
+		done      chan []staging_selftest.Result
+		awaitRun  bool
+	)
+
+	steps := func(results []staging_selftest.Result) []string {
+		names := []string{}
+		for _, result := range results {
+			names = append(names, result.Step)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		fakeBBS = &fake_bbs.FakeClient{}
+		fakeBBS.PingReturns(true)
+		fakeBBS.TaskByGuidReturns(&models.Task{State: models.Task_Completed}, nil)
+
+		fakeNATS = &fakes.FakeConnection{}
+		natsConn = fakeNATS
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		config = staging_selftest.Config{
+			RootFS:       "preloaded:cflinuxfs2",
+			Timeout:      time.Minute,
+			PollInterval: time.Second,
+		}
+		awaitRun = true
+	})
+
+	JustBeforeEach(func() {
+		done = make(chan []staging_selftest.Result, 1)
+		go func() {
+			done <- staging_selftest.Run(lagertest.NewTestLogger("test"), fakeBBS, natsConn, config, fakeClock)
+		}()
+
+		if awaitRun {
+			Eventually(done).Should(Receive(&results))
+		}
+	})
+
+	It("runs every step", func() {
+		Expect(steps(results)).To(Equal([]string{"publish-to-nats", "ping-bbs", "desire-task", "complete-task", "delete-task"}))
+		Expect(staging_selftest.Passed(results)).To(BeTrue())
+	})
+
+	It("publishes to NATS and flushes the connection", func() {
+		Expect(fakeNATS.PublishCallCount()).To(Equal(1))
+		subject, _ := fakeNATS.PublishArgsForCall(0)
+		Expect(subject).To(Equal(staging_selftest.DefaultSubject))
+		Expect(fakeNATS.FlushCallCount()).To(Equal(1))
+	})
+
+	It("desires a trivial task in the self test domain", func() {
+		Expect(fakeBBS.DesireTaskCallCount()).To(Equal(1))
+		_, guid, domain, taskDefinition := fakeBBS.DesireTaskArgsForCall(0)
+		Expect(guid).To(HavePrefix("selftest-"))
+		Expect(domain).To(Equal(staging_selftest.DefaultDomain))
+		Expect(taskDefinition.RootFs).To(Equal("preloaded:cflinuxfs2"))
+		Expect(taskDefinition.Action.GetRunAction().Path).To(Equal("/bin/true"))
+	})
+
+	It("deletes the task once it completed", func() {
+		_, desiredGuid, _, _ := fakeBBS.DesireTaskArgsForCall(0)
+
+		Expect(fakeBBS.ResolvingTaskCallCount()).To(Equal(1))
+		_, guid := fakeBBS.ResolvingTaskArgsForCall(0)
+		Expect(guid).To(Equal(desiredGuid))
+
+		Expect(fakeBBS.DeleteTaskCallCount()).To(Equal(1))
+		_, guid = fakeBBS.DeleteTaskArgsForCall(0)
+		Expect(guid).To(Equal(desiredGuid))
+	})
+
+	Context("without a NATS connection", func() {
+		BeforeEach(func() {
+			natsConn = nil
+		})
+
+		It("skips publishing to NATS", func() {
+			Expect(steps(results)).To(Equal([]string{"ping-bbs", "desire-task", "complete-task", "delete-task"}))
+		})
+	})
+
+	Context("when the BBS cannot be reached", func() {
+		BeforeEach(func() {
+			fakeBBS.PingReturns(false)
+		})
+
+		It("stops at the failed step", func() {
+			Expect(steps(results)).To(Equal([]string{"publish-to-nats", "ping-bbs"}))
+			Expect(results[1].Err).To(Equal(staging_selftest.ErrBBSUnreachable))
+			Expect(staging_selftest.Passed(results)).To(BeFalse())
+			Expect(fakeBBS.DesireTaskCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when the task fails", func() {
+		BeforeEach(func() {
+			fakeBBS.TaskByGuidReturns(&models.Task{State: models.Task_Completed, Failed: true, FailureReason: "no cells"}, nil)
+		})
+
+		It("reports the failure and still deletes the task", func() {
+			Expect(results[3].Err).To(MatchError("task failed: no cells"))
+			Expect(fakeBBS.CancelTaskCallCount()).To(Equal(1))
+			Expect(fakeBBS.DeleteTaskCallCount()).To(Equal(1))
+			Expect(staging_selftest.Passed(results)).To(BeFalse())
+		})
+	})
+
+	Context("when looking up the task fails", func() {
+		BeforeEach(func() {
+			fakeBBS.TaskByGuidReturns(nil, errors.New("boom"))
+		})
+
+		It("reports the failure", func() {
+			Expect(results[3].Err).To(MatchError("boom"))
+			Expect(staging_selftest.Passed(results)).To(BeFalse())
+		})
+	})
+
+	Context("when the task does not complete in time", func() {
+		BeforeEach(func() {
+			fakeBBS.TaskByGuidReturns(&models.Task{State: models.Task_Pending}, nil)
+			awaitRun = false
+		})
+
+		It("gives up after the timeout and cancels the task", func() {
+			for i := 0; i < 60; i++ {
+				fakeClock.WaitForWatcherAndIncrement(time.Second)
+			}
+
+			Eventually(done).Should(Receive(&results))
+			Expect(results[3].Err).To(MatchError(ContainSubstring("task did not complete within 1m0s")))
+			Expect(fakeBBS.CancelTaskCallCount()).To(Equal(1))
+			Expect(fakeBBS.DeleteTaskCallCount()).To(Equal(1))
+		})
+	})
+})
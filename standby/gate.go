@@ -0,0 +1,68 @@
+package standby
+
+import (
+	"net/http"
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+// Gate refuses the requests served by the handlers it wraps until it is run,
+// so that a standby stager can listen without staging. It is run once the
+// standby lock is acquired and closes again when signalled.
+type Gate interface {
+	ifrit.Runner
+	Wrap(handler http.Handler) http.Handler
+}
+
+type gate struct {
+	logger lager.Logger
+
+	lock sync.RWMutex
+	open bool
+}
+
+func NewGate(logger lager.Logger) Gate {
+	return &gate{
+		logger: logger.Session("standby-gate"),
+	}
+}
+
+func (g *gate) Wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !g.isOpen() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		handler.ServeHTTP(w, req)
+	})
+}
+
+func (g *gate) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	g.setOpen(true)
+	g.logger.Info("opened")
+	close(ready)
+
+	<-signals
+
+	g.setOpen(false)
+	g.logger.Info("closed")
+	return nil
+}
+
+func (g *gate) isOpen() bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	return g.open
+}
+
+func (g *gate) setOpen(open bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.open = open
+}
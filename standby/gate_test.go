@@ -0,0 +1,64 @@
+package standby_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/standby"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Gate", func() {
+	var (
+		gate    standby.Gate
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		gate = standby.NewGate(lagertest.NewTestLogger("test"))
+		handler = gate.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+	})
+
+	serve := func() int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, &http.Request{})
+		return recorder.Code
+	}
+
+	It("refuses requests until it is run", func() {
+		Expect(serve()).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	Context("when running", func() {
+		var process ifrit.Process
+
+		BeforeEach(func() {
+			process = ifrit.Invoke(gate)
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Kill)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("serves requests with the wrapped handler", func() {
+			Expect(serve()).To(Equal(http.StatusTeapot))
+		})
+
+		Context("when signalled", func() {
+			It("refuses requests again", func() {
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive(BeNil()))
+
+				Expect(serve()).To(Equal(http.StatusServiceUnavailable))
+			})
+		})
+	})
+})
@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"github.com/tedsuo/ifrit"
+)
+
+// Percentile is a staging duration percentile emitted as a metric.
+type Percentile struct {
+	Name  string
+	Value float64
+}
+
+var EmittedPercentiles = []Percentile{
+	{Name: "P50", Value: 0.50},
+	{Name: "P95", Value: 0.95},
+	{Name: "P99", Value: 0.99},
+}
+
+type percentileEmitter struct {
+	logger   lager.Logger
+	stats    Stats
+	window   time.Duration
+	interval time.Duration
+	clock    clock.Clock
+}
+
+// NewPercentileEmitter returns a runner that, every interval, emits the
+// EmittedPercentiles of the durations of the stagings completed within the
+// window, separately for each lifecycle, e.g. BuildpackStagingDurationP95.
+func NewPercentileEmitter(logger lager.Logger, stats Stats, window, interval time.Duration, clock clock.Clock) ifrit.Runner {
+	return &percentileEmitter{
+		logger:   logger.Session("percentile-emitter"),
+		stats:    stats,
+		window:   window,
+		interval: interval,
+		clock:    clock,
+	}
+}
+
+func (e *percentileEmitter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := e.clock.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C():
+			e.emit()
+		}
+	}
+}
+
+func (e *percentileEmitter) emit() {
+	values := make([]float64, 0, len(EmittedPercentiles))
+	for _, p := range EmittedPercentiles {
+		values = append(values, p.Value)
+	}
+
+	for lifecycle, durations := range e.stats.DurationPercentiles(e.window, values) {
+		for i, p := range EmittedPercentiles {
+			name := PercentileMetricName(lifecycle, p)
+			err := metric.Duration(name).Send(durations[i])
+			if err != nil {
+				e.logger.Error("failed-to-send-percentile", err, lager.Data{"metric": name})
+			}
+		}
+	}
+}
+
+// PercentileMetricName is the name of the metric for a percentile of the
+// staging durations of a lifecycle.
+func PercentileMetricName(lifecycle string, p Percentile) string {
+	return fmt.Sprintf("%sStagingDuration%s", strings.Title(lifecycle), p.Name)
+}
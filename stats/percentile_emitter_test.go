@@ -0,0 +1,74 @@
+package stats_test
+
+import (
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/stats"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PercentileEmitter", func() {
+	var (
+		fakeClock    *fakeclock.FakeClock
+		metricSender *fake.FakeMetricSender
+		stagingStats stats.Stats
+		process      ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		metricSender = fake.NewFakeMetricSender()
+		metrics.Initialize(metricSender, nil)
+
+		stagingStats = stats.NewStats([]time.Duration{time.Hour}, fakeClock)
+		for i := 1; i <= 100; i++ {
+			stagingStats.Record(stats.Sample{
+				Lifecycle:   "buildpack",
+				Duration:    time.Duration(i) * time.Second,
+				CompletedAt: fakeClock.Now(),
+			})
+		}
+		stagingStats.Record(stats.Sample{
+			Lifecycle:   "docker",
+			Duration:    30 * time.Second,
+			CompletedAt: fakeClock.Now(),
+		})
+
+		emitter := stats.NewPercentileEmitter(lagertest.NewTestLogger("test"), stagingStats, 10*time.Minute, time.Minute, fakeClock)
+		process = ifrit.Invoke(emitter)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("names the metrics after the lifecycle and percentile", func() {
+		Expect(stats.PercentileMetricName("buildpack", stats.Percentile{Name: "P95"})).To(Equal("BuildpackStagingDurationP95"))
+	})
+
+	It("emits nothing before the first interval", func() {
+		Consistently(func() float64 {
+			return metricSender.GetValue("BuildpackStagingDurationP50").Value
+		}).Should(BeZero())
+	})
+
+	It("emits the percentiles of each lifecycle every interval", func() {
+		fakeClock.WaitForWatcherAndIncrement(time.Minute)
+
+		Eventually(func() float64 {
+			return metricSender.GetValue("BuildpackStagingDurationP99").Value
+		}).Should(BeEquivalentTo(99 * time.Second))
+		Expect(metricSender.GetValue("BuildpackStagingDurationP50").Value).To(BeEquivalentTo(50 * time.Second))
+		Expect(metricSender.GetValue("BuildpackStagingDurationP95").Value).To(BeEquivalentTo(95 * time.Second))
+		Expect(metricSender.GetValue("DockerStagingDurationP50").Value).To(BeEquivalentTo(30 * time.Second))
+	})
+})
@@ -0,0 +1,192 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// Sample is the outcome of a single completed staging task.
+type Sample struct {
+	Lifecycle       string
+	Stack           string
+	Failed          bool
+	FailureCategory string
+	Duration        time.Duration
+	CompletedAt     time.Time
+}
+
+type Summary struct {
+	Windows []WindowSummary `json:"windows"`
+}
+
+type WindowSummary struct {
+	Window             string                      `json:"window"`
+	Total              int                         `json:"total"`
+	Succeeded          int                         `json:"succeeded"`
+	Failed             int                         `json:"failed"`
+	SuccessRate        float64                     `json:"success_rate"`
+	P95DurationSeconds float64                     `json:"p95_duration_seconds"`
+	FailuresByCategory map[string]int              `json:"failures_by_category"`
+	ByLifecycle        map[string]LifecycleSummary `json:"by_lifecycle"`
+}
+
+type LifecycleSummary struct {
+	Total  int `json:"total"`
+	Failed int `json:"failed"`
+}
+
+type Stats interface {
+	Record(sample Sample)
+	Summarize() Summary
+
+	// DurationPercentiles returns the given percentiles, between 0 and 1, of
+	// the durations of the stagings completed within the window, by
+	// lifecycle. Lifecycles without stagings in the window are omitted.
+	DurationPercentiles(window time.Duration, percentiles []float64) map[string][]time.Duration
+}
+
+type stats struct {
+	windows []time.Duration
+	clock   clock.Clock
+
+	lock    sync.Mutex
+	samples []Sample
+}
+
+// NewStats keeps staging samples for the longest of the given windows and
+// summarizes them over each window.
+func NewStats(windows []time.Duration, clock clock.Clock) Stats {
+	sorted := append([]time.Duration{}, windows...)
+	sort.Sort(durations(sorted))
+
+	return &stats{
+		windows: sorted,
+		clock:   clock,
+	}
+}
+
+func (s *stats) Record(sample Sample) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.samples = append(s.samples, sample)
+	s.prune()
+}
+
+func (s *stats) Summarize() Summary {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune()
+
+	now := s.clock.Now()
+	summary := Summary{Windows: []WindowSummary{}}
+	for _, window := range s.windows {
+		summary.Windows = append(summary.Windows, summarizeWindow(window, now, s.samples))
+	}
+
+	return summary
+}
+
+func (s *stats) DurationPercentiles(window time.Duration, percentiles []float64) map[string][]time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.prune()
+
+	cutoff := s.clock.Now().Add(-window)
+	byLifecycle := map[string][]time.Duration{}
+	for _, sample := range s.samples {
+		if sample.CompletedAt.Before(cutoff) {
+			continue
+		}
+		byLifecycle[sample.Lifecycle] = append(byLifecycle[sample.Lifecycle], sample.Duration)
+	}
+
+	result := map[string][]time.Duration{}
+	for lifecycle, durations := range byLifecycle {
+		values := make([]time.Duration, 0, len(percentiles))
+		for _, p := range percentiles {
+			values = append(values, percentile(durations, p))
+		}
+		result[lifecycle] = values
+	}
+
+	return result
+}
+
+func (s *stats) prune() {
+	if len(s.windows) == 0 {
+		s.samples = nil
+		return
+	}
+
+	cutoff := s.clock.Now().Add(-s.windows[len(s.windows)-1])
+	i := 0
+	for i < len(s.samples) && s.samples[i].CompletedAt.Before(cutoff) {
+		i++
+	}
+	s.samples = s.samples[i:]
+}
+
+func summarizeWindow(window time.Duration, now time.Time, samples []Sample) WindowSummary {
+	summary := WindowSummary{
+		Window:             window.String(),
+		FailuresByCategory: map[string]int{},
+		ByLifecycle:        map[string]LifecycleSummary{},
+	}
+
+	cutoff := now.Add(-window)
+	durationsInWindow := []time.Duration{}
+
+	for _, sample := range samples {
+		if sample.CompletedAt.Before(cutoff) {
+			continue
+		}
+
+		key := sample.Lifecycle
+		if sample.Stack != "" {
+			key = key + "/" + sample.Stack
+		}
+		lifecycle := summary.ByLifecycle[key]
+		lifecycle.Total++
+
+		summary.Total++
+		if sample.Failed {
+			summary.Failed++
+			summary.FailuresByCategory[sample.FailureCategory]++
+			lifecycle.Failed++
+		} else {
+			summary.Succeeded++
+		}
+
+		summary.ByLifecycle[key] = lifecycle
+		durationsInWindow = append(durationsInWindow, sample.Duration)
+	}
+
+	if summary.Total > 0 {
+		summary.SuccessRate = float64(summary.Succeeded) / float64(summary.Total)
+		summary.P95DurationSeconds = percentile(durationsInWindow, 0.95).Seconds()
+	}
+
+	return summary
+}
+
+func percentile(values []time.Duration, p float64) time.Duration {
+	sort.Sort(durations(values))
+	index := int(math.Ceil(p*float64(len(values)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return values[index]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
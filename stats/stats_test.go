@@ -0,0 +1,96 @@
+package stats_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/stager/stats"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stats", func() {
+	var (
+		fakeClock    *fakeclock.FakeClock
+		stagingStats stats.Stats
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		stagingStats = stats.NewStats([]time.Duration{time.Hour, 5 * time.Minute}, fakeClock)
+	})
+
+	record := func(lifecycle string, failed bool, category string, duration time.Duration) {
+		stagingStats.Record(stats.Sample{
+			Lifecycle:       lifecycle,
+			Stack:           "cflinuxfs2",
+			Failed:          failed,
+			FailureCategory: category,
+			Duration:        duration,
+			CompletedAt:     fakeClock.Now(),
+		})
+	}
+
+	It("summarizes each window from shortest to longest", func() {
+		summary := stagingStats.Summarize()
+		Expect(summary.Windows).To(HaveLen(2))
+		Expect(summary.Windows[0].Window).To(Equal("5m0s"))
+		Expect(summary.Windows[1].Window).To(Equal("1h0m0s"))
+		Expect(summary.Windows[0].Total).To(Equal(0))
+	})
+
+	Context("when stagings have been recorded", func() {
+		BeforeEach(func() {
+			record("buildpack", false, "", 10*time.Second)
+			fakeClock.Increment(10 * time.Minute)
+
+			for i := 1; i <= 19; i++ {
+				record("buildpack", false, "", time.Duration(i)*time.Second)
+			}
+			record("docker", true, "StagingError", 100*time.Second)
+		})
+
+		It("only counts samples within each window", func() {
+			summary := stagingStats.Summarize()
+			Expect(summary.Windows[0].Total).To(Equal(20))
+			Expect(summary.Windows[1].Total).To(Equal(21))
+		})
+
+		It("reports success rate, p95 duration and failure categories", func() {
+			window := stagingStats.Summarize().Windows[0]
+			Expect(window.Succeeded).To(Equal(19))
+			Expect(window.Failed).To(Equal(1))
+			Expect(window.SuccessRate).To(BeNumerically("~", 0.95))
+			Expect(window.P95DurationSeconds).To(BeNumerically("~", 19))
+			Expect(window.FailuresByCategory).To(Equal(map[string]int{"StagingError": 1}))
+		})
+
+		It("breaks the counts down by lifecycle and stack", func() {
+			window := stagingStats.Summarize().Windows[0]
+			Expect(window.ByLifecycle).To(Equal(map[string]stats.LifecycleSummary{
+				"buildpack/cflinuxfs2": {Total: 19},
+				"docker/cflinuxfs2":    {Total: 1, Failed: 1},
+			}))
+		})
+
+		It("reports duration percentiles by lifecycle within a window", func() {
+			percentiles := stagingStats.DurationPercentiles(5*time.Minute, []float64{0.5, 0.95, 0.99})
+			Expect(percentiles).To(Equal(map[string][]time.Duration{
+				"buildpack": {10 * time.Second, 19 * time.Second, 19 * time.Second},
+				"docker":    {100 * time.Second, 100 * time.Second, 100 * time.Second},
+			}))
+		})
+
+		Context("when samples age out of the longest window", func() {
+			BeforeEach(func() {
+				fakeClock.Increment(2 * time.Hour)
+			})
+
+			It("drops them", func() {
+				summary := stagingStats.Summarize()
+				Expect(summary.Windows[1].Total).To(Equal(0))
+			})
+		})
+	})
+})
@@ -0,0 +1,56 @@
+package tagged_metric
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/metrics"
+)
+
+// Tag qualifies a metric, e.g. by the lifecycle or the stack of the staging
+// it measures. Dropsonde metrics carry no tags of their own, so the tags
+// are appended to the metric name: a counter StagingRequestsSucceeded
+// tagged lifecycle=buildpack and stack=cflinuxfs2 is emitted as
+// StagingRequestsSucceeded.lifecycle.buildpack.stack.cflinuxfs2, next to
+// the untagged metric of the same name.
+type Tag struct {
+	Name  string
+	Value string
+}
+
+type Counter string
+
+func (name Counter) Increment(tags ...Tag) error {
+	return metrics.IncrementCounter(Name(string(name), tags...))
+}
+
+func (name Counter) Add(delta uint64, tags ...Tag) error {
+	return metrics.AddToCounter(Name(string(name), tags...), delta)
+}
+
+type Duration string
+
+func (name Duration) Send(duration time.Duration, tags ...Tag) error {
+	return metrics.SendValue(Name(string(name), tags...), float64(duration), "nanos")
+}
+
+// Name returns the name the metric is emitted under with the given tags, in
+// the order they are given. Tag values that are empty are emitted as
+// "none", and characters that statsd or the firehose treat specially are
+// replaced with underscores.
+func Name(name string, tags ...Tag) string {
+	parts := []string{name}
+	for _, tag := range tags {
+		parts = append(parts, sanitize(tag.Name), sanitize(tag.Value))
+	}
+	return strings.Join(parts, ".")
+}
+
+var replacer = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_", "/", "_")
+
+func sanitize(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return replacer.Replace(value)
+}
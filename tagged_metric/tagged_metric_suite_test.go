@@ -0,0 +1,13 @@
+package tagged_metric_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestTaggedMetric(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tagged Metric Suite")
+}
@@ -0,0 +1,66 @@
+package tagged_metric_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/stager/tagged_metric"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TaggedMetric", func() {
+	var (
+		metricSender *fake.FakeMetricSender
+		tags         []tagged_metric.Tag
+	)
+
+	BeforeEach(func() {
+		metricSender = fake.NewFakeMetricSender()
+		metrics.Initialize(metricSender, nil)
+
+		tags = []tagged_metric.Tag{{"lifecycle", "buildpack"}, {"stack", "cflinuxfs2"}}
+	})
+
+	Describe("Name", func() {
+		It("appends the tags in order", func() {
+			Expect(tagged_metric.Name("Metric", tags...)).To(Equal("Metric.lifecycle.buildpack.stack.cflinuxfs2"))
+		})
+
+		It("leaves untagged names alone", func() {
+			Expect(tagged_metric.Name("Metric")).To(Equal("Metric"))
+		})
+
+		It("replaces empty tag values", func() {
+			Expect(tagged_metric.Name("Metric", tagged_metric.Tag{"stack", ""})).To(Equal("Metric.stack.none"))
+		})
+
+		It("replaces characters that separate metric names", func() {
+			Expect(tagged_metric.Name("Metric", tagged_metric.Tag{"stack", "windows 2012.R2:a|b"})).To(Equal("Metric.stack.windows_2012_R2_a_b"))
+		})
+	})
+
+	Describe("Counter", func() {
+		It("counts under the tagged name", func() {
+			counter := tagged_metric.Counter("Requests")
+			Expect(counter.Increment(tags...)).To(Succeed())
+			Expect(counter.Add(2, tags...)).To(Succeed())
+
+			Expect(metricSender.GetCounter("Requests.lifecycle.buildpack.stack.cflinuxfs2")).To(BeEquivalentTo(3))
+			Expect(metricSender.GetCounter("Requests")).To(BeEquivalentTo(0))
+		})
+	})
+
+	Describe("Duration", func() {
+		It("sends the duration in nanoseconds under the tagged name", func() {
+			Expect(tagged_metric.Duration("Duration").Send(time.Second, tags...)).To(Succeed())
+
+			Expect(metricSender.GetValue("Duration.lifecycle.buildpack.stack.cflinuxfs2")).To(Equal(fake.Metric{
+				Value: float64(time.Second),
+				Unit:  "nanos",
+			}))
+		})
+	})
+})
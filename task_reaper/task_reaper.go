@@ -0,0 +1,100 @@
+package task_reaper
+
+import (
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	// Metrics
+	stagingTasksReaped = metric.Counter("StagingTasksReaped")
+)
+
+type reaper struct {
+	logger    lager.Logger
+	bbsClient bbs.Client
+	domains   []string
+	ttl       time.Duration
+	interval  time.Duration
+	clock     clock.Clock
+}
+
+// NewReaper returns a runner that cancels, at every interval, the staging
+// tasks in the given domains that are still pending or running ttl after they
+// were desired. Such tasks are typically orphaned by CC giving up on a staging
+// and retrying it, and would otherwise hold on to cell resources until they
+// time out.
+func NewReaper(logger lager.Logger, bbsClient bbs.Client, domains []string, ttl, interval time.Duration, clock clock.Clock) ifrit.Runner {
+	return &reaper{
+		logger:    logger.Session("task-reaper"),
+		bbsClient: bbsClient,
+		domains:   domains,
+		ttl:       ttl,
+		interval:  interval,
+		clock:     clock,
+	}
+}
+
+func (r *reaper) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C():
+			r.reap()
+		}
+	}
+}
+
+func (r *reaper) reap() {
+	logger := r.logger.Session("reap")
+
+	cutoff := r.clock.Now().Add(-r.ttl).UnixNano()
+	for _, domain := range r.domains {
+		tasks, err := r.bbsClient.TasksByDomain(logger, domain)
+		if err != nil {
+			logger.Error("fetching-tasks-failed", err, lager.Data{"domain": domain})
+			continue
+		}
+
+		r.reapTasks(logger, tasks, cutoff)
+	}
+}
+
+func (r *reaper) reapTasks(logger lager.Logger, tasks []*models.Task, cutoff int64) {
+	for _, task := range tasks {
+		if task.State != models.Task_Pending && task.State != models.Task_Running {
+			continue
+		}
+		if task.CreatedAt > cutoff {
+			continue
+		}
+
+		taskLogger := logger.Session("cancel", lager.Data{
+			"task-guid":  task.TaskGuid,
+			"state":      task.State.String(),
+			"created-at": task.CreatedAt,
+		})
+
+		err := r.bbsClient.CancelTask(taskLogger, task.TaskGuid)
+		if err != nil {
+			taskLogger.Error("failed-to-cancel-task", err)
+			continue
+		}
+
+		taskLogger.Info("reaped-task")
+		stagingTasksReaped.Increment()
+	}
+}
@@ -0,0 +1,13 @@
+package task_reaper_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestTaskReaper(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Task Reaper Suite")
+}
@@ -0,0 +1,135 @@
+package task_reaper_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/task_reaper"
+	fake_metric_sender "github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reaper", func() {
+	var (
+		fakeBBSClient    *fake_bbs.FakeClient
+		fakeClock        *fakeclock.FakeClock
+		fakeMetricSender *fake_metric_sender.FakeMetricSender
+		domains          []string
+		process          ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		domains = []string{cc_messages.StagingTaskDomain}
+		old := fakeClock.Now().Add(-time.Hour).UnixNano()
+		recent := fakeClock.Now().Add(-time.Minute).UnixNano()
+
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeBBSClient.TasksByDomainReturns([]*models.Task{
+			{TaskGuid: "old-pending", State: models.Task_Pending, CreatedAt: old},
+			{TaskGuid: "old-running", State: models.Task_Running, CreatedAt: old},
+			{TaskGuid: "old-completed", State: models.Task_Completed, CreatedAt: old},
+			{TaskGuid: "old-resolving", State: models.Task_Resolving, CreatedAt: old},
+			{TaskGuid: "recent-running", State: models.Task_Running, CreatedAt: recent},
+		}, nil)
+
+		fakeMetricSender = fake_metric_sender.NewFakeMetricSender()
+		metrics.Initialize(fakeMetricSender, nil)
+	})
+
+	JustBeforeEach(func() {
+		reaper := task_reaper.NewReaper(lagertest.NewTestLogger("test"), fakeBBSClient, domains, 30*time.Minute, time.Minute, fakeClock)
+		process = ifrit.Invoke(reaper)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("does nothing until the interval has passed", func() {
+		Consistently(fakeBBSClient.TasksByDomainCallCount).Should(BeZero())
+	})
+
+	Context("when the interval passes", func() {
+		JustBeforeEach(func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(fakeBBSClient.TasksByDomainCallCount).Should(Equal(1))
+		})
+
+		It("looks at the staging tasks", func() {
+			_, domain := fakeBBSClient.TasksByDomainArgsForCall(0)
+			Expect(domain).To(Equal(cc_messages.StagingTaskDomain))
+		})
+
+		It("cancels the pending and running tasks older than the TTL", func() {
+			Eventually(fakeBBSClient.CancelTaskCallCount).Should(Equal(2))
+			Consistently(fakeBBSClient.CancelTaskCallCount).Should(Equal(2))
+
+			_, guid := fakeBBSClient.CancelTaskArgsForCall(0)
+			Expect(guid).To(Equal("old-pending"))
+			_, guid = fakeBBSClient.CancelTaskArgsForCall(1)
+			Expect(guid).To(Equal("old-running"))
+		})
+
+		It("counts the reaped tasks", func() {
+			Eventually(func() uint64 {
+				return fakeMetricSender.GetCounter("StagingTasksReaped")
+			}).Should(BeEquivalentTo(2))
+		})
+
+		Context("when a task cannot be cancelled", func() {
+			BeforeEach(func() {
+				fakeBBSClient.CancelTaskStub = func(logger lager.Logger, guid string) error {
+					if guid == "old-pending" {
+						return models.ErrResourceNotFound
+					}
+					return nil
+				}
+			})
+
+			It("carries on with the other tasks", func() {
+				Eventually(fakeBBSClient.CancelTaskCallCount).Should(Equal(2))
+				Eventually(func() uint64 {
+					return fakeMetricSender.GetCounter("StagingTasksReaped")
+				}).Should(BeEquivalentTo(1))
+			})
+		})
+
+		Context("when the tasks cannot be fetched", func() {
+			BeforeEach(func() {
+				fakeBBSClient.TasksByDomainReturns(nil, errors.New("bbs down"))
+			})
+
+			It("cancels nothing", func() {
+				Consistently(fakeBBSClient.CancelTaskCallCount).Should(BeZero())
+			})
+		})
+	})
+
+	Context("when several staging task domains are configured", func() {
+		BeforeEach(func() {
+			domains = []string{cc_messages.StagingTaskDomain, "windows-staging"}
+		})
+
+		It("reaps the tasks of every domain", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(fakeBBSClient.TasksByDomainCallCount).Should(Equal(2))
+
+			_, domain := fakeBBSClient.TasksByDomainArgsForCall(1)
+			Expect(domain).To(Equal("windows-staging"))
+			Eventually(fakeBBSClient.CancelTaskCallCount).Should(Equal(4))
+		})
+	})
+})
@@ -0,0 +1,328 @@
+package task_watcher
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/bbs"
+	"code.cloudfoundry.org/bbs/events"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/runtimeschema/metric"
+	"code.cloudfoundry.org/stager/backend"
+	"code.cloudfoundry.org/stager/cc_client"
+	"code.cloudfoundry.org/stager/staging_events"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	// Metrics
+	stagingTaskWatchLag          = metric.Duration("StagingTaskWatchLag")
+	stagingTaskWatchResubscribes = metric.Counter("StagingTaskWatchResubscribes")
+)
+
+var ErrResubscribeAttemptsExhausted = errors.New("exhausted attempts to subscribe to task events")
+
+// startedQueueSize bounds the staging started notifications waiting to be
+// delivered to CC; notifications beyond it are dropped.
+const startedQueueSize = 100
+
+// stableWatchPeriod is how long an event stream has to stay up before its
+// failure no longer counts as one of consecutive failures.
+const stableWatchPeriod = time.Minute
+
+// ResubscribePolicy controls how the watcher resubscribes to task events when
+// subscribing fails or the event stream fails. The interval doubles after
+// every consecutive failure up to MaxInterval, and is spread by up to Jitter
+// (a fraction of the interval) in either direction. When MaxAttempts is
+// positive, the watcher exits with an error after that many consecutive
+// failures to subscribe. An Interval of zero or less is taken as the
+// default interval.
+type ResubscribePolicy struct {
+	Interval    time.Duration
+	MaxInterval time.Duration
+	MaxAttempts int
+	Jitter      float64
+}
+
+var DefaultResubscribePolicy = ResubscribePolicy{
+	Interval:    time.Second,
+	MaxInterval: 30 * time.Second,
+}
+
+// TaskWatcher follows BBS task events, and reports whether it is currently
+// subscribed to them.
+type TaskWatcher interface {
+	ifrit.Runner
+	Watching() bool
+}
+
+type taskWatcher struct {
+	watching        int32
+	logger          lager.Logger
+	bbsClient       bbs.Client
+	domains         map[string]bool
+	ccClient        cc_client.CcClient
+	publishStarted  bool
+	startedCallback string
+	events          staging_events.Emitter
+	policy          ResubscribePolicy
+	clock           clock.Clock
+
+	started chan *models.Task
+}
+
+// NewTaskWatcher returns a runner that follows BBS task events and reports
+// how long after staging tasks in the given domains are created or completed
+// it sees them. When
+// publishStarted is set, it also emits an event when a staging task starts
+// running on a cell, and when startedCallback is set, the cell is posted to
+// it, with :staging_guid replaced by the guid of the staging. Those
+// notifications are delivered apart from the event stream, so a slow CC does
+// not hold up the watcher.
+func NewTaskWatcher(logger lager.Logger, bbsClient bbs.Client, domains []string, ccClient cc_client.CcClient, publishStarted bool, startedCallback string, events staging_events.Emitter, policy ResubscribePolicy, clock clock.Clock) TaskWatcher {
+	domainSet := map[string]bool{}
+	for _, domain := range domains {
+		domainSet[domain] = true
+	}
+
+	if policy.Interval <= 0 {
+		policy.Interval = DefaultResubscribePolicy.Interval
+	}
+
+	return &taskWatcher{
+		logger:          logger.Session("task-watcher"),
+		bbsClient:       bbsClient,
+		domains:         domainSet,
+		ccClient:        ccClient,
+		publishStarted:  publishStarted,
+		startedCallback: startedCallback,
+		events:          events,
+		policy:          policy,
+		clock:           clock,
+		started:         make(chan *models.Task, startedQueueSize),
+	}
+}
+
+func (w *taskWatcher) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	done := make(chan struct{})
+	defer close(done)
+	go w.deliverStarted(done)
+
+	close(ready)
+
+	failures := 0
+	for {
+		eventSource, err := w.bbsClient.SubscribeToTaskEvents(w.logger)
+		if err != nil {
+			failures++
+			if w.policy.MaxAttempts > 0 && failures >= w.policy.MaxAttempts {
+				w.logger.Error("giving-up-subscribing-to-task-events", err, lager.Data{"attempts": failures})
+				return ErrResubscribeAttemptsExhausted
+			}
+
+			interval := w.resubscribeInterval(failures)
+			w.logger.Error("failed-subscribing-to-task-events", err, lager.Data{"attempts": failures, "retry-in": interval.String()})
+
+			if w.wait(interval, signals) {
+				return nil
+			}
+			w.reportResubscribe()
+			continue
+		}
+
+		subscribedAt := w.clock.Now()
+		if w.watch(eventSource, signals) {
+			return nil
+		}
+
+		// A stream that fails soon after subscribing backs off like a failed
+		// subscription, so that a BBS dropping every stream is not hammered.
+		if w.clock.Since(subscribedAt) >= stableWatchPeriod {
+			failures = 0
+		}
+		failures++
+
+		interval := w.resubscribeInterval(failures)
+		w.logger.Info("waiting-to-resubscribe-to-task-events", lager.Data{"failures": failures, "retry-in": interval.String()})
+
+		if w.wait(interval, signals) {
+			return nil
+		}
+		w.reportResubscribe()
+	}
+}
+
+// wait waits for the given interval, reporting whether the watcher was
+// signalled meanwhile.
+func (w *taskWatcher) wait(interval time.Duration, signals <-chan os.Signal) bool {
+	timer := w.clock.NewTimer(interval)
+	defer timer.Stop()
+
+	select {
+	case <-signals:
+		return true
+	case <-timer.C():
+		return false
+	}
+}
+
+func (w *taskWatcher) Watching() bool {
+	return atomic.LoadInt32(&w.watching) == 1
+}
+
+func (w *taskWatcher) resubscribeInterval(failures int) time.Duration {
+	interval := w.policy.Interval
+	for i := 1; i < failures && interval < w.policy.MaxInterval; i++ {
+		interval *= 2
+	}
+	if w.policy.MaxInterval > 0 && interval > w.policy.MaxInterval {
+		interval = w.policy.MaxInterval
+	}
+
+	if w.policy.Jitter > 0 {
+		spread := float64(interval) * w.policy.Jitter
+		interval += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+
+	return interval
+}
+
+func (w *taskWatcher) reportResubscribe() {
+	w.logger.Info("resubscribing-to-task-events")
+	stagingTaskWatchResubscribes.Increment()
+}
+
+func (w *taskWatcher) watch(eventSource events.EventSource, signals <-chan os.Signal) bool {
+	defer eventSource.Close()
+
+	atomic.StoreInt32(&w.watching, 1)
+	defer atomic.StoreInt32(&w.watching, 0)
+
+	eventChan := make(chan models.Event)
+	errChan := make(chan error, 1)
+
+	// Closing the event source when the watch ends unblocks the reader, and
+	// done stops it from waiting on events nobody reads anymore.
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			event, err := eventSource.Next()
+			if err != nil {
+				errChan <- err
+				return
+			}
+
+			select {
+			case eventChan <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-signals:
+			return true
+		case err := <-errChan:
+			w.logger.Error("task-event-stream-failed", err)
+			return false
+		case event := <-eventChan:
+			w.handleEvent(event)
+		}
+	}
+}
+
+func (w *taskWatcher) handleEvent(event models.Event) {
+	switch event := event.(type) {
+	case *models.TaskCreatedEvent:
+		if event.Task != nil && w.domains[event.Task.Domain] {
+			w.reportWatchLag(event.Task.CreatedAt)
+		}
+	case *models.TaskChangedEvent:
+		w.handleTaskChanged(event)
+	}
+}
+
+func (w *taskWatcher) handleTaskChanged(changed *models.TaskChangedEvent) {
+	if changed.Before == nil || changed.After == nil {
+		return
+	}
+
+	task := changed.After
+	if !w.domains[task.Domain] {
+		return
+	}
+
+	if changed.Before.State != models.Task_Completed && task.State == models.Task_Completed {
+		w.reportWatchLag(task.FirstCompletedAt)
+	}
+
+	if !w.publishStarted || changed.Before.State != models.Task_Pending || task.State != models.Task_Running {
+		return
+	}
+
+	w.events.Emit(staging_events.TaskStarted, task.TaskGuid, map[string]interface{}{"cell_id": task.CellId})
+
+	if w.startedCallback == "" {
+		return
+	}
+
+	select {
+	case w.started <- task:
+	default:
+		w.logger.Info("dropping-staging-started", lager.Data{"task-guid": task.TaskGuid})
+	}
+}
+
+// deliverStarted posts the queued staging started notifications to CC until
+// done is closed.
+func (w *taskWatcher) deliverStarted(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case task := <-w.started:
+			logger := w.logger.Session("staging-started", lager.Data{"task-guid": task.TaskGuid, "cell-id": task.CellId, "request-id": requestId(task)})
+			callback := strings.Replace(w.startedCallback, ":staging_guid", task.TaskGuid, -1)
+
+			err := w.ccClient.StagingStarted(task.TaskGuid, callback, task.CellId, logger)
+			if err != nil {
+				logger.Error("failed-to-publish-staging-started", err)
+			}
+		}
+	}
+}
+
+func requestId(task *models.Task) string {
+	if task.TaskDefinition == nil {
+		return ""
+	}
+
+	annotation, err := backend.DecodeAnnotation(task.Annotation)
+	if err != nil {
+		return ""
+	}
+	return annotation.RequestId
+}
+
+// reportWatchLag reports how long after a staging task was created or
+// completed, at the given time, the watcher saw it.
+func (w *taskWatcher) reportWatchLag(at int64) {
+	if at == 0 {
+		return
+	}
+
+	err := stagingTaskWatchLag.Send(w.clock.Now().Sub(time.Unix(0, at)))
+	if err != nil {
+		w.logger.Error("failed-to-send-watch-lag-metric", err)
+	}
+}
@@ -0,0 +1,379 @@
+package task_watcher_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/bbs/events/eventfakes"
+	"code.cloudfoundry.org/bbs/fake_bbs"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/runtimeschema/cc_messages"
+	"code.cloudfoundry.org/stager/cc_client/fakes"
+	"code.cloudfoundry.org/stager/staging_events"
+	event_fakes "code.cloudfoundry.org/stager/staging_events/fakes"
+	"code.cloudfoundry.org/stager/task_watcher"
+	"github.com/cloudfoundry/dropsonde/metric_sender/fake"
+	"github.com/cloudfoundry/dropsonde/metrics"
+	"github.com/tedsuo/ifrit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TaskWatcher", func() {
+	var (
+		fakeBBSClient   *fake_bbs.FakeClient
+		fakeCCClient    *fakes.FakeCcClient
+		fakeEventSource *eventfakes.FakeEventSource
+		fakeClock       *fakeclock.FakeClock
+		metricSender    *fake.FakeMetricSender
+		fakeEmitter     *event_fakes.FakeEmitter
+		policy          task_watcher.ResubscribePolicy
+		publishStarted  bool
+		startedCallback string
+
+		events  chan models.Event
+		watcher task_watcher.TaskWatcher
+		process ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeBBSClient = &fake_bbs.FakeClient{}
+		fakeCCClient = &fakes.FakeCcClient{}
+		fakeEventSource = &eventfakes.FakeEventSource{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		metricSender = fake.NewFakeMetricSender()
+		metrics.Initialize(metricSender, nil)
+
+		events = make(chan models.Event, 10)
+		fakeEventSource.NextStub = func() (models.Event, error) {
+			event, ok := <-events
+			if !ok {
+				return nil, errors.New("closed")
+			}
+			return event, nil
+		}
+
+		fakeBBSClient.SubscribeToTaskEventsReturns(fakeEventSource, nil)
+		fakeEmitter = &event_fakes.FakeEmitter{}
+		policy = task_watcher.DefaultResubscribePolicy
+		publishStarted = true
+		startedCallback = "https://cc.example.com/staging/:staging_guid/started"
+	})
+
+	JustBeforeEach(func() {
+		watcher = task_watcher.NewTaskWatcher(lagertest.NewTestLogger("test"), fakeBBSClient, []string{cc_messages.StagingTaskDomain, "windows-staging"}, fakeCCClient, publishStarted, startedCallback, fakeEmitter, policy, fakeClock)
+		process = ifrit.Invoke(watcher)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	taskChanged := func(domain string, before, after models.Task_State) *models.TaskChangedEvent {
+		return models.NewTaskChangedEvent(
+			&models.Task{TaskGuid: "the-task-guid", Domain: domain, State: before},
+			&models.Task{TaskGuid: "the-task-guid", Domain: domain, State: after, CellId: "the-cell-id"},
+		)
+	}
+
+	It("reports that it is watching once subscribed", func() {
+		Eventually(watcher.Watching).Should(BeTrue())
+	})
+
+	Context("when a staging task starts running", func() {
+		JustBeforeEach(func() {
+			events <- taskChanged(cc_messages.StagingTaskDomain, models.Task_Pending, models.Task_Running)
+		})
+
+		It("tells the CC which cell the task is running on", func() {
+			Eventually(fakeCCClient.StagingStartedCallCount).Should(Equal(1))
+			guid, callback, cellId, _ := fakeCCClient.StagingStartedArgsForCall(0)
+			Expect(guid).To(Equal("the-task-guid"))
+			Expect(callback).To(Equal("https://cc.example.com/staging/the-task-guid/started"))
+			Expect(cellId).To(Equal("the-cell-id"))
+		})
+
+		Context("when telling the CC blocks", func() {
+			var unblock chan struct{}
+
+			BeforeEach(func() {
+				unblock = make(chan struct{})
+				fakeCCClient.StagingStartedStub = func(string, string, string, lager.Logger) error {
+					<-unblock
+					return nil
+				}
+			})
+
+			AfterEach(func() {
+				close(unblock)
+			})
+
+			It("keeps handling task events", func() {
+				Eventually(fakeCCClient.StagingStartedCallCount).Should(Equal(1))
+
+				events <- taskChanged("some-other-domain", models.Task_Pending, models.Task_Running)
+				events <- taskChanged(cc_messages.StagingTaskDomain, models.Task_Pending, models.Task_Running)
+				Eventually(fakeEmitter.EmitCallCount).Should(Equal(2))
+			})
+		})
+
+		Context("when no started callback is configured", func() {
+			BeforeEach(func() {
+				startedCallback = ""
+			})
+
+			It("does not tell the CC", func() {
+				Eventually(fakeEmitter.EmitCallCount).Should(Equal(1))
+				Consistently(fakeCCClient.StagingStartedCallCount).Should(Equal(0))
+			})
+		})
+
+		Context("when started staging tasks are not published", func() {
+			BeforeEach(func() {
+				publishStarted = false
+			})
+
+			It("neither tells the CC nor emits a task started event", func() {
+				Consistently(fakeEmitter.EmitCallCount).Should(Equal(0))
+				Expect(fakeCCClient.StagingStartedCallCount()).To(Equal(0))
+			})
+		})
+
+		It("emits a task started event", func() {
+			Eventually(fakeEmitter.EmitCallCount).Should(Equal(1))
+			eventType, guid, data := fakeEmitter.EmitArgsForCall(0)
+			Expect(eventType).To(Equal(staging_events.TaskStarted))
+			Expect(guid).To(Equal("the-task-guid"))
+			Expect(data).To(Equal(map[string]interface{}{"cell_id": "the-cell-id"}))
+		})
+	})
+
+	Context("when a staging task is created", func() {
+		JustBeforeEach(func() {
+			events <- models.NewTaskCreatedEvent(&models.Task{
+				TaskGuid:  "the-task-guid",
+				Domain:    cc_messages.StagingTaskDomain,
+				State:     models.Task_Pending,
+				CreatedAt: fakeClock.Now().Add(-3 * time.Second).UnixNano(),
+			})
+		})
+
+		It("emits the time between the task being created and the watcher seeing it", func() {
+			Eventually(func() fake.Metric {
+				return metricSender.GetValue("StagingTaskWatchLag")
+			}).Should(Equal(fake.Metric{
+				Value: float64(3 * time.Second),
+				Unit:  "nanos",
+			}))
+		})
+	})
+
+	Context("when a staging task event carries its completion time", func() {
+		JustBeforeEach(func() {
+			event := taskChanged(cc_messages.StagingTaskDomain, models.Task_Running, models.Task_Completed)
+			event.After.UpdatedAt = fakeClock.Now().Add(-time.Second).UnixNano()
+			event.After.FirstCompletedAt = fakeClock.Now().Add(-5 * time.Second).UnixNano()
+			events <- event
+		})
+
+		It("emits the time between the task completing and the watcher seeing it", func() {
+			Eventually(func() fake.Metric {
+				return metricSender.GetValue("StagingTaskWatchLag")
+			}).Should(Equal(fake.Metric{
+				Value: float64(5 * time.Second),
+				Unit:  "nanos",
+			}))
+		})
+
+		Context("when started staging tasks are not published", func() {
+			BeforeEach(func() {
+				publishStarted = false
+			})
+
+			It("still emits the watch lag", func() {
+				Eventually(func() fake.Metric {
+					return metricSender.GetValue("StagingTaskWatchLag")
+				}).Should(Equal(fake.Metric{
+					Value: float64(5 * time.Second),
+					Unit:  "nanos",
+				}))
+			})
+		})
+	})
+
+	Context("when a staging task completes", func() {
+		JustBeforeEach(func() {
+			events <- taskChanged(cc_messages.StagingTaskDomain, models.Task_Running, models.Task_Completed)
+		})
+
+		It("does not tell the CC", func() {
+			Consistently(fakeCCClient.StagingStartedCallCount).Should(Equal(0))
+		})
+	})
+
+	Context("when a staging task in another configured domain starts running", func() {
+		JustBeforeEach(func() {
+			events <- taskChanged("windows-staging", models.Task_Pending, models.Task_Running)
+		})
+
+		It("tells the CC", func() {
+			Eventually(fakeCCClient.StagingStartedCallCount).Should(Equal(1))
+		})
+	})
+
+	Context("when a task from another domain starts running", func() {
+		JustBeforeEach(func() {
+			events <- taskChanged("some-other-domain", models.Task_Pending, models.Task_Running)
+		})
+
+		It("does not tell the CC", func() {
+			Consistently(fakeCCClient.StagingStartedCallCount).Should(Equal(0))
+		})
+	})
+
+	Context("when subscribing to task events fails", func() {
+		BeforeEach(func() {
+			fakeBBSClient.SubscribeToTaskEventsReturns(nil, errors.New("boom"))
+		})
+
+		It("reports that it is not watching", func() {
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(1))
+			Consistently(watcher.Watching).Should(BeFalse())
+		})
+
+		It("resubscribes after an interval", func() {
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(1))
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+		})
+
+		It("counts each resubscribe", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(func() uint64 {
+				return metricSender.GetCounter("StagingTaskWatchResubscribes")
+			}).Should(BeEquivalentTo(1))
+		})
+
+		It("backs off by default", func() {
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(1))
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Consistently(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+			fakeClock.Increment(time.Second)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(3))
+		})
+
+		Context("when the resubscribe interval backs off", func() {
+			BeforeEach(func() {
+				policy = task_watcher.ResubscribePolicy{
+					Interval:    time.Second,
+					MaxInterval: 3 * time.Second,
+				}
+			})
+
+			It("doubles the interval after every failure up to the maximum", func() {
+				Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(1))
+				fakeClock.WaitForWatcherAndIncrement(time.Second)
+				Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+
+				fakeClock.WaitForWatcherAndIncrement(time.Second)
+				Consistently(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+				fakeClock.Increment(time.Second)
+				Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(3))
+
+				fakeClock.WaitForWatcherAndIncrement(2 * time.Second)
+				Consistently(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(3))
+				fakeClock.Increment(time.Second)
+				Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(4))
+			})
+		})
+
+		Context("when the maximum number of attempts is reached", func() {
+			BeforeEach(func() {
+				policy.MaxAttempts = 2
+			})
+
+			It("exits with an error", func() {
+				fakeClock.WaitForWatcherAndIncrement(time.Second)
+				Eventually(process.Wait()).Should(Receive(Equal(task_watcher.ErrResubscribeAttemptsExhausted)))
+				Expect(fakeBBSClient.SubscribeToTaskEventsCallCount()).To(Equal(2))
+			})
+		})
+	})
+
+	Context("when the resubscribe interval is zero", func() {
+		BeforeEach(func() {
+			fakeBBSClient.SubscribeToTaskEventsReturns(nil, errors.New("boom"))
+			policy = task_watcher.ResubscribePolicy{}
+		})
+
+		It("resubscribes after the default interval", func() {
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(1))
+			Consistently(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(1))
+
+			fakeClock.WaitForWatcherAndIncrement(task_watcher.DefaultResubscribePolicy.Interval)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+		})
+	})
+
+	Context("when the task event stream fails", func() {
+		var failStream chan struct{}
+
+		BeforeEach(func() {
+			failStream = make(chan struct{})
+			fakeEventSource.NextStub = func() (models.Event, error) {
+				select {
+				case event := <-events:
+					return event, nil
+				case <-failStream:
+					return nil, errors.New("dropped")
+				}
+			}
+		})
+
+		JustBeforeEach(func() {
+			Eventually(watcher.Watching).Should(BeTrue())
+			failStream <- struct{}{}
+		})
+
+		It("resubscribes after an interval", func() {
+			Consistently(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(1))
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+		})
+
+		It("backs off while the stream keeps failing soon after subscribing", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+
+			Eventually(watcher.Watching).Should(BeTrue())
+			failStream <- struct{}{}
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Consistently(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+			fakeClock.Increment(time.Second)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(3))
+		})
+
+		It("stops backing off once a stream stayed up for a while", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(2))
+
+			Eventually(watcher.Watching).Should(BeTrue())
+			fakeClock.Increment(time.Minute)
+			failStream <- struct{}{}
+
+			fakeClock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(fakeBBSClient.SubscribeToTaskEventsCallCount).Should(Equal(3))
+		})
+	})
+})
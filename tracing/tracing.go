@@ -0,0 +1,35 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+// RequestIdHeader carries the id CC assigns to the request that started a
+// staging, so that the staging can be followed through CC and Diego logs.
+const RequestIdHeader = "X-Vcap-Request-Id"
+
+// RequestId returns the request id sent by CC, or a new one when CC did not
+// send one.
+func RequestId(req *http.Request) string {
+	requestId := req.Header.Get(RequestIdHeader)
+	if requestId != "" {
+		return requestId
+	}
+	return NewRequestId()
+}
+
+// NewRequestId returns a random (version 4) UUID.
+func NewRequestId() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic("failed to generate request id: " + err.Error())
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,31 @@
+package tracing_test
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/stager/tracing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracing", func() {
+	var req *http.Request
+
+	BeforeEach(func() {
+		var err error
+		req, err = http.NewRequest("PUT", "/v1/staging/a-staging-guid", nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("propagates the request id sent by CC", func() {
+		req.Header.Set("X-VCAP-Request-ID", "cc-request-id")
+		Expect(tracing.RequestId(req)).To(Equal("cc-request-id"))
+	})
+
+	It("generates a request id when CC did not send one", func() {
+		requestId := tracing.RequestId(req)
+		Expect(requestId).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		Expect(tracing.RequestId(req)).NotTo(Equal(requestId))
+	})
+})
@@ -0,0 +1,74 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/stager/uaa_client"
+)
+
+type FakeClient struct {
+	TokenStub        func(logger lager.Logger) (string, error)
+	tokenMutex       sync.RWMutex
+	tokenArgsForCall []struct {
+		logger lager.Logger
+	}
+	tokenReturns struct {
+		result1 string
+		result2 error
+	}
+	InvalidateStub        func()
+	invalidateMutex       sync.RWMutex
+	invalidateArgsForCall []struct{}
+}
+
+func (fake *FakeClient) Token(logger lager.Logger) (string, error) {
+	fake.tokenMutex.Lock()
+	fake.tokenArgsForCall = append(fake.tokenArgsForCall, struct {
+		logger lager.Logger
+	}{logger})
+	fake.tokenMutex.Unlock()
+	if fake.TokenStub != nil {
+		return fake.TokenStub(logger)
+	} else {
+		return fake.tokenReturns.result1, fake.tokenReturns.result2
+	}
+}
+
+func (fake *FakeClient) TokenCallCount() int {
+	fake.tokenMutex.RLock()
+	defer fake.tokenMutex.RUnlock()
+	return len(fake.tokenArgsForCall)
+}
+
+func (fake *FakeClient) TokenArgsForCall(i int) lager.Logger {
+	fake.tokenMutex.RLock()
+	defer fake.tokenMutex.RUnlock()
+	return fake.tokenArgsForCall[i].logger
+}
+
+func (fake *FakeClient) TokenReturns(result1 string, result2 error) {
+	fake.TokenStub = nil
+	fake.tokenReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) Invalidate() {
+	fake.invalidateMutex.Lock()
+	fake.invalidateArgsForCall = append(fake.invalidateArgsForCall, struct{}{})
+	fake.invalidateMutex.Unlock()
+	if fake.InvalidateStub != nil {
+		fake.InvalidateStub()
+	}
+}
+
+func (fake *FakeClient) InvalidateCallCount() int {
+	fake.invalidateMutex.RLock()
+	defer fake.invalidateMutex.RUnlock()
+	return len(fake.invalidateArgsForCall)
+}
+
+var _ uaa_client.Client = new(FakeClient)
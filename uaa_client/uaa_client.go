@@ -0,0 +1,167 @@
+package uaa_client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	TokenPath = "/oauth/token"
+
+	// ExpiryMargin is how long before it expires a cached token is replaced,
+	// so that a token is not rejected on its way to CC. Tokens that live for
+	// less than twice the margin are replaced halfway through their life.
+	ExpiryMargin = 30 * time.Second
+
+	requestTimeout = 10 * time.Second
+)
+
+var ErrMissingToken = errors.New("UAA response carries no access token")
+
+// Client fetches access tokens from UAA with the client credentials grant
+// and caches them until they are about to expire.
+//
+//go:generate counterfeiter -o fakes/fake_client.go . Client
+type Client interface {
+	// Token returns the cached access token, fetching a new one first if
+	// none is cached or the cached one is about to expire.
+	Token(logger lager.Logger) (string, error)
+
+	// Invalidate drops the cached access token, e.g. after it was rejected,
+	// so that the next call to Token fetches a new one.
+	Invalidate()
+}
+
+type BadResponseError struct {
+	StatusCode int
+}
+
+func (e *BadResponseError) Error() string {
+	return fmt.Sprintf("UAA token request failed with %d", e.StatusCode)
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type client struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	httpClient   *http.Client
+	clock        clock.Clock
+
+	lock      sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func NewClient(uaaURL, clientID, clientSecret string, skipCertVerify bool, clock clock.Clock) Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: skipCertVerify,
+			MinVersion:         tls.VersionTLS10,
+		},
+	}
+
+	return &client{
+		tokenURL:     strings.TrimSuffix(uaaURL, "/") + TokenPath,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		httpClient:   &http.Client{Timeout: requestTimeout, Transport: transport},
+		clock:        clock,
+	}
+}
+
+func (c *client) Token(logger lager.Logger) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.token != "" && c.clock.Now().Before(c.expiresAt) {
+		return c.token, nil
+	}
+
+	logger = logger.Session("uaa-client")
+	logger.Info("fetching-token")
+
+	token, expiresIn, err := c.fetchToken()
+	if err != nil {
+		logger.Error("fetching-token-failed", err)
+		return "", err
+	}
+
+	margin := ExpiryMargin
+	if margin > expiresIn/2 {
+		margin = expiresIn / 2
+	}
+
+	c.token = token
+	c.expiresAt = c.clock.Now().Add(expiresIn - margin)
+	logger.Info("fetched-token", lager.Data{"expires-in": expiresIn.String()})
+
+	return c.token, nil
+}
+
+func (c *client) Invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.token = ""
+}
+
+func (c *client) fetchToken() (string, time.Duration, error) {
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {c.clientID},
+	}
+
+	request, err := http.NewRequest("POST", c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+
+	request.SetBasicAuth(c.clientID, c.clientSecret)
+	request.Header.Set("content-type", "application/x-www-form-urlencoded")
+	request.Header.Set("accept", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", 0, &BadResponseError{response.StatusCode}
+	}
+
+	var token tokenResponse
+	err = json.NewDecoder(response.Body).Decode(&token)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if token.AccessToken == "" {
+		return "", 0, ErrMissingToken
+	}
+
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,137 @@
+package uaa_client_test
+
+import (
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/stager/uaa_client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("UaaClient", func() {
+	var (
+		fakeUAA   *ghttp.Server
+		fakeClock *fakeclock.FakeClock
+		logger    *lagertest.TestLogger
+		client    uaa_client.Client
+	)
+
+	respondWithToken := func(token string, expiresIn int) http.HandlerFunc {
+		return ghttp.CombineHandlers(
+			ghttp.VerifyRequest("POST", "/oauth/token"),
+			ghttp.VerifyBasicAuth("the-client", "the-secret"),
+			ghttp.VerifyContentType("application/x-www-form-urlencoded"),
+			ghttp.VerifyFormKV("grant_type", "client_credentials"),
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"access_token": token,
+				"token_type":   "bearer",
+				"expires_in":   expiresIn,
+			}),
+		)
+	}
+
+	BeforeEach(func() {
+		fakeUAA = ghttp.NewServer()
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		logger = lagertest.NewTestLogger("test")
+		client = uaa_client.NewClient(fakeUAA.URL()+"/", "the-client", "the-secret", false, fakeClock)
+	})
+
+	AfterEach(func() {
+		fakeUAA.Close()
+	})
+
+	It("fetches a token with the client credentials grant", func() {
+		fakeUAA.AppendHandlers(respondWithToken("the-token", 3600))
+
+		token, err := client.Token(logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(Equal("the-token"))
+		Expect(fakeUAA.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	Context("when a token is cached", func() {
+		BeforeEach(func() {
+			fakeUAA.AppendHandlers(
+				respondWithToken("the-token", 3600),
+				respondWithToken("the-next-token", 3600),
+			)
+
+			_, err := client.Token(logger)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reuses it until it is about to expire", func() {
+			fakeClock.Increment(time.Hour - uaa_client.ExpiryMargin - time.Second)
+
+			token, err := client.Token(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).To(Equal("the-token"))
+			Expect(fakeUAA.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("fetches a new one once it is about to expire", func() {
+			fakeClock.Increment(time.Hour - uaa_client.ExpiryMargin)
+
+			token, err := client.Token(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).To(Equal("the-next-token"))
+			Expect(fakeUAA.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("fetches a new one once it is invalidated", func() {
+			client.Invalidate()
+
+			token, err := client.Token(logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(token).To(Equal("the-next-token"))
+		})
+	})
+
+	Context("when tokens live for less than twice the expiry margin", func() {
+		BeforeEach(func() {
+			fakeUAA.AppendHandlers(
+				respondWithToken("the-token", 40),
+				respondWithToken("the-next-token", 40),
+			)
+
+			_, err := client.Token(logger)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("replaces them halfway through their life", func() {
+			fakeClock.Increment(19 * time.Second)
+			Expect(client.Token(logger)).To(Equal("the-token"))
+
+			fakeClock.Increment(time.Second)
+			Expect(client.Token(logger)).To(Equal("the-next-token"))
+		})
+	})
+
+	Context("when UAA rejects the client", func() {
+		BeforeEach(func() {
+			fakeUAA.AppendHandlers(ghttp.RespondWith(http.StatusUnauthorized, `{"error":"unauthorized"}`))
+		})
+
+		It("returns a BadResponseError", func() {
+			_, err := client.Token(logger)
+			Expect(err).To(Equal(&uaa_client.BadResponseError{StatusCode: http.StatusUnauthorized}))
+		})
+	})
+
+	Context("when UAA responds without a token", func() {
+		BeforeEach(func() {
+			fakeUAA.AppendHandlers(ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{"expires_in": 3600}))
+		})
+
+		It("returns ErrMissingToken", func() {
+			_, err := client.Token(logger)
+			Expect(err).To(Equal(uaa_client.ErrMissingToken))
+		})
+	})
+})
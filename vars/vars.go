@@ -1,6 +1,9 @@
 package vars
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 type StringList map[string]struct{}
 
@@ -24,3 +27,27 @@ func (sl StringList) Values() []string {
 	}
 	return result
 }
+
+type KeyValueList map[string]string
+
+func (kv KeyValueList) Set(arg string) error {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected key=value, got '%s'", arg)
+	}
+
+	kv[parts[0]] = parts[1]
+	return nil
+}
+
+func (kv KeyValueList) String() string {
+	var pairs []string
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (kv KeyValueList) Get() interface{} {
+	return map[string]string(kv)
+}
@@ -74,3 +74,23 @@ var _ = Describe("Vars", func() {
 		})
 	})
 })
+
+var _ = Describe("KeyValueList", func() {
+	var kv vars.KeyValueList
+
+	BeforeEach(func() {
+		kv = make(vars.KeyValueList)
+	})
+
+	Describe("Set", func() {
+		It("stores the key and value", func() {
+			Expect(kv.Set("key=value=with=equals")).To(Succeed())
+			Expect(kv.Get()).To(Equal(map[string]string{"key": "value=with=equals"}))
+		})
+
+		It("rejects arguments without a key", func() {
+			Expect(kv.Set("=value")).NotTo(Succeed())
+			Expect(kv.Set("no-equals")).NotTo(Succeed())
+		})
+	})
+})